@@ -3,14 +3,16 @@ package analysis
 import (
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Token represents a single token in the text
 type Token struct {
 	Text      string
-	Position  int  // Position in the original text
-	StartByte int  // Start byte offset
-	EndByte   int  // End byte offset
+	Original  string // Surface form of the word before normalization
+	Position  int    // Position in the original text
+	StartByte int    // Start byte offset
+	EndByte   int    // End byte offset
 }
 
 // Analyzer defines the interface for text analysis
@@ -18,19 +20,72 @@ type Analyzer interface {
 	Analyze(text string) []Token
 }
 
+// AnalyzeToTerms runs text through the analyzer and returns the resulting
+// terms. Indexing and query term extraction both go through this function so
+// documents and queries are always normalized the same way.
+func AnalyzeToTerms(a Analyzer, text string) []string {
+	tokens := a.Analyze(text)
+	terms := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token.Text == "" {
+			continue
+		}
+		terms = append(terms, token.Text)
+	}
+	return terms
+}
+
+// StandardAnalyzerOptions configures which cleaned tokens StandardAnalyzer keeps
+type StandardAnalyzerOptions struct {
+	MinTermLength int  // Minimum token length in runes; tokens shorter are dropped
+	DropNumeric   bool // Drop tokens made up entirely of digits
+}
+
 // StandardAnalyzer implements a basic analyzer that splits on whitespace,
 // converts to lowercase, and removes punctuation
-type StandardAnalyzer struct{}
+type StandardAnalyzer struct {
+	opts StandardAnalyzerOptions
+}
 
 // NewStandardAnalyzer creates a new StandardAnalyzer
 func NewStandardAnalyzer() *StandardAnalyzer {
-	return &StandardAnalyzer{}
+	return NewStandardAnalyzerWithOptions(StandardAnalyzerOptions{MinTermLength: 1})
+}
+
+// NewStandardAnalyzerWithOptions creates a StandardAnalyzer with the given options
+func NewStandardAnalyzerWithOptions(opts StandardAnalyzerOptions) *StandardAnalyzer {
+	if opts.MinTermLength < 1 {
+		opts.MinTermLength = 1
+	}
+	return &StandardAnalyzer{opts: opts}
+}
+
+// keepToken reports whether a cleaned token passes the configured options
+func (a *StandardAnalyzer) keepToken(token string) bool {
+	if utf8.RuneCountInString(token) < a.opts.MinTermLength {
+		return false
+	}
+	if a.opts.DropNumeric && isNumeric(token) {
+		return false
+	}
+	return true
+}
+
+// isNumeric reports whether a token consists only of digits
+func isNumeric(token string) bool {
+	for _, r := range token {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
 }
 
 // Analyze performs the text analysis process:
 // 1. Splits text into tokens based on whitespace
 // 2. Converts tokens to lowercase
 // 3. Removes punctuation
+// 4. Drops tokens rejected by the analyzer options
 func (a *StandardAnalyzer) Analyze(text string) []Token {
 	if len(strings.TrimSpace(text)) == 0 {
 		return []Token{}
@@ -38,57 +93,104 @@ func (a *StandardAnalyzer) Analyze(text string) []Token {
 
 	var tokens []Token
 	position := 0
-	startByte := 0
 
 	// Split on whitespace first
-	words := strings.Fields(text)
-
-	for _, word := range words {
-		// Skip empty words
-		if len(word) == 0 {
-			continue
-		}
-
-		// Process the word
-		cleanWord := strings.Map(func(r rune) rune {
-			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
-				return -1 // Remove punctuation and symbols
+	for _, word := range splitWords(text) {
+		// Lowercase the word and remove punctuation and symbols, tracking the
+		// source span of the characters that were kept
+		var clean strings.Builder
+		spanStart, spanEnd := -1, 0
+		for i := 0; i < len(word.text); {
+			r, size := utf8.DecodeRuneInString(word.text[i:])
+			if !unicode.IsPunct(r) && !unicode.IsSymbol(r) {
+				if spanStart < 0 {
+					spanStart = i
+				}
+				spanEnd = i + size
+				clean.WriteRune(unicode.ToLower(r))
 			}
-			return unicode.ToLower(r)
-		}, word)
+			i += size
+		}
+		cleanWord := clean.String()
 
 		// Skip if the word became empty after cleaning
 		if len(cleanWord) == 0 {
 			continue
 		}
 
-		// Calculate byte offsets
-		wordStartByte := startByte
-		if startByte > 0 {
-			// Find the start of the current word in the original text
-			for i := startByte; i < len(text); i++ {
-				if strings.HasPrefix(strings.ToLower(text[i:]), strings.ToLower(word)) {
-					wordStartByte = i
-					break
-				}
-			}
+		// Dropped tokens still consume a position
+		if !a.keepToken(cleanWord) {
+			position++
+			continue
 		}
-		wordEndByte := wordStartByte + len(cleanWord)
 
 		tokens = append(tokens, Token{
 			Text:      cleanWord,
+			Original:  word.text,
 			Position:  position,
-			StartByte: wordStartByte,
-			EndByte:   wordEndByte,
+			StartByte: word.start + spanStart,
+			EndByte:   word.start + spanEnd,
 		})
 
 		position++
-		startByte = wordStartByte + len(word)
 	}
 
 	return tokens
 }
 
+// sourceWord is a whitespace-delimited word and its byte offset in the text
+type sourceWord struct {
+	text  string
+	start int
+}
+
+// splitWords splits text on whitespace like strings.Fields, recording where
+// each word starts so offsets stay correct for repeated words and leading
+// whitespace
+func splitWords(text string) []sourceWord {
+	var words []sourceWord
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				words = append(words, sourceWord{text: text[start:i], start: start})
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, sourceWord{text: text[start:], start: start})
+	}
+	return words
+}
+
+// KeywordAnalyzer emits the whole input as a single token, for exact-value
+// fields such as status codes or tags
+type KeywordAnalyzer struct{}
+
+// NewKeywordAnalyzer creates a new KeywordAnalyzer
+func NewKeywordAnalyzer() *KeywordAnalyzer {
+	return &KeywordAnalyzer{}
+}
+
+// Analyze returns the input unchanged as one token; empty input has no tokens
+func (a *KeywordAnalyzer) Analyze(text string) []Token {
+	if text == "" {
+		return nil
+	}
+	return []Token{{
+		Text:      text,
+		Original:  text,
+		Position:  0,
+		StartByte: 0,
+		EndByte:   len(text),
+	}}
+}
+
 // CustomAnalyzer allows for configurable analysis with custom filters
 type CustomAnalyzer struct {
 	filters []TokenFilter
@@ -99,6 +201,30 @@ type TokenFilter interface {
 	Filter(token string) string
 }
 
+// ExpandingFilter is a TokenFilter that can turn one token into several, such
+// as synonyms or n-grams. CustomAnalyzer calls Expand instead of Filter and
+// emits every resulting token at the position of the original.
+type ExpandingFilter interface {
+	TokenFilter
+	Expand(token string) []string
+}
+
+// StreamFilter is a TokenFilter that works on the whole token stream instead
+// of one token at a time, for filters that combine neighbouring tokens such as
+// shingles. CustomAnalyzer passes it the tokens produced by the filters before
+// it, and the filters after it see the tokens it returns.
+type StreamFilter interface {
+	TokenFilter
+	FilterStream(tokens []Token) []Token
+}
+
+// filteredToken is one variant of a word produced by the filter chain
+type filteredToken struct {
+	text     string
+	spanLen  int  // Bytes of source text the variant covers
+	expanded bool // Produced by an ExpandingFilter
+}
+
 // NewCustomAnalyzer creates a new CustomAnalyzer with the specified filters
 func NewCustomAnalyzer(filters []TokenFilter) *CustomAnalyzer {
 	return &CustomAnalyzer{
@@ -106,55 +232,98 @@ func NewCustomAnalyzer(filters []TokenFilter) *CustomAnalyzer {
 	}
 }
 
-// Analyze performs text analysis using the configured filters
-func (a *CustomAnalyzer) Analyze(text string) []Token {
-	if len(strings.TrimSpace(text)) == 0 {
-		return []Token{}
-	}
-
-	var tokens []Token
-	position := 0
-	startByte := 0
-
-	words := strings.Fields(text)
-
-	for _, word := range words {
-		if len(word) == 0 {
-			continue
-		}
+// applyFilters runs a token through a chain of per-token filters, returning
+// its non-empty, distinct variants. A token's span follows its filtered length
+// unless fixedSpan is set; variants created by an ExpandingFilter keep the
+// source span of the token they were expanded from.
+func applyFilters(filters []TokenFilter, token string, spanLen int, fixedSpan bool) []filteredToken {
+	variants := []filteredToken{{text: token, spanLen: spanLen, expanded: fixedSpan}}
+	for _, filter := range filters {
+		next := make([]filteredToken, 0, len(variants))
+		for _, variant := range variants {
+			if expander, ok := filter.(ExpandingFilter); ok {
+				for _, text := range expander.Expand(variant.text) {
+					next = append(next, filteredToken{text: text, spanLen: variant.spanLen, expanded: true})
+				}
+				continue
+			}
 
-		processedWord := word
-		for _, filter := range a.filters {
-			processedWord = filter.Filter(processedWord)
+			text := filter.Filter(variant.text)
+			spanLen := variant.spanLen
+			if !variant.expanded {
+				spanLen = len(text)
+			}
+			next = append(next, filteredToken{text: text, spanLen: spanLen, expanded: variant.expanded})
 		}
+		variants = next
+	}
 
-		if len(processedWord) == 0 {
+	seen := make(map[string]bool, len(variants))
+	kept := variants[:0]
+	for _, variant := range variants {
+		if variant.text == "" || seen[variant.text] {
 			continue
 		}
+		seen[variant.text] = true
+		kept = append(kept, variant)
+	}
+	return kept
+}
 
-		// Calculate byte offsets
-		wordStartByte := startByte
-		if startByte > 0 {
-			// Find the start of the current word in the original text
-			for i := startByte; i < len(text); i++ {
-				if strings.HasPrefix(strings.ToLower(text[i:]), strings.ToLower(word)) {
-					wordStartByte = i
-					break
-				}
-			}
-		}
-		wordEndByte := wordStartByte + len(processedWord)
+// Analyze performs text analysis using the configured filters. Filters only
+// transform the token text; Original keeps the word as it appeared.
+func (a *CustomAnalyzer) Analyze(text string) []Token {
+	if len(strings.TrimSpace(text)) == 0 {
+		return []Token{}
+	}
 
+	words := splitWords(text)
+	tokens := make([]Token, 0, len(words))
+	for position, word := range words {
 		tokens = append(tokens, Token{
-			Text:      processedWord,
+			Text:      word.text,
+			Original:  word.text,
 			Position:  position,
-			StartByte: wordStartByte,
-			EndByte:   wordEndByte,
+			StartByte: word.start,
+			EndByte:   word.start + len(word.text),
 		})
+	}
 
-		position++
-		startByte = wordStartByte + len(word)
+	// Run consecutive per-token filters together, and stream filters on the
+	// tokens produced so far
+	var pending []TokenFilter
+	streamed := false
+	for _, filter := range a.filters {
+		stream, ok := filter.(StreamFilter)
+		if !ok {
+			pending = append(pending, filter)
+			continue
+		}
+		tokens = filterTokens(pending, tokens, streamed)
+		tokens = stream.FilterStream(tokens)
+		pending = nil
+		streamed = true
 	}
+	return filterTokens(pending, tokens, streamed)
+}
 
-	return tokens
+// filterTokens applies per-token filters to each token. Tokens removed by a
+// filter, such as stop words, leave a gap in the positions; expanded variants
+// all share the position of their token. Spans of tokens built by a stream
+// filter are kept as they are.
+func filterTokens(filters []TokenFilter, tokens []Token, fixedSpan bool) []Token {
+	filtered := make([]Token, 0, len(tokens))
+	for _, token := range tokens {
+		spanLen := token.EndByte - token.StartByte
+		for _, variant := range applyFilters(filters, token.Text, spanLen, fixedSpan) {
+			filtered = append(filtered, Token{
+				Text:      variant.text,
+				Original:  token.Original,
+				Position:  token.Position,
+				StartByte: token.StartByte,
+				EndByte:   token.StartByte + variant.spanLen,
+			})
+		}
+	}
+	return filtered
 }
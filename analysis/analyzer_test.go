@@ -2,6 +2,7 @@ package analysis
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -27,32 +28,32 @@ func TestStandardAnalyzer(t *testing.T) {
 			name:  "Simple text",
 			input: "Hello World",
 			expected: []Token{
-				{Text: "hello", Position: 0, StartByte: 0, EndByte: 5},
-				{Text: "world", Position: 1, StartByte: 6, EndByte: 11},
+				{Text: "hello", Original: "Hello", Position: 0, StartByte: 0, EndByte: 5},
+				{Text: "world", Original: "World", Position: 1, StartByte: 6, EndByte: 11},
 			},
 		},
 		{
 			name:  "Text with punctuation",
 			input: "Hello, World!",
 			expected: []Token{
-				{Text: "hello", Position: 0, StartByte: 0, EndByte: 5},
-				{Text: "world", Position: 1, StartByte: 7, EndByte: 12},
+				{Text: "hello", Original: "Hello,", Position: 0, StartByte: 0, EndByte: 5},
+				{Text: "world", Original: "World!", Position: 1, StartByte: 7, EndByte: 12},
 			},
 		},
 		{
 			name:  "Multiple spaces",
 			input: "Hello    World",
 			expected: []Token{
-				{Text: "hello", Position: 0, StartByte: 0, EndByte: 5},
-				{Text: "world", Position: 1, StartByte: 9, EndByte: 14},
+				{Text: "hello", Original: "Hello", Position: 0, StartByte: 0, EndByte: 5},
+				{Text: "world", Original: "World", Position: 1, StartByte: 9, EndByte: 14},
 			},
 		},
 		{
 			name:  "Mixed case",
 			input: "HeLLo WoRLD",
 			expected: []Token{
-				{Text: "hello", Position: 0, StartByte: 0, EndByte: 5},
-				{Text: "world", Position: 1, StartByte: 6, EndByte: 11},
+				{Text: "hello", Original: "HeLLo", Position: 0, StartByte: 0, EndByte: 5},
+				{Text: "world", Original: "WoRLD", Position: 1, StartByte: 6, EndByte: 11},
 			},
 		},
 	}
@@ -89,12 +90,12 @@ func TestCustomAnalyzer(t *testing.T) {
 			name:  "Complex text",
 			input: "Hello, World! This is a TEST.",
 			expected: []Token{
-				{Text: "hello", Position: 0, StartByte: 0, EndByte: 5},
-				{Text: "world", Position: 1, StartByte: 7, EndByte: 12},
-				{Text: "this", Position: 2, StartByte: 14, EndByte: 18},
-				{Text: "is", Position: 3, StartByte: 19, EndByte: 21},
-				{Text: "a", Position: 4, StartByte: 22, EndByte: 23},
-				{Text: "test", Position: 5, StartByte: 24, EndByte: 28},
+				{Text: "hello", Original: "Hello,", Position: 0, StartByte: 0, EndByte: 5},
+				{Text: "world", Original: "World!", Position: 1, StartByte: 7, EndByte: 12},
+				{Text: "this", Original: "This", Position: 2, StartByte: 14, EndByte: 18},
+				{Text: "is", Original: "is", Position: 3, StartByte: 19, EndByte: 21},
+				{Text: "a", Original: "a", Position: 4, StartByte: 22, EndByte: 23},
+				{Text: "test", Original: "TEST.", Position: 5, StartByte: 24, EndByte: 28},
 			},
 		},
 	}
@@ -145,3 +146,452 @@ func TestFilters(t *testing.T) {
 		})
 	}
 }
+
+func TestStandardAnalyzerOptions(t *testing.T) {
+	input := "I have 2 cats and 10 dogs"
+
+	tests := []struct {
+		name     string
+		opts     StandardAnalyzerOptions
+		expected []string
+	}{
+		{
+			name:     "Defaults keep everything",
+			opts:     StandardAnalyzerOptions{},
+			expected: []string{"i", "have", "2", "cats", "and", "10", "dogs"},
+		},
+		{
+			name:     "Minimum term length",
+			opts:     StandardAnalyzerOptions{MinTermLength: 2},
+			expected: []string{"have", "cats", "and", "10", "dogs"},
+		},
+		{
+			name:     "Drop numeric tokens",
+			opts:     StandardAnalyzerOptions{DropNumeric: true},
+			expected: []string{"i", "have", "cats", "and", "dogs"},
+		},
+		{
+			name:     "Both options",
+			opts:     StandardAnalyzerOptions{MinTermLength: 4, DropNumeric: true},
+			expected: []string{"have", "cats", "dogs"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := NewStandardAnalyzerWithOptions(tt.opts).Analyze(input)
+			got := make([]string, len(tokens))
+			for i, token := range tokens {
+				got[i] = token.Text
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Analyze() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+
+	// Dropped tokens still consume positions so later tokens keep theirs
+	tokens := NewStandardAnalyzerWithOptions(StandardAnalyzerOptions{DropNumeric: true}).Analyze(input)
+	if tokens[2].Text != "cats" || tokens[2].Position != 3 {
+		t.Errorf("expected \"cats\" at position 3, got %q at %d", tokens[2].Text, tokens[2].Position)
+	}
+	if tokens[2].StartByte != 9 {
+		t.Errorf("expected \"cats\" to start at byte 9, got %d", tokens[2].StartByte)
+	}
+}
+
+func TestAnalyzeToTerms(t *testing.T) {
+	analyzer := NewStandardAnalyzer()
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"Running!", []string{"running"}},
+		{"RUNNING!", []string{"running"}},
+		{"  Quick,  brown fox. ", []string{"quick", "brown", "fox"}},
+		{"!!! ...", []string{}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := AnalyzeToTerms(analyzer, tt.input)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("AnalyzeToTerms(%q) = %v, want %v", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestTokenOriginal(t *testing.T) {
+	input := "The QUICK, Brown Fox!"
+	expected := []string{"The", "QUICK,", "Brown", "Fox!"}
+
+	analyzers := map[string]Analyzer{
+		"standard": NewStandardAnalyzer(),
+		"custom":   NewCustomAnalyzer([]TokenFilter{NewLowercaseFilter(), NewPunctuationFilter()}),
+	}
+
+	for name, analyzer := range analyzers {
+		t.Run(name, func(t *testing.T) {
+			tokens := analyzer.Analyze(input)
+			if len(tokens) != len(expected) {
+				t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+			}
+			for i, token := range tokens {
+				if token.Original != expected[i] {
+					t.Errorf("token %d: expected original %q, got %q", i, expected[i], token.Original)
+				}
+				if token.Text == token.Original {
+					t.Errorf("token %d: expected normalized text to differ from %q", i, token.Original)
+				}
+				if input[token.StartByte:token.StartByte+len(token.Original)] != token.Original {
+					t.Errorf("token %d: original %q does not match the input at byte %d", i, token.Original, token.StartByte)
+				}
+			}
+		})
+	}
+}
+
+func TestStopWordFilter(t *testing.T) {
+	filter := NewStopWordFilter(DefaultEnglishStopWords())
+	for _, word := range []string{"the", "The", "THE", "Is", "a"} {
+		if got := filter.Filter(word); got != "" {
+			t.Errorf("Filter(%q) = %q, want it dropped", word, got)
+		}
+	}
+	if got := filter.Filter("Fox"); got != "Fox" {
+		t.Errorf("Filter(\"Fox\") = %q, want it kept unchanged", got)
+	}
+
+	empty := NewStopWordFilter(nil)
+	if got := empty.Filter("the"); got != "the" {
+		t.Errorf("Expected an empty stop word list to keep %q, got %q", "the", got)
+	}
+
+	// Dropped stop words still consume positions
+	analyzer := NewCustomAnalyzer([]TokenFilter{
+		NewStopWordFilter(DefaultEnglishStopWords()),
+		NewLowercaseFilter(),
+	})
+	tokens := analyzer.Analyze("The fox IS in THE box")
+	expected := []Token{
+		{Text: "fox", Original: "fox", Position: 1, StartByte: 4, EndByte: 7},
+		{Text: "box", Original: "box", Position: 5, StartByte: 18, EndByte: 21},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Analyze() = %v, want %v", tokens, expected)
+	}
+}
+
+func TestPorterStemmerFilter(t *testing.T) {
+	stemmer := NewPorterStemmerFilter()
+
+	tests := map[string]string{
+		"caresses":    "caress",
+		"ponies":      "poni",
+		"agreed":      "agre",
+		"running":     "run",
+		"runs":        "run",
+		"hopping":     "hop",
+		"relational":  "relat",
+		"adjustment":  "adjust",
+		"hopefulness": "hope",
+		"sky":         "sky",
+		"Running":     "Running", // Not lowercased, left unchanged
+	}
+	for input, expected := range tests {
+		if got := stemmer.Filter(input); got != expected {
+			t.Errorf("Filter(%q) = %q, want %q", input, got, expected)
+		}
+	}
+
+	// Already-stemmed tokens are unchanged
+	for _, stem := range []string{"caress", "poni", "run", "connect", "hop"} {
+		if got := stemmer.Filter(stem); got != stem {
+			t.Errorf("Filter(%q) = %q, want it unchanged", stem, got)
+		}
+	}
+
+	analyzer := NewCustomAnalyzer([]TokenFilter{NewLowercaseFilter(), NewPorterStemmerFilter()})
+	got := AnalyzeToTerms(analyzer, "Running ponies")
+	if !reflect.DeepEqual(got, []string{"run", "poni"}) {
+		t.Errorf("Analyze() = %v, want [run poni]", got)
+	}
+}
+
+func TestSynonymFilter(t *testing.T) {
+	filter := NewSynonymFilter(map[string][]string{"tv": {"television", "telly"}})
+	if got := filter.Expand("tv"); !reflect.DeepEqual(got, []string{"tv", "television", "telly"}) {
+		t.Errorf("Expand(\"tv\") = %v, want the token and its synonyms", got)
+	}
+	if got := filter.Expand("radio"); !reflect.DeepEqual(got, []string{"radio"}) {
+		t.Errorf("Expand(\"radio\") = %v, want only the token", got)
+	}
+
+	// Synonyms share the original token's position and source span
+	analyzer := NewCustomAnalyzer([]TokenFilter{
+		NewLowercaseFilter(),
+		filter,
+	})
+	tokens := analyzer.Analyze("my TV set")
+	expected := []Token{
+		{Text: "my", Original: "my", Position: 0, StartByte: 0, EndByte: 2},
+		{Text: "tv", Original: "TV", Position: 1, StartByte: 3, EndByte: 5},
+		{Text: "television", Original: "TV", Position: 1, StartByte: 3, EndByte: 5},
+		{Text: "telly", Original: "TV", Position: 1, StartByte: 3, EndByte: 5},
+		{Text: "set", Original: "set", Position: 2, StartByte: 6, EndByte: 9},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Analyze() = %v, want %v", tokens, expected)
+	}
+}
+
+func TestEdgeNGramFilter(t *testing.T) {
+	filter, err := NewEdgeNGramFilter(2, 4)
+	if err != nil {
+		t.Fatalf("NewEdgeNGramFilter(2, 4) failed: %v", err)
+	}
+
+	tests := []struct {
+		token    string
+		expected []string
+	}{
+		{"search", []string{"se", "sea", "sear"}},
+		{"sea", []string{"se", "sea"}}, // Max gram longer than the token
+		{"s", nil},                     // Shorter than the min gram
+		{"über", []string{"üb", "übe", "über"}},
+		{"日本語です", []string{"日本", "日本語", "日本語で"}},
+	}
+	for _, tt := range tests {
+		if got := filter.Expand(tt.token); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("Expand(%q) = %v, want %v", tt.token, got, tt.expected)
+		}
+	}
+
+	if _, err := NewEdgeNGramFilter(4, 2); err == nil {
+		t.Error("Expected an error when min is greater than max")
+	}
+	if _, err := NewEdgeNGramFilter(0, 2); err == nil {
+		t.Error("Expected an error for a min gram size below 1")
+	}
+
+	// Grams share the position and span of the token they came from
+	analyzer := NewCustomAnalyzer([]TokenFilter{NewLowercaseFilter(), filter})
+	tokens := analyzer.Analyze("Sea x")
+	expected := []Token{
+		{Text: "se", Original: "Sea", Position: 0, StartByte: 0, EndByte: 3},
+		{Text: "sea", Original: "Sea", Position: 0, StartByte: 0, EndByte: 3},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Analyze() = %v, want %v", tokens, expected)
+	}
+}
+
+func TestASCIIFoldingFilter(t *testing.T) {
+	filter := NewASCIIFoldingFilter()
+
+	tests := map[string]string{
+		"café":       "cafe",
+		"naïve":      "naive",
+		"mañana":     "manana",
+		"über":       "uber",
+		"straße":     "strasse",
+		"ÀÉÎÕÜ":      "AEIOU",
+		"façade":     "facade",
+		"Ærøskøbing": "AEroskobing",
+		"œuvre":      "oeuvre",
+		"Łódź":       "Lodz",
+		"plain":      "plain",
+		"東京":         "東京",
+		"Москва":     "Москва",
+	}
+	for input, expected := range tests {
+		if got := filter.Filter(input); got != expected {
+			t.Errorf("Filter(%q) = %q, want %q", input, got, expected)
+		}
+	}
+
+	// Folding runs before stemming so accented forms share a stem
+	analyzer := NewCustomAnalyzer([]TokenFilter{
+		NewLowercaseFilter(),
+		NewASCIIFoldingFilter(),
+		NewPorterStemmerFilter(),
+	})
+	terms := AnalyzeToTerms(analyzer, "Cafés")
+	if !reflect.DeepEqual(terms, []string{"cafe"}) {
+		t.Errorf("AnalyzeToTerms() = %v, want [cafe]", terms)
+	}
+}
+
+func TestKeywordAnalyzer(t *testing.T) {
+	analyzer := NewKeywordAnalyzer()
+	tokens := analyzer.Analyze("In Progress")
+	expected := []Token{{Text: "In Progress", Original: "In Progress", Position: 0, StartByte: 0, EndByte: 11}}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Analyze() = %v, want %v", tokens, expected)
+	}
+	if tokens := analyzer.Analyze(""); len(tokens) != 0 {
+		t.Errorf("Expected no tokens for empty input, got %v", tokens)
+	}
+}
+
+func TestStandardAnalyzerOffsets(t *testing.T) {
+	analyzer := NewStandardAnalyzer()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected []Token
+	}{
+		{
+			name:  "Repeated words",
+			input: "test test test",
+			expected: []Token{
+				{Text: "test", Original: "test", Position: 0, StartByte: 0, EndByte: 4},
+				{Text: "test", Original: "test", Position: 1, StartByte: 5, EndByte: 9},
+				{Text: "test", Original: "test", Position: 2, StartByte: 10, EndByte: 14},
+			},
+		},
+		{
+			name:  "Leading whitespace",
+			input: "  \tHello world",
+			expected: []Token{
+				{Text: "hello", Original: "Hello", Position: 0, StartByte: 3, EndByte: 8},
+				{Text: "world", Original: "world", Position: 1, StartByte: 9, EndByte: 14},
+			},
+		},
+		{
+			name:  "Surrounding punctuation",
+			input: "(Go) \"rocks\"! -- yes",
+			expected: []Token{
+				{Text: "go", Original: "(Go)", Position: 0, StartByte: 1, EndByte: 3},
+				{Text: "rocks", Original: "\"rocks\"!", Position: 1, StartByte: 6, EndByte: 11},
+				{Text: "yes", Original: "yes", Position: 2, StartByte: 17, EndByte: 20},
+			},
+		},
+		{
+			name:  "Multi-byte runes",
+			input: "Café über café",
+			expected: []Token{
+				{Text: "café", Original: "Café", Position: 0, StartByte: 0, EndByte: 5},
+				{Text: "über", Original: "über", Position: 1, StartByte: 6, EndByte: 11},
+				{Text: "café", Original: "café", Position: 2, StartByte: 12, EndByte: 17},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := analyzer.Analyze(tt.input)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Analyze() = %v, want %v", got, tt.expected)
+			}
+			// Offsets slice the source text at the token's characters
+			for _, token := range got {
+				if source := tt.input[token.StartByte:token.EndByte]; !strings.EqualFold(source, token.Text) {
+					t.Errorf("Source span %q does not match token %q", source, token.Text)
+				}
+			}
+		})
+	}
+}
+
+func TestLengthFilter(t *testing.T) {
+	filter := NewLengthFilter(2, 5)
+
+	tests := map[string]string{
+		"a":      "",      // Shorter than min
+		"ab":     "ab",    // Min boundary
+		"abcde":  "abcde", // Max boundary
+		"abcdef": "",      // Longer than max
+		"東京":     "東京",    // Two runes, six bytes
+		"東京都庁舎所": "",      // Six runes
+		"é":      "",      // One rune, two bytes
+	}
+	for input, expected := range tests {
+		if got := filter.Filter(input); got != expected {
+			t.Errorf("Filter(%q) = %q, want %q", input, got, expected)
+		}
+	}
+
+	unbounded := NewLengthFilter(2, 0)
+	if got := unbounded.Filter("supercalifragilistic"); got != "supercalifragilistic" {
+		t.Errorf("Expected a max of 0 to keep long tokens, got %q", got)
+	}
+}
+
+func TestShingleFilter(t *testing.T) {
+	shingles, err := NewShingleFilter(2, 2, " ")
+	if err != nil {
+		t.Fatalf("NewShingleFilter(2, 2) failed: %v", err)
+	}
+
+	analyzer := NewCustomAnalyzer([]TokenFilter{NewLowercaseFilter(), shingles})
+	tokens := analyzer.Analyze("The quick brown fox")
+	expected := []Token{
+		{Text: "the", Original: "The", Position: 0, StartByte: 0, EndByte: 3},
+		{Text: "the quick", Original: "The quick", Position: 0, StartByte: 0, EndByte: 9},
+		{Text: "quick", Original: "quick", Position: 1, StartByte: 4, EndByte: 9},
+		{Text: "quick brown", Original: "quick brown", Position: 1, StartByte: 4, EndByte: 15},
+		{Text: "brown", Original: "brown", Position: 2, StartByte: 10, EndByte: 15},
+		{Text: "brown fox", Original: "brown fox", Position: 2, StartByte: 10, EndByte: 19},
+		{Text: "fox", Original: "fox", Position: 3, StartByte: 16, EndByte: 19},
+	}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("Analyze() = %v, want %v", tokens, expected)
+	}
+
+	// Filters after the stream filter see the shingles
+	upper, _ := NewShingleFilter(2, 3, "_")
+	analyzer = NewCustomAnalyzer([]TokenFilter{upper, NewLowercaseFilter()})
+	terms := AnalyzeToTerms(analyzer, "A B C")
+	expectedTerms := []string{"a", "a_b", "a_b_c", "b", "b_c", "c"}
+	if !reflect.DeepEqual(terms, expectedTerms) {
+		t.Errorf("AnalyzeToTerms() = %v, want %v", terms, expectedTerms)
+	}
+
+	// A removed stop word breaks the run
+	analyzer = NewCustomAnalyzer([]TokenFilter{
+		NewStopWordFilter(DefaultEnglishStopWords()),
+		shingles,
+	})
+	terms = AnalyzeToTerms(analyzer, "fox and dog run")
+	expectedTerms = []string{"fox", "dog", "dog run", "run"}
+	if !reflect.DeepEqual(terms, expectedTerms) {
+		t.Errorf("AnalyzeToTerms() = %v, want %v", terms, expectedTerms)
+	}
+
+	if _, err := NewShingleFilter(3, 2, " "); err == nil {
+		t.Error("Expected an error when min is greater than max")
+	}
+	if _, err := NewShingleFilter(1, 2, " "); err == nil {
+		t.Error("Expected an error for a min shingle size below 2")
+	}
+}
+
+func TestAnalyzerRegistry(t *testing.T) {
+	for _, name := range []string{"standard", "keyword", "whitespace"} {
+		if _, ok := LookupAnalyzer(name); !ok {
+			t.Errorf("Expected built-in analyzer %q to be registered", name)
+		}
+	}
+	if _, ok := LookupAnalyzer("missing"); ok {
+		t.Error("Expected no analyzer registered as missing")
+	}
+
+	RegisterAnalyzer("folding", func() Analyzer {
+		return NewCustomAnalyzer([]TokenFilter{NewLowercaseFilter(), NewASCIIFoldingFilter()})
+	})
+	analyzer, ok := LookupAnalyzer("folding")
+	if !ok {
+		t.Fatal("Expected the registered analyzer to be found")
+	}
+	if terms := AnalyzeToTerms(analyzer, "Café"); !reflect.DeepEqual(terms, []string{"cafe"}) {
+		t.Errorf("AnalyzeToTerms() = %v, want [cafe]", terms)
+	}
+
+	names := AnalyzerNames()
+	if !reflect.DeepEqual(names, []string{"folding", "keyword", "standard", "whitespace"}) {
+		t.Errorf("AnalyzerNames() = %v", names)
+	}
+}
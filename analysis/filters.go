@@ -1,8 +1,10 @@
 package analysis
 
 import (
+	"fmt"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // LowercaseFilter converts tokens to lowercase
@@ -42,3 +44,507 @@ func NewTrimSpaceFilter() *TrimSpaceFilter {
 func (f *TrimSpaceFilter) Filter(token string) string {
 	return strings.TrimSpace(token)
 }
+
+// StopWordFilter drops common words by returning an empty token, which
+// CustomAnalyzer skips. Matching ignores case.
+type StopWordFilter struct {
+	words map[string]bool
+}
+
+// NewStopWordFilter creates a StopWordFilter for the given words. An empty
+// list drops nothing.
+func NewStopWordFilter(words []string) *StopWordFilter {
+	set := make(map[string]bool, len(words))
+	for _, word := range words {
+		set[strings.ToLower(word)] = true
+	}
+	return &StopWordFilter{words: set}
+}
+
+func (f *StopWordFilter) Filter(token string) string {
+	if f.words[strings.ToLower(token)] {
+		return ""
+	}
+	return token
+}
+
+// LengthFilter drops tokens shorter than min or longer than max runes, such as
+// single-character noise or very long strings. A max of 0 or less means no
+// upper limit.
+type LengthFilter struct {
+	min int
+	max int
+}
+
+func NewLengthFilter(min, max int) *LengthFilter {
+	return &LengthFilter{min: min, max: max}
+}
+
+func (f *LengthFilter) Filter(token string) string {
+	length := utf8.RuneCountInString(token)
+	if length < f.min || (f.max > 0 && length > f.max) {
+		return ""
+	}
+	return token
+}
+
+// SynonymFilter expands a token into itself and its configured synonyms, so
+// for example "tv" also matches "television". Lookups are exact, so the filter
+// usually follows a LowercaseFilter.
+type SynonymFilter struct {
+	synonyms map[string][]string
+}
+
+// NewSynonymFilter creates a SynonymFilter from a map of term to synonyms
+func NewSynonymFilter(synonyms map[string][]string) *SynonymFilter {
+	return &SynonymFilter{synonyms: synonyms}
+}
+
+// Filter returns the token unchanged; synonyms are only added by Expand
+func (f *SynonymFilter) Filter(token string) string {
+	return token
+}
+
+// Expand returns the token followed by its synonyms
+func (f *SynonymFilter) Expand(token string) []string {
+	return append([]string{token}, f.synonyms[token]...)
+}
+
+// EdgeNGramFilter expands a token into its prefixes between a minimum and
+// maximum length in runes, so "search" with sizes 2 to 4 becomes "se", "sea"
+// and "sear". Indexing the grams lets ordinary term lookups serve as-you-type
+// completion. Tokens shorter than the minimum produce no grams.
+type EdgeNGramFilter struct {
+	min int
+	max int
+}
+
+// NewEdgeNGramFilter creates an EdgeNGramFilter emitting grams of min to max
+// runes
+func NewEdgeNGramFilter(min, max int) (*EdgeNGramFilter, error) {
+	if min < 1 {
+		return nil, fmt.Errorf("min gram size must be at least 1, got %d", min)
+	}
+	if min > max {
+		return nil, fmt.Errorf("min gram size %d is greater than max gram size %d", min, max)
+	}
+	return &EdgeNGramFilter{min: min, max: max}, nil
+}
+
+// Filter returns the longest gram of the token
+func (f *EdgeNGramFilter) Filter(token string) string {
+	grams := f.Expand(token)
+	if len(grams) == 0 {
+		return ""
+	}
+	return grams[len(grams)-1]
+}
+
+// Expand returns the token's prefixes from the minimum to the maximum gram
+// size, stopping at the full token
+func (f *EdgeNGramFilter) Expand(token string) []string {
+	runes := []rune(token)
+	max := f.max
+	if max > len(runes) {
+		max = len(runes)
+	}
+	if f.min > max {
+		return nil
+	}
+
+	grams := make([]string, 0, max-f.min+1)
+	for size := f.min; size <= max; size++ {
+		grams = append(grams, string(runes[:size]))
+	}
+	return grams
+}
+
+// ASCIIFoldingFilter replaces accented Latin letters with their ASCII
+// equivalents, so "café" indexes and matches as "cafe". It should run before
+// stemming. Characters without an ASCII equivalent, such as CJK, are kept.
+type ASCIIFoldingFilter struct{}
+
+func NewASCIIFoldingFilter() *ASCIIFoldingFilter {
+	return &ASCIIFoldingFilter{}
+}
+
+func (f *ASCIIFoldingFilter) Filter(token string) string {
+	// Most tokens are already ASCII
+	ascii := true
+	for i := 0; i < len(token); i++ {
+		if token[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return token
+	}
+
+	var b strings.Builder
+	b.Grow(len(token))
+	for _, r := range token {
+		if folded, ok := asciiFoldings[r]; ok {
+			b.WriteString(folded)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// asciiFoldings maps Latin-1 Supplement and Latin Extended-A letters to ASCII
+var asciiFoldings = buildASCIIFoldings(map[string]string{
+	"ÀÁÂÃÄÅĀĂĄ": "A", "àáâãäåāăą": "a",
+	"ÇĆĈĊČ": "C", "çćĉċč": "c",
+	"ÐĎĐ": "D", "ðďđ": "d",
+	"ÈÉÊËĒĔĖĘĚ": "E", "èéêëēĕėęě": "e",
+	"ĜĞĠĢ": "G", "ĝğġģ": "g",
+	"ĤĦ": "H", "ĥħ": "h",
+	"ÌÍÎÏĨĪĬĮİ": "I", "ìíîïĩīĭįı": "i",
+	"Ĵ": "J", "ĵ": "j",
+	"Ķ": "K", "ķĸ": "k",
+	"ĹĻĽĿŁ": "L", "ĺļľŀł": "l",
+	"ÑŃŅŇŊ": "N", "ñńņňŉŋ": "n",
+	"ÒÓÔÕÖØŌŎŐ": "O", "òóôõöøōŏő": "o",
+	"ŔŖŘ": "R", "ŕŗř": "r",
+	"ŚŜŞŠ": "S", "śŝşšſ": "s",
+	"ŢŤŦ": "T", "ţťŧ": "t",
+	"ÙÚÛÜŨŪŬŮŰŲ": "U", "ùúûüũūŭůűų": "u",
+	"Ŵ": "W", "ŵ": "w",
+	"ÝŶŸ": "Y", "ýÿŷ": "y",
+	"ŹŻŽ": "Z", "źżž": "z",
+	"Æ": "AE", "æ": "ae",
+	"Œ": "OE", "œ": "oe",
+	"Ĳ": "IJ", "ĳ": "ij",
+	"Þ": "TH", "þ": "th",
+	"ß": "ss",
+})
+
+// buildASCIIFoldings expands a map of accented letters to their folding into
+// a per-rune lookup table
+func buildASCIIFoldings(groups map[string]string) map[rune]string {
+	foldings := make(map[rune]string)
+	for letters, folded := range groups {
+		for _, r := range letters {
+			foldings[r] = folded
+		}
+	}
+	return foldings
+}
+
+// ShingleFilter combines runs of consecutive tokens into single terms, so
+// "quick brown" can be indexed and looked up as one term. It emits each token
+// followed by the shingles starting at it, all at the token's position.
+// Positions left empty by removed tokens, such as stop words, break a run.
+type ShingleFilter struct {
+	min       int
+	max       int
+	separator string
+}
+
+// NewShingleFilter creates a ShingleFilter emitting shingles of min to max
+// tokens joined by separator
+func NewShingleFilter(min, max int, separator string) (*ShingleFilter, error) {
+	if min < 2 {
+		return nil, fmt.Errorf("min shingle size must be at least 2, got %d", min)
+	}
+	if min > max {
+		return nil, fmt.Errorf("min shingle size %d is greater than max shingle size %d", min, max)
+	}
+	return &ShingleFilter{min: min, max: max, separator: separator}, nil
+}
+
+// Filter returns the token unchanged; shingles are only built by FilterStream
+func (f *ShingleFilter) Filter(token string) string {
+	return token
+}
+
+// FilterStream returns the tokens with shingles added. Only the first token
+// at each position, such as the original of a synonym, takes part in shingles.
+func (f *ShingleFilter) FilterStream(tokens []Token) []Token {
+	// The token that starts each position, in stream order
+	var heads []Token
+	for _, token := range tokens {
+		if len(heads) == 0 || token.Position != heads[len(heads)-1].Position {
+			heads = append(heads, token)
+		}
+	}
+
+	result := make([]Token, 0, len(tokens)*(f.max-f.min+2))
+	head := 0
+	for i, token := range tokens {
+		result = append(result, token)
+
+		// Add shingles after the last token at this position
+		if i+1 < len(tokens) && tokens[i+1].Position == token.Position {
+			continue
+		}
+		for size := f.min; size <= f.max && head+size <= len(heads); size++ {
+			run := heads[head : head+size]
+			if run[size-1].Position-run[0].Position != size-1 {
+				break
+			}
+
+			texts := make([]string, size)
+			originals := make([]string, size)
+			for j, t := range run {
+				texts[j] = t.Text
+				originals[j] = t.Original
+			}
+			result = append(result, Token{
+				Text:      strings.Join(texts, f.separator),
+				Original:  strings.Join(originals, " "),
+				Position:  token.Position,
+				StartByte: run[0].StartByte,
+				EndByte:   run[size-1].EndByte,
+			})
+		}
+		head++
+	}
+	return result
+}
+
+// DefaultEnglishStopWords returns the English stop words used by Lucene's
+// standard stop filter
+func DefaultEnglishStopWords() []string {
+	return []string{
+		"a", "an", "and", "are", "as", "at", "be", "but", "by",
+		"for", "if", "in", "into", "is", "it",
+		"no", "not", "of", "on", "or", "such",
+		"that", "the", "their", "then", "there", "these",
+		"they", "this", "to", "was", "will", "with",
+	}
+}
+
+// PorterStemmerFilter reduces English words to their stem with the Porter
+// stemming algorithm, so "running", "runs" and "run" index the same term.
+// Documents and queries are analyzed by the same analyzer, so a stemmed index
+// is always queried with stemmed terms. Tokens should be lowercased first;
+// tokens with characters other than a-z are left unchanged.
+type PorterStemmerFilter struct{}
+
+func NewPorterStemmerFilter() *PorterStemmerFilter {
+	return &PorterStemmerFilter{}
+}
+
+func (f *PorterStemmerFilter) Filter(token string) string {
+	if len(token) <= 2 {
+		return token
+	}
+	for i := 0; i < len(token); i++ {
+		if token[i] < 'a' || token[i] > 'z' {
+			return token
+		}
+	}
+
+	word := porterStep1a(token)
+	word = porterStep1b(word)
+	word = porterStep1c(word)
+	word = porterReplace(word, porterStep2Rules, 0)
+	word = porterReplace(word, porterStep3Rules, 0)
+	word = porterStep4(word)
+	word = porterStep5(word)
+	return word
+}
+
+// porterRule replaces a suffix with a replacement
+type porterRule struct {
+	suffix      string
+	replacement string
+}
+
+var porterStep2Rules = []porterRule{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"},
+	{"izer", "ize"}, {"abli", "able"}, {"alli", "al"}, {"entli", "ent"},
+	{"eli", "e"}, {"ousli", "ous"}, {"ization", "ize"}, {"ation", "ate"},
+	{"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"}, {"fulness", "ful"},
+	{"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+}
+
+var porterStep3Rules = []porterRule{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"},
+	{"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var porterStep4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment",
+	"ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// porterIsConsonant reports whether word[i] is a consonant. A y is a
+// consonant at the start of a word or after a vowel.
+func porterIsConsonant(word string, i int) bool {
+	switch word[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !porterIsConsonant(word, i-1)
+	}
+	return true
+}
+
+// porterMeasure returns m, the number of vowel-consonant sequences in stem
+func porterMeasure(stem string) int {
+	m := 0
+	i := 0
+	for i < len(stem) && porterIsConsonant(stem, i) {
+		i++
+	}
+	for i < len(stem) {
+		for i < len(stem) && !porterIsConsonant(stem, i) {
+			i++
+		}
+		if i == len(stem) {
+			break
+		}
+		for i < len(stem) && porterIsConsonant(stem, i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+// porterHasVowel reports whether stem contains a vowel
+func porterHasVowel(stem string) bool {
+	for i := range stem {
+		if !porterIsConsonant(stem, i) {
+			return true
+		}
+	}
+	return false
+}
+
+// porterEndsDoubleConsonant reports whether stem ends with a double consonant
+func porterEndsDoubleConsonant(stem string) bool {
+	n := len(stem)
+	return n >= 2 && stem[n-1] == stem[n-2] && porterIsConsonant(stem, n-1)
+}
+
+// porterEndsCVC reports whether stem ends consonant-vowel-consonant where the
+// last consonant is not w, x or y, as in "hop" or "fil"
+func porterEndsCVC(stem string) bool {
+	n := len(stem)
+	if n < 3 || !porterIsConsonant(stem, n-3) || porterIsConsonant(stem, n-2) || !porterIsConsonant(stem, n-1) {
+		return false
+	}
+	switch stem[n-1] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// porterReplace applies the rule with the longest matching suffix when the
+// remaining stem has a measure above minMeasure
+func porterReplace(word string, rules []porterRule, minMeasure int) string {
+	best := -1
+	for i, rule := range rules {
+		if strings.HasSuffix(word, rule.suffix) && (best < 0 || len(rule.suffix) > len(rules[best].suffix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return word
+	}
+	stem := strings.TrimSuffix(word, rules[best].suffix)
+	if porterMeasure(stem) > minMeasure {
+		return stem + rules[best].replacement
+	}
+	return word
+}
+
+// porterStep1a removes plurals
+func porterStep1a(word string) string {
+	switch {
+	case strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ies"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ss"):
+		return word
+	case strings.HasSuffix(word, "s"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// porterStep1b removes -eed, -ed and -ing
+func porterStep1b(word string) string {
+	if strings.HasSuffix(word, "eed") {
+		if porterMeasure(word[:len(word)-3]) > 0 {
+			return word[:len(word)-1]
+		}
+		return word
+	}
+
+	var stem string
+	switch {
+	case strings.HasSuffix(word, "ed") && porterHasVowel(word[:len(word)-2]):
+		stem = word[:len(word)-2]
+	case strings.HasSuffix(word, "ing") && porterHasVowel(word[:len(word)-3]):
+		stem = word[:len(word)-3]
+	default:
+		return word
+	}
+
+	switch {
+	case strings.HasSuffix(stem, "at"), strings.HasSuffix(stem, "bl"), strings.HasSuffix(stem, "iz"):
+		return stem + "e"
+	case porterEndsDoubleConsonant(stem):
+		switch stem[len(stem)-1] {
+		case 'l', 's', 'z':
+			return stem
+		}
+		return stem[:len(stem)-1]
+	case porterMeasure(stem) == 1 && porterEndsCVC(stem):
+		return stem + "e"
+	}
+	return stem
+}
+
+// porterStep1c turns a terminal y into i when the stem has a vowel
+func porterStep1c(word string) string {
+	if strings.HasSuffix(word, "y") && porterHasVowel(word[:len(word)-1]) {
+		return word[:len(word)-1] + "i"
+	}
+	return word
+}
+
+// porterStep4 removes suffixes such as -ance and -ment from long stems
+func porterStep4(word string) string {
+	suffix := ""
+	for _, s := range porterStep4Suffixes {
+		if strings.HasSuffix(word, s) && len(s) > len(suffix) {
+			suffix = s
+		}
+	}
+	if suffix == "" {
+		return word
+	}
+
+	stem := strings.TrimSuffix(word, suffix)
+	if porterMeasure(stem) <= 1 {
+		return word
+	}
+	if suffix == "ion" && !strings.HasSuffix(stem, "s") && !strings.HasSuffix(stem, "t") {
+		return word
+	}
+	return stem
+}
+
+// porterStep5 removes a final -e and reduces a final -ll
+func porterStep5(word string) string {
+	if strings.HasSuffix(word, "e") {
+		stem := word[:len(word)-1]
+		m := porterMeasure(stem)
+		if m > 1 || (m == 1 && !porterEndsCVC(stem)) {
+			word = stem
+		}
+	}
+	if porterMeasure(word) > 1 && porterEndsDoubleConsonant(word) && strings.HasSuffix(word, "l") {
+		word = word[:len(word)-1]
+	}
+	return word
+}
@@ -0,0 +1,48 @@
+package analysis
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]func() Analyzer{
+		"standard":   func() Analyzer { return NewStandardAnalyzer() },
+		"keyword":    func() Analyzer { return NewKeywordAnalyzer() },
+		"whitespace": func() Analyzer { return NewCustomAnalyzer(nil) },
+	}
+)
+
+// RegisterAnalyzer makes an analyzer available by name, replacing any analyzer
+// already registered under it. The standard, keyword and whitespace analyzers
+// are registered by default.
+func RegisterAnalyzer(name string, factory func() Analyzer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+// LookupAnalyzer returns a new instance of the analyzer registered under name
+func LookupAnalyzer(name string) (Analyzer, bool) {
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// AnalyzerNames returns the names of the registered analyzers in sorted order
+func AnalyzerNames() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,12 +1,95 @@
 package document
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultMaxFieldValueBytes is the default cap on the size of a single field value
+const DefaultMaxFieldValueBytes = 10 << 20
+
+// ErrFieldValueTooLarge is returned when a field value exceeds the configured cap
+var ErrFieldValueTooLarge = errors.New("field value too large")
+
+// maxFieldValueBytes caps the byte size of a single string field value
+var maxFieldValueBytes atomic.Int64
+
+func init() {
+	maxFieldValueBytes.Store(DefaultMaxFieldValueBytes)
+}
+
+// SetMaxFieldValueBytes sets the maximum byte size of a single field value.
+// A value of 0 or less disables the cap.
+func SetMaxFieldValueBytes(n int) {
+	maxFieldValueBytes.Store(int64(n))
+}
+
+// MaxFieldValueBytes returns the maximum byte size of a single field value
+func MaxFieldValueBytes() int {
+	return int(maxFieldValueBytes.Load())
+}
+
+// ParseDate parses an RFC 3339 date, with or without fractional seconds
+func ParseDate(s string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	return t, err == nil
+}
+
+// dateTokenLayout formats dates in UTC with fixed-width fractional seconds,
+// so that date tokens sort lexically in time order
+const dateTokenLayout = "2006-01-02T15:04:05.000000000Z"
+
+// DateToken returns the canonical term a date is indexed under
+func DateToken(t time.Time) string {
+	return t.UTC().Format(dateTokenLayout)
+}
+
+// NumberToken returns the canonical term a number is indexed under, so 30
+// and 30.0 share a term
+func NumberToken(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// checkFieldValueSize rejects string values larger than the configured cap,
+// so one huge field can't exhaust memory during tokenization
+func checkFieldValueSize(name string, value interface{}) error {
+	limit := maxFieldValueBytes.Load()
+	if limit <= 0 {
+		return nil
+	}
+	if values, ok := value.([]interface{}); ok {
+		for _, element := range values {
+			if err := checkFieldValueSize(name, element); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if object, ok := value.(map[string]interface{}); ok {
+		for key, nested := range object {
+			if err := checkFieldValueSize(name+"."+key, nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s, ok := value.(string); ok && int64(len(s)) > limit {
+		return fmt.Errorf("field %s is %d bytes, limit is %d: %w", name, len(s), limit, ErrFieldValueTooLarge)
+	}
+	return nil
+}
+
 // FieldType represents the type of a field value
 type FieldType int
 
@@ -19,6 +102,15 @@ const (
 	FloatType
 	// TimeType represents time.Time field values
 	TimeType
+	// ArrayType represents multi-valued fields, held as a []interface{} of
+	// string, numeric or time.Time elements
+	ArrayType
+	// ObjectType represents nested objects, held as a map[string]interface{}
+	// of field values. They are indexed as dotted paths such as "author.name".
+	ObjectType
+	// BoolType represents boolean field values, indexed as the terms "true"
+	// and "false"
+	BoolType
 )
 
 // Field represents a single field in a document
@@ -28,6 +120,58 @@ type Field struct {
 	Value    interface{}
 }
 
+// Values returns the values of the field: its elements for an array field, or
+// its single value otherwise
+func (f Field) Values() []interface{} {
+	if values, ok := f.Value.([]interface{}); ok {
+		return values
+	}
+	return []interface{}{f.Value}
+}
+
+// Dates returns the date values of the field
+func (f Field) Dates() []time.Time {
+	var dates []time.Time
+	for _, value := range f.Values() {
+		if date, ok := value.(time.Time); ok {
+			dates = append(dates, date)
+		}
+	}
+	return dates
+}
+
+// Numbers returns the numeric values of the field
+func (f Field) Numbers() []float64 {
+	var numbers []float64
+	for _, value := range f.Values() {
+		v := reflect.ValueOf(value)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			numbers = append(numbers, float64(v.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			numbers = append(numbers, float64(v.Uint()))
+		case reflect.Float32, reflect.Float64:
+			numbers = append(numbers, v.Float())
+		}
+	}
+	return numbers
+}
+
+// Strings returns the text values of the field, which are the ones analyzed
+// into the index: its strings, and its booleans as "true" or "false"
+func (f Field) Strings() []string {
+	var texts []string
+	for _, value := range f.Values() {
+		switch v := value.(type) {
+		case string:
+			texts = append(texts, v)
+		case bool:
+			texts = append(texts, strconv.FormatBool(v))
+		}
+	}
+	return texts
+}
+
 // Document represents a searchable document with multiple fields
 type Document struct {
 	mu     sync.RWMutex
@@ -47,10 +191,44 @@ func (d *Document) AddField(name string, value interface{}) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	value, err := normalizeValue(value)
+	if err != nil {
+		return fmt.Errorf("failed to add field: %w", err)
+	}
 	fieldType, err := determineFieldType(value)
 	if err != nil {
 		return fmt.Errorf("failed to add field: %w", err)
 	}
+	if err := checkFieldValueSize(name, value); err != nil {
+		return fmt.Errorf("failed to add field: %w", err)
+	}
+
+	d.fields[name] = Field{
+		Name:  name,
+		Type:  fieldType,
+		Value: value,
+	}
+	return nil
+}
+
+// SetTypedField sets a field to a value already converted to the type the
+// field should hold, such as by an explicit mapping. Unlike AddField, strings
+// are kept as strings when date detection is enabled.
+func (d *Document) SetTypedField(name string, value interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	value, err := normalizeValue(value)
+	if err != nil {
+		return fmt.Errorf("failed to set field: %w", err)
+	}
+	fieldType, err := determineFieldType(value)
+	if err != nil {
+		return fmt.Errorf("failed to set field: %w", err)
+	}
+	if err := checkFieldValueSize(name, value); err != nil {
+		return fmt.Errorf("failed to set field: %w", err)
+	}
 
 	d.fields[name] = Field{
 		Name:  name,
@@ -67,11 +245,41 @@ func (d *Document) GetField(name string) (Field, error) {
 
 	field, exists := d.fields[name]
 	if !exists {
-		return Field{}, fmt.Errorf("field %s not found", name)
+		if field, exists = d.lookupPath(name); !exists {
+			return Field{}, fmt.Errorf("field %s not found", name)
+		}
 	}
 	return field, nil
 }
 
+// lookupPath resolves a dotted path such as "author.name" through nested
+// object fields
+// Note: Caller must hold read lock
+func (d *Document) lookupPath(path string) (Field, bool) {
+	parts := strings.Split(path, ".")
+	field, exists := d.fields[parts[0]]
+	if !exists {
+		return Field{}, false
+	}
+
+	value := field.Value
+	for _, part := range parts[1:] {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return Field{}, false
+		}
+		if value, ok = object[part]; !ok {
+			return Field{}, false
+		}
+	}
+
+	fieldType, err := determineFieldType(value)
+	if err != nil {
+		return Field{}, false
+	}
+	return Field{Name: path, Type: fieldType, Value: value}, true
+}
+
 // GetFields returns a map of all fields in the document
 func (d *Document) GetFields() map[string]Field {
 	d.mu.RLock()
@@ -85,6 +293,68 @@ func (d *Document) GetFields() map[string]Field {
 	return fields
 }
 
+// IndexedFields returns the fields of the document as they are indexed, with
+// nested objects flattened into a field per dotted path
+func (d *Document) IndexedFields() map[string]Field {
+	fields := make(map[string]Field)
+	for name, field := range d.GetFields() {
+		flattenField(fields, name, field.Value)
+	}
+	return fields
+}
+
+// flattenField adds value to fields under name, or each value of a nested
+// object under its dotted path
+func flattenField(fields map[string]Field, name string, value interface{}) {
+	if object, ok := value.(map[string]interface{}); ok {
+		for key, nested := range object {
+			flattenField(fields, name+"."+key, nested)
+		}
+		return
+	}
+
+	fieldType, err := determineFieldType(value)
+	if err != nil {
+		return
+	}
+	fields[name] = Field{Name: name, Type: fieldType, Value: value}
+}
+
+// Merge returns a new document holding the fields of d overlaid with those of
+// other, as a partial update does: fields of other replace fields of the same
+// name and fields only in d are kept. Neither document is modified.
+func (d *Document) Merge(other *Document) *Document {
+	merged := NewDocument()
+	merged.ID = d.ID
+	for name, field := range d.GetFields() {
+		merged.fields[name] = field
+	}
+	for name, field := range other.GetFields() {
+		merged.fields[name] = field
+	}
+	return merged
+}
+
+// ContentHash returns a stable hash of the document's fields and values,
+// independent of the order in which fields were added
+func (d *Document) ContentHash() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	names := make([]string, 0, len(d.fields))
+	for name := range d.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		field := d.fields[name]
+		fmt.Fprintf(h, "%q=%d:%T:%v\n", name, field.Type, field.Value, field.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // determineFieldType infers the FieldType from a value
 func determineFieldType(value interface{}) (FieldType, error) {
 	switch value.(type) {
@@ -96,11 +366,114 @@ func determineFieldType(value interface{}) (FieldType, error) {
 		return FloatType, nil
 	case time.Time:
 		return TimeType, nil
+	case bool:
+		return BoolType, nil
+	case []interface{}:
+		return ArrayType, nil
+	case map[string]interface{}:
+		return ObjectType, nil
 	default:
 		return 0, fmt.Errorf("unsupported field type for value: %v", value)
 	}
 }
 
+// normalizeValue converts a field value to the form documents hold it in:
+// JSON numbers become int64 or float64, slices of any element type become a
+// []interface{} of scalar elements, and nested objects are normalized
+// recursively.
+func normalizeValue(value interface{}) (interface{}, error) {
+	if number, ok := value.(json.Number); ok {
+		return numberValue(number)
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		normalized := make(map[string]interface{}, len(object))
+		for key, nested := range object {
+			if key == "" || strings.Contains(key, ".") {
+				return nil, fmt.Errorf("invalid object field name %q", key)
+			}
+			nested, err := normalizeValue(nested)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			if _, err := determineFieldType(nested); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+			normalized[key] = nested
+		}
+		return normalized, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		return value, nil
+	}
+
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		element, err := normalizeValue(rv.Index(i).Interface())
+		if err != nil {
+			return nil, fmt.Errorf("invalid array element: %v", err)
+		}
+		if fieldType, err := determineFieldType(element); err != nil || fieldType == ArrayType || fieldType == ObjectType {
+			return nil, fmt.Errorf("unsupported array element: %v", element)
+		}
+		values[i] = element
+	}
+	return values, nil
+}
+
+// DetectDates returns a normalized field value with its RFC 3339 strings,
+// including those in arrays and nested objects, converted to dates. It
+// reports whether any string was converted.
+func DetectDates(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if date, ok := ParseDate(v); ok {
+			return date, true
+		}
+	case []interface{}:
+		var detected []interface{}
+		for i, element := range v {
+			if date, ok := DetectDates(element); ok {
+				if detected == nil {
+					detected = append([]interface{}(nil), v...)
+				}
+				detected[i] = date
+			}
+		}
+		if detected != nil {
+			return detected, true
+		}
+	case map[string]interface{}:
+		var detected map[string]interface{}
+		for key, nested := range v {
+			if converted, ok := DetectDates(nested); ok {
+				if detected == nil {
+					detected = make(map[string]interface{}, len(v))
+					for k, n := range v {
+						detected[k] = n
+					}
+				}
+				detected[key] = converted
+			}
+		}
+		if detected != nil {
+			return detected, true
+		}
+	}
+	return value, false
+}
+
+// numberValue converts a JSON number to an int64 when it is a whole number
+// that fits, and to a float64 otherwise
+func numberValue(number json.Number) (interface{}, error) {
+	if i, err := number.Int64(); err == nil {
+		return i, nil
+	}
+	return number.Float64()
+}
+
 // MarshalJSON implements json.Marshaler interface
 func (d *Document) MarshalJSON() ([]byte, error) {
 	d.mu.RLock()
@@ -125,14 +498,22 @@ func (d *Document) UnmarshalJSON(data []byte) error {
 		d.fields = make(map[string]Field)
 	}
 
-	// Unmarshal into a temporary map
+	// Unmarshal into a temporary map, keeping numbers as json.Number so that
+	// integers aren't widened to float64
 	var fields map[string]interface{}
-	if err := json.Unmarshal(data, &fields); err != nil {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
 		return err
 	}
 
 	// Convert each field into a Document Field
 	for name, value := range fields {
+		value, err := normalizeValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for field %s: %v", name, err)
+		}
+
 		// Determine field type based on the value
 		var fieldType FieldType
 		switch value.(type) {
@@ -142,9 +523,20 @@ func (d *Document) UnmarshalJSON(data []byte) error {
 			fieldType = FloatType
 		case int, int64:
 			fieldType = IntType
+		case bool:
+			fieldType = BoolType
+		case time.Time:
+			fieldType = TimeType
+		case []interface{}:
+			fieldType = ArrayType
+		case map[string]interface{}:
+			fieldType = ObjectType
 		default:
 			return fmt.Errorf("unsupported field type for field %s", name)
 		}
+		if err := checkFieldValueSize(name, value); err != nil {
+			return err
+		}
 
 		// Add the field to the document
 		d.fields[name] = Field{
@@ -1,7 +1,12 @@
 package document
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewDocument(t *testing.T) {
@@ -24,7 +29,9 @@ func TestAddField(t *testing.T) {
 		{"string field", "title", "test document", false},
 		{"integer field", "count", 42, false},
 		{"float field", "score", 3.14, false},
-		{"invalid type", "invalid", []string{"test"}, true},
+		{"boolean field", "published", true, false},
+		{"invalid type", "invalid", struct{}{}, true},
+		{"nested array", "invalid", []interface{}{[]interface{}{"test"}}, true},
 	}
 
 	for _, tt := range tests {
@@ -86,3 +93,253 @@ func TestGetField(t *testing.T) {
 		t.Errorf("Field value = %v, want %v", field.Value, fieldValue)
 	}
 }
+
+func TestContentHash(t *testing.T) {
+	doc1 := NewDocument()
+	doc1.AddField("title", "hello")
+	doc1.AddField("count", 1)
+
+	doc2 := NewDocument()
+	doc2.AddField("count", 1)
+	doc2.AddField("title", "hello")
+
+	if doc1.ContentHash() != doc2.ContentHash() {
+		t.Error("ContentHash should not depend on field insertion order")
+	}
+
+	doc3 := NewDocument()
+	doc3.AddField("title", "hello")
+	doc3.AddField("count", "1")
+
+	if doc1.ContentHash() == doc3.ContentHash() {
+		t.Error("ContentHash should distinguish values of different types")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	doc := NewDocument()
+	doc.AddField("title", "hello")
+	doc.AddField("count", 1)
+
+	partial := NewDocument()
+	partial.AddField("count", 2)
+	partial.AddField("tag", "new")
+
+	merged := doc.Merge(partial)
+	for name, want := range map[string]interface{}{"title": "hello", "count": 2, "tag": "new"} {
+		if field, err := merged.GetField(name); err != nil || field.Value != want {
+			t.Errorf("Expected merged %s to be %v, got %v (%v)", name, want, field.Value, err)
+		}
+	}
+
+	if field, _ := doc.GetField("count"); field.Value != 1 {
+		t.Errorf("Expected Merge to leave the original untouched, got count %v", field.Value)
+	}
+	if _, err := doc.GetField("tag"); err == nil {
+		t.Error("Expected Merge not to add fields to the original")
+	}
+}
+
+func TestUnmarshalJSONNumberTypes(t *testing.T) {
+	data := []byte(`{"count": 42, "price": 9.99, "big": 9007199254740993, "huge": 1e300}`)
+
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		wantType  FieldType
+		wantValue interface{}
+	}{
+		{"count", IntType, int64(42)},
+		{"price", FloatType, 9.99},
+		{"big", IntType, int64(9007199254740993)},
+		{"huge", FloatType, 1e300},
+	}
+	for _, tt := range tests {
+		field, err := doc.GetField(tt.name)
+		if err != nil {
+			t.Fatalf("Expected field %s, got error: %v", tt.name, err)
+		}
+		if field.Type != tt.wantType || field.Value != tt.wantValue {
+			t.Errorf("Expected %s to be %v of type %d, got %v (%T) of type %d",
+				tt.name, tt.wantValue, tt.wantType, field.Value, field.Value, field.Type)
+		}
+	}
+
+	// Values survive a round trip unchanged
+	encoded, err := json.Marshal(&doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+	var decoded Document
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal round-tripped document: %v", err)
+	}
+	if decoded.ContentHash() != doc.ContentHash() {
+		t.Errorf("Expected round trip to preserve the document, got %s", encoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"n": 1e400}`), &Document{}); err == nil {
+		t.Error("Expected an error for an out-of-range number")
+	}
+}
+
+func TestArrayFields(t *testing.T) {
+	doc := NewDocument()
+	if err := doc.AddField("tags", []string{"go", "search"}); err != nil {
+		t.Fatalf("Failed to add string array: %v", err)
+	}
+	if err := doc.AddField("scores", []interface{}{1, 2.5}); err != nil {
+		t.Fatalf("Failed to add numeric array: %v", err)
+	}
+
+	tags, _ := doc.GetField("tags")
+	if tags.Type != ArrayType || !reflect.DeepEqual(tags.Value, []interface{}{"go", "search"}) {
+		t.Errorf("Expected typed slice to be stored as an array, got %v (%T) of type %d", tags.Value, tags.Value, tags.Type)
+	}
+	if !reflect.DeepEqual(tags.Strings(), []string{"go", "search"}) {
+		t.Errorf("Expected string values [go search], got %v", tags.Strings())
+	}
+	scores, _ := doc.GetField("scores")
+	if scores.Type != ArrayType || len(scores.Strings()) != 0 {
+		t.Errorf("Expected numeric array with no string values, got %v of type %d", scores.Strings(), scores.Type)
+	}
+
+	var decoded Document
+	if err := json.Unmarshal([]byte(`{"tags": ["go", "search"], "scores": [1, 2.5]}`), &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	scores, _ = decoded.GetField("scores")
+	if scores.Type != ArrayType || !reflect.DeepEqual(scores.Values(), []interface{}{int64(1), 2.5}) {
+		t.Errorf("Expected decoded scores [1 2.5] keeping number types, got %#v", scores.Value)
+	}
+
+	if err := json.Unmarshal([]byte(`{"tags": [{"name": "go"}]}`), &Document{}); err == nil {
+		t.Error("Expected an error for an array of objects")
+	}
+}
+
+func TestNestedObjects(t *testing.T) {
+	var doc Document
+	data := []byte(`{"title": "Intro", "author": {"name": "Jane", "age": 30, "address": {"city": "Paris"}}}`)
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+
+	author, err := doc.GetField("author")
+	if err != nil || author.Type != ObjectType {
+		t.Fatalf("Expected author to be an object field, got %v (%v)", author, err)
+	}
+
+	paths := []struct {
+		path      string
+		wantType  FieldType
+		wantValue interface{}
+	}{
+		{"author.name", StringType, "Jane"},
+		{"author.age", IntType, int64(30)},
+		{"author.address.city", StringType, "Paris"},
+	}
+	indexed := doc.IndexedFields()
+	for _, tt := range paths {
+		field, err := doc.GetField(tt.path)
+		if err != nil || field.Type != tt.wantType || field.Value != tt.wantValue {
+			t.Errorf("Expected %s to resolve to %v, got %v (%v)", tt.path, tt.wantValue, field.Value, err)
+		}
+		if field := indexed[tt.path]; field.Name != tt.path || field.Value != tt.wantValue {
+			t.Errorf("Expected %s to be indexed as %v, got %v", tt.path, tt.wantValue, field)
+		}
+	}
+	if len(indexed) != 4 {
+		t.Errorf("Expected 4 indexed fields, got %v", indexed)
+	}
+	for _, path := range []string{"author.missing", "author.name.first", "title.name"} {
+		if _, err := doc.GetField(path); err == nil {
+			t.Errorf("Expected %s not to resolve", path)
+		}
+	}
+
+	// The nested object is kept as is in the source
+	encoded, err := json.Marshal(&doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal document: %v", err)
+	}
+	var source, want map[string]interface{}
+	json.Unmarshal(encoded, &source)
+	json.Unmarshal(data, &want)
+	if !reflect.DeepEqual(source, want) {
+		t.Errorf("Expected source %v, got %v", want, source)
+	}
+
+	if err := NewDocument().AddField("author", map[string]interface{}{"a.b": 1}); err == nil {
+		t.Error("Expected an error for an object key containing a dot")
+	}
+}
+
+func TestDateDetection(t *testing.T) {
+	// Documents keep dates as strings; indices detect them
+	doc := NewDocument()
+	if err := doc.AddField("created", "2021-03-01T10:00:00Z"); err != nil {
+		t.Fatalf("Failed to add date field: %v", err)
+	}
+	created, _ := doc.GetField("created")
+	if created.Type != StringType {
+		t.Errorf("Expected created to stay a string, got type %d", created.Type)
+	}
+
+	value, detected := DetectDates(created.Value)
+	want := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	if date, ok := value.(time.Time); !detected || !ok || !date.Equal(want) {
+		t.Errorf("Expected created to be detected as the date %v, got %v (%T)", want, value, value)
+	}
+	if _, detected := DetectDates("2021 review"); detected {
+		t.Error("Expected a non-date string not to be detected")
+	}
+
+	var decoded Document
+	if err := json.Unmarshal([]byte(`{"dates": ["2021-03-01T12:00:00.5+02:00", "soon"], "meta": {"at": "2021-03-01T10:00:00Z"}}`), &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal document: %v", err)
+	}
+	dates, _ := decoded.GetField("dates")
+	value, detected = DetectDates(dates.Value)
+	if elements := value.([]interface{}); !detected || DateToken(elements[0].(time.Time)) != "2021-03-01T10:00:00.500000000Z" || elements[1] != "soon" {
+		t.Errorf("Expected the first array element to be a date with canonical token, got %v", value)
+	}
+	if original := dates.Value.([]interface{}); original[0] != "2021-03-01T12:00:00.5+02:00" {
+		t.Errorf("Expected detection not to modify the document value, got %v", original)
+	}
+	meta, _ := decoded.GetField("meta")
+	if value, detected := DetectDates(meta.Value); !detected || value.(map[string]interface{})["at"] == "2021-03-01T10:00:00Z" {
+		t.Errorf("Expected the nested date to be detected, got %v", value)
+	}
+}
+
+func TestFieldValueSizeCap(t *testing.T) {
+	defer SetMaxFieldValueBytes(MaxFieldValueBytes())
+	SetMaxFieldValueBytes(16)
+
+	doc := NewDocument()
+	if err := doc.AddField("title", strings.Repeat("a", 16)); err != nil {
+		t.Errorf("Expected field at the cap to be accepted, got %v", err)
+	}
+	if err := doc.AddField("body", strings.Repeat("a", 17)); !errors.Is(err, ErrFieldValueTooLarge) {
+		t.Errorf("Expected ErrFieldValueTooLarge, got %v", err)
+	}
+	if _, err := doc.GetField("body"); err == nil {
+		t.Error("Expected rejected field not to be added")
+	}
+
+	decoded := NewDocument()
+	if err := decoded.UnmarshalJSON([]byte(`{"body":"` + strings.Repeat("a", 17) + `"}`)); !errors.Is(err, ErrFieldValueTooLarge) {
+		t.Errorf("Expected ErrFieldValueTooLarge from UnmarshalJSON, got %v", err)
+	}
+
+	// Disabling the cap accepts any size
+	SetMaxFieldValueBytes(0)
+	if err := doc.AddField("body", strings.Repeat("a", 1024)); err != nil {
+		t.Errorf("Expected no cap when disabled, got %v", err)
+	}
+}
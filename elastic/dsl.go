@@ -3,7 +3,11 @@ package elastic
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"strings"
 	"time"
+
+	"my-indexer/query"
 )
 
 // QueryType represents the type of query
@@ -14,6 +18,8 @@ const (
 	MatchQuery QueryType = "match"
 	// Term query for exact term matches
 	TermQuery QueryType = "term"
+	// Terms query for exact matches of any of several terms
+	TermsQuery QueryType = "terms"
 	// Range query for numeric/date ranges
 	RangeQuery QueryType = "range"
 	// Bool query for combining multiple queries
@@ -22,6 +28,20 @@ const (
 	MatchAllQuery QueryType = "match_all"
 	// Prefix query for prefix matches
 	PrefixQuery QueryType = "prefix"
+	// Exists query for documents that contain a field
+	ExistsQuery QueryType = "exists"
+	// Wildcard query for terms matching a pattern with * and ?
+	WildcardQuery QueryType = "wildcard"
+	// Fuzzy query for terms within an edit distance
+	FuzzyQuery QueryType = "fuzzy"
+	// QueryString query for Lucene query syntax
+	QueryStringQuery QueryType = "query_string"
+	// SimpleQueryString query for lenient query syntax that never fails to parse
+	SimpleQueryStringQuery QueryType = "simple_query_string"
+	// ConstantScore query for filters that give every match the same score
+	ConstantScoreQuery QueryType = "constant_score"
+	// Ids query for documents with the given IDs
+	IdsQuery QueryType = "ids"
 )
 
 // Query represents the base query interface
@@ -42,8 +62,10 @@ func (q BaseQuery) Type() QueryType {
 // MatchQueryClause represents a full text query
 type MatchQueryClause struct {
 	BaseQuery
-	Field string      // Field to search in
-	Value interface{} // Value to search for (must be a string)
+	Field    string      // Field to search in
+	Value    interface{} // Value to search for (must be a string)
+	Operator string      // "and" or "or"; empty uses the default (or)
+	Boost    float64     // Score multiplier; 0 uses the default (1)
 }
 
 func (q *MatchQueryClause) MarshalJSON() ([]byte, error) {
@@ -58,11 +80,19 @@ func (q *MatchQueryClause) MarshalJSON() ([]byte, error) {
 		return nil, fmt.Errorf("match query value must be a string, got %T", q.Value)
 	}
 
+	body := map[string]interface{}{
+		"query": q.Value,
+	}
+	if q.Operator != "" {
+		body["operator"] = q.Operator
+	}
+	if q.Boost != 0 {
+		body["boost"] = q.Boost
+	}
+
 	return json.Marshal(map[string]interface{}{
 		"match": map[string]interface{}{
-			q.Field: map[string]interface{}{
-				"query": q.Value,
-			},
+			q.Field: body,
 		},
 	})
 }
@@ -72,14 +102,20 @@ type TermQueryClause struct {
 	BaseQuery
 	Field string
 	Value interface{}
+	Boost float64 // Score multiplier; 0 uses the default (1)
 }
 
 func (q *TermQueryClause) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{
+		"value": q.Value,
+	}
+	if q.Boost != 0 {
+		body["boost"] = q.Boost
+	}
+
 	return json.Marshal(map[string]interface{}{
 		"term": map[string]interface{}{
-			q.Field: map[string]interface{}{
-				"value": q.Value,
-			},
+			q.Field: body,
 		},
 	})
 }
@@ -153,6 +189,9 @@ type BoolQueryClause struct {
 	Should  []Query `json:"should,omitempty"`
 	MustNot []Query `json:"must_not,omitempty"`
 	Filter  []Query `json:"filter,omitempty"`
+	// MinimumShouldMatch is the number of should clauses a document has to
+	// match when there are no must clauses
+	MinimumShouldMatch int `json:"minimum_should_match,omitempty"`
 }
 
 func (q *BoolQueryClause) MarshalJSON() ([]byte, error) {
@@ -225,6 +264,10 @@ func (q *BoolQueryClause) MarshalJSON() ([]byte, error) {
 		boolQuery["filter"] = filter
 	}
 
+	if q.MinimumShouldMatch > 0 {
+		boolQuery["minimum_should_match"] = q.MinimumShouldMatch
+	}
+
 	boolContents["bool"] = boolQuery
 
 	// Wrap in query object
@@ -238,15 +281,28 @@ const maxBoolNestingDepth = 2 // Maximum allowed nesting depth for bool queries
 type queryContext struct {
 	depth      int
 	seenFields map[string]map[string]bool // clause type -> field -> seen
+	path       string                     // JSON path of the clause being parsed
 }
 
 func newQueryContext() *queryContext {
 	return &queryContext{
 		depth:      0,
 		seenFields: make(map[string]map[string]bool),
+		path:       "query",
 	}
 }
 
+// ParseError is a query parse error annotated with the JSON path of the
+// offending clause, shared with the query mapper
+type ParseError = query.ParseError
+
+// wrapParseError and clausePath annotate errors with clause paths as the
+// query mapper does, reachable where a variable shadows the query package
+var (
+	wrapParseError = query.WrapParseError
+	clausePath     = query.ClausePath
+)
+
 func (ctx *queryContext) checkAndAddField(clauseType, field string) error {
 	if _, exists := ctx.seenFields[clauseType]; !exists {
 		ctx.seenFields[clauseType] = make(map[string]bool)
@@ -298,6 +354,151 @@ func (q *PrefixQueryClause) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// TermsQueryClause represents a query matching any of several exact terms
+type TermsQueryClause struct {
+	BaseQuery
+	Field  string
+	Values []string
+}
+
+func (q *TermsQueryClause) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"terms": map[string]interface{}{
+			q.Field: q.Values,
+		},
+	})
+}
+
+// IdsQueryClause represents a query for documents by ID
+type IdsQueryClause struct {
+	BaseQuery
+	Values []string
+}
+
+func (q *IdsQueryClause) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"ids": map[string]interface{}{
+			"values": q.Values,
+		},
+	})
+}
+
+// ExistsQueryClause represents a query for documents that contain a field
+type ExistsQueryClause struct {
+	BaseQuery
+	Field string
+}
+
+func (q *ExistsQueryClause) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"exists": map[string]interface{}{
+			"field": q.Field,
+		},
+	})
+}
+
+// ConstantScoreQueryClause represents a filter whose matches all score Boost
+type ConstantScoreQueryClause struct {
+	BaseQuery
+	Filter Query
+	Boost  float64
+}
+
+func (q *ConstantScoreQueryClause) MarshalJSON() ([]byte, error) {
+	data, err := q.Filter.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	var filter interface{}
+	if err := json.Unmarshal(data, &filter); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{
+		"constant_score": map[string]interface{}{
+			"filter": filter,
+			"boost":  q.Boost,
+		},
+	})
+}
+
+// WildcardQueryClause represents a wildcard query
+type WildcardQueryClause struct {
+	BaseQuery
+	Field string
+	Value string // Pattern where * matches any characters and ? matches one
+}
+
+func (q *WildcardQueryClause) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"wildcard": map[string]interface{}{
+			q.Field: map[string]interface{}{
+				"value": q.Value,
+			},
+		},
+	})
+}
+
+// FuzzyQueryClause represents a fuzzy query
+type FuzzyQueryClause struct {
+	BaseQuery
+	Field     string
+	Value     string
+	Fuzziness interface{} // 0, 1, 2 or "AUTO"; nil uses the default
+}
+
+func (q *FuzzyQueryClause) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{
+		"value": q.Value,
+	}
+	if q.Fuzziness != nil {
+		body["fuzziness"] = q.Fuzziness
+	}
+	return json.Marshal(map[string]interface{}{
+		"fuzzy": map[string]interface{}{
+			q.Field: body,
+		},
+	})
+}
+
+// QueryStringClause represents a query written in Lucene query syntax
+type QueryStringClause struct {
+	BaseQuery
+	Query        string // Lucene-style query, e.g. "title:foo AND bar"
+	DefaultField string // Field for unscoped terms; empty searches all fields
+}
+
+func (q *QueryStringClause) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{
+		"query": q.Query,
+	}
+	if q.DefaultField != "" {
+		body["default_field"] = q.DefaultField
+	}
+	return json.Marshal(map[string]interface{}{
+		"query_string": body,
+	})
+}
+
+// SimpleQueryStringClause represents a query in simple_query_string syntax,
+// where + and - mark required and prohibited terms
+type SimpleQueryStringClause struct {
+	BaseQuery
+	Query        string // Simple query, e.g. "foo + bar -baz"
+	DefaultField string // Field to search; empty searches all fields
+}
+
+func (q *SimpleQueryStringClause) MarshalJSON() ([]byte, error) {
+	body := map[string]interface{}{
+		"query": q.Query,
+	}
+	if q.DefaultField != "" {
+		body["default_field"] = q.DefaultField
+	}
+	return json.Marshal(map[string]interface{}{
+		"simple_query_string": body,
+	})
+}
+
 func ParseQuery(data []byte) (Query, error) {
 	var wrapper struct {
 		Query json.RawMessage `json:"query"`
@@ -331,7 +532,7 @@ func parseQueryClause(data []byte, ctx *queryContext) (Query, error) {
 
 	// Validate that we have exactly one query type
 	if len(raw) != 1 {
-		return nil, fmt.Errorf("query must have exactly one query type")
+		return nil, wrapParseError(fmt.Errorf("query must have exactly one query type"), ctx.path)
 	}
 
 	for queryType, value := range raw {
@@ -340,22 +541,43 @@ func parseQueryClause(data []byte, ctx *queryContext) (Query, error) {
 			return nil, fmt.Errorf("failed to marshal query value: %v", err)
 		}
 
+		var query Query
 		switch queryType {
 		case "match":
-			return parseMatchQuery(valueBytes, ctx)
+			query, err = parseMatchQuery(valueBytes, ctx)
 		case "term":
-			return parseTermQuery(valueBytes, ctx)
+			query, err = parseTermQuery(valueBytes, ctx)
+		case "terms":
+			query, err = parseTermsQuery(valueBytes, ctx)
 		case "range":
-			return parseRangeQuery(valueBytes, ctx)
+			query, err = parseRangeQuery(valueBytes, ctx)
 		case "bool":
-			return parseBoolQuery(raw, ctx)
+			query, err = parseBoolQuery(raw, ctx)
 		case "match_all":
-			return parseMatchAllQuery(valueBytes, ctx)
+			query, err = parseMatchAllQuery(valueBytes, ctx)
 		case "prefix":
-			return parsePrefixQuery(valueBytes, ctx)
+			query, err = parsePrefixQuery(valueBytes, ctx)
+		case "exists":
+			query, err = parseExistsQuery(valueBytes, ctx)
+		case "ids":
+			query, err = parseIdsQuery(valueBytes, ctx)
+		case "wildcard":
+			query, err = parseWildcardQuery(valueBytes, ctx)
+		case "fuzzy":
+			query, err = parseFuzzyQuery(valueBytes, ctx)
+		case "query_string":
+			query, err = parseQueryStringQuery(valueBytes, ctx)
+		case "simple_query_string":
+			query, err = parseSimpleQueryStringQuery(valueBytes, ctx)
+		case "constant_score":
+			query, err = parseConstantScoreQuery(valueBytes, ctx)
 		default:
-			return nil, fmt.Errorf("unsupported query type: %s", queryType)
+			err = fmt.Errorf("unsupported query type: %s", queryType)
 		}
+		if err != nil {
+			return nil, wrapParseError(err, clausePath(ctx.path, queryType, value))
+		}
+		return query, nil
 	}
 
 	return nil, fmt.Errorf("invalid query structure")
@@ -373,6 +595,8 @@ func parseMatchQuery(data []byte, ctx *queryContext) (Query, error) {
 
 	var field string
 	var value interface{}
+	var operator string
+	var boost float64
 
 	for f, v := range raw {
 		field = f
@@ -386,6 +610,19 @@ func parseMatchQuery(data []byte, ctx *queryContext) (Query, error) {
 				// If no query field is present, use the value directly
 				value = val
 			}
+			if op, ok := val["operator"]; ok {
+				opStr, isString := op.(string)
+				if !isString || (!strings.EqualFold(opStr, "and") && !strings.EqualFold(opStr, "or")) {
+					return nil, fmt.Errorf("match query operator must be \"and\" or \"or\", got %v", op)
+				}
+				operator = strings.ToLower(opStr)
+			}
+			if b, ok := val["boost"]; ok {
+				var err error
+				if boost, err = parseBoost(b); err != nil {
+					return nil, err
+				}
+			}
 		default:
 			value = val
 		}
@@ -399,9 +636,20 @@ func parseMatchQuery(data []byte, ctx *queryContext) (Query, error) {
 		BaseQuery: BaseQuery{queryType: MatchQuery},
 		Field:     field,
 		Value:     value,
+		Operator:  operator,
+		Boost:     boost,
 	}, nil
 }
 
+// parseBoost parses the boost of a query clause, a non-negative number
+func parseBoost(value interface{}) (float64, error) {
+	boost, ok := value.(float64)
+	if !ok || boost < 0 {
+		return 0, fmt.Errorf("boost must be a non-negative number")
+	}
+	return boost, nil
+}
+
 func parseTermQuery(data []byte, ctx *queryContext) (Query, error) {
 	var raw map[string]interface{}
 	if err := json.Unmarshal(data, &raw); err != nil {
@@ -414,6 +662,7 @@ func parseTermQuery(data []byte, ctx *queryContext) (Query, error) {
 
 	var field string
 	var value interface{}
+	var boost float64
 
 	for f, v := range raw {
 		field = f
@@ -425,6 +674,12 @@ func parseTermQuery(data []byte, ctx *queryContext) (Query, error) {
 				// If no value field is present, use the value directly
 				value = val
 			}
+			if b, ok := val["boost"]; ok {
+				var err error
+				if boost, err = parseBoost(b); err != nil {
+					return nil, err
+				}
+			}
 		default:
 			value = val
 		}
@@ -438,6 +693,7 @@ func parseTermQuery(data []byte, ctx *queryContext) (Query, error) {
 		BaseQuery: BaseQuery{queryType: TermQuery},
 		Field:     field,
 		Value:     value,
+		Boost:     boost,
 	}, nil
 }
 
@@ -510,68 +766,48 @@ func parseBoolQuery(data map[string]interface{}, ctx *queryContext) (Query, erro
 		switch key {
 		case "must", "should", "must_not", "filter":
 			// Valid keys
+		case "minimum_should_match":
+			n, ok := boolClauses[key].(float64)
+			if !ok || n < 0 || n != math.Trunc(n) {
+				return nil, fmt.Errorf("minimum_should_match must be a non-negative integer")
+			}
+			boolQuery.MinimumShouldMatch = int(n)
 		default:
 			return nil, fmt.Errorf("invalid bool query clause: %s", key)
 		}
 	}
 
-	// Process must clauses
-	if mustClauses, ok := boolClauses["must"].([]interface{}); ok {
-		for _, clause := range mustClauses {
-			clauseBytes, err := json.Marshal(clause)
-			if err != nil {
-				return nil, fmt.Errorf("failed to marshal must clause: %v", err)
-			}
-			query, err := parseQueryClause(clauseBytes, ctx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse must clause: %v", err)
-			}
-			boolQuery.Must = append(boolQuery.Must, query)
+	// Process must, should, must_not and filter clauses
+	for _, kind := range []string{"must", "should", "must_not", "filter"} {
+		clauses, ok := boolClauses[kind].([]interface{})
+		if !ok {
+			continue
 		}
-	}
-
-	// Process should clauses
-	if shouldClauses, ok := boolClauses["should"].([]interface{}); ok {
-		for _, clause := range shouldClauses {
+		for i, clause := range clauses {
 			clauseBytes, err := json.Marshal(clause)
 			if err != nil {
-				return nil, fmt.Errorf("failed to marshal should clause: %v", err)
+				return nil, fmt.Errorf("failed to marshal %s clause: %v", kind, err)
 			}
-			query, err := parseQueryClause(clauseBytes, ctx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse should clause: %v", err)
-			}
-			boolQuery.Should = append(boolQuery.Should, query)
-		}
-	}
 
-	// Process must_not clauses
-	if mustNotClauses, ok := boolClauses["must_not"].([]interface{}); ok {
-		for _, clause := range mustNotClauses {
-			clauseBytes, err := json.Marshal(clause)
-			if err != nil {
-				return nil, fmt.Errorf("failed to marshal must_not clause: %v", err)
-			}
+			// Parse the clause with its position appended to the path
+			parentPath := ctx.path
+			ctx.path = fmt.Sprintf("%s.bool.%s[%d]", parentPath, kind, i)
 			query, err := parseQueryClause(clauseBytes, ctx)
+			ctx.path = parentPath
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse must_not clause: %v", err)
+				return nil, err
 			}
-			boolQuery.MustNot = append(boolQuery.MustNot, query)
-		}
-	}
 
-	// Process filter clauses
-	if filterClauses, ok := boolClauses["filter"].([]interface{}); ok {
-		for _, clause := range filterClauses {
-			clauseBytes, err := json.Marshal(clause)
-			if err != nil {
-				return nil, fmt.Errorf("failed to marshal filter clause: %v", err)
+			switch kind {
+			case "must":
+				boolQuery.Must = append(boolQuery.Must, query)
+			case "should":
+				boolQuery.Should = append(boolQuery.Should, query)
+			case "must_not":
+				boolQuery.MustNot = append(boolQuery.MustNot, query)
+			case "filter":
+				boolQuery.Filter = append(boolQuery.Filter, query)
 			}
-			query, err := parseQueryClause(clauseBytes, ctx)
-			if err != nil {
-				return nil, fmt.Errorf("failed to parse filter clause: %v", err)
-			}
-			boolQuery.Filter = append(boolQuery.Filter, query)
 		}
 	}
 
@@ -633,6 +869,295 @@ func parsePrefixQuery(data []byte, ctx *queryContext) (Query, error) {
 	}, nil
 }
 
+func parseTermsQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if len(raw) != 1 {
+		return nil, fmt.Errorf("terms query must have exactly one field")
+	}
+
+	var field string
+	var values []string
+
+	for f, v := range raw {
+		field = f
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("terms value must be an array, got %T", v)
+		}
+		if len(list) == 0 {
+			return nil, fmt.Errorf("terms value cannot be empty")
+		}
+		for _, item := range list {
+			term, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("terms values must be strings, got %T", item)
+			}
+			values = append(values, term)
+		}
+	}
+
+	if field == "" {
+		return nil, fmt.Errorf("field name cannot be empty")
+	}
+
+	if err := ctx.checkAndAddField("terms", field); err != nil {
+		return nil, err
+	}
+
+	return &TermsQueryClause{
+		BaseQuery: BaseQuery{queryType: TermsQuery},
+		Field:     field,
+		Values:    values,
+	}, nil
+}
+
+func parseIdsQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	list, ok := raw["values"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("ids query must specify an array of values")
+	}
+	if len(raw) != 1 {
+		return nil, fmt.Errorf("ids query only supports the values parameter")
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("ids values cannot be empty")
+	}
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		id, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("ids values must be strings, got %T", item)
+		}
+		values = append(values, id)
+	}
+
+	return &IdsQueryClause{
+		BaseQuery: BaseQuery{queryType: IdsQuery},
+		Values:    values,
+	}, nil
+}
+
+func parseExistsQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	field, ok := raw["field"].(string)
+	if !ok || field == "" {
+		return nil, fmt.Errorf("exists query must specify a non-empty field")
+	}
+	if len(raw) != 1 {
+		return nil, fmt.Errorf("exists query only supports the field parameter")
+	}
+
+	if err := ctx.checkAndAddField("exists", field); err != nil {
+		return nil, err
+	}
+
+	return &ExistsQueryClause{
+		BaseQuery: BaseQuery{queryType: ExistsQuery},
+		Field:     field,
+	}, nil
+}
+
+func parseConstantScoreQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid constant_score query structure")
+	}
+
+	constantScore := &ConstantScoreQueryClause{
+		BaseQuery: BaseQuery{queryType: ConstantScoreQuery},
+		Boost:     1.0,
+	}
+	for key, value := range raw {
+		switch key {
+		case "filter":
+		case "boost":
+			var boost interface{}
+			if err := json.Unmarshal(value, &boost); err != nil {
+				return nil, err
+			}
+			var err error
+			if constantScore.Boost, err = parseBoost(boost); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported constant_score parameter: %s", key)
+		}
+	}
+
+	filter, ok := raw["filter"]
+	if !ok {
+		return nil, fmt.Errorf("constant_score query must specify a filter query")
+	}
+	parentPath := ctx.path
+	ctx.path = parentPath + ".constant_score.filter"
+	query, err := parseQueryClause(filter, ctx)
+	ctx.path = parentPath
+	if err != nil {
+		return nil, err
+	}
+	constantScore.Filter = query
+	return constantScore, nil
+}
+
+func parseWildcardQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if len(raw) != 1 {
+		return nil, fmt.Errorf("wildcard query must have exactly one field")
+	}
+
+	var field string
+	var value interface{}
+
+	for f, v := range raw {
+		field = f
+		value = v
+		if obj, ok := v.(map[string]interface{}); ok {
+			value = obj["value"]
+			if value == nil {
+				value = obj["wildcard"]
+			}
+		}
+	}
+
+	if field == "" {
+		return nil, fmt.Errorf("field name cannot be empty")
+	}
+
+	pattern, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("wildcard value must be a string, got %T", value)
+	}
+	if pattern == "" {
+		return nil, fmt.Errorf("wildcard value cannot be empty")
+	}
+
+	if err := ctx.checkAndAddField("wildcard", field); err != nil {
+		return nil, err
+	}
+
+	return &WildcardQueryClause{
+		BaseQuery: BaseQuery{queryType: WildcardQuery},
+		Field:     field,
+		Value:     pattern,
+	}, nil
+}
+
+func parseFuzzyQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	if len(raw) != 1 {
+		return nil, fmt.Errorf("fuzzy query must have exactly one field")
+	}
+
+	var field string
+	var value interface{}
+	var fuzziness interface{}
+
+	for f, v := range raw {
+		field = f
+		value = v
+		if obj, ok := v.(map[string]interface{}); ok {
+			value = obj["value"]
+			fuzziness = obj["fuzziness"]
+		}
+	}
+
+	if field == "" {
+		return nil, fmt.Errorf("field name cannot be empty")
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("fuzzy value must be a string, got %T", value)
+	}
+	if text == "" {
+		return nil, fmt.Errorf("fuzzy value cannot be empty")
+	}
+	if fuzziness != nil {
+		if _, err := query.ParseFuzziness(fuzziness); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.checkAndAddField("fuzzy", field); err != nil {
+		return nil, err
+	}
+
+	return &FuzzyQueryClause{
+		BaseQuery: BaseQuery{queryType: FuzzyQuery},
+		Field:     field,
+		Value:     text,
+		Fuzziness: fuzziness,
+	}, nil
+}
+
+func parseQueryStringQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw struct {
+		Query        *string `json:"query"`
+		DefaultField string  `json:"default_field"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid query_string query: %v", err)
+	}
+
+	if raw.Query == nil {
+		return nil, fmt.Errorf("query_string query must specify a query")
+	}
+
+	// Reject queries the Lucene-style parser can't handle up front
+	if _, err := query.NewParser(raw.DefaultField).Parse(*raw.Query); err != nil {
+		return nil, fmt.Errorf("failed to parse query_string: %v", err)
+	}
+
+	return &QueryStringClause{
+		BaseQuery:    BaseQuery{queryType: QueryStringQuery},
+		Query:        *raw.Query,
+		DefaultField: raw.DefaultField,
+	}, nil
+}
+
+func parseSimpleQueryStringQuery(data []byte, ctx *queryContext) (Query, error) {
+	var raw struct {
+		Query        *string `json:"query"`
+		DefaultField string  `json:"default_field"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid simple_query_string query: %v", err)
+	}
+
+	// Only the structure is checked; the query syntax itself never fails
+	if raw.Query == nil {
+		return nil, fmt.Errorf("simple_query_string query must specify a query")
+	}
+
+	return &SimpleQueryStringClause{
+		BaseQuery:    BaseQuery{queryType: SimpleQueryStringQuery},
+		Query:        *raw.Query,
+		DefaultField: raw.DefaultField,
+	}, nil
+}
+
 func parseMatchAllQuery(data []byte, ctx *queryContext) (Query, error) {
 	return &MatchAllQueryClause{
 		BaseQuery: BaseQuery{queryType: MatchAllQuery},
@@ -2,6 +2,7 @@ package elastic
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -77,6 +78,34 @@ func TestParseQuery(t *testing.T) {
 			}`,
 			wantErr: false,
 		},
+		{
+			name: "match query with operator",
+			query: `{
+				"query": {
+					"match": {
+						"title": {
+							"query": "golang programming",
+							"operator": "AND"
+						}
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "match query with invalid operator",
+			query: `{
+				"query": {
+					"match": {
+						"title": {
+							"query": "golang programming",
+							"operator": "xor"
+						}
+					}
+				}
+			}`,
+			wantErr: true,
+		},
 		{
 			name: "invalid query structure",
 			query: `{
@@ -84,6 +113,160 @@ func TestParseQuery(t *testing.T) {
 			}`,
 			wantErr: true,
 		},
+		{
+			name: "query_string query",
+			query: `{
+				"query": {
+					"query_string": {
+						"query": "title:golang AND programming",
+						"default_field": "content"
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "simple_query_string with malformed syntax",
+			query: `{
+				"query": {
+					"simple_query_string": {
+						"query": "golang + -- \"unclosed ((",
+						"default_field": "content"
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "terms query",
+			query: `{
+				"query": {
+					"terms": {
+						"status": ["active", "pending"]
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "terms query with empty list",
+			query: `{
+				"query": {
+					"terms": {
+						"status": []
+					}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "terms query with non-string value",
+			query: `{
+				"query": {
+					"terms": {
+						"status": ["active", 1]
+					}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "ids query",
+			query: `{
+				"query": {
+					"ids": {
+						"values": ["1", "3", "7"]
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "ids query with non-string value",
+			query: `{
+				"query": {
+					"ids": {
+						"values": ["1", 3]
+					}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "exists query",
+			query: `{
+				"query": {
+					"exists": {
+						"field": "author"
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "exists query without field",
+			query: `{
+				"query": {
+					"exists": {}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "wildcard query",
+			query: `{
+				"query": {
+					"wildcard": {
+						"title": {"value": "te*t"}
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "wildcard query with empty pattern",
+			query: `{
+				"query": {
+					"wildcard": {
+						"title": ""
+					}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "fuzzy query",
+			query: `{
+				"query": {
+					"fuzzy": {
+						"title": {"value": "quik", "fuzziness": 1}
+					}
+				}
+			}`,
+			wantErr: false,
+		},
+		{
+			name: "fuzzy query with invalid fuzziness",
+			query: `{
+				"query": {
+					"fuzzy": {
+						"title": {"value": "quik", "fuzziness": 5}
+					}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "query_string without query",
+			query: `{
+				"query": {
+					"query_string": {
+						"default_field": "content"
+					}
+				}
+			}`,
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -104,6 +287,9 @@ func TestParseQuery(t *testing.T) {
 					if q.Type() != MatchQuery {
 						t.Errorf("Expected MatchQuery type, got %v", q.Type())
 					}
+					if tt.name == "match query with operator" && q.Operator != "and" {
+						t.Errorf("Expected operator and, got %q", q.Operator)
+					}
 				case *TermQueryClause:
 					if q.Type() != TermQuery {
 						t.Errorf("Expected TermQuery type, got %v", q.Type())
@@ -116,12 +302,44 @@ func TestParseQuery(t *testing.T) {
 					if q.Type() != BoolQuery {
 						t.Errorf("Expected BoolQuery type, got %v", q.Type())
 					}
+				case *QueryStringClause:
+					if q.Type() != QueryStringQuery || q.DefaultField != "content" {
+						t.Errorf("Expected QueryStringQuery with default field content, got %v %q", q.Type(), q.DefaultField)
+					}
 				}
 			}
 		})
 	}
 }
 
+func TestParseQueryErrorPath(t *testing.T) {
+	input := `{
+		"query": {
+			"bool": {
+				"must": [
+					{"match": {"title": "golang"}},
+					{"range": {"age": 30}}
+				]
+			}
+		}
+	}`
+
+	_, err := ParseQuery([]byte(input))
+	if err == nil {
+		t.Fatal("ParseQuery() expected error for malformed range clause")
+	}
+	parseErr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("ParseQuery() error type = %T, want *ParseError", err)
+	}
+	if want := "query.bool.must[1].range.age"; parseErr.Path != want {
+		t.Errorf("ParseError.Path = %s, want %s", parseErr.Path, want)
+	}
+	if !strings.Contains(err.Error(), "query.bool.must[1].range.age") {
+		t.Errorf("error %q does not contain the clause path", err.Error())
+	}
+}
+
 func TestParseComplexQueries(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -246,6 +464,103 @@ func TestParseComplexQueries(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "Bool query with minimum_should_match",
+			query: `{
+				"query": {
+					"bool": {
+						"should": [
+							{"term": {"language": "go"}},
+							{"term": {"topic": "search"}},
+							{"term": {"status": "active"}}
+						],
+						"minimum_should_match": 2
+					}
+				}
+			}`,
+			wantErr: false,
+			validate: func(t *testing.T, q Query) {
+				boolQuery, ok := q.(*BoolQueryClause)
+				if !ok {
+					t.Fatal("Expected BoolQueryClause")
+				}
+				if len(boolQuery.Should) != 3 {
+					t.Errorf("Expected 3 should clauses, got %d", len(boolQuery.Should))
+				}
+				if boolQuery.MinimumShouldMatch != 2 {
+					t.Errorf("Expected minimum_should_match 2, got %d", boolQuery.MinimumShouldMatch)
+				}
+			},
+		},
+		{
+			name: "Boosted match query",
+			query: `{
+				"query": {
+					"match": {
+						"title": {"query": "go", "boost": 2}
+					}
+				}
+			}`,
+			wantErr: false,
+			validate: func(t *testing.T, q Query) {
+				matchQuery, ok := q.(*MatchQueryClause)
+				if !ok {
+					t.Fatal("Expected MatchQueryClause")
+				}
+				if matchQuery.Boost != 2 {
+					t.Errorf("Expected boost 2, got %v", matchQuery.Boost)
+				}
+			},
+		},
+		{
+			name: "Constant score query",
+			query: `{
+				"query": {
+					"constant_score": {
+						"filter": {"term": {"status": "active"}},
+						"boost": 2.0
+					}
+				}
+			}`,
+			wantErr: false,
+			validate: func(t *testing.T, q Query) {
+				constantScore, ok := q.(*ConstantScoreQueryClause)
+				if !ok {
+					t.Fatal("Expected ConstantScoreQueryClause")
+				}
+				if constantScore.Boost != 2.0 {
+					t.Errorf("Expected boost 2.0, got %v", constantScore.Boost)
+				}
+				if termQuery, ok := constantScore.Filter.(*TermQueryClause); !ok || termQuery.Field != "status" {
+					t.Errorf("Expected a term filter on status, got %#v", constantScore.Filter)
+				}
+			},
+		},
+		{
+			name: "Invalid - constant score without filter",
+			query: `{
+				"query": {
+					"constant_score": {
+						"boost": 2.0
+					}
+				}
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "Invalid - fractional minimum_should_match",
+			query: `{
+				"query": {
+					"bool": {
+						"should": [
+							{"term": {"tags": "go"}}
+						],
+						"minimum_should_match": 1.5
+					}
+				}
+			}`,
+			wantErr: true,
+		},
 		{
 			name: "Invalid - duplicate must clauses",
 			query: `{
@@ -0,0 +1,80 @@
+package index
+
+import (
+	"my-indexer/document"
+)
+
+// fieldLengthsOf returns the number of indexed terms in each text field of a
+// document, counting the terms that survive the token limit
+// Note: Caller must hold read lock
+func (idx *Index) fieldLengthsOf(doc *document.Document) map[string]int {
+	lengths := make(map[string]int)
+	for _, field := range doc.IndexedFields() {
+		if len(field.Strings()) == 0 && len(field.Dates()) == 0 {
+			continue
+		}
+		lengths[field.Name] = len(idx.limitTokens(idx.analyzeField(field)))
+	}
+	return lengths
+}
+
+// trackLengths records the field lengths of a document
+// Note: Caller must hold write lock
+func (idx *Index) trackLengths(docID int, lengths map[string]int) {
+	idx.untrackLengths(docID)
+	idx.fieldLengths[docID] = lengths
+	for _, length := range lengths {
+		idx.totalLength += length
+	}
+}
+
+// untrackLengths forgets the field lengths of a document
+// Note: Caller must hold write lock
+func (idx *Index) untrackLengths(docID int) {
+	for _, length := range idx.fieldLengths[docID] {
+		idx.totalLength -= length
+	}
+	delete(idx.fieldLengths, docID)
+}
+
+// rebuildLengths recomputes the field lengths from the stored documents
+// Note: Caller must hold write lock
+func (idx *Index) rebuildLengths() {
+	idx.fieldLengths = make(map[int]map[string]int)
+	idx.totalLength = 0
+	for docID, doc := range idx.docIDMap {
+		idx.trackLengths(docID, idx.fieldLengthsOf(doc))
+	}
+}
+
+// FieldLength returns the number of terms indexed for a field of a document
+func (idx *Index) FieldLength(docID int, field string) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.fieldLengths[docID][field]
+}
+
+// DocumentLength returns the number of terms indexed for a document across
+// all of its fields
+func (idx *Index) DocumentLength(docID int) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	length := 0
+	for _, fieldLength := range idx.fieldLengths[docID] {
+		length += fieldLength
+	}
+	return length
+}
+
+// AverageDocumentLength returns the mean number of terms indexed per
+// document, or 0 for an empty index
+func (idx *Index) AverageDocumentLength() float64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if len(idx.fieldLengths) == 0 {
+		return 0
+	}
+	return float64(idx.totalLength) / float64(len(idx.fieldLengths))
+}
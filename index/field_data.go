@@ -0,0 +1,73 @@
+package index
+
+import (
+	"sync"
+)
+
+// FieldData holds the value of a single field for every document, indexed by
+// document ID, so sorting and aggregations don't have to load each document
+type FieldData struct {
+	Field      string        // Name of the field
+	Generation uint64        // Index generation the values were built from
+	values     []interface{} // Field values indexed by document ID
+	present    []bool        // Whether the document at each ID has the field
+}
+
+// Value returns the field value of a document and whether it has one
+func (fd *FieldData) Value(docID int) (interface{}, bool) {
+	if docID < 0 || docID >= len(fd.values) || !fd.present[docID] {
+		return nil, false
+	}
+	return fd.values[docID], true
+}
+
+// fieldDataCache holds the field data built so far, keyed by field name
+type fieldDataCache struct {
+	mu     sync.Mutex
+	fields map[string]*FieldData
+	builds int // Number of times field data was built, for tests and benchmarks
+}
+
+// newFieldDataCache creates an empty field data cache
+func newFieldDataCache() *fieldDataCache {
+	return &fieldDataCache{
+		fields: make(map[string]*FieldData),
+	}
+}
+
+// FieldData returns the per-document values of a field. The values are built
+// on first use and reused until a write changes the index generation.
+// The returned FieldData must be treated as read-only.
+func (idx *Index) FieldData(field string) *FieldData {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	idx.fieldData.mu.Lock()
+	defer idx.fieldData.mu.Unlock()
+
+	if fd, exists := idx.fieldData.fields[field]; exists && fd.Generation == idx.generation {
+		return fd
+	}
+
+	fd := &FieldData{
+		Field:      field,
+		Generation: idx.generation,
+		values:     make([]interface{}, idx.nextDocID),
+		present:    make([]bool, idx.nextDocID),
+	}
+	for docID, doc := range idx.docIDMap {
+		if docID < 0 || docID >= idx.nextDocID {
+			continue
+		}
+		f, err := doc.GetField(field)
+		if err != nil {
+			continue
+		}
+		fd.values[docID] = f.Value
+		fd.present[docID] = true
+	}
+
+	idx.fieldData.fields[field] = fd
+	idx.fieldData.builds++
+	return fd
+}
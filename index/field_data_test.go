@@ -0,0 +1,118 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"my-indexer/analysis"
+	"my-indexer/document"
+)
+
+// newFieldDataIndex builds an index with n documents carrying a numeric
+// "price" field
+func newFieldDataIndex(tb testing.TB, n int) *Index {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	for i := 0; i < n; i++ {
+		doc := document.NewDocument()
+		doc.AddField("title", fmt.Sprintf("item %d", i))
+		doc.AddField("price", (i*7919)%n)
+		if _, err := idx.AddDocument(doc); err != nil {
+			tb.Fatalf("Failed to add document: %v", err)
+		}
+	}
+	return idx
+}
+
+func TestFieldData(t *testing.T) {
+	idx := newFieldDataIndex(t, 3)
+
+	fd := idx.FieldData("price")
+	for docID := 0; docID < 3; docID++ {
+		if _, ok := fd.Value(docID); !ok {
+			t.Errorf("Expected a price value for document %d", docID)
+		}
+	}
+	if _, ok := fd.Value(99); ok {
+		t.Error("Expected no value for an unknown document")
+	}
+	if _, ok := idx.FieldData("missing").Value(0); ok {
+		t.Error("Expected no value for a missing field")
+	}
+
+	// Repeated lookups reuse the cached field data
+	if idx.FieldData("price") != fd {
+		t.Error("Expected cached field data to be reused")
+	}
+	if idx.fieldData.builds != 2 {
+		t.Errorf("Expected 2 field data builds, got %d", idx.fieldData.builds)
+	}
+
+	// A write invalidates the cache
+	updated := document.NewDocument()
+	updated.AddField("price", 1000)
+	if err := idx.UpdateDocument(0, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	rebuilt := idx.FieldData("price")
+	if rebuilt == fd {
+		t.Fatal("Expected field data to be rebuilt after a write")
+	}
+	if v, _ := rebuilt.Value(0); v != 1000 {
+		t.Errorf("Expected updated price 1000, got %v", v)
+	}
+
+	if err := idx.DeleteDocument(1); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if _, ok := idx.FieldData("price").Value(1); ok {
+		t.Error("Expected no value for a deleted document")
+	}
+}
+
+// sortByPrice sorts docIDs ascending by the value returned from price
+func sortByPrice(docIDs []int, price func(docID int) int) {
+	sort.Slice(docIDs, func(i, j int) bool {
+		return price(docIDs[i]) < price(docIDs[j])
+	})
+}
+
+// BenchmarkSortByField compares sorting on a field by loading documents with
+// sorting through field data. With field data only the first sort touches the
+// documents, which shows up as zero builds/op.
+func BenchmarkSortByField(b *testing.B) {
+	const numDocs = 10000
+	idx := newFieldDataIndex(b, numDocs)
+	docIDs := make([]int, numDocs)
+
+	b.Run("LoadDocuments", func(b *testing.B) {
+		loads := 0
+		for i := 0; i < b.N; i++ {
+			for j := range docIDs {
+				docIDs[j] = numDocs - 1 - j
+			}
+			sortByPrice(docIDs, func(docID int) int {
+				loads++
+				doc, _ := idx.GetDocument(docID)
+				field, _ := doc.GetField("price")
+				return field.Value.(int)
+			})
+		}
+		b.ReportMetric(float64(loads)/float64(b.N), "loads/op")
+	})
+
+	b.Run("FieldData", func(b *testing.B) {
+		builds := idx.fieldData.builds
+		for i := 0; i < b.N; i++ {
+			for j := range docIDs {
+				docIDs[j] = numDocs - 1 - j
+			}
+			fd := idx.FieldData("price")
+			sortByPrice(docIDs, func(docID int) int {
+				v, _ := fd.Value(docID)
+				return v.(int)
+			})
+		}
+		b.ReportMetric(float64(idx.fieldData.builds-builds)/float64(b.N), "builds/op")
+	})
+}
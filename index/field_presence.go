@@ -0,0 +1,57 @@
+package index
+
+import (
+	"sort"
+
+	"my-indexer/document"
+)
+
+// trackFields records docID in the presence set of each of its fields
+// Note: Caller must hold write lock
+func (idx *Index) trackFields(docID int, doc *document.Document) {
+	for name := range doc.IndexedFields() {
+		docs, exists := idx.fieldDocs[name]
+		if !exists {
+			docs = make(map[int]bool)
+			idx.fieldDocs[name] = docs
+		}
+		docs[docID] = true
+	}
+}
+
+// untrackFields removes docID from the presence set of each of its fields
+// Note: Caller must hold write lock
+func (idx *Index) untrackFields(docID int, doc *document.Document) {
+	for name := range doc.IndexedFields() {
+		if docs, exists := idx.fieldDocs[name]; exists {
+			delete(docs, docID)
+			if len(docs) == 0 {
+				delete(idx.fieldDocs, name)
+			}
+		}
+	}
+}
+
+// rebuildFieldPresence recomputes the field presence sets from the stored documents
+// Note: Caller must hold write lock
+func (idx *Index) rebuildFieldPresence() {
+	idx.fieldDocs = make(map[string]map[int]bool)
+	for docID, doc := range idx.docIDMap {
+		idx.trackFields(docID, doc)
+	}
+}
+
+// DocsWithField returns the sorted IDs of documents that contain field, without
+// scanning the documents
+func (idx *Index) DocsWithField(field string) []int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	docs := idx.fieldDocs[field]
+	docIDs := make([]int, 0, len(docs))
+	for docID := range docs {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	return docIDs
+}
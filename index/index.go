@@ -1,8 +1,11 @@
 package index
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"my-indexer/analysis"
 	"my-indexer/document"
@@ -13,15 +16,51 @@ import (
 type PostingList struct {
 	DocFreq int                    // Number of documents containing the term
 	Postings map[int]*PostingEntry // Map of document ID to posting entry
+
+	sorted atomic.Pointer[[]int] // Cached SortedDocIDs, cleared when documents are added or removed
+}
+
+// SortedDocIDs returns the IDs of the documents in the posting list in
+// ascending order, for merging with other posting lists. The IDs are sorted
+// once and cached until the list changes, so the returned slice must be
+// treated as read-only.
+// Note: Caller must hold read lock
+func (pl *PostingList) SortedDocIDs() []int {
+	if cached := pl.sorted.Load(); cached != nil {
+		return *cached
+	}
+
+	docIDs := make([]int, 0, len(pl.Postings))
+	for docID := range pl.Postings {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	// Concurrent readers may both sort the list; they store equal slices
+	pl.sorted.Store(&docIDs)
+	return docIDs
+}
+
+// removeDoc removes a document from the posting list, reporting whether it
+// was in the list
+// Note: Caller must hold write lock
+func (pl *PostingList) removeDoc(docID int) bool {
+	if _, exists := pl.Postings[docID]; !exists {
+		return false
+	}
+	delete(pl.Postings, docID)
+	pl.DocFreq--
+	pl.sorted.Store(nil)
+	return true
 }
 
 // PostingEntry represents a single document entry in a posting list
 type PostingEntry struct {
-	DocID         int       // Document ID
-	TermFreq      int       // Frequency of term in document
-	Positions     []int     // Positions of term in document
-	FieldName     string    // Name of the field containing the term
-	Fields        []string  // Names of the fields containing the term
+	DocID          int              // Document ID
+	TermFreq       int              // Frequency of term in document
+	Positions      []int            // Positions of term in document; set with FieldName when only one field has the term
+	FieldName      string           // Name of the field containing the term
+	Fields         []string         // Names of the fields containing the term
+	FieldPositions map[string][]int // Positions of term within each field
 }
 
 // Index represents an inverted index
@@ -33,6 +72,53 @@ type Index struct {
 	nextDocID     int
 	docIDMap      map[int]*document.Document // Maps document IDs to documents
 	txLog         *txlog.TransactionLog      // Transaction log for crash recovery
+	dedupe        bool                       // Skip adding documents whose content already exists
+	contentHashes map[string]int             // Maps content hashes to document IDs when deduping
+	docHashes     map[int]string             // Maps document IDs to content hashes when deduping
+	versions      map[int]int64              // Per-document version, incremented on every write
+	seqNos        map[int]int64              // Sequence number of each document's last write
+	nextSeqNo     int64                      // Sequence number assigned to the next write
+	generation    uint64                     // Incremented on every change to the indexed documents
+	fieldData     *fieldDataCache            // Lazily built per-field values for sorting and aggregations
+	closed        bool                       // Set by Close; writes are rejected afterwards
+	maxTokens     int                        // Maximum tokens indexed per field; 0 means no limit
+	tokenLimit    TokenLimitMode             // What to do with fields over maxTokens
+	fieldDocs     map[string]map[int]bool    // Per field, the IDs of documents containing it
+	analyzers     map[string]analysis.Analyzer // Per-field analyzers overriding the default
+	mappings      map[string]FieldMapping    // Explicit field mappings, by field name
+	dateDetection bool                       // Index unmapped RFC 3339 strings as dates
+	fieldLengths  map[int]map[string]int     // Per document, the number of terms indexed for each field
+	totalLength   int                        // Sum of all document lengths, for the average used in scoring
+	persistence   Persistence                // Mirrors document writes to storage when set
+	snapshotMu    sync.RWMutex               // Held by writes, and exclusively while a snapshot is taken
+}
+
+var (
+	// ErrIndexClosed is returned by write operations on a closed index
+	ErrIndexClosed = errors.New("index is closed")
+	// ErrTooManyTokens is returned when a field exceeds the token limit in TokenLimitError mode
+	ErrTooManyTokens = errors.New("too many tokens in field")
+	// ErrDocumentExists is returned by CreateDocument when the ID is already in use
+	ErrDocumentExists = errors.New("document already exists")
+	// ErrDocumentNotFound is returned by MergeDocument when the ID is not in use
+	ErrDocumentNotFound = errors.New("document not found")
+)
+
+// TokenLimitMode selects what happens to a field that produces more tokens
+// than the configured maximum
+type TokenLimitMode int
+
+const (
+	// TokenLimitTruncate indexes the first tokens up to the limit and drops the rest
+	TokenLimitTruncate TokenLimitMode = iota
+	// TokenLimitError rejects the document
+	TokenLimitError
+)
+
+// DocVersion holds the version metadata of a document
+type DocVersion struct {
+	Version int64 // Starts at 1 and is incremented by each update
+	SeqNo   int64 // Index-wide sequence number of the document's last write
 }
 
 // NewIndex creates a new inverted index
@@ -41,9 +127,18 @@ func NewIndex(analyzer analysis.Analyzer) *Index {
 		analyzer = analysis.NewStandardAnalyzer()
 	}
 	return &Index{
-		terms:     make(map[string]*PostingList),
-		analyzer:  analyzer,
-		docIDMap:  make(map[int]*document.Document),
+		terms:         make(map[string]*PostingList),
+		analyzer:      analyzer,
+		docIDMap:      make(map[int]*document.Document),
+		contentHashes: make(map[string]int),
+		docHashes:     make(map[int]string),
+		versions:      make(map[int]int64),
+		seqNos:        make(map[int]int64),
+		fieldData:     newFieldDataCache(),
+		fieldDocs:     make(map[string]map[int]bool),
+		analyzers:     make(map[string]analysis.Analyzer),
+		mappings:      make(map[string]FieldMapping),
+		fieldLengths:  make(map[int]map[string]int),
 	}
 }
 
@@ -61,6 +156,9 @@ func (idx *Index) InitTransactionLog(logDir string) error {
 
 // recover processes any pending operations from the transaction log
 func (idx *Index) recover() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
 	fmt.Printf("recover: Starting recovery process\n")
 	if idx.txLog == nil {
 		fmt.Printf("recover: No transaction log present, skipping recovery\n")
@@ -73,15 +171,18 @@ func (idx *Index) recover() error {
 		return fmt.Errorf("failed to recover from transaction log: %v", err)
 	}
 
-	// Reset index state
-	fmt.Printf("recover: Resetting index state\n")
-	idx.terms = make(map[string]*PostingList)
-	idx.docIDMap = make(map[int]*document.Document)
-	idx.docCount = 0
-	idx.nextDocID = 0
+	// Remember the ID space of a previously loaded index so replaying a
+	// shorter log can't hand out its IDs again
+	loadedNextDocID := idx.nextDocID
+
+	// The log holds the writes since the last snapshot, so it is replayed on
+	// top of the documents already loaded. Replaying a write the snapshot
+	// already covers leaves the document as it was.
+	idx.generation++
 
 	fmt.Printf("recover: Processing %d entries in chronological order\n", len(entries))
-	// Process entries in chronological order
+	// Process entries in chronological order, replaying them through the same
+	// code paths as live writes so postings, field data and lengths match
 	for _, entry := range entries {
 		if !entry.Committed {
 			continue
@@ -90,99 +191,34 @@ func (idx *Index) recover() error {
 		fmt.Printf("recover: Processing entry [Operation: %s, DocID: %d, Committed: %v]\n", 
 			entry.Operation, entry.DocumentID, entry.Committed)
 		switch entry.Operation {
-		case txlog.OpAdd:
-			if entry.Document != nil {
-				// Create a new document and copy all fields
-				newDoc := document.NewDocument()
-				for _, field := range entry.Document.GetFields() {
-					newDoc.AddField(field.Name, field.Value)
-				}
-				
-				// Use the original document ID from the log entry
-				idx.docIDMap[entry.DocumentID] = newDoc
-				idx.docCount++
-				
-				// Index the document terms
-				docTermFreqs := make(map[string]int)
-				for _, field := range newDoc.GetFields() {
-					fieldValue, ok := field.Value.(string)
-					if !ok {
-						continue
-					}
-					
-					tokens := idx.analyzer.Analyze(fieldValue)
-					for _, token := range tokens {
-						docTermFreqs[token.Text]++
-					}
-				}
-				
-				// Update posting lists
-				for term, freq := range docTermFreqs {
-					postingList, exists := idx.terms[term]
-					if !exists {
-						postingList = &PostingList{
-							Postings: make(map[int]*PostingEntry),
-						}
-						idx.terms[term] = postingList
-					}
-					
-					postingEntry := &PostingEntry{
-						DocID:    entry.DocumentID,
-						TermFreq: freq,
-					}
-					postingList.Postings[entry.DocumentID] = postingEntry
-					postingList.DocFreq++
-				}
+		case txlog.OpAdd, txlog.OpUpdate:
+			if entry.Document == nil {
+				continue
 			}
-		case txlog.OpUpdate:
-			if entry.Document != nil {
-				// Create a new document and copy all fields
-				newDoc := document.NewDocument()
-				for _, field := range entry.Document.GetFields() {
-					if err := newDoc.AddField(field.Name, field.Value); err != nil {
-						return fmt.Errorf("failed to restore field %s: %v", field.Name, err)
-					}
-				}
-			
-				// Store document directly in map since we're recovering
-				idx.docIDMap[entry.DocumentID] = newDoc
-				
-				// Index the document terms
-				docTermFreqs := make(map[string]int)
-				for _, field := range newDoc.GetFields() {
-					fieldValue, ok := field.Value.(string)
-					if !ok {
-						continue
-					}
-					
-					tokens := idx.analyzer.Analyze(fieldValue)
-					for _, token := range tokens {
-						docTermFreqs[token.Text]++
-					}
-				}
-				
-				// Update posting lists
-				for term, freq := range docTermFreqs {
-					postingList, exists := idx.terms[term]
-					if !exists {
-						postingList = &PostingList{
-							Postings: make(map[int]*PostingEntry),
-						}
-						idx.terms[term] = postingList
-					}
-					
-					postingEntry := &PostingEntry{
-						DocID:    entry.DocumentID,
-						TermFreq: freq,
-					}
-					postingList.Postings[entry.DocumentID] = postingEntry
-					postingList.DocFreq++
+
+			// Create a new document and copy all fields
+			newDoc := document.NewDocument()
+			for _, field := range entry.Document.GetFields() {
+				if err := newDoc.AddField(field.Name, field.Value); err != nil {
+					return fmt.Errorf("failed to restore field %s: %v", field.Name, err)
 				}
 			}
+
+			// Use the original document ID from the log entry. An update of a
+			// document missing from the log, or an add of one already replayed,
+			// still leaves the logged content in place.
+			if _, exists := idx.docIDMap[entry.DocumentID]; exists {
+				err = idx.updateDocumentInternal(entry.DocumentID, newDoc)
+			} else {
+				err = idx.addDocumentAt(entry.DocumentID, newDoc)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to replay %s operation: %v", entry.Operation, err)
+			}
 		case txlog.OpDelete:
 			// Only attempt delete if document exists
 			if _, exists := idx.docIDMap[entry.DocumentID]; exists {
-				if err := idx.deleteDocumentInternal(entry.DocumentID); err != nil {
+				if err := idx.deleteDocumentLocked(entry.DocumentID); err != nil {
 					return fmt.Errorf("failed to replay delete operation: %v", err)
 				}
 			}
@@ -190,18 +226,13 @@ func (idx *Index) recover() error {
 	}
 
 	// Update nextDocID to be after the highest used ID
-	maxID := -1
-	for docID := range idx.docIDMap {
-		if docID > maxID {
-			maxID = docID
-		}
-	}
-	idx.nextDocID = maxID + 1
+	idx.reconcileNextDocID(loadedNextDocID)
 	fmt.Printf("recover: Set nextDocID to %d after scanning existing documents\n", idx.nextDocID)
 
-	fmt.Printf("recover: Recovery completed successfully, truncating log\n")
-	// Clear the log after successful recovery
-	return idx.txLog.Truncate()
+	fmt.Printf("recover: Recovery completed successfully\n")
+	// The log is kept until a snapshot covers the replayed writes; truncating
+	// it here would lose them if the process stopped before the next snapshot
+	return nil
 }
 
 // addDocumentInternal adds a document without transaction logging
@@ -212,49 +243,39 @@ func (idx *Index) addDocumentInternal(doc *document.Document) (int, error) {
 
 	// Note: Caller must hold write lock
 	docID := idx.nextDocID
-	idx.nextDocID++
-	idx.docCount++
-
-	// Store document in map
-	idx.docIDMap[docID] = doc
+	return docID, idx.addDocumentAt(docID, doc)
+}
 
-	// Track total term frequencies across all fields
-	type termInfo struct {
-		freq   int
-		fields []string
-	}
-	docTermInfo := make(map[string]*termInfo)
+// termInfo holds how often a term occurs in a document, in which fields and
+// at which positions
+type termInfo struct {
+	freq      int
+	fields    []string
+	positions map[string][]int
+}
 
-	// First pass: collect term frequencies across all fields
-	for _, field := range doc.GetFields() {
-		fieldValue, ok := field.Value.(string)
-		if !ok {
-			continue
+// addFieldTerms counts the tokens of one field into a document's term info
+func addFieldTerms(docTermInfo map[string]*termInfo, field string, tokens []analysis.Token) {
+	for _, token := range tokens {
+		info, exists := docTermInfo[token.Text]
+		if !exists {
+			info = &termInfo{fields: make([]string, 0), positions: make(map[string][]int)}
+			docTermInfo[token.Text] = info
 		}
-
-		tokens := idx.analyzer.Analyze(fieldValue)
-		for _, token := range tokens {
-			info, exists := docTermInfo[token.Text]
-			if !exists {
-				info = &termInfo{fields: make([]string, 0)}
-				docTermInfo[token.Text] = info
-			}
-			info.freq++
-			// Only add field name once
-			fieldFound := false
-			for _, f := range info.fields {
-				if f == field.Name {
-					fieldFound = true
-					break
-				}
-			}
-			if !fieldFound {
-				info.fields = append(info.fields, field.Name)
-			}
+		info.freq++
+		// Only add field name once
+		if _, fieldFound := info.positions[field]; !fieldFound {
+			info.fields = append(info.fields, field)
 		}
+		info.positions[field] = append(info.positions[field], token.Position)
 	}
+}
 
-	// Second pass: update posting lists
+// addPostings adds a document's terms to the posting lists, recording the
+// fields each term occurs in. FieldName is only set for terms found in a
+// single field.
+// Note: Caller must hold write lock
+func (idx *Index) addPostings(docID int, docTermInfo map[string]*termInfo) {
 	for term, info := range docTermInfo {
 		postingList, exists := idx.terms[term]
 		if !exists {
@@ -265,15 +286,65 @@ func (idx *Index) addDocumentInternal(doc *document.Document) (int, error) {
 		}
 
 		entry := &PostingEntry{
-			DocID:    docID,
-			TermFreq: info.freq,
-			Fields:   info.fields,
+			DocID:          docID,
+			TermFreq:       info.freq,
+			Fields:         info.fields,
+			FieldPositions: info.positions,
+		}
+		if len(info.fields) == 1 {
+			entry.FieldName = info.fields[0]
+			entry.Positions = info.positions[entry.FieldName]
+		}
+		if _, exists := postingList.Postings[docID]; !exists {
+			postingList.DocFreq++
+			postingList.sorted.Store(nil)
 		}
 		postingList.Postings[docID] = entry
-		postingList.DocFreq++
 	}
+}
 
-	return docID, nil
+// addDocumentAt indexes a document under an unused document ID
+// Note: Caller must hold write lock
+func (idx *Index) addDocumentAt(docID int, doc *document.Document) error {
+	doc, err := idx.applyMapping(doc)
+	if err != nil {
+		return err
+	}
+
+	// Track total term frequencies across all fields
+	docTermInfo := make(map[string]*termInfo)
+	lengths := make(map[string]int)
+
+	// First pass: collect term frequencies across all fields
+	for _, field := range doc.IndexedFields() {
+		if len(field.Strings()) == 0 && len(field.Dates()) == 0 && len(field.Numbers()) == 0 {
+			continue
+		}
+
+		tokens, err := idx.fieldTokens(field)
+		if err != nil {
+			return err
+		}
+		lengths[field.Name] = len(tokens)
+		addFieldTerms(docTermInfo, field.Name, tokens)
+	}
+
+	if docID >= idx.nextDocID {
+		idx.nextDocID = docID + 1
+	}
+	idx.docCount++
+
+	// Store document in map
+	idx.docIDMap[docID] = doc
+	idx.trackContentHash(docID, doc)
+	idx.trackFields(docID, doc)
+	idx.trackLengths(docID, lengths)
+	idx.bumpVersion(docID)
+
+	// Second pass: update posting lists
+	idx.addPostings(docID, docTermInfo)
+
+	return nil
 }
 
 // AddDocument adds a document to the index with transaction logging
@@ -283,6 +354,9 @@ func (idx *Index) AddDocument(doc *document.Document) (int, error) {
 		return 0, fmt.Errorf("cannot index nil document")
 	}
 
+	idx.snapshotMu.RLock()
+	defer idx.snapshotMu.RUnlock()
+
 	fmt.Printf("AddDocument: Attempting to acquire write lock\n")
 	idx.mu.Lock()
 	fmt.Printf("AddDocument: Write lock acquired\n")
@@ -291,33 +365,133 @@ func (idx *Index) AddDocument(doc *document.Document) (int, error) {
 		fmt.Printf("AddDocument: Released write lock\n")
 	}()
 
+	if idx.closed {
+		return 0, ErrIndexClosed
+	}
+
+	// Skip documents whose content is already indexed
+	if existingID, ok := idx.findDuplicate(doc); ok {
+		return existingID, nil
+	}
+
 	// Get the next document ID under the lock
 	docID := idx.nextDocID
 
 	// Handle transaction logging if enabled
 	if idx.txLog != nil {
 		fmt.Printf("AddDocument: Using transaction log\n")
-		if err := idx.txLog.LogOperation(txlog.OpAdd, docID, doc); err != nil {
+		txID, err := idx.txLog.LogOperation(txlog.OpAdd, docID, doc)
+		if err != nil {
 			return 0, fmt.Errorf("failed to log add operation: %v", err)
 		}
 
 		// Add the document with transaction logging
 		id, err := idx.addDocumentInternal(doc)
 		if err != nil {
-			idx.txLog.Rollback(docID)
+			idx.txLog.Rollback(txID)
 			return 0, err
 		}
 
 		// Commit the operation
-		if err := idx.txLog.Commit(docID); err != nil {
+		if err := idx.txLog.Commit(txID); err != nil {
 			return 0, fmt.Errorf("failed to commit add operation: %v", err)
 		}
 
+		idx.persist(id)
 		return id, nil
 	}
 
 	// If no transaction log, add document directly
-	return idx.addDocumentInternal(doc)
+	id, err := idx.addDocumentInternal(doc)
+	if err != nil {
+		return 0, err
+	}
+	idx.persist(id)
+	return id, nil
+}
+
+// PutDocument stores a document under a caller-chosen ID with transaction
+// logging. The document is added if the ID is unused and replaces the existing
+// document otherwise; the result reports whether it was created.
+func (idx *Index) PutDocument(docID int, doc *document.Document) (bool, error) {
+	if doc == nil {
+		return false, fmt.Errorf("cannot index nil document")
+	}
+	if docID < 0 {
+		return false, fmt.Errorf("invalid document ID %d", docID)
+	}
+
+	idx.snapshotMu.RLock()
+	defer idx.snapshotMu.RUnlock()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.closed {
+		return false, ErrIndexClosed
+	}
+
+	_, exists := idx.docIDMap[docID]
+	if exists {
+		return false, idx.applyLogged(txlog.OpUpdate, docID, doc, func() error { return idx.updateDocumentInternal(docID, doc) })
+	}
+	if err := idx.applyLogged(txlog.OpAdd, docID, doc, func() error { return idx.addDocumentAt(docID, doc) }); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CreateDocument stores a new document under a caller-chosen ID with
+// transaction logging. It fails with ErrDocumentExists if the ID is in use.
+func (idx *Index) CreateDocument(docID int, doc *document.Document) error {
+	if doc == nil {
+		return fmt.Errorf("cannot index nil document")
+	}
+	if docID < 0 {
+		return fmt.Errorf("invalid document ID %d", docID)
+	}
+
+	idx.snapshotMu.RLock()
+	defer idx.snapshotMu.RUnlock()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.closed {
+		return ErrIndexClosed
+	}
+	if _, exists := idx.docIDMap[docID]; exists {
+		return ErrDocumentExists
+	}
+	return idx.applyLogged(txlog.OpAdd, docID, doc, func() error { return idx.addDocumentAt(docID, doc) })
+}
+
+// applyLogged runs a write, recording it in the transaction log when one is
+// enabled and rolling the log entry back if the write fails. Successful writes
+// are mirrored to the persistence.
+// Note: Caller must hold write lock
+func (idx *Index) applyLogged(op string, docID int, doc *document.Document, apply func() error) error {
+	if idx.txLog == nil {
+		if err := apply(); err != nil {
+			return err
+		}
+		idx.persist(docID)
+		return nil
+	}
+
+	txID, err := idx.txLog.LogOperation(op, docID, doc)
+	if err != nil {
+		return fmt.Errorf("failed to log %s operation: %v", op, err)
+	}
+
+	if err := apply(); err != nil {
+		idx.txLog.Rollback(txID)
+		return err
+	}
+
+	if err := idx.txLog.Commit(txID); err != nil {
+		return fmt.Errorf("failed to commit %s operation: %v", op, err)
+	}
+	idx.persist(docID)
+	return nil
 }
 
 // updateDocumentInternal updates a document without transaction logging
@@ -331,161 +505,385 @@ func (idx *Index) updateDocumentInternal(docID int, doc *document.Document) erro
 	if !exists {
 		return fmt.Errorf("document with ID %d does not exist", docID)
 	}
-
-	// Remove old document's terms
-	for _, field := range oldDoc.GetFields() {
-		fieldValue, ok := field.Value.(string)
-		if !ok {
-			continue
-		}
-
-		tokens := idx.analyzer.Analyze(fieldValue)
-		for _, token := range tokens {
-			if postingList, exists := idx.terms[token.Text]; exists {
-				if _, exists := postingList.Postings[docID]; exists {
-					delete(postingList.Postings, docID)
-					postingList.DocFreq--
-					if postingList.DocFreq == 0 {
-						delete(idx.terms, token.Text)
-					}
-				}
-			}
-		}
+	doc, err := idx.applyMapping(doc)
+	if err != nil {
+		return err
 	}
 
-	// Add new document's terms
-	docTermFreqs := make(map[string]int)
-	for _, field := range doc.GetFields() {
-		fieldValue, ok := field.Value.(string)
-		if !ok {
+	// Analyze the new document first so a rejected update leaves the old one intact
+	docTermInfo := make(map[string]*termInfo)
+	lengths := make(map[string]int)
+	for _, field := range doc.IndexedFields() {
+		if len(field.Strings()) == 0 && len(field.Dates()) == 0 && len(field.Numbers()) == 0 {
 			continue
 		}
 
-		tokens := idx.analyzer.Analyze(fieldValue)
-		for _, token := range tokens {
-			docTermFreqs[token.Text]++
+		tokens, err := idx.fieldTokens(field)
+		if err != nil {
+			return err
 		}
+		lengths[field.Name] = len(tokens)
+		addFieldTerms(docTermInfo, field.Name, tokens)
 	}
 
-	for term, freq := range docTermFreqs {
-		postingList, exists := idx.terms[term]
-		if !exists {
-			postingList = &PostingList{
-				Postings: make(map[int]*PostingEntry),
+	// Remove old document's terms
+	for _, field := range oldDoc.IndexedFields() {
+		for _, term := range idx.fieldTerms(field) {
+			if postingList, exists := idx.terms[term]; exists {
+				if postingList.removeDoc(docID) && postingList.DocFreq == 0 {
+					delete(idx.terms, term)
+				}
 			}
-			idx.terms[term] = postingList
-		}
-
-		entry := &PostingEntry{
-			DocID:    docID,
-			TermFreq: freq,
-		}
-		postingList.Postings[docID] = entry
-		if _, exists := postingList.Postings[docID]; !exists {
-			postingList.DocFreq++
 		}
 	}
 
+	// Add new document's terms
+	idx.addPostings(docID, docTermInfo)
+
+	idx.untrackContentHash(docID)
+	idx.untrackFields(docID, oldDoc)
 	idx.docIDMap[docID] = doc
+	idx.trackContentHash(docID, doc)
+	idx.trackFields(docID, doc)
+	idx.trackLengths(docID, lengths)
+	idx.bumpVersion(docID)
 	return nil
 }
 
 // UpdateDocument updates a document with transaction logging
 func (idx *Index) UpdateDocument(docID int, doc *document.Document) error {
+	idx.snapshotMu.RLock()
+	defer idx.snapshotMu.RUnlock()
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
+	if idx.closed {
+		return ErrIndexClosed
+	}
+
 	// Log the operation first
 	if idx.txLog != nil {
-		if err := idx.txLog.LogOperation(txlog.OpUpdate, docID, doc); err != nil {
+		txID, err := idx.txLog.LogOperation(txlog.OpUpdate, docID, doc)
+		if err != nil {
 			return fmt.Errorf("failed to log update operation: %v", err)
 		}
 
 		// Update the document
 		if err := idx.updateDocumentInternal(docID, doc); err != nil {
-			idx.txLog.Rollback(docID)
+			idx.txLog.Rollback(txID)
 			return err
 		}
 
 		// Commit the operation
-		if err := idx.txLog.Commit(docID); err != nil {
+		if err := idx.txLog.Commit(txID); err != nil {
 			return fmt.Errorf("failed to commit update operation: %v", err)
 		}
 
+		idx.persist(docID)
 		return nil
 	}
 
 	// If no transaction log, just update the document
-	return idx.updateDocumentInternal(docID, doc)
+	if err := idx.updateDocumentInternal(docID, doc); err != nil {
+		return err
+	}
+	idx.persist(docID)
+	return nil
 }
 
-// deleteDocumentInternal deletes a document without transaction logging
-func (idx *Index) deleteDocumentInternal(docID int) error {
+// MergeDocument applies a partial update with transaction logging: the
+// fields of partial replace those of the stored document and its other fields
+// are kept. The result reports whether the document changed; merging values
+// it already holds is a no-op. It fails with ErrDocumentNotFound if the ID is
+// not in use.
+func (idx *Index) MergeDocument(docID int, partial *document.Document) (bool, error) {
+	if partial == nil {
+		return false, fmt.Errorf("cannot update with nil document")
+	}
+
+	idx.snapshotMu.RLock()
+	defer idx.snapshotMu.RUnlock()
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
+	if idx.closed {
+		return false, ErrIndexClosed
+	}
+	existing, exists := idx.docIDMap[docID]
+	if !exists {
+		return false, ErrDocumentNotFound
+	}
+
+	merged, err := idx.applyMapping(existing.Merge(partial))
+	if err != nil {
+		return false, err
+	}
+	if merged.ContentHash() == existing.ContentHash() {
+		return false, nil
+	}
+	err = idx.applyLogged(txlog.OpUpdate, docID, merged, func() error { return idx.updateDocumentInternal(docID, merged) })
+	return err == nil, err
+}
+
+// deleteDocumentLocked deletes a document without transaction logging
+// Note: Caller must hold write lock
+func (idx *Index) deleteDocumentLocked(docID int) error {
 	doc, exists := idx.docIDMap[docID]
 	if !exists {
 		return fmt.Errorf("document with ID %d does not exist", docID)
 	}
 
 	// Remove document's terms from posting lists
-	for _, field := range doc.GetFields() {
-		fieldValue, ok := field.Value.(string)
-		if !ok {
-			continue
-		}
-
-		tokens := idx.analyzer.Analyze(fieldValue)
-		for _, token := range tokens {
-			if postingList, exists := idx.terms[token.Text]; exists {
-				if _, exists := postingList.Postings[docID]; exists {
-					delete(postingList.Postings, docID)
-					postingList.DocFreq--
-					if postingList.DocFreq == 0 {
-						delete(idx.terms, token.Text)
-					}
+	for _, field := range doc.IndexedFields() {
+		for _, term := range idx.fieldTerms(field) {
+			if postingList, exists := idx.terms[term]; exists {
+				if postingList.removeDoc(docID) && postingList.DocFreq == 0 {
+					delete(idx.terms, term)
 				}
 			}
 		}
 	}
 
+	idx.untrackContentHash(docID)
+	idx.untrackFields(docID, doc)
+	idx.untrackLengths(docID)
 	delete(idx.docIDMap, docID)
+	delete(idx.versions, docID)
+	delete(idx.seqNos, docID)
+	idx.nextSeqNo++
+	idx.generation++
 	idx.docCount--
 	return nil
 }
 
 // DeleteDocument deletes a document with transaction logging
 func (idx *Index) DeleteDocument(docID int) error {
+	idx.snapshotMu.RLock()
+	defer idx.snapshotMu.RUnlock()
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.closed {
+		return ErrIndexClosed
+	}
+
 	// Log the operation first if transaction logging is enabled
 	if idx.txLog != nil {
-		if err := idx.txLog.LogOperation(txlog.OpDelete, docID, nil); err != nil {
+		txID, err := idx.txLog.LogOperation(txlog.OpDelete, docID, nil)
+		if err != nil {
 			return fmt.Errorf("failed to log delete operation: %v", err)
 		}
 
 		// Delete the document
-		if err := idx.deleteDocumentInternal(docID); err != nil {
-			idx.txLog.Rollback(docID)
+		if err := idx.deleteDocumentLocked(docID); err != nil {
+			idx.txLog.Rollback(txID)
 			return err
 		}
 
 		// Commit the operation
-		if err := idx.txLog.Commit(docID); err != nil {
+		if err := idx.txLog.Commit(txID); err != nil {
 			return fmt.Errorf("failed to commit delete operation: %v", err)
 		}
 
+		idx.persist(docID)
 		return nil
 	}
 
 	// If no transaction log, just delete the document
-	return idx.deleteDocumentInternal(docID)
+	if err := idx.deleteDocumentLocked(docID); err != nil {
+		return err
+	}
+	idx.persist(docID)
+	return nil
+}
+
+// SetMaxTokenCount caps the number of tokens indexed per field, like
+// Elasticsearch's index.analyze.max_token_count. Fields over the limit are
+// truncated or rejected depending on mode. A max of 0 or less removes the limit.
+func (idx *Index) SetMaxTokenCount(max int, mode TokenLimitMode) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.maxTokens = max
+	idx.tokenLimit = mode
+}
+
+// positionIncrementGap separates the token positions of consecutive elements
+// of an array field, so phrases can't match across elements
+const positionIncrementGap = 100
+
+// analyzeField runs the string values of a field through the field's
+// analyzer, dropping tokens with no text like AnalyzeToTerms does. The
+// elements of an array field are analyzed in order into one token stream,
+// followed by the canonical tokens of its dates and numbers.
+// Note: Caller must hold read lock
+func (idx *Index) analyzeField(field document.Field) []analysis.Token {
+	analyzer := idx.analyzerFor(field.Name)
+	kept := make([]analysis.Token, 0)
+	offset := 0
+	for i, value := range field.Strings() {
+		if i > 0 && len(kept) > 0 {
+			offset = kept[len(kept)-1].Position + positionIncrementGap
+		}
+		for _, token := range analyzer.Analyze(value) {
+			if token.Text != "" {
+				token.Position += offset
+				kept = append(kept, token)
+			}
+		}
+	}
+
+	// Dates and numbers are indexed unanalyzed, under their canonical tokens
+	var canonical []string
+	for _, date := range field.Dates() {
+		canonical = append(canonical, document.DateToken(date))
+	}
+	for _, n := range field.Numbers() {
+		canonical = append(canonical, document.NumberToken(n))
+	}
+	for _, text := range canonical {
+		position := 0
+		if len(kept) > 0 {
+			position = kept[len(kept)-1].Position + positionIncrementGap
+		}
+		kept = append(kept, analysis.Token{Text: text, Position: position})
+	}
+	return kept
+}
+
+// fieldTerms returns the terms a field's values are indexed under
+// Note: Caller must hold read lock
+func (idx *Index) fieldTerms(field document.Field) []string {
+	var terms []string
+	for _, value := range field.Strings() {
+		terms = append(terms, analysis.AnalyzeToTerms(idx.analyzerFor(field.Name), value)...)
+	}
+	for _, date := range field.Dates() {
+		terms = append(terms, document.DateToken(date))
+	}
+	for _, n := range field.Numbers() {
+		terms = append(terms, document.NumberToken(n))
+	}
+	return terms
+}
+
+// fieldTokens analyzes a field for indexing, applying the token limit
+// Note: Caller must hold read lock
+func (idx *Index) fieldTokens(field document.Field) ([]analysis.Token, error) {
+	tokens := idx.analyzeField(field)
+	if idx.maxTokens > 0 && len(tokens) > idx.maxTokens && idx.tokenLimit == TokenLimitError {
+		return nil, fmt.Errorf("field %s has %d tokens, limit is %d: %w", field.Name, len(tokens), idx.maxTokens, ErrTooManyTokens)
+	}
+	return idx.limitTokens(tokens), nil
+}
+
+// limitTokens truncates tokens to the token limit
+// Note: Caller must hold read lock
+func (idx *Index) limitTokens(tokens []analysis.Token) []analysis.Token {
+	if idx.maxTokens > 0 && len(tokens) > idx.maxTokens {
+		return tokens[:idx.maxTokens]
+	}
+	return tokens
+}
+
+// SetDedupe enables or disables duplicate detection. When enabled, adding a
+// document whose content hash matches an indexed document returns the
+// existing document's ID instead of indexing it again.
+func (idx *Index) SetDedupe(enabled bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.dedupe = enabled
+	idx.resetContentHashes()
+	for docID, doc := range idx.docIDMap {
+		idx.trackContentHash(docID, doc)
+	}
+}
+
+// resetContentHashes clears the duplicate detection maps
+// Note: Caller must hold write lock
+func (idx *Index) resetContentHashes() {
+	idx.contentHashes = make(map[string]int)
+	idx.docHashes = make(map[int]string)
+}
+
+// trackContentHash records a document's content hash when deduping
+// Note: Caller must hold write lock
+func (idx *Index) trackContentHash(docID int, doc *document.Document) {
+	if !idx.dedupe {
+		return
+	}
+	hash := doc.ContentHash()
+	if _, exists := idx.contentHashes[hash]; !exists {
+		idx.contentHashes[hash] = docID
+	}
+	idx.docHashes[docID] = hash
+}
+
+// untrackContentHash forgets a document's content hash
+// Note: Caller must hold write lock
+func (idx *Index) untrackContentHash(docID int) {
+	hash, exists := idx.docHashes[docID]
+	if !exists {
+		return
+	}
+	delete(idx.docHashes, docID)
+	if idx.contentHashes[hash] == docID {
+		delete(idx.contentHashes, hash)
+	}
+}
+
+// findDuplicate returns the ID of an indexed document with the same content
+// Note: Caller must hold read or write lock
+func (idx *Index) findDuplicate(doc *document.Document) (int, bool) {
+	if !idx.dedupe {
+		return 0, false
+	}
+	docID, exists := idx.contentHashes[doc.ContentHash()]
+	return docID, exists
+}
+
+// bumpVersion records a write to a document, incrementing its version and
+// assigning it the next sequence number
+// Note: Caller must hold write lock
+func (idx *Index) bumpVersion(docID int) {
+	idx.versions[docID]++
+	idx.seqNos[docID] = idx.nextSeqNo
+	idx.nextSeqNo++
+	idx.generation++
+}
+
+// GetDocumentVersion returns the version metadata of a document
+func (idx *Index) GetDocumentVersion(docID int) (DocVersion, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	version, exists := idx.versions[docID]
+	if !exists {
+		return DocVersion{}, false
+	}
+	return DocVersion{Version: version, SeqNo: idx.seqNos[docID]}, true
+}
+
+// Generation returns a counter that changes whenever documents are added,
+// updated or deleted, so callers can tell when derived data is stale
+func (idx *Index) Generation() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.generation
 }
 
-// Close closes the index and its transaction log
+// Close closes the index and its transaction log. Writes after Close fail
+// with ErrIndexClosed; closing an already closed index is a no-op.
 func (idx *Index) Close() error {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
+	if idx.closed {
+		return nil
+	}
+	idx.closed = true
+
 	if idx.txLog != nil {
 		if err := idx.txLog.Close(); err != nil {
 			return fmt.Errorf("failed to close transaction log: %v", err)
@@ -510,8 +908,30 @@ func (idx *Index) GetDocument(docID int) (*document.Document, error) {
 	return doc, nil
 }
 
-// GetPostingList retrieves the posting list for a term
+// GetDocuments retrieves several documents from one consistent view of the
+// index. Documents that don't exist are left out of the result.
+func (idx *Index) GetDocuments(docIDs []int) map[int]*document.Document {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	docs := make(map[int]*document.Document, len(docIDs))
+	for _, docID := range docIDs {
+		if doc, exists := idx.docIDMap[docID]; exists {
+			docs[docID] = doc
+		}
+	}
+	return docs
+}
+
+// GetPostingList retrieves the posting list for a term, analyzed with the
+// default analyzer
 func (idx *Index) GetPostingList(term string) (*PostingList, error) {
+	return idx.GetFieldPostingList("", term)
+}
+
+// GetFieldPostingList retrieves the posting list for a term, analyzed with the
+// analyzer of the given field
+func (idx *Index) GetFieldPostingList(field, term string) (*PostingList, error) {
 	if term == "" {
 		return nil, fmt.Errorf("empty term")
 	}
@@ -519,14 +939,14 @@ func (idx *Index) GetPostingList(term string) (*PostingList, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	// Analyze the term using the same analyzer
-	tokens := idx.analyzer.Analyze(term)
-	if len(tokens) == 0 {
+	// Analyze the term using the same analyzer as the field
+	terms := analysis.AnalyzeToTerms(idx.analyzerFor(field), term)
+	if len(terms) == 0 {
 		return nil, nil
 	}
 
 	// Use the first token as the term
-	analyzedTerm := tokens[0].Text
+	analyzedTerm := terms[0]
 	postingList, exists := idx.terms[analyzedTerm]
 	if !exists {
 		return nil, nil
@@ -552,6 +972,19 @@ func (idx *Index) GetTermFrequency(term string, docID int) (int, error) {
 	return 0, nil
 }
 
+// GetFieldTermFrequency returns the frequency of a term in a document after
+// analyzing the term with the analyzer of the given field
+func (idx *Index) GetFieldTermFrequency(field, term string, docID int) (int, error) {
+	idx.mu.RLock()
+	terms := analysis.AnalyzeToTerms(idx.analyzerFor(field), term)
+	idx.mu.RUnlock()
+
+	if len(terms) == 0 {
+		return 0, nil
+	}
+	return idx.GetTermFrequency(terms[0], docID)
+}
+
 // GetDocumentFrequency returns the number of documents containing a term
 func (idx *Index) GetDocumentFrequency(term string) (int, error) {
 	idx.mu.RLock()
@@ -579,6 +1012,49 @@ func (idx *Index) GetPostings(term string) map[int]*PostingEntry {
 	return make(map[int]*PostingEntry)
 }
 
+// TermDocIDs returns the IDs of the documents containing a term in ascending
+// order, for intersecting with other terms. The returned slice is shared and
+// must be treated as read-only.
+func (idx *Index) TermDocIDs(term string) []int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if postingList, exists := idx.terms[term]; exists {
+		return postingList.SortedDocIDs()
+	}
+	return nil
+}
+
+// ViewPostings calls fn with the posting entries of a term while holding the
+// index read lock, without the copy GetPostings makes. The map is nil when the
+// term is not indexed. fn must not modify the map or its entries, keep them
+// after it returns, or call back into the index.
+func (idx *Index) ViewPostings(term string, fn func(postings map[int]*PostingEntry)) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var postings map[int]*PostingEntry
+	if postingList, exists := idx.terms[term]; exists {
+		postings = postingList.Postings
+	}
+	fn(postings)
+}
+
+// MatchingTerms returns the indexed terms accepted by match, in sorted order
+func (idx *Index) MatchingTerms(match func(term string) bool) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var terms []string
+	for term := range idx.terms {
+		if match(term) {
+			terms = append(terms, term)
+		}
+	}
+	sort.Strings(terms)
+	return terms
+}
+
 // GetDocumentCount returns the total number of documents in the index
 func (idx *Index) GetDocumentCount() int {
 	idx.mu.RLock()
@@ -612,6 +1088,38 @@ func (idx *Index) Analyzer() analysis.Analyzer {
 	return idx.analyzer
 }
 
+// SetFieldAnalyzer sets the analyzer used for a field, such as a keyword
+// analyzer for exact-value fields. A nil analyzer restores the default. Set
+// field analyzers before indexing documents; terms already indexed under the
+// previous analyzer are not reanalyzed.
+func (idx *Index) SetFieldAnalyzer(field string, a analysis.Analyzer) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if a == nil {
+		delete(idx.analyzers, field)
+		return
+	}
+	idx.analyzers[field] = a
+}
+
+// FieldAnalyzer returns the analyzer used for a field, falling back to the
+// index's default analyzer
+func (idx *Index) FieldAnalyzer(field string) analysis.Analyzer {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.analyzerFor(field)
+}
+
+// analyzerFor returns the analyzer used for a field
+// Note: Caller must hold read lock
+func (idx *Index) analyzerFor(field string) analysis.Analyzer {
+	if a, ok := idx.analyzers[field]; ok {
+		return a
+	}
+	return idx.analyzer
+}
+
 // RestoreFromData restores the index state from serialized data
 func (idx *Index) RestoreFromData(terms map[string]*PostingList, docCount, nextDocID int) error {
 	idx.mu.Lock()
@@ -619,10 +1127,63 @@ func (idx *Index) RestoreFromData(terms map[string]*PostingList, docCount, nextD
 
 	idx.terms = terms
 	idx.docCount = docCount
-	idx.nextDocID = nextDocID
+	idx.reconcileNextDocID(nextDocID)
+	idx.generation++
+	return nil
+}
+
+// RestoreDocuments restores the stored documents of an index loaded from
+// serialized data, replacing any documents already present. Postings are
+// restored separately with RestoreFromData; the document count, field
+// presence, lengths and content hashes are rebuilt from the documents, and
+// every document starts again at version 1.
+func (idx *Index) RestoreDocuments(docs map[int]*document.Document) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	docIDMap := make(map[int]*document.Document, len(docs))
+	for docID, doc := range docs {
+		if doc == nil {
+			return fmt.Errorf("cannot restore nil document %d", docID)
+		}
+		if docID < 0 {
+			return fmt.Errorf("invalid document ID %d", docID)
+		}
+		docIDMap[docID] = doc
+	}
+
+	idx.docIDMap = docIDMap
+	idx.docCount = len(docIDMap)
+	idx.versions = make(map[int]int64)
+	idx.seqNos = make(map[int]int64)
+	idx.resetContentHashes()
+	for docID, doc := range idx.docIDMap {
+		idx.trackContentHash(docID, doc)
+		idx.bumpVersion(docID)
+	}
+	idx.rebuildFieldPresence()
+	idx.rebuildLengths()
+	idx.reconcileNextDocID(0)
+	idx.generation++
 	return nil
 }
 
+// reconcileNextDocID sets nextDocID past every ID known to the index. Storage
+// and the transaction log each record the ID space; taking the highest of the
+// current value, the given one and the stored documents means neither source
+// can cause an ID to be reused.
+// Note: Caller must hold write lock
+func (idx *Index) reconcileNextDocID(nextDocID int) {
+	if nextDocID > idx.nextDocID {
+		idx.nextDocID = nextDocID
+	}
+	for docID := range idx.docIDMap {
+		if docID >= idx.nextDocID {
+			idx.nextDocID = docID + 1
+		}
+	}
+}
+
 // Optimize performs index optimization by removing gaps in document IDs
 // and cleaning up unused terms
 func (idx *Index) Optimize() error {
@@ -634,10 +1195,15 @@ func (idx *Index) Optimize() error {
 	oldToNewID := make(map[int]int)
 	newID := 0
 
+	newVersions := make(map[int]int64)
+	newSeqNos := make(map[int]int64)
+
 	// Reassign document IDs sequentially
 	for oldID, doc := range idx.docIDMap {
 		newDocIDMap[newID] = doc
 		oldToNewID[oldID] = newID
+		newVersions[newID] = idx.versions[oldID]
+		newSeqNos[newID] = idx.seqNos[oldID]
 		newID++
 	}
 
@@ -653,6 +1219,7 @@ func (idx *Index) Optimize() error {
 		}
 		if len(newPostings) > 0 {
 			postingList.Postings = newPostings
+			postingList.sorted.Store(nil)
 			newTerms[term] = postingList
 		}
 	}
@@ -661,6 +1228,17 @@ func (idx *Index) Optimize() error {
 	idx.docIDMap = newDocIDMap
 	idx.terms = newTerms
 	idx.nextDocID = len(newDocIDMap)
+	idx.versions = newVersions
+	idx.seqNos = newSeqNos
+	idx.generation++
+
+	// Content hashes, field presence and lengths are keyed by the old document IDs
+	idx.resetContentHashes()
+	for docID, doc := range idx.docIDMap {
+		idx.trackContentHash(docID, doc)
+	}
+	idx.rebuildFieldPresence()
+	idx.rebuildLengths()
 
 	return nil
 }
@@ -681,7 +1259,8 @@ func (idx *Index) IndexDocument(indexName string, docID string, doc map[string]i
         }
     }
 
-    // If docID is provided, try to update existing document
+    // If docID is provided, store the document under it, replacing any
+    // existing document
     if docID != "" {
         // Convert string docID to int
         var intDocID int
@@ -690,12 +1269,8 @@ func (idx *Index) IndexDocument(indexName string, docID string, doc map[string]i
             return fmt.Errorf("invalid document ID format: %v", err)
         }
 
-        // Check if document exists
-        existingDoc, err := idx.GetDocument(intDocID)
-        if err == nil && existingDoc != nil {
-            // Update existing document
-            return idx.UpdateDocument(intDocID, internalDoc)
-        }
+        _, err = idx.PutDocument(intDocID, internalDoc)
+        return err
     }
 
     // Add as new document
@@ -714,3 +1289,18 @@ func (idx *Index) GetAllDocuments() ([]*document.Document, error) {
 	}
 	return docs, nil
 }
+
+// ForEachDocument calls fn for every document in the index, stopping early
+// when fn returns false. Documents are visited in no particular order and
+// without copying the corpus. The read lock is held for the whole iteration,
+// so fn must not call methods that modify the index.
+func (idx *Index) ForEachDocument(fn func(docID int, doc *document.Document) bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	for docID, doc := range idx.docIDMap {
+		if !fn(docID, doc) {
+			return
+		}
+	}
+}
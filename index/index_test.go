@@ -1,7 +1,9 @@
 package index
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -186,3 +188,809 @@ func TestConcurrentAccess(t *testing.T) {
 		t.Fatal("Test timed out - possible deadlock")
 	}
 }
+
+func TestForEachDocument(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	for i := 0; i < 5; i++ {
+		doc := document.NewDocument()
+		doc.AddField("title", fmt.Sprintf("document %d", i))
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document %d: %v", i, err)
+		}
+	}
+
+	// All documents are visited when fn keeps returning true
+	visited := make(map[int]bool)
+	idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		if doc == nil {
+			t.Errorf("ForEachDocument passed nil document for ID %d", docID)
+		}
+		visited[docID] = true
+		return true
+	})
+	if len(visited) != 5 {
+		t.Errorf("ForEachDocument visited %d documents, want 5", len(visited))
+	}
+
+	// Returning false stops iteration
+	calls := 0
+	idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		calls++
+		return calls < 2
+	})
+	if calls != 2 {
+		t.Errorf("ForEachDocument made %d calls after early return, want 2", calls)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	newDoc := func(title, body string) *document.Document {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		doc.AddField("body", body)
+		return doc
+	}
+
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	idx.SetDedupe(true)
+
+	// Same content twice yields a single document
+	id1, err := idx.AddDocument(newDoc("hello", "world"))
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	id2, err := idx.AddDocument(newDoc("hello", "world"))
+	if err != nil {
+		t.Fatalf("Failed to add duplicate document: %v", err)
+	}
+	if id1 != id2 {
+		t.Errorf("Duplicate document got ID %d, want existing ID %d", id2, id1)
+	}
+	if count := idx.GetDocumentCount(); count != 1 {
+		t.Errorf("GetDocumentCount() = %d after duplicate add, want 1", count)
+	}
+	if df, _ := idx.GetDocumentFrequency("hello"); df != 1 {
+		t.Errorf("GetDocumentFrequency(\"hello\") = %d, want 1", df)
+	}
+
+	// Different content yields a second document
+	id3, err := idx.AddDocument(newDoc("hello", "there"))
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	if id3 == id1 {
+		t.Errorf("Different content reused ID %d", id1)
+	}
+	if count := idx.GetDocumentCount(); count != 2 {
+		t.Errorf("GetDocumentCount() = %d, want 2", count)
+	}
+
+	// Deleting a document frees its content for re-indexing
+	if err := idx.DeleteDocument(id1); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	id4, err := idx.AddDocument(newDoc("hello", "world"))
+	if err != nil {
+		t.Fatalf("Failed to re-add document: %v", err)
+	}
+	if id4 == id1 {
+		t.Errorf("Re-added document reused deleted ID %d", id1)
+	}
+
+	// Without dedupe, duplicates are indexed separately
+	plain := NewIndex(analysis.NewStandardAnalyzer())
+	plain.AddDocument(newDoc("hello", "world"))
+	plain.AddDocument(newDoc("hello", "world"))
+	if count := plain.GetDocumentCount(); count != 2 {
+		t.Errorf("GetDocumentCount() = %d without dedupe, want 2", count)
+	}
+}
+
+func TestDocumentVersions(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("title", "first")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	version, ok := idx.GetDocumentVersion(docID)
+	if !ok || version.Version != 1 || version.SeqNo != 0 {
+		t.Errorf("GetDocumentVersion() after add = %+v, %v; want version 1, seq_no 0", version, ok)
+	}
+
+	updated := document.NewDocument()
+	updated.AddField("title", "second")
+	if err := idx.UpdateDocument(docID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+
+	version, _ = idx.GetDocumentVersion(docID)
+	if version.Version != 2 || version.SeqNo != 1 {
+		t.Errorf("GetDocumentVersion() after update = %+v; want version 2, seq_no 1", version)
+	}
+
+	if err := idx.DeleteDocument(docID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if _, ok := idx.GetDocumentVersion(docID); ok {
+		t.Error("GetDocumentVersion() should report no version after delete")
+	}
+}
+
+func TestPutDocument(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("title", "first")
+	created, err := idx.PutDocument(5, doc)
+	if err != nil || !created {
+		t.Fatalf("PutDocument() = %v, %v; want created", created, err)
+	}
+	if next := idx.GetNextDocID(); next != 6 {
+		t.Errorf("Expected next document ID 6, got %d", next)
+	}
+
+	replacement := document.NewDocument()
+	replacement.AddField("title", "second")
+	created, err = idx.PutDocument(5, replacement)
+	if err != nil || created {
+		t.Fatalf("PutDocument() = %v, %v; want updated", created, err)
+	}
+	if postings := idx.GetPostings("second"); postings[5] == nil {
+		t.Error("Expected the replacement to be indexed under ID 5")
+	}
+	if count := idx.GetDocumentCount(); count != 1 {
+		t.Errorf("Expected 1 document, got %d", count)
+	}
+}
+
+func TestCreateDocument(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("title", "first")
+	if err := idx.CreateDocument(3, doc); err != nil {
+		t.Fatalf("CreateDocument() error = %v", err)
+	}
+
+	duplicate := document.NewDocument()
+	duplicate.AddField("title", "second")
+	if err := idx.CreateDocument(3, duplicate); !errors.Is(err, ErrDocumentExists) {
+		t.Fatalf("Expected ErrDocumentExists, got %v", err)
+	}
+	if postings := idx.GetPostings("first"); postings[3] == nil {
+		t.Error("Expected the original document to be kept under ID 3")
+	}
+	if postings := idx.GetPostings("second"); len(postings) != 0 {
+		t.Error("Expected the rejected document not to be indexed")
+	}
+}
+
+func TestMergeDocument(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("title", "draft")
+	doc.AddField("author", "alice")
+	if err := idx.CreateDocument(1, doc); err != nil {
+		t.Fatalf("Failed to create document: %v", err)
+	}
+
+	partial := document.NewDocument()
+	partial.AddField("title", "published")
+	changed, err := idx.MergeDocument(1, partial)
+	if err != nil || !changed {
+		t.Fatalf("MergeDocument() = %v, %v; want changed", changed, err)
+	}
+
+	merged, _ := idx.GetDocument(1)
+	if field, _ := merged.GetField("author"); field.Value != "alice" {
+		t.Errorf("Expected author to be kept, got %v", field.Value)
+	}
+	if postings := idx.GetPostings("published"); postings[1] == nil {
+		t.Error("Expected the merged title to be indexed")
+	}
+	if postings := idx.GetPostings("draft"); len(postings) != 0 {
+		t.Error("Expected the replaced title to be removed from the index")
+	}
+	if version, _ := idx.GetDocumentVersion(1); version.Version != 2 {
+		t.Errorf("Expected version 2 after the update, got %d", version.Version)
+	}
+
+	// Merging values the document already holds changes nothing
+	changed, err = idx.MergeDocument(1, partial)
+	if err != nil || changed {
+		t.Errorf("MergeDocument() = %v, %v; want a no-op", changed, err)
+	}
+	if version, _ := idx.GetDocumentVersion(1); version.Version != 2 {
+		t.Errorf("Expected a no-op to keep version 2, got %d", version.Version)
+	}
+
+	if _, err := idx.MergeDocument(9, partial); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+}
+
+func TestAddDocumentAfterClose(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	if err := idx.InitTransactionLog(t.TempDir()); err != nil {
+		t.Fatalf("Failed to init transaction log: %v", err)
+	}
+
+	doc := document.NewDocument()
+	doc.AddField("title", "before close")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	if err := idx.Close(); err != nil {
+		t.Fatalf("Failed to close index: %v", err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Errorf("Expected closing twice to succeed, got %v", err)
+	}
+
+	late := document.NewDocument()
+	late.AddField("title", "after close")
+	if _, err := idx.AddDocument(late); !errors.Is(err, ErrIndexClosed) {
+		t.Errorf("Expected ErrIndexClosed from AddDocument, got %v", err)
+	}
+	if err := idx.UpdateDocument(docID, late); !errors.Is(err, ErrIndexClosed) {
+		t.Errorf("Expected ErrIndexClosed from UpdateDocument, got %v", err)
+	}
+	if err := idx.DeleteDocument(docID); !errors.Is(err, ErrIndexClosed) {
+		t.Errorf("Expected ErrIndexClosed from DeleteDocument, got %v", err)
+	}
+
+	// Reads keep working on the closed index
+	if _, err := idx.GetDocument(docID); err != nil {
+		t.Errorf("Expected document to remain readable, got %v", err)
+	}
+}
+
+func TestMaxTokenCount(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	idx.SetMaxTokenCount(3, TokenLimitTruncate)
+
+	doc := document.NewDocument()
+	doc.AddField("title", "alpha beta gamma delta epsilon")
+	doc.AddField("tag", "zeta")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	// The first tokens of each field stay searchable; the rest are dropped
+	for _, term := range []string{"alpha", "beta", "gamma", "zeta"} {
+		if _, ok := idx.GetPostings(term)[docID]; !ok {
+			t.Errorf("Expected %q to be indexed", term)
+		}
+	}
+	for _, term := range []string{"delta", "epsilon"} {
+		if _, ok := idx.GetPostings(term)[docID]; ok {
+			t.Errorf("Expected %q to be truncated", term)
+		}
+	}
+
+	idx.SetMaxTokenCount(3, TokenLimitError)
+	long := document.NewDocument()
+	long.AddField("title", "one two three four")
+	if _, err := idx.AddDocument(long); !errors.Is(err, ErrTooManyTokens) {
+		t.Errorf("Expected ErrTooManyTokens from AddDocument, got %v", err)
+	}
+	if err := idx.UpdateDocument(docID, long); !errors.Is(err, ErrTooManyTokens) {
+		t.Errorf("Expected ErrTooManyTokens from UpdateDocument, got %v", err)
+	}
+	if count := idx.GetDocumentCount(); count != 1 {
+		t.Errorf("Expected rejected document not to be counted, got %d documents", count)
+	}
+	if _, ok := idx.GetPostings("alpha")[docID]; !ok {
+		t.Error("Expected rejected update to leave the existing document indexed")
+	}
+}
+
+func TestDocsWithField(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	add := func(fields map[string]interface{}) int {
+		doc := document.NewDocument()
+		for name, value := range fields {
+			doc.AddField(name, value)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		return docID
+	}
+	expect := func(field string, want []int) {
+		t.Helper()
+		if got := idx.DocsWithField(field); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("DocsWithField(%q) = %v, want %v", field, got, want)
+		}
+	}
+
+	first := add(map[string]interface{}{"title": "first", "tag": "a"})
+	second := add(map[string]interface{}{"title": "second", "tag": "b"})
+	third := add(map[string]interface{}{"title": "third"})
+	expect("title", []int{first, second, third})
+	expect("tag", []int{first, second})
+	expect("missing", []int{})
+
+	// An update that drops a field removes the document from its set
+	updated := document.NewDocument()
+	updated.AddField("title", "second updated")
+	if err := idx.UpdateDocument(second, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	expect("tag", []int{first})
+
+	if err := idx.DeleteDocument(first); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	expect("title", []int{second, third})
+	expect("tag", []int{})
+}
+
+func TestStemmedIndexAndQuery(t *testing.T) {
+	idx := NewIndex(analysis.NewCustomAnalyzer([]analysis.TokenFilter{
+		analysis.NewLowercaseFilter(),
+		analysis.NewPorterStemmerFilter(),
+	}))
+
+	doc := document.NewDocument()
+	doc.AddField("title", "She runs every day")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	// Query terms go through the same stemmer as the indexed text
+	for _, term := range []string{"running", "run", "Runs"} {
+		postings, err := idx.GetPostingList(term)
+		if err != nil {
+			t.Errorf("Expected %q to match the stemmed index, got %v", term, err)
+			continue
+		}
+		if _, ok := postings.Postings[docID]; !ok {
+			t.Errorf("Expected %q to find document %d", term, docID)
+		}
+	}
+}
+
+func TestEdgeNGramIndexing(t *testing.T) {
+	ngrams, err := analysis.NewEdgeNGramFilter(2, 10)
+	if err != nil {
+		t.Fatalf("Failed to create edge n-gram filter: %v", err)
+	}
+	idx := NewIndex(analysis.NewCustomAnalyzer([]analysis.TokenFilter{
+		analysis.NewLowercaseFilter(),
+		ngrams,
+	}))
+
+	doc := document.NewDocument()
+	doc.AddField("title", "Search engines")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	// Every prefix is an ordinary term in the inverted index
+	for _, prefix := range []string{"se", "sea", "search", "eng"} {
+		postings, ok := idx.terms[prefix]
+		if !ok {
+			t.Errorf("Expected prefix %q to be indexed", prefix)
+			continue
+		}
+		if _, ok := postings.Postings[docID]; !ok {
+			t.Errorf("Expected prefix %q to find document %d", prefix, docID)
+		}
+	}
+	if _, ok := idx.terms["arch"]; ok {
+		t.Error("Expected only leading grams to be indexed")
+	}
+}
+
+func TestFieldAnalyzer(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	idx.SetFieldAnalyzer("status", analysis.NewKeywordAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("status", "In Progress")
+	doc.AddField("body", "Work in progress")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	// The keyword field is one token, spaces and case included
+	if _, ok := idx.terms["In Progress"]; !ok {
+		t.Error("Expected the keyword field to be indexed as a single token")
+	}
+	postings, err := idx.GetFieldPostingList("status", "In Progress")
+	if err != nil || postings == nil {
+		t.Fatalf("Expected a posting list for the keyword value, got %v, %v", postings, err)
+	}
+	if _, ok := postings.Postings[docID]; !ok {
+		t.Errorf("Expected the keyword value to find document %d", docID)
+	}
+	if freq, _ := idx.GetFieldTermFrequency("status", "In Progress", docID); freq != 1 {
+		t.Errorf("Expected keyword term frequency 1, got %d", freq)
+	}
+
+	// Other fields still use the default analyzer
+	if freq, _ := idx.GetFieldTermFrequency("body", "PROGRESS", docID); freq != 1 {
+		t.Errorf("Expected body term frequency 1, got %d", freq)
+	}
+	if postings, _ := idx.GetFieldPostingList("body", "Progress"); postings == nil {
+		t.Error("Expected the analyzed body term to be indexed")
+	}
+
+	// Deleting analyzes fields the same way, leaving no terms behind
+	if err := idx.DeleteDocument(docID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if len(idx.terms) != 0 {
+		t.Errorf("Expected no terms after delete, got %v", idx.GetTerms())
+	}
+}
+
+func TestDocumentLengths(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	short := document.NewDocument()
+	short.AddField("title", "quick fox")
+	shortID, err := idx.AddDocument(short)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	long := document.NewDocument()
+	long.AddField("title", "the quick brown fox")
+	long.AddField("body", "jumps over the lazy dog")
+	longID, err := idx.AddDocument(long)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	if got := idx.FieldLength(longID, "body"); got != 5 {
+		t.Errorf("Expected body length 5, got %d", got)
+	}
+	if got := idx.DocumentLength(longID); got != 9 {
+		t.Errorf("Expected document length 9, got %d", got)
+	}
+	if got := idx.AverageDocumentLength(); got != 5.5 {
+		t.Errorf("Expected average length 5.5, got %v", got)
+	}
+
+	// Updates replace the old lengths and deletes remove them
+	updated := document.NewDocument()
+	updated.AddField("title", "fox")
+	if err := idx.UpdateDocument(shortID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	if got := idx.AverageDocumentLength(); got != 5 {
+		t.Errorf("Expected average length 5 after update, got %v", got)
+	}
+	if err := idx.DeleteDocument(longID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if got := idx.DocumentLength(longID); got != 0 {
+		t.Errorf("Expected no length for a deleted document, got %d", got)
+	}
+	if got := idx.AverageDocumentLength(); got != 1 {
+		t.Errorf("Expected average length 1 after delete, got %v", got)
+	}
+}
+
+func TestPostingFields(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("title", "fox news")
+	doc.AddField("body", "the fox and the hound")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	check := func(term, wantFieldName string, wantFields ...string) {
+		t.Helper()
+		entry, ok := idx.GetPostings(term)[docID]
+		if !ok {
+			t.Fatalf("Expected a posting for %q", term)
+		}
+		sort.Strings(entry.Fields)
+		if fmt.Sprint(entry.Fields) != fmt.Sprint(wantFields) {
+			t.Errorf("Expected %q in fields %v, got %v", term, wantFields, entry.Fields)
+		}
+		if entry.FieldName != wantFieldName {
+			t.Errorf("Expected %q to have field name %q, got %q", term, wantFieldName, entry.FieldName)
+		}
+	}
+	check("fox", "", "body", "title")
+	check("news", "title", "title")
+	check("hound", "body", "body")
+
+	// Updates record the fields of the new content and keep document frequencies
+	updated := document.NewDocument()
+	updated.AddField("title", "hound news")
+	updated.AddField("body", "no foxes here")
+	if err := idx.UpdateDocument(docID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	check("hound", "title", "title")
+	check("news", "title", "title")
+	if df, _ := idx.GetDocumentFrequency("news"); df != 1 {
+		t.Errorf("Expected document frequency 1 after update, got %d", df)
+	}
+	if _, ok := idx.GetPostings("fox")[docID]; ok {
+		t.Error("Expected the old term to be removed by the update")
+	}
+}
+
+func TestPostingPositions(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+
+	doc := document.NewDocument()
+	doc.AddField("title", "to be or not to be")
+	doc.AddField("body", "be quick")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	entry := idx.GetPostings("to")[docID]
+	if fmt.Sprint(entry.Positions) != "[0 4]" || fmt.Sprint(entry.FieldPositions["title"]) != "[0 4]" {
+		t.Errorf("Expected \"to\" at positions [0 4], got %v and %v", entry.Positions, entry.FieldPositions)
+	}
+
+	// Terms in several fields keep their positions per field
+	entry = idx.GetPostings("be")[docID]
+	if entry.Positions != nil {
+		t.Errorf("Expected no document-wide positions for a term in two fields, got %v", entry.Positions)
+	}
+	if fmt.Sprint(entry.FieldPositions["title"]) != "[1 5]" || fmt.Sprint(entry.FieldPositions["body"]) != "[0]" {
+		t.Errorf("Expected \"be\" at title [1 5] and body [0], got %v", entry.FieldPositions)
+	}
+}
+
+func TestPutMapping(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	err := idx.PutMapping(map[string]FieldMapping{
+		"age":     {Type: MappingInteger},
+		"title":   {Type: MappingText},
+		"city":    {Type: MappingKeyword},
+		"created": {Type: MappingDate},
+	})
+	if err != nil {
+		t.Fatalf("Failed to put mapping: %v", err)
+	}
+
+	doc := document.NewDocument()
+	doc.AddField("age", "5")
+	doc.AddField("title", "Moving to New York")
+	doc.AddField("city", "New York")
+	doc.AddField("created", 1614592800000.0)
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	stored, _ := idx.GetDocument(docID)
+	if age, _ := stored.GetField("age"); age.Type != document.IntType || age.Value != int64(5) {
+		t.Errorf("Expected age to be coerced to the integer 5, got %v (%T)", age.Value, age.Value)
+	}
+	if created, _ := stored.GetField("created"); created.Type != document.TimeType {
+		t.Errorf("Expected created to be coerced to a date, got %v (%T)", created.Value, created.Value)
+	}
+
+	// The keyword field is indexed as a single term, the text field is analyzed
+	if postings := idx.GetPostings("New York"); postings[docID] == nil || fmt.Sprint(postings[docID].Fields) != "[city]" {
+		t.Errorf("Expected city to be indexed as the term \"New York\", got %v", postings)
+	}
+	if postings := idx.GetPostings("york"); postings[docID] == nil || fmt.Sprint(postings[docID].Fields) != "[title]" {
+		t.Errorf("Expected only title to be tokenized, got %v", postings)
+	}
+
+	invalid := document.NewDocument()
+	invalid.AddField("age", "five")
+	if _, err := idx.AddDocument(invalid); !errors.Is(err, ErrInvalidFieldValue) {
+		t.Errorf("Expected ErrInvalidFieldValue for a non-numeric age, got %v", err)
+	}
+	partial := document.NewDocument()
+	partial.AddField("age", 1<<40)
+	if _, err := idx.MergeDocument(docID, partial); !errors.Is(err, ErrInvalidFieldValue) {
+		t.Errorf("Expected ErrInvalidFieldValue for an age out of the integer range, got %v", err)
+	}
+	if idx.GetDocumentCount() != 1 {
+		t.Errorf("Expected rejected documents not to be indexed, got %d documents", idx.GetDocumentCount())
+	}
+
+	// Mapped fields keep their type
+	if err := idx.PutMapping(map[string]FieldMapping{"age": {Type: MappingText}}); !errors.Is(err, ErrMappingConflict) {
+		t.Errorf("Expected ErrMappingConflict when remapping age, got %v", err)
+	}
+	if err := idx.PutMapping(map[string]FieldMapping{"age": {Type: MappingInteger}}); err != nil {
+		t.Errorf("Expected an identical mapping to be accepted, got %v", err)
+	}
+	for name, mapping := range map[string]FieldMapping{
+		"unknown type":        {Type: "geo_shape"},
+		"analyzer on keyword": {Type: MappingKeyword, Analyzer: "standard"},
+		"unknown analyzer":    {Type: MappingText, Analyzer: "klingon"},
+	} {
+		if err := idx.PutMapping(map[string]FieldMapping{"other": mapping}); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+	if len(idx.Mapping()) != 4 {
+		t.Errorf("Expected 4 mapped fields, got %v", idx.Mapping())
+	}
+}
+
+func TestPutMappingIndexedField(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	doc := document.NewDocument()
+	doc.AddField("title", "Quick Fox")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	// Remapping a dynamically indexed field would swap its analyzer
+	if err := idx.PutMapping(map[string]FieldMapping{"title": {Type: MappingKeyword}}); !errors.Is(err, ErrMappingConflict) {
+		t.Errorf("Expected ErrMappingConflict when mapping an indexed field, got %v", err)
+	}
+	if err := idx.DeleteDocument(docID); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	for _, term := range []string{"quick", "fox"} {
+		if postings := idx.GetPostings(term); len(postings) != 0 {
+			t.Errorf("Expected no postings for %q after the delete, got %v", term, postings)
+		}
+	}
+
+	// Once its documents are gone the field can be mapped
+	if err := idx.PutMapping(map[string]FieldMapping{"title": {Type: MappingKeyword}}); err != nil {
+		t.Errorf("Expected a field without documents to be mapped, got %v", err)
+	}
+
+	idx.SetFieldAnalyzer("tag", analysis.NewKeywordAnalyzer())
+	if err := idx.PutMapping(map[string]FieldMapping{"tag": {Type: MappingText}}); !errors.Is(err, ErrMappingConflict) {
+		t.Errorf("Expected ErrMappingConflict when mapping a field with a custom analyzer, got %v", err)
+	}
+	if _, ok := idx.FieldAnalyzer("tag").(*analysis.KeywordAnalyzer); !ok {
+		t.Errorf("Expected the custom analyzer to be kept, got %T", idx.FieldAnalyzer("tag"))
+	}
+}
+
+func TestDateDetection(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	addCreated := func() int {
+		doc := document.NewDocument()
+		doc.AddField("created", "2021-03-01T10:00:00Z")
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		return docID
+	}
+
+	// Off by default, dates stay strings
+	docID := addCreated()
+	if stored, _ := idx.GetDocument(docID); stored.GetFields()["created"].Type != document.StringType {
+		t.Errorf("Expected created to stay a string without date detection, got %v", stored.GetFields()["created"])
+	}
+	if idx.IndexesDates("created") {
+		t.Error("Expected created not to be indexed as a date without date detection")
+	}
+
+	idx.SetDateDetection(true)
+	docID = addCreated()
+	stored, _ := idx.GetDocument(docID)
+	if stored.GetFields()["created"].Type != document.TimeType {
+		t.Errorf("Expected created to be detected as a date, got %v", stored.GetFields()["created"])
+	}
+	if postings := idx.GetPostings("2021-03-01T10:00:00.000000000Z"); postings[docID] == nil {
+		t.Errorf("Expected the detected date to be indexed under its canonical token, got %v", postings)
+	}
+	if !idx.IndexesDates("created") {
+		t.Error("Expected created to be indexed as a date with date detection")
+	}
+
+	// Mapped fields keep their mapped type
+	if err := idx.PutMapping(map[string]FieldMapping{"note": {Type: MappingKeyword}}); err != nil {
+		t.Fatalf("Failed to put mapping: %v", err)
+	}
+	if idx.IndexesDates("note") {
+		t.Error("Expected a keyword field not to be indexed as a date")
+	}
+}
+
+func TestViewPostings(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	for _, title := range []string{"quick fox", "lazy fox", "quick dog"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	var viewed map[int]int
+	idx.ViewPostings("fox", func(postings map[int]*PostingEntry) {
+		viewed = make(map[int]int, len(postings))
+		for docID, entry := range postings {
+			viewed[docID] = entry.TermFreq
+		}
+	})
+	copied := idx.GetPostings("fox")
+	if len(viewed) != 2 || len(viewed) != len(copied) {
+		t.Fatalf("Expected ViewPostings to see the 2 documents GetPostings returns, got %v", viewed)
+	}
+	for docID, entry := range copied {
+		if viewed[docID] != entry.TermFreq {
+			t.Errorf("Expected document %d to have frequency %d, got %d", docID, entry.TermFreq, viewed[docID])
+		}
+	}
+
+	idx.ViewPostings("missing", func(postings map[int]*PostingEntry) {
+		if len(postings) != 0 {
+			t.Errorf("Expected no postings for an unindexed term, got %v", postings)
+		}
+	})
+}
+
+func TestTermDocIDs(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	for _, title := range []string{"quick fox", "lazy dog", "fox fox", "quick dog", "red fox"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	docIDs := idx.TermDocIDs("fox")
+	if len(docIDs) != 3 || !sort.IntsAreSorted(docIDs) {
+		t.Errorf("Expected 3 sorted document IDs for fox, got %v", docIDs)
+	}
+	if docIDs := idx.TermDocIDs("missing"); len(docIDs) != 0 {
+		t.Errorf("Expected no document IDs for an unindexed term, got %v", docIDs)
+	}
+}
+
+// failingPersistence is a Persistence whose saves and removals always fail
+type failingPersistence struct{}
+
+func (failingPersistence) SaveDocument(docID int, doc *document.Document) error {
+	return fmt.Errorf("disk full")
+}
+
+func (failingPersistence) RemoveDocument(docID int) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestPersistenceFailureKeepsWrite(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	idx.SetPersistence(failingPersistence{})
+
+	// The write is applied before it is persisted, so it succeeds and stays
+	doc := document.NewDocument()
+	doc.AddField("title", "quick fox")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Expected a persistence failure not to fail the write, got %v", err)
+	}
+	if _, err := idx.GetDocument(docID); err != nil {
+		t.Errorf("Expected the document to stay indexed, got %v", err)
+	}
+	if err := idx.DeleteDocument(docID); err != nil {
+		t.Errorf("Expected a persistence failure not to fail the delete, got %v", err)
+	}
+}
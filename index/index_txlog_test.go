@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"os"
 	"testing"
 
@@ -186,3 +187,196 @@ func TestConcurrentTransactions(t *testing.T) {
 		t.Errorf("Expected 10 documents after recovery, got %d", count)
 	}
 }
+
+func TestNextDocIDAfterLoadAndRecovery(t *testing.T) {
+	tests := []struct {
+		name       string
+		loadedNext int // nextDocID restored from storage
+		loggedDocs int // documents added through the transaction log
+		wantNext   int
+	}{
+		{"Log has higher IDs", 2, 5, 5},
+		{"Storage has higher IDs", 10, 3, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logDir := t.TempDir()
+
+			// Write documents to the log without recovering them yet
+			writer := NewIndex(nil)
+			if err := writer.InitTransactionLog(logDir); err != nil {
+				t.Fatalf("Failed to initialize transaction log: %v", err)
+			}
+			for i := 0; i < tt.loggedDocs; i++ {
+				doc := document.NewDocument()
+				doc.AddField("title", "logged")
+				doc.AddField("n", i)
+				if _, err := writer.AddDocument(doc); err != nil {
+					t.Fatalf("Failed to add document: %v", err)
+				}
+			}
+			writer.Close()
+
+			// Load a snapshot from storage, then replay the log
+			idx := NewIndex(nil)
+			if err := idx.RestoreFromData(make(map[string]*PostingList), tt.loadedNext, tt.loadedNext); err != nil {
+				t.Fatalf("Failed to restore index: %v", err)
+			}
+			if err := idx.InitTransactionLog(logDir); err != nil {
+				t.Fatalf("Failed to recover transaction log: %v", err)
+			}
+			defer idx.Close()
+
+			if next := idx.GetNextDocID(); next != tt.wantNext {
+				t.Errorf("Expected nextDocID %d, got %d", tt.wantNext, next)
+			}
+
+			doc := document.NewDocument()
+			doc.AddField("title", "after restart")
+			docID, err := idx.AddDocument(doc)
+			if err != nil {
+				t.Fatalf("Failed to add document: %v", err)
+			}
+			if docID < tt.loadedNext || docID < tt.loggedDocs {
+				t.Errorf("Expected a fresh ID, got %d which collides with loaded or logged IDs", docID)
+			}
+		})
+	}
+}
+
+func TestPostingFieldsAfterRecovery(t *testing.T) {
+	logDir := t.TempDir()
+
+	writer := NewIndex(nil)
+	if err := writer.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to initialize transaction log: %v", err)
+	}
+	doc := document.NewDocument()
+	doc.AddField("title", "draft")
+	docID, err := writer.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	updated := document.NewDocument()
+	updated.AddField("title", "release notes")
+	updated.AddField("body", "see the notes")
+	if err := writer.UpdateDocument(docID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	writer.Close()
+
+	idx := NewIndex(nil)
+	if err := idx.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to recover transaction log: %v", err)
+	}
+	defer idx.Close()
+
+	entry, ok := idx.GetPostings("release")[docID]
+	if !ok || entry.FieldName != "title" || len(entry.Fields) != 1 {
+		t.Errorf("Expected a recovered title-only posting for \"release\", got %+v", entry)
+	}
+	entry, ok = idx.GetPostings("notes")[docID]
+	if !ok || entry.FieldName != "" || len(entry.Fields) != 2 {
+		t.Errorf("Expected a recovered posting in both fields for \"notes\", got %+v", entry)
+	}
+}
+
+func TestRecoveredIndexIsSearchable(t *testing.T) {
+	logDir := t.TempDir()
+
+	writer := NewIndex(nil)
+	if err := writer.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to initialize transaction log: %v", err)
+	}
+	var ids []int
+	for _, title := range []string{"first draft", "second draft", "third draft"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := writer.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		ids = append(ids, docID)
+	}
+	updated := document.NewDocument()
+	updated.AddField("title", "first final")
+	updated.AddField("status", "published")
+	if err := writer.UpdateDocument(ids[0], updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	if err := writer.DeleteDocument(ids[1]); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	writer.Close()
+
+	idx := NewIndex(nil)
+	if err := idx.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to recover transaction log: %v", err)
+	}
+	defer idx.Close()
+
+	doc, err := idx.GetDocument(ids[0])
+	if err != nil {
+		t.Fatalf("Failed to retrieve recovered document: %v", err)
+	}
+	if title, _ := doc.GetField("title"); title.Value != "first final" {
+		t.Errorf("Expected the updated title after recovery, got %v", title.Value)
+	}
+	if _, err := idx.GetDocument(ids[1]); err == nil {
+		t.Error("Expected the deleted document to stay deleted after recovery")
+	}
+	if count := idx.GetDocumentCount(); count != 2 {
+		t.Errorf("Expected 2 documents after recovery, got %d", count)
+	}
+
+	// Terms replaced by the update are gone and the rest are searchable
+	if _, ok := idx.GetPostings("final")[ids[0]]; !ok {
+		t.Error("Expected the updated term to be searchable after recovery")
+	}
+	if df, _ := idx.GetDocumentFrequency("draft"); df != 1 {
+		t.Errorf("Expected \"draft\" in 1 document after recovery, got %d", df)
+	}
+	if _, ok := idx.GetPostings("draft")[ids[0]]; ok {
+		t.Error("Expected the term removed by the update to stay removed after recovery")
+	}
+	if entry := idx.GetPostings("draft")[ids[2]]; entry == nil || fmt.Sprint(entry.FieldPositions["title"]) != "[1]" {
+		t.Errorf("Expected \"draft\" at title position 1 after recovery, got %+v", entry)
+	}
+	if docs := idx.DocsWithField("status"); fmt.Sprint(docs) != fmt.Sprint([]int{ids[0]}) {
+		t.Errorf("Expected only document %d to have a status, got %v", ids[0], docs)
+	}
+	if length := idx.DocumentLength(ids[0]); length != 3 {
+		t.Errorf("Expected recovered document length 3, got %d", length)
+	}
+	if next := idx.GetNextDocID(); next != ids[2]+1 {
+		t.Errorf("Expected nextDocID %d after recovery, got %d", ids[2]+1, next)
+	}
+}
+
+func TestRecoveryKeepsLogUntilSnapshot(t *testing.T) {
+	logDir := t.TempDir()
+
+	writer := NewIndex(nil)
+	if err := writer.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to initialize transaction log: %v", err)
+	}
+	doc := document.NewDocument()
+	doc.AddField("title", "logged document")
+	if _, err := writer.AddDocument(doc); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	writer.Close()
+
+	// Without a snapshot in between, every restart replays the log again
+	for restart := 1; restart <= 2; restart++ {
+		idx := NewIndex(nil)
+		if err := idx.InitTransactionLog(logDir); err != nil {
+			t.Fatalf("Failed to recover transaction log: %v", err)
+		}
+		if count := idx.GetDocumentCount(); count != 1 {
+			t.Errorf("Expected 1 document after restart %d, got %d", restart, count)
+		}
+		idx.Close()
+	}
+}
@@ -0,0 +1,403 @@
+package index
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-indexer/analysis"
+	"my-indexer/document"
+)
+
+// Field mapping types
+const (
+	MappingText    = "text"    // Analyzed full text
+	MappingKeyword = "keyword" // Exact values, indexed as a single term
+	MappingLong    = "long"    // 64-bit integers
+	MappingInteger = "integer" // 32-bit integers
+	MappingShort   = "short"   // 16-bit integers
+	MappingByte    = "byte"    // 8-bit integers
+	MappingDouble  = "double"  // 64-bit floating point numbers
+	MappingFloat   = "float"   // 32-bit floating point numbers
+	MappingBoolean = "boolean" // true or false
+	MappingDate    = "date"    // RFC 3339 dates or epoch milliseconds
+)
+
+var (
+	// ErrMappingConflict is returned by PutMapping when it would change the
+	// type of a mapped field, or map a field that is already indexed
+	ErrMappingConflict = errors.New("mapping conflict")
+	// ErrInvalidFieldValue is returned when a document value can't be coerced
+	// to the mapped type of its field
+	ErrInvalidFieldValue = errors.New("invalid field value")
+)
+
+// integerRanges holds the bounds of each integer mapping type
+var integerRanges = map[string][2]int64{
+	MappingLong:    {math.MinInt64, math.MaxInt64},
+	MappingInteger: {math.MinInt32, math.MaxInt32},
+	MappingShort:   {math.MinInt16, math.MaxInt16},
+	MappingByte:    {math.MinInt8, math.MaxInt8},
+}
+
+// FieldMapping declares the type of a field. Text fields may also name the
+// analyzer their values are analyzed with.
+type FieldMapping struct {
+	Type     string `json:"type"`
+	Analyzer string `json:"analyzer,omitempty"`
+}
+
+// MappingTypes returns the supported field mapping types in sorted order
+func MappingTypes() []string {
+	types := []string{MappingText, MappingKeyword, MappingBoolean, MappingDate, MappingDouble, MappingFloat}
+	for name := range integerRanges {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// fieldAnalyzer returns the analyzer a mapping indexes its field with, or nil
+// for the index's default analyzer
+func (m FieldMapping) fieldAnalyzer() (analysis.Analyzer, error) {
+	switch m.Type {
+	case MappingText:
+		if m.Analyzer == "" {
+			return nil, nil
+		}
+		a, ok := analysis.LookupAnalyzer(m.Analyzer)
+		if !ok {
+			return nil, fmt.Errorf("unknown analyzer %q", m.Analyzer)
+		}
+		return a, nil
+	case MappingKeyword:
+		return analysis.NewKeywordAnalyzer(), nil
+	}
+	return nil, nil
+}
+
+// validate checks that a mapping names a supported type and only uses
+// parameters that type accepts
+func (m FieldMapping) validate() error {
+	found := false
+	for _, t := range MappingTypes() {
+		if m.Type == t {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown field type %q, expected one of %s", m.Type, strings.Join(MappingTypes(), ", "))
+	}
+	if m.Analyzer != "" && m.Type != MappingText {
+		return fmt.Errorf("analyzer is only supported by text fields")
+	}
+	return nil
+}
+
+// PutMapping adds explicit mappings for the given fields, keyed by field
+// name. Fields already mapped keep their type: remapping one to a different
+// type fails with ErrMappingConflict. So does mapping an unmapped field that
+// documents are already indexed under, or that has an analyzer set with
+// SetFieldAnalyzer, since changing its analyzer would leave the existing
+// terms unreachable by later updates and deletes. Documents indexed
+// afterwards have their values coerced to the mapped types.
+func (idx *Index) PutMapping(properties map[string]FieldMapping) error {
+	analyzers := make(map[string]analysis.Analyzer, len(properties))
+	for field, mapping := range properties {
+		if field == "" || strings.Contains(field, ".") {
+			return fmt.Errorf("invalid field name %q", field)
+		}
+		if err := mapping.validate(); err != nil {
+			return fmt.Errorf("field %s: %v", field, err)
+		}
+		a, err := mapping.fieldAnalyzer()
+		if err != nil {
+			return fmt.Errorf("field %s: %v", field, err)
+		}
+		analyzers[field] = a
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for field, mapping := range properties {
+		existing, ok := idx.mappings[field]
+		if ok && existing != mapping {
+			return fmt.Errorf("field %s is mapped as %s and cannot be changed to %s: %w",
+				field, existing.Type, mapping.Type, ErrMappingConflict)
+		}
+		if ok {
+			continue
+		}
+		if len(idx.fieldDocs[field]) > 0 {
+			return fmt.Errorf("field %s already has indexed documents and cannot be mapped as %s: %w",
+				field, mapping.Type, ErrMappingConflict)
+		}
+		if _, custom := idx.analyzers[field]; custom {
+			return fmt.Errorf("field %s has a custom analyzer and cannot be mapped as %s: %w",
+				field, mapping.Type, ErrMappingConflict)
+		}
+	}
+	for field, mapping := range properties {
+		idx.mappings[field] = mapping
+		if analyzers[field] == nil {
+			delete(idx.analyzers, field)
+		} else {
+			idx.analyzers[field] = analyzers[field]
+		}
+	}
+	return nil
+}
+
+// Mapping returns the explicit field mappings of the index, keyed by field
+// name
+func (idx *Index) Mapping() map[string]FieldMapping {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	mappings := make(map[string]FieldMapping, len(idx.mappings))
+	for field, mapping := range idx.mappings {
+		mappings[field] = mapping
+	}
+	return mappings
+}
+
+// SetDateDetection enables or disables date detection for the index. When
+// enabled, string values of unmapped fields in RFC 3339 format are indexed as
+// dates, so range queries compare them as dates. It is off by default and
+// only affects documents indexed afterwards.
+func (idx *Index) SetDateDetection(enabled bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.dateDetection = enabled
+}
+
+// DateDetection reports whether date detection is enabled for the index
+func (idx *Index) DateDetection() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.dateDetection
+}
+
+// IndexesDates reports whether RFC 3339 string values of a field are indexed
+// as dates: the field is mapped as a date, or is unmapped with date detection
+// enabled
+func (idx *Index) IndexesDates(field string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if mapping, ok := idx.mappings[field]; ok {
+		return mapping.Type == MappingDate
+	}
+	return idx.dateDetection
+}
+
+// applyMapping returns a copy of doc with the values of its mapped fields
+// coerced to their mapped types, and with dates detected in its unmapped
+// fields when date detection is enabled. Doc itself is returned when nothing
+// changes.
+// Note: Caller must hold read lock
+func (idx *Index) applyMapping(doc *document.Document) (*document.Document, error) {
+	if len(idx.mappings) == 0 && !idx.dateDetection {
+		return doc, nil
+	}
+
+	var coerced *document.Document
+	for name, field := range doc.GetFields() {
+		var value interface{}
+		mapping, mapped := idx.mappings[name]
+		switch {
+		case mapped:
+			var err error
+			if value, err = coerceValue(mapping.Type, field.Value); err != nil {
+				return nil, fmt.Errorf("field %s: %v: %w", name, err, ErrInvalidFieldValue)
+			}
+		case idx.dateDetection:
+			var detected bool
+			if value, detected = document.DetectDates(field.Value); !detected {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if coerced == nil {
+			// Merging nothing copies the document
+			coerced = doc.Merge(document.NewDocument())
+		}
+		if err := coerced.SetTypedField(name, value); err != nil {
+			return nil, fmt.Errorf("field %s: %v: %w", name, err, ErrInvalidFieldValue)
+		}
+	}
+
+	if coerced == nil {
+		return doc, nil
+	}
+	return coerced, nil
+}
+
+// coerceValue converts a field value to a mapping type, converting each
+// element of an array
+func coerceValue(mappingType string, value interface{}) (interface{}, error) {
+	if values, ok := value.([]interface{}); ok {
+		coerced := make([]interface{}, len(values))
+		for i, element := range values {
+			var err error
+			if coerced[i], err = coerceValue(mappingType, element); err != nil {
+				return nil, err
+			}
+		}
+		return coerced, nil
+	}
+
+	switch mappingType {
+	case MappingText, MappingKeyword:
+		return coerceString(value)
+	case MappingDouble, MappingFloat:
+		return coerceFloat(value)
+	case MappingBoolean:
+		return coerceBool(value)
+	case MappingDate:
+		return coerceDate(value)
+	}
+	if bounds, ok := integerRanges[mappingType]; ok {
+		return coerceInteger(value, bounds)
+	}
+	return nil, fmt.Errorf("unknown field type %q", mappingType)
+}
+
+// coerceString converts a value to the string a text or keyword field holds
+func coerceString(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case time.Time:
+		// Detected dates are kept as the text they were given as
+		return v.Format(time.RFC3339Nano), nil
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	}
+	return nil, fmt.Errorf("cannot use %v as a string", value)
+}
+
+// coerceFloat converts a number or numeric string to a float64
+func coerceFloat(value interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return f, nil
+	}
+	if f, ok := numberValue(value); ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("%v is not a number", value)
+}
+
+// coerceInteger converts a number or numeric string to an int64 within
+// bounds. Fractions are truncated toward zero.
+func coerceInteger(value interface{}, bounds [2]int64) (interface{}, error) {
+	var n int64
+	switch v := value.(type) {
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case string:
+		s := strings.TrimSpace(v)
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil {
+				return nil, fmt.Errorf("%q is not an integer", v)
+			}
+			return coerceInteger(f, bounds)
+		}
+		n = i
+	default:
+		f, ok := numberValue(value)
+		if !ok {
+			return nil, fmt.Errorf("%v is not an integer", value)
+		}
+		f = math.Trunc(f)
+		if f < float64(bounds[0]) || f > float64(bounds[1]) {
+			return nil, fmt.Errorf("%v is out of range", value)
+		}
+		n = int64(f)
+	}
+	if n < bounds[0] || n > bounds[1] {
+		return nil, fmt.Errorf("%v is out of range", value)
+	}
+	return n, nil
+}
+
+// coerceBool converts a boolean or the strings "true" and "false" to a bool
+func coerceBool(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if b, err := strconv.ParseBool(v); err == nil && (v == "true" || v == "false") {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%v is not a boolean", value)
+}
+
+// coerceDate converts an RFC 3339 string or epoch milliseconds to a time
+func coerceDate(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		if date, ok := document.ParseDate(v); ok {
+			return date, nil
+		}
+		return nil, fmt.Errorf("%q is not an RFC 3339 date", v)
+	}
+	if millis, ok := numberValue(value); ok {
+		return time.UnixMilli(int64(millis)).UTC(), nil
+	}
+	return nil, fmt.Errorf("%v is not a date", value)
+}
+
+// numberValue converts a numeric value to a float64
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -0,0 +1,46 @@
+package index
+
+import (
+	"my-indexer/document"
+	"my-indexer/logger"
+)
+
+// Persistence stores the documents of an index outside of it. An index with
+// a persistence mirrors each document it adds, updates or deletes, so the
+// stored copies don't outlive the indexed ones.
+type Persistence interface {
+	SaveDocument(docID int, doc *document.Document) error
+	RemoveDocument(docID int) error
+}
+
+// SetPersistence mirrors the index's document writes to p from now on, or
+// stops mirroring them when p is nil. Documents already indexed are not
+// saved.
+func (idx *Index) SetPersistence(p Persistence) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.persistence = p
+}
+
+// persist mirrors the current state of a document to the persistence: it is
+// saved if it is indexed and removed otherwise. The write has already been
+// applied and committed by then, so a failure is logged rather than reported
+// as a failed write. Persistence implementations must not call back into the
+// index, since the write lock is held.
+// Note: Caller must hold write lock
+func (idx *Index) persist(docID int) {
+	if idx.persistence == nil {
+		return
+	}
+
+	if doc, exists := idx.docIDMap[docID]; exists {
+		if err := idx.persistence.SaveDocument(docID, doc); err != nil {
+			logger.Error("Failed to persist document %d: %v", docID, err)
+		}
+		return
+	}
+	if err := idx.persistence.RemoveDocument(docID); err != nil {
+		logger.Error("Failed to remove persisted document %d: %v", docID, err)
+	}
+}
@@ -0,0 +1,150 @@
+package index
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrQueueFull is returned when the indexing queue cannot accept more writes
+	ErrQueueFull = errors.New("indexing queue is full")
+	// ErrQueueClosed is returned when writing to a closed indexing queue
+	ErrQueueClosed = errors.New("indexing queue is closed")
+)
+
+// indexRequest is a single queued write
+type indexRequest struct {
+	indexName string
+	docID     string
+	doc       map[string]interface{}
+}
+
+// IndexingQueue decouples callers from index mutation by applying writes
+// asynchronously in batches on a background worker
+type IndexingQueue struct {
+	idx       *Index
+	requests  chan indexRequest
+	batchSize int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending int   // Writes enqueued but not yet applied
+	lastErr error // First error since the last Refresh
+	closed  bool
+	done    chan struct{}
+}
+
+// NewIndexingQueue creates a queue holding at most capacity pending writes and
+// starts its worker. Each batch applies up to batchSize writes.
+func NewIndexingQueue(idx *Index, capacity, batchSize int) *IndexingQueue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	q := &IndexingQueue{
+		idx:       idx,
+		requests:  make(chan indexRequest, capacity),
+		batchSize: batchSize,
+		done:      make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	go q.run()
+	return q
+}
+
+// Enqueue schedules a document for indexing. It never blocks: when the queue
+// is full ErrQueueFull is returned so callers can apply backpressure.
+func (q *IndexingQueue) Enqueue(indexName, docID string, doc map[string]interface{}) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	select {
+	case q.requests <- indexRequest{indexName: indexName, docID: docID, doc: doc}:
+		q.pending++
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Refresh blocks until every write enqueued before the call has been applied.
+// It returns the first indexing error seen since the previous Refresh.
+func (q *IndexingQueue) Refresh() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.pending > 0 {
+		q.cond.Wait()
+	}
+
+	err := q.lastErr
+	q.lastErr = nil
+	return err
+}
+
+// Close stops accepting writes and waits for queued writes to be applied
+func (q *IndexingQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.requests)
+	q.mu.Unlock()
+
+	<-q.done
+	return nil
+}
+
+// run is the worker loop that drains the queue in batches
+func (q *IndexingQueue) run() {
+	defer close(q.done)
+
+	batch := make([]indexRequest, 0, q.batchSize)
+	for req := range q.requests {
+		batch = append(batch[:0], req)
+
+		// Collect whatever else is already waiting, up to the batch size
+	drain:
+		for len(batch) < q.batchSize {
+			select {
+			case next, ok := <-q.requests:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		q.apply(batch)
+	}
+}
+
+// apply indexes a batch of writes and wakes any waiting Refresh callers
+func (q *IndexingQueue) apply(batch []indexRequest) {
+	var firstErr error
+	for _, req := range batch {
+		if err := q.idx.IndexDocument(req.indexName, req.docID, req.doc); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	q.mu.Lock()
+	q.pending -= len(batch)
+	if q.lastErr == nil {
+		q.lastErr = firstErr
+	}
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
@@ -0,0 +1,87 @@
+package index
+
+import (
+	"fmt"
+	"testing"
+
+	"my-indexer/analysis"
+)
+
+func TestIndexingQueueEventualConsistency(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	queue := NewIndexingQueue(idx, 100, 10)
+	defer queue.Close()
+
+	for i := 0; i < 25; i++ {
+		doc := map[string]interface{}{"title": fmt.Sprintf("document %d", i)}
+		if err := queue.Enqueue("test", "", doc); err != nil {
+			t.Fatalf("Enqueue(%d) returned error: %v", i, err)
+		}
+	}
+
+	if err := queue.Refresh(); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+
+	if count := idx.GetDocumentCount(); count != 25 {
+		t.Errorf("GetDocumentCount() = %d after refresh, want 25", count)
+	}
+	if df, _ := idx.GetDocumentFrequency("document"); df != 25 {
+		t.Errorf("GetDocumentFrequency(\"document\") = %d after refresh, want 25", df)
+	}
+}
+
+func TestIndexingQueueFull(t *testing.T) {
+	idx := NewIndex(analysis.NewStandardAnalyzer())
+	queue := NewIndexingQueue(idx, 1, 1)
+	defer queue.Close()
+
+	// Hold the index lock so the worker cannot apply anything
+	idx.mu.Lock()
+
+	accepted := 0
+	var fullErr error
+	for i := 0; i < 10; i++ {
+		err := queue.Enqueue("test", "", map[string]interface{}{"title": "blocked"})
+		if err != nil {
+			fullErr = err
+			break
+		}
+		accepted++
+	}
+
+	idx.mu.Unlock()
+
+	if fullErr != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull once the queue filled up, got %v", fullErr)
+	}
+
+	if err := queue.Refresh(); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+	if count := idx.GetDocumentCount(); count != accepted {
+		t.Errorf("GetDocumentCount() = %d, want %d accepted writes", count, accepted)
+	}
+
+	// Once drained the queue accepts writes again
+	if err := queue.Enqueue("test", "", map[string]interface{}{"title": "after"}); err != nil {
+		t.Errorf("Enqueue after drain returned error: %v", err)
+	}
+}
+
+func TestIndexingQueueClosed(t *testing.T) {
+	idx := NewIndex(nil)
+	queue := NewIndexingQueue(idx, 10, 5)
+
+	if err := queue.Enqueue("test", "", map[string]interface{}{"title": "pending"}); err != nil {
+		t.Fatalf("Enqueue returned error: %v", err)
+	}
+	queue.Close()
+
+	if count := idx.GetDocumentCount(); count != 1 {
+		t.Errorf("Close should apply pending writes, got %d documents", count)
+	}
+	if err := queue.Enqueue("test", "", map[string]interface{}{"title": "late"}); err != ErrQueueClosed {
+		t.Errorf("Enqueue after Close = %v, want ErrQueueClosed", err)
+	}
+}
@@ -0,0 +1,151 @@
+package index
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SnapshotStore saves a snapshot of a whole index, such as
+// storage.IndexStorage
+type SnapshotStore interface {
+	SaveIndex(idx *Index) error
+}
+
+// Snapshotter periodically saves an index to a SnapshotStore in the
+// background. After each successful snapshot the transaction log is
+// compacted up to the last transaction the snapshot covers; recovery then
+// loads the snapshot and replays the log on top of it.
+type Snapshotter struct {
+	mu        sync.Mutex
+	idx       *Index
+	store     SnapshotStore
+	interval  time.Duration
+	savedGen  uint64 // Index generation covered by the last snapshot
+	snapshots int    // Number of snapshots taken
+	lastErr   error  // Error of the most recent failed snapshot
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// EnableSnapshots starts saving the index to store every interval until the
+// returned snapshotter is stopped. The first snapshot is taken on the first
+// tick; later ones are skipped while the index is unchanged since the last.
+func (idx *Index) EnableSnapshots(store SnapshotStore, interval time.Duration) *Snapshotter {
+	s := &Snapshotter{
+		idx:      idx,
+		store:    store,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// run snapshots on every tick until Stop is called
+func (s *Snapshotter) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Snapshot()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Snapshot saves the index if it changed since the last snapshot and
+// compacts the transaction log, reporting whether a snapshot was taken.
+// Writes wait until it is done, so the log can't lose writes the snapshot
+// missed; searches are not blocked.
+func (s *Snapshotter) Snapshot() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idx.snapshotMu.Lock()
+	defer s.idx.snapshotMu.Unlock()
+
+	gen := s.idx.Generation()
+	if s.snapshots > 0 && gen == s.savedGen {
+		return false, nil
+	}
+
+	snapshotTxID := s.idx.lastTransactionID()
+	if err := s.store.SaveIndex(s.idx); err != nil {
+		s.lastErr = err
+		return false, err
+	}
+	if err := s.idx.compactLog(snapshotTxID); err != nil {
+		s.lastErr = err
+		return false, err
+	}
+
+	s.savedGen = gen
+	s.snapshots++
+	return true, nil
+}
+
+// Stop stops the background goroutine and takes a final snapshot so no
+// changes are lost on shutdown
+func (s *Snapshotter) Stop() error {
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		_, err = s.Snapshot()
+	})
+	return err
+}
+
+// Snapshots returns the number of snapshots taken
+func (s *Snapshotter) Snapshots() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshots
+}
+
+// Err returns the error of the most recent failed snapshot, if any
+func (s *Snapshotter) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastErr
+}
+
+// lastTransactionID returns the ID of the last logged transaction, 0 if the
+// index has no transaction log
+func (idx *Index) lastTransactionID() int64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.txLog == nil {
+		return 0
+	}
+	return idx.txLog.LastTransactionID()
+}
+
+// compactLog removes the transaction log segments covered by a snapshot of
+// the transactions up to snapshotTxID, if the index has a log. The active
+// segment is rolled over first, since writes wait for the snapshot and it
+// holds no later transactions.
+func (idx *Index) compactLog(snapshotTxID int64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.txLog == nil || idx.closed {
+		return nil
+	}
+	if err := idx.txLog.Roll(); err != nil {
+		return fmt.Errorf("failed to roll transaction log: %v", err)
+	}
+	if _, err := idx.txLog.Compact(snapshotTxID); err != nil {
+		return fmt.Errorf("failed to compact transaction log: %v", err)
+	}
+	return nil
+}
@@ -20,11 +20,12 @@ func main() {
 	}
 
 	r := router.NewRouter()
+	r.EnableMetrics()
 	
 	// Configure server
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: r,
+		Handler: router.RecoveryMiddleware(router.GzipMiddleware(r)),
 	}
 
 	// Server run context
@@ -2,7 +2,12 @@ package query
 
 import (
 	"fmt"
+	"math"
+	"my-indexer/document"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 // Use the QueryType from query.go
@@ -14,7 +19,15 @@ type ParsedQuery struct {
 	Terms      []string
 	IsPhrase   bool
 	SubQueries []ParsedQuery
-	Operator   string // "AND" or "OR"
+	Operator   string  // "AND", "OR", or "NOT" negating its single subquery
+	Boost      float64 // Score multiplier from the term^boost syntax, 0 when not given
+	Fuzziness  int     // Maximum edit distance of a fuzzy query, or FuzzinessAuto
+
+	// Bounds of a range query; nil when the range is open on that side
+	Gt  interface{} // Exclusive lower bound
+	Gte interface{} // Inclusive lower bound
+	Lt  interface{} // Exclusive upper bound
+	Lte interface{} // Inclusive upper bound
 }
 
 // Parser handles query parsing
@@ -29,13 +42,175 @@ func NewParser(defaultField string) *Parser {
 	}
 }
 
-// Parse parses a query string into a ParsedQuery object
+// Parse parses a query string into a ParsedQuery object. NOT binds tighter
+// than AND, which binds tighter than OR, and parentheses group expressions,
+// so "(quick OR brown) AND NOT fox" is an AND of an OR group and a negation.
 func (p *Parser) Parse(queryStr string) (*ParsedQuery, error) {
 	queryStr = strings.TrimSpace(queryStr)
 	if queryStr == "" {
 		return nil, fmt.Errorf("empty query")
 	}
 
+	e := &expressionParser{parser: p, tokens: tokenize(queryStr)}
+	parsed, err := e.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	switch token := e.peek(); token {
+	case "":
+		return parsed, nil
+	case ")":
+		return nil, fmt.Errorf("unbalanced closing parenthesis")
+	default:
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+}
+
+// tokenize splits a query string into words, parentheses and the AND, OR
+// and NOT operators. Quoted phrases, with any field prefix, stay one word.
+func tokenize(queryStr string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	inPhrase := false
+	for _, r := range queryStr {
+		switch {
+		case r == '"':
+			inPhrase = !inPhrase
+			word.WriteRune(r)
+		case inPhrase:
+			word.WriteRune(r)
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// isOperator reports whether a token is an operator or parenthesis rather
+// than part of a clause
+func isOperator(token string) bool {
+	switch token {
+	case "AND", "OR", "NOT", "(", ")":
+		return true
+	}
+	return false
+}
+
+// expressionParser is a recursive-descent parser over query tokens
+type expressionParser struct {
+	parser *Parser
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token, or "" at the end of the query
+func (e *expressionParser) peek() string {
+	if e.pos < len(e.tokens) {
+		return e.tokens[e.pos]
+	}
+	return ""
+}
+
+// parseOr parses OR-separated AND expressions, the lowest precedence
+func (e *expressionParser) parseOr() (*ParsedQuery, error) {
+	return e.parseGroup("OR", e.parseAnd)
+}
+
+// parseAnd parses AND-separated NOT expressions. A NOT directly after an
+// operand is an implicit AND, as in "quick NOT fox".
+func (e *expressionParser) parseAnd() (*ParsedQuery, error) {
+	return e.parseGroup("AND", e.parseNot)
+}
+
+// parseGroup parses operands joined by an operator into one flat group, or
+// returns the single operand when the operator doesn't follow it
+func (e *expressionParser) parseGroup(operator string, operand func() (*ParsedQuery, error)) (*ParsedQuery, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	group := &ParsedQuery{Type: TermQuery, SubQueries: []ParsedQuery{*first}, Operator: operator}
+	for {
+		token := e.peek()
+		if token == operator {
+			e.pos++
+		} else if operator != "AND" || token != "NOT" {
+			break
+		}
+
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		group.SubQueries = append(group.SubQueries, *next)
+	}
+
+	if len(group.SubQueries) == 1 {
+		return first, nil
+	}
+	return group, nil
+}
+
+// parseNot parses a negation, which binds tighter than AND and OR
+func (e *expressionParser) parseNot() (*ParsedQuery, error) {
+	if e.peek() != "NOT" {
+		return e.parsePrimary()
+	}
+	e.pos++
+
+	negated, err := e.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return &ParsedQuery{Type: TermQuery, SubQueries: []ParsedQuery{*negated}, Operator: "NOT"}, nil
+}
+
+// parsePrimary parses a parenthesized expression or a clause of consecutive
+// words, such as title:quick or a quoted phrase
+func (e *expressionParser) parsePrimary() (*ParsedQuery, error) {
+	switch token := e.peek(); token {
+	case "(":
+		e.pos++
+		group, err := e.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if e.peek() != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis")
+		}
+		e.pos++
+		return group, nil
+	case "":
+		return nil, fmt.Errorf("unexpected end of query")
+	case ")", "AND", "OR":
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+
+	var words []string
+	for token := e.peek(); token != "" && !isOperator(token); token = e.peek() {
+		words = append(words, token)
+		e.pos++
+	}
+	return e.parser.parseClause(strings.Join(words, " "))
+}
+
+// parseClause parses a single clause without operators: a field query, a
+// phrase or plain terms
+func (p *Parser) parseClause(queryStr string) (*ParsedQuery, error) {
 	// Handle field-specific queries (field:value)
 	if strings.Contains(queryStr, ":") {
 		parts := strings.SplitN(queryStr, ":", 2)
@@ -49,6 +224,11 @@ func (p *Parser) Parse(queryStr string) (*ParsedQuery, error) {
 			return nil, fmt.Errorf("empty field value")
 		}
 		
+		// Handle range queries
+		if isRange(value) {
+			return parseRange(field, value)
+		}
+
 		// Handle phrase queries
 		if strings.HasPrefix(value, "\"") && strings.HasSuffix(value, "\"") {
 			value = strings.Trim(value, "\"")
@@ -64,16 +244,21 @@ func (p *Parser) Parse(queryStr string) (*ParsedQuery, error) {
 			}, nil
 		}
 		
+		value, boost, err := splitBoost(value)
+		if err != nil {
+			return nil, err
+		}
 		terms := strings.Fields(value)
 		if len(terms) == 0 {
 			return nil, fmt.Errorf("empty field value")
 		}
 		
-		return &ParsedQuery{
+		return parseTermSyntax(&ParsedQuery{
 			Type:  FieldQuery,
 			Field: field,
 			Terms: terms,
-		}, nil
+			Boost: boost,
+		})
 	}
 
 	// Handle phrase queries
@@ -91,52 +276,155 @@ func (p *Parser) Parse(queryStr string) (*ParsedQuery, error) {
 		}, nil
 	}
 
-	// Handle AND/OR queries
-	if strings.Contains(queryStr, " AND ") {
-		parts := strings.Split(queryStr, " AND ")
-		subQueries := make([]ParsedQuery, 0, len(parts))
-		for _, part := range parts {
-			subQuery, err := p.Parse(part)
-			if err != nil {
-				return nil, err
-			}
-			subQueries = append(subQueries, *subQuery)
-		}
-		return &ParsedQuery{
-			Type:       TermQuery,
-			SubQueries: subQueries,
-			Operator:   "AND",
-		}, nil
-	}
-
-	if strings.Contains(queryStr, " OR ") {
-		parts := strings.Split(queryStr, " OR ")
-		subQueries := make([]ParsedQuery, 0, len(parts))
-		for _, part := range parts {
-			subQuery, err := p.Parse(part)
-			if err != nil {
-				return nil, err
-			}
-			subQueries = append(subQueries, *subQuery)
-		}
-		return &ParsedQuery{
-			Type:       TermQuery,
-			SubQueries: subQueries,
-			Operator:   "OR",
-		}, nil
+	// Handle range queries
+	if isRange(queryStr) {
+		return parseRange(p.defaultField, queryStr)
 	}
 
 	// Simple term query
+	queryStr, boost, err := splitBoost(queryStr)
+	if err != nil {
+		return nil, err
+	}
 	terms := strings.Fields(queryStr)
 	if len(terms) == 0 {
 		return nil, fmt.Errorf("empty query")
 	}
 
-	return &ParsedQuery{
+	return parseTermSyntax(&ParsedQuery{
 		Type:  TermQuery,
 		Field: p.defaultField,
 		Terms: terms,
-	}, nil
+		Boost: boost,
+	})
+}
+
+// isRange reports whether a value is written as a range, [a TO b] or {a TO b}
+func isRange(value string) bool {
+	return strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{")
+}
+
+// parseRange parses a Lucene-style range. Square brackets include their
+// bound and braces exclude it, and they may be mixed, as in [10 TO 20}. A *
+// bound leaves that side of the range open.
+func parseRange(field, value string) (*ParsedQuery, error) {
+	closing := value[len(value)-1]
+	if len(value) < 2 || (closing != ']' && closing != '}') {
+		return nil, fmt.Errorf("malformed range %q: missing closing bracket", value)
+	}
+	bounds := strings.Fields(value[1 : len(value)-1])
+	if len(bounds) != 3 || bounds[1] != "TO" {
+		return nil, fmt.Errorf("malformed range %q: expected [lower TO upper]", value)
+	}
+
+	parsed := &ParsedQuery{Type: RangeQuery, Field: field}
+	if lower := bounds[0]; lower != "*" {
+		if value[0] == '[' {
+			parsed.Gte = rangeBound(lower)
+		} else {
+			parsed.Gt = rangeBound(lower)
+		}
+	}
+	if upper := bounds[2]; upper != "*" {
+		if closing == ']' {
+			parsed.Lte = rangeBound(upper)
+		} else {
+			parsed.Lt = rangeBound(upper)
+		}
+	}
+	return parsed, nil
+}
+
+// rangeBound converts a range bound to the value range queries compare:
+// numbers become float64 and dates, RFC 3339 or plain yyyy-mm-dd, become
+// times. Other bounds are kept as strings.
+func rangeBound(bound string) interface{} {
+	if n, err := strconv.ParseFloat(bound, 64); err == nil {
+		return n
+	}
+	if date, ok := document.ParseDate(bound); ok {
+		return date
+	}
+	if date, err := time.Parse("2006-01-02", bound); err == nil {
+		return date
+	}
+	return bound
+}
+
+// parseTermSyntax turns a single term ending in * into a prefix query, and
+// one ending in ~ or ~n into a fuzzy query with a maximum edit distance of n.
+// A backslash escapes the next character, so qui\* searches for "qui*".
+func parseTermSyntax(parsed *ParsedQuery) (*ParsedQuery, error) {
+	if len(parsed.Terms) == 1 {
+		term := parsed.Terms[0]
+		tilde := strings.LastIndex(term, "~")
+		switch {
+		case strings.HasSuffix(term, "*") && !escapedAt(term, len(term)-1):
+			parsed.Type = PrefixQuery
+			parsed.Terms[0] = term[:len(term)-1]
+		case tilde >= 0 && !escapedAt(term, tilde):
+			parsed.Type = FuzzyQuery
+			parsed.Fuzziness = DefaultFuzziness
+			if tilde < len(term)-1 {
+				fuzziness, err := ParseFuzziness(term[tilde+1:])
+				if err != nil {
+					return nil, err
+				}
+				parsed.Fuzziness = fuzziness
+			}
+			parsed.Terms[0] = term[:tilde]
+		}
+	}
+
+	for i, term := range parsed.Terms {
+		parsed.Terms[i] = unescapeTerm(term)
+	}
+	if (parsed.Type == PrefixQuery || parsed.Type == FuzzyQuery) && parsed.Terms[0] == "" {
+		return nil, fmt.Errorf("prefix and fuzzy queries require a term")
+	}
+	return parsed, nil
+}
+
+// escapedAt reports whether the character at index i is escaped by an odd
+// number of backslashes
+func escapedAt(s string, i int) bool {
+	backslashes := 0
+	for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// unescapeTerm removes the backslashes escaping characters of a term
+func unescapeTerm(term string) string {
+	if !strings.Contains(term, "\\") {
+		return term
+	}
+	var b strings.Builder
+	escaped := false
+	for _, r := range term {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// splitBoost splits a trailing ^boost off a query value, as in title:go^2.
+// The boost is 0 when the value has none.
+func splitBoost(value string) (string, float64, error) {
+	i := strings.LastIndex(value, "^")
+	if i < 0 {
+		return value, 0, nil
+	}
+	boost, err := strconv.ParseFloat(value[i+1:], 64)
+	if err != nil || !(boost > 0) || math.IsInf(boost, 0) {
+		return "", 0, fmt.Errorf("invalid boost %q: must be a positive number", value[i+1:])
+	}
+	return strings.TrimSpace(value[:i]), boost, nil
 }
 
 // Validate checks if a query is valid
@@ -145,7 +433,7 @@ func (p *Parser) Validate(query *ParsedQuery) error {
 		return fmt.Errorf("nil query")
 	}
 
-	if len(query.Terms) == 0 && len(query.SubQueries) == 0 {
+	if len(query.Terms) == 0 && len(query.SubQueries) == 0 && query.Type != RangeQuery {
 		return fmt.Errorf("query must contain at least one term or subquery")
 	}
 
@@ -153,6 +441,10 @@ func (p *Parser) Validate(query *ParsedQuery) error {
 		return fmt.Errorf("phrase query must contain at least two terms")
 	}
 
+	if query.Operator == "NOT" && len(query.SubQueries) != 1 {
+		return fmt.Errorf("NOT query must negate exactly one subquery")
+	}
+
 	// Validate subqueries recursively
 	for _, subQuery := range query.SubQueries {
 		if err := p.Validate(&subQuery); err != nil {
@@ -162,3 +454,149 @@ func (p *Parser) Validate(query *ParsedQuery) error {
 
 	return nil
 }
+
+// ToQuery converts a parsed query into a query tree the executor can run.
+// AND and OR groups become bool queries with must and should clauses, and
+// negations become must_not clauses. Field and term queries become match
+// queries, and phrases become match_phrase queries. Clauses without a field
+// use the parser's default field.
+func (p *Parser) ToQuery(parsed *ParsedQuery) (Query, error) {
+	if parsed == nil {
+		return nil, fmt.Errorf("nil query")
+	}
+
+	if len(parsed.SubQueries) > 0 {
+		bq := NewBooleanQuery()
+		for i := range parsed.SubQueries {
+			subQuery := &parsed.SubQueries[i]
+			var err error
+			switch {
+			case parsed.Operator == "NOT":
+				err = p.addClause(bq.AddMustNot, subQuery)
+			case parsed.Operator == "AND" && subQuery.Operator == "NOT" && len(subQuery.SubQueries) == 1:
+				// Negations inside an AND group exclude from the group
+				err = p.addClause(bq.AddMustNot, &subQuery.SubQueries[0])
+			case parsed.Operator == "AND":
+				err = p.addClause(bq.AddMust, subQuery)
+			default:
+				err = p.addClause(bq.AddShould, subQuery)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// A purely negative query excludes from all documents
+		if len(bq.Must()) == 0 && len(bq.Should()) == 0 {
+			bq.AddMust(NewMatchAllQuery())
+		}
+		return bq, nil
+	}
+
+	field := parsed.Field
+	if field == "" {
+		field = p.defaultField
+	}
+
+	if parsed.Type == RangeQuery {
+		rq := NewRangeQuery(field)
+		if parsed.Gt != nil {
+			rq.GreaterThan(parsed.Gt)
+		}
+		if parsed.Gte != nil {
+			rq.GreaterThanOrEqual(parsed.Gte)
+		}
+		if parsed.Lt != nil {
+			rq.LessThan(parsed.Lt)
+		}
+		if parsed.Lte != nil {
+			rq.LessThanOrEqual(parsed.Lte)
+		}
+		return rq, nil
+	}
+
+	if len(parsed.Terms) == 0 {
+		return nil, fmt.Errorf("query must contain at least one term or subquery")
+	}
+
+	text := strings.Join(parsed.Terms, " ")
+	if parsed.IsPhrase {
+		return NewMatchPhraseQuery(field, text), nil
+	}
+
+	// Prefix and fuzzy terms match indexed terms directly, which the
+	// analyzer lowercased
+	switch parsed.Type {
+	case PrefixQuery:
+		pq := NewPrefixQuery(field, strings.ToLower(text))
+		if parsed.Boost > 0 {
+			pq.SetBoost(parsed.Boost)
+		}
+		return pq, nil
+	case FuzzyQuery:
+		fq := NewFuzzyQuery(field, strings.ToLower(text))
+		fq.SetFuzziness(parsed.Fuzziness)
+		if parsed.Boost > 0 {
+			fq.SetBoost(parsed.Boost)
+		}
+		return fq, nil
+	}
+
+	mq := NewMatchQuery(field, text)
+	if parsed.Boost > 0 {
+		mq.SetBoost(parsed.Boost)
+	}
+	return mq, nil
+}
+
+// addClause converts a parsed subquery and adds it to a bool query with add
+func (p *Parser) addClause(add func(Query), parsed *ParsedQuery) error {
+	sub, err := p.ToQuery(parsed)
+	if err != nil {
+		return err
+	}
+	add(sub)
+	return nil
+}
+
+// ParseSimple leniently parses simple_query_string syntax into a query tree.
+// Terms prefixed with + must match, terms prefixed with - must not match and
+// other terms should match. It never fails: stray operators, quotes and
+// parentheses are ignored rather than reported as syntax errors.
+func (p *Parser) ParseSimple(queryStr string) Query {
+	bq := NewBooleanQuery()
+	for _, token := range strings.Fields(queryStr) {
+		occur := byte(0)
+		if token[0] == '+' || token[0] == '-' {
+			occur = token[0]
+		}
+
+		// Drop operator and grouping characters the lenient parser ignores
+		term := strings.Map(func(r rune) rune {
+			switch r {
+			case '+', '-', '|', '"', '(', ')':
+				return -1
+			}
+			return r
+		}, token)
+		if term == "" {
+			continue
+		}
+
+		clause := NewMatchQuery(p.defaultField, term)
+		switch occur {
+		case '+':
+			bq.AddMust(clause)
+		case '-':
+			bq.AddMustNot(clause)
+		default:
+			bq.AddShould(clause)
+		}
+	}
+
+	// A purely negative query excludes from all documents, as in Elasticsearch
+	if len(bq.Must()) == 0 && len(bq.Should()) == 0 && len(bq.MustNot()) > 0 {
+		bq.AddMust(NewMatchAllQuery())
+	}
+	return bq
+}
@@ -3,6 +3,7 @@ package query
 import (
 	"testing"
 	"reflect"
+	"time"
 )
 
 func TestQueryParser(t *testing.T) {
@@ -34,6 +35,160 @@ func TestQueryParser(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "Boosted field query",
+			input: "title:test^2.5",
+			want: &ParsedQuery{
+				Type:  FieldQuery,
+				Field: "title",
+				Terms: []string{"test"},
+				Boost: 2.5,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Boosted term query",
+			input: "test^2",
+			want: &ParsedQuery{
+				Type:  TermQuery,
+				Field: "content",
+				Terms: []string{"test"},
+				Boost: 2,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Prefix query",
+			input: "qui*",
+			want: &ParsedQuery{
+				Type:  PrefixQuery,
+				Field: "content",
+				Terms: []string{"qui"},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Field prefix query",
+			input: "title:qui*",
+			want: &ParsedQuery{
+				Type:  PrefixQuery,
+				Field: "title",
+				Terms: []string{"qui"},
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Fuzzy query",
+			input: "quik~1",
+			want: &ParsedQuery{
+				Type:      FuzzyQuery,
+				Field:     "content",
+				Terms:     []string{"quik"},
+				Fuzziness: 1,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Fuzzy query with default distance",
+			input: "title:quik~",
+			want: &ParsedQuery{
+				Type:      FuzzyQuery,
+				Field:     "title",
+				Terms:     []string{"quik"},
+				Fuzziness: DefaultFuzziness,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Escaped asterisk",
+			input: `qui\*`,
+			want: &ParsedQuery{
+				Type:  TermQuery,
+				Field: "content",
+				Terms: []string{"qui*"},
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Invalid fuzzy distance",
+			input:   "quik~3",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:  "Inclusive range",
+			input: "age:[18 TO 65]",
+			want: &ParsedQuery{
+				Type:  RangeQuery,
+				Field: "age",
+				Gte:   18.0,
+				Lte:   65.0,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Exclusive range",
+			input: "price:{10 TO 20}",
+			want: &ParsedQuery{
+				Type:  RangeQuery,
+				Field: "price",
+				Gt:    10.0,
+				Lt:    20.0,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Mixed range",
+			input: "price:[10 TO 20}",
+			want: &ParsedQuery{
+				Type:  RangeQuery,
+				Field: "price",
+				Gte:   10.0,
+				Lt:    20.0,
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Open-ended range",
+			input: "date:[2020-01-01 TO *]",
+			want: &ParsedQuery{
+				Type:  RangeQuery,
+				Field: "date",
+				Gte:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Range in a group",
+			input: "title:go AND (age:[* TO 30])",
+			want: &ParsedQuery{
+				Type: TermQuery,
+				SubQueries: []ParsedQuery{
+					{Type: FieldQuery, Field: "title", Terms: []string{"go"}},
+					{Type: RangeQuery, Field: "age", Lte: 30.0},
+				},
+				Operator: "AND",
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Malformed range",
+			input:   "age:[18 65]",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Unclosed range",
+			input:   "age:[18 TO 65",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid boost",
+			input:   "title:test^high",
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:  "Phrase query",
 			input: "\"quick brown fox\"",
@@ -77,6 +232,27 @@ func TestQueryParser(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "Field-scoped AND query",
+			input: "title:foo AND bar",
+			want: &ParsedQuery{
+				Type: TermQuery,
+				SubQueries: []ParsedQuery{
+					{
+						Type:  FieldQuery,
+						Field: "title",
+						Terms: []string{"foo"},
+					},
+					{
+						Type:  TermQuery,
+						Field: "content",
+						Terms: []string{"bar"},
+					},
+				},
+				Operator: "AND",
+			},
+			wantErr: false,
+		},
 		{
 			name:  "OR query",
 			input: "quick OR fox",
@@ -98,6 +274,115 @@ func TestQueryParser(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:  "NOT query",
+			input: "quick AND NOT fox",
+			want: &ParsedQuery{
+				Type: TermQuery,
+				SubQueries: []ParsedQuery{
+					{Type: TermQuery, Field: "content", Terms: []string{"quick"}},
+					{
+						Type:       TermQuery,
+						SubQueries: []ParsedQuery{{Type: TermQuery, Field: "content", Terms: []string{"fox"}}},
+						Operator:   "NOT",
+					},
+				},
+				Operator: "AND",
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Implicit AND before NOT",
+			input: "quick NOT title:fox",
+			want: &ParsedQuery{
+				Type: TermQuery,
+				SubQueries: []ParsedQuery{
+					{Type: TermQuery, Field: "content", Terms: []string{"quick"}},
+					{
+						Type:       TermQuery,
+						SubQueries: []ParsedQuery{{Type: FieldQuery, Field: "title", Terms: []string{"fox"}}},
+						Operator:   "NOT",
+					},
+				},
+				Operator: "AND",
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Grouped query",
+			input: "(quick OR brown) AND dog",
+			want: &ParsedQuery{
+				Type: TermQuery,
+				SubQueries: []ParsedQuery{
+					{
+						Type: TermQuery,
+						SubQueries: []ParsedQuery{
+							{Type: TermQuery, Field: "content", Terms: []string{"quick"}},
+							{Type: TermQuery, Field: "content", Terms: []string{"brown"}},
+						},
+						Operator: "OR",
+					},
+					{Type: TermQuery, Field: "content", Terms: []string{"dog"}},
+				},
+				Operator: "AND",
+			},
+			wantErr: false,
+		},
+		{
+			name:  "AND binds tighter than OR",
+			input: "quick OR brown AND dog",
+			want: &ParsedQuery{
+				Type: TermQuery,
+				SubQueries: []ParsedQuery{
+					{Type: TermQuery, Field: "content", Terms: []string{"quick"}},
+					{
+						Type: TermQuery,
+						SubQueries: []ParsedQuery{
+							{Type: TermQuery, Field: "content", Terms: []string{"brown"}},
+							{Type: TermQuery, Field: "content", Terms: []string{"dog"}},
+						},
+						Operator: "AND",
+					},
+				},
+				Operator: "OR",
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Phrase inside a group",
+			input: "(title:\"quick (brown) fox\")",
+			want: &ParsedQuery{
+				Type:     PhraseQuery,
+				Field:    "title",
+				Terms:    []string{"quick", "(brown)", "fox"},
+				IsPhrase: true,
+			},
+			wantErr: false,
+		},
+		{
+			name:    "Missing closing parenthesis",
+			input:   "(quick OR brown AND dog",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Unbalanced closing parenthesis",
+			input:   "quick OR brown) AND dog",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Empty group",
+			input:   "()",
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "Dangling operator",
+			input:   "quick AND",
+			want:    nil,
+			wantErr: true,
+		},
 		{
 			name:    "Empty query",
 			input:   "",
@@ -130,6 +415,117 @@ func TestQueryParser(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Negation to query", func(t *testing.T) {
+		tests := []struct {
+			input   string
+			mustNot int
+			matches map[string]bool
+		}{
+			{"quick AND NOT fox", 1, map[string]bool{"quick": true, "fox": false}},
+			{"NOT fox", 1, map[string]bool{"quick": true, "fox": false}},
+			{"NOT (quick OR fox)", 1, map[string]bool{"quick": false, "fox": false, "dog": true}},
+		}
+		for _, tt := range tests {
+			parsed, err := parser.Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tt.input, err)
+			}
+			q, err := parser.ToQuery(parsed)
+			if err != nil {
+				t.Fatalf("ToQuery(%q) error = %v", tt.input, err)
+			}
+			bq, ok := q.(*BooleanQueryImpl)
+			if !ok || len(bq.MustNot()) != tt.mustNot {
+				t.Fatalf("Expected %q to become a bool query with %d must_not clauses, got %#v", tt.input, tt.mustNot, q)
+			}
+			for value, want := range tt.matches {
+				if got := q.Match(value); got != want {
+					t.Errorf("%q: Match(%q) = %v, want %v", tt.input, value, got, want)
+				}
+			}
+		}
+	})
+
+	t.Run("Prefix and fuzzy to query", func(t *testing.T) {
+		parsed, err := parser.Parse("title:Qui*")
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		q, err := parser.ToQuery(parsed)
+		if err != nil {
+			t.Fatalf("ToQuery() error = %v", err)
+		}
+		if pq, ok := q.(*PrefixQueryImpl); !ok || pq.Field() != "title" || pq.Prefix() != "qui" {
+			t.Errorf("Expected a prefix query for qui on title, got %#v", q)
+		}
+
+		parsed, err = parser.Parse("quik~1")
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		q, err = parser.ToQuery(parsed)
+		if err != nil {
+			t.Fatalf("ToQuery() error = %v", err)
+		}
+		fq, ok := q.(*FuzzyQueryImpl)
+		if !ok || fq.Value() != "quik" || fq.Fuzziness() != 1 {
+			t.Fatalf("Expected a fuzzy query for quik with distance 1, got %#v", q)
+		}
+		if !fq.MatchTerm("quick") || fq.MatchTerm("quack") {
+			t.Error("Expected the fuzzy query to match within one edit only")
+		}
+	})
+
+	t.Run("Range to query", func(t *testing.T) {
+		parsed, err := parser.Parse("age:[18 TO 65}")
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		q, err := parser.ToQuery(parsed)
+		if err != nil {
+			t.Fatalf("ToQuery() error = %v", err)
+		}
+		rq, ok := q.(*RangeQueryImpl)
+		if !ok || rq.Field() != "age" {
+			t.Fatalf("Expected a range query on age, got %#v", q)
+		}
+		for value, want := range map[float64]bool{17: false, 18: true, 64: true, 65: false} {
+			if got := rq.Match(value); got != want {
+				t.Errorf("Match(%v) = %v, want %v", value, got, want)
+			}
+		}
+	})
+
+	t.Run("Boost carried to query", func(t *testing.T) {
+		parsed, err := parser.Parse("title:go^3")
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		q, err := parser.ToQuery(parsed)
+		if err != nil {
+			t.Fatalf("ToQuery() error = %v", err)
+		}
+		mq, ok := q.(*MatchQueryImpl)
+		if !ok || mq.Boost() != 3 {
+			t.Errorf("Expected a match query with boost 3, got %#v", q)
+		}
+
+		for input, want := range map[string]float64{"title:qui*^2": 2, "quik~1^4": 4, "title:qui*": 1} {
+			parsed, err := parser.Parse(input)
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", input, err)
+			}
+			q, err := parser.ToQuery(parsed)
+			if err != nil {
+				t.Fatalf("ToQuery(%q) error = %v", input, err)
+			}
+			mtq, ok := q.(MultiTermQuery)
+			if !ok || mtq.Boost() != want {
+				t.Errorf("%q: expected a multi-term query with boost %v, got %#v", input, want, q)
+			}
+		}
+	})
 }
 
 func TestQueryValidation(t *testing.T) {
@@ -203,3 +599,41 @@ func TestQueryValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSimple(t *testing.T) {
+	parser := NewParser("content")
+
+	// Malformed input degrades gracefully instead of failing
+	for _, input := range []string{"", "+", "- -- |", "\"unclosed", "((foo", "foo +", "+-bar"} {
+		if q := parser.ParseSimple(input); q == nil {
+			t.Errorf("ParseSimple(%q) returned nil", input)
+		}
+	}
+
+	q, ok := parser.ParseSimple("foo + bar -baz +qux \"(").(*BooleanQueryImpl)
+	if !ok {
+		t.Fatal("Expected a boolean query")
+	}
+	texts := func(queries []Query) []string {
+		out := make([]string, 0, len(queries))
+		for _, q := range queries {
+			out = append(out, q.(*MatchQueryImpl).Text())
+		}
+		return out
+	}
+	if got := texts(q.Should()); !reflect.DeepEqual(got, []string{"foo", "bar"}) {
+		t.Errorf("Expected should clauses [foo bar], got %v", got)
+	}
+	if got := texts(q.Must()); !reflect.DeepEqual(got, []string{"qux"}) {
+		t.Errorf("Expected must clauses [qux], got %v", got)
+	}
+	if got := texts(q.MustNot()); !reflect.DeepEqual(got, []string{"baz"}) {
+		t.Errorf("Expected must_not clauses [baz], got %v", got)
+	}
+
+	// A purely negative query excludes from all documents
+	negative := parser.ParseSimple("-baz").(*BooleanQueryImpl)
+	if len(negative.Must()) != 1 || negative.Must()[0].Type() != MatchAllQuery {
+		t.Error("Expected a purely negative query to match all other documents")
+	}
+}
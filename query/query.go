@@ -2,7 +2,10 @@ package query
 
 import (
 	"fmt"
+	"math"
 	"my-indexer/document"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,6 +32,18 @@ const (
 	MatchPhraseQuery
 	// MatchAllQuery for matching all documents
 	MatchAllQuery
+	// ExistsQuery for documents that contain a field
+	ExistsQuery
+	// WildcardQuery for terms matching a pattern with * and ? wildcards
+	WildcardQuery
+	// FuzzyQuery for terms within an edit distance of a value
+	FuzzyQuery
+	// TermsQuery for exact matches of any of several terms
+	TermsQuery
+	// ConstantScoreQuery for filters that give every match the same score
+	ConstantScoreQuery
+	// IdsQuery for documents with the given IDs
+	IdsQuery
 )
 
 // Query represents the internal query interface
@@ -40,24 +55,104 @@ type Query interface {
 
 // TermQueryImpl represents an exact term match query
 type TermQueryImpl struct {
-	field string
-	term  string
+	field         string
+	term          string
+	caseSensitive bool    // When false, terms match regardless of case like the analyzer
+	boost         float64 // Multiplies the score of matching documents
 }
 
 func NewTermQuery(field, term string) *TermQueryImpl {
-	return &TermQueryImpl{field: field, term: term}
+	return &TermQueryImpl{field: field, term: term, boost: 1}
+}
+
+func (q *TermQueryImpl) Type() QueryType     { return TermQuery }
+func (q *TermQueryImpl) Field() string       { return q.field }
+func (q *TermQueryImpl) Term() string        { return q.term }
+func (q *TermQueryImpl) CaseSensitive() bool { return q.caseSensitive }
+func (q *TermQueryImpl) Boost() float64      { return q.boost }
+
+// SetBoost sets the factor the scores of matching documents are multiplied by
+func (q *TermQueryImpl) SetBoost(boost float64) {
+	q.boost = boost
+}
+
+// SetCaseSensitive controls whether the term must match the original case
+func (q *TermQueryImpl) SetCaseSensitive(caseSensitive bool) {
+	q.caseSensitive = caseSensitive
 }
 
-func (q *TermQueryImpl) Type() QueryType { return TermQuery }
-func (q *TermQueryImpl) Field() string   { return q.field }
-func (q *TermQueryImpl) Term() string    { return q.term }
 func (q *TermQueryImpl) Match(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		if q.caseSensitive {
+			return v == q.term
+		}
+		return strings.EqualFold(v, q.term)
+	case bool:
+		// Booleans are indexed as "true" and "false"
+		return strconv.FormatBool(v) == strings.ToLower(q.term)
+	case int, int64, float64:
+		// Numbers compare by value, so a term of "30" matches 30.0
+		term, err := strconv.ParseFloat(q.term, 64)
+		if err != nil {
+			return false
+		}
+		switch n := v.(type) {
+		case int:
+			return float64(n) == term
+		case int64:
+			return float64(n) == term
+		case float64:
+			return n == term
+		}
+	}
+	return false
+}
+
+// TermsQueryImpl represents a query matching any of several exact terms
+type TermsQueryImpl struct {
+	field string
+	terms []string
+}
+
+func NewTermsQuery(field string, terms []string) *TermsQueryImpl {
+	return &TermsQueryImpl{field: field, terms: terms}
+}
+
+func (q *TermsQueryImpl) Type() QueryType { return TermsQuery }
+func (q *TermsQueryImpl) Field() string   { return q.field }
+func (q *TermsQueryImpl) Terms() []string { return q.terms }
+
+func (q *TermsQueryImpl) Match(value interface{}) bool {
 	if str, ok := value.(string); ok {
-		return str == q.term
+		for _, term := range q.terms {
+			if strings.EqualFold(str, term) {
+				return true
+			}
+		}
 	}
 	return false
 }
 
+// IdsQueryImpl represents a query for documents by ID
+type IdsQueryImpl struct {
+	ids []string
+}
+
+func NewIdsQuery(ids []string) *IdsQueryImpl {
+	return &IdsQueryImpl{ids: ids}
+}
+
+func (q *IdsQueryImpl) Type() QueryType { return IdsQuery }
+func (q *IdsQueryImpl) Field() string   { return "" }
+func (q *IdsQueryImpl) IDs() []string   { return q.ids }
+
+// Match never matches a field value: field values don't carry the ID of
+// their document, so ids queries are resolved against the index instead
+func (q *IdsQueryImpl) Match(value interface{}) bool {
+	return false
+}
+
 // RangeQueryImpl implements a range query
 type RangeQueryImpl struct {
 	field string
@@ -128,7 +223,7 @@ func (q *RangeQueryImpl) matchNumeric(val float64) bool {
 
 func (q *RangeQueryImpl) matchTime(val time.Time) bool {
 	if q.gt != nil {
-		if gt, ok := q.gt.(time.Time); ok && val.Before(gt) || val.Equal(gt) {
+		if gt, ok := q.gt.(time.Time); ok && !val.After(gt) {
 			return false
 		}
 	}
@@ -138,7 +233,7 @@ func (q *RangeQueryImpl) matchTime(val time.Time) bool {
 		}
 	}
 	if q.lt != nil {
-		if lt, ok := q.lt.(time.Time); ok && val.After(lt) || val.Equal(lt) {
+		if lt, ok := q.lt.(time.Time); ok && !val.Before(lt) {
 			return false
 		}
 	}
@@ -155,8 +250,20 @@ func (q *RangeQueryImpl) Match(value interface{}) bool {
 	switch v := value.(type) {
 	case float64:
 		return q.matchNumeric(v)
+	case int:
+		return q.matchNumeric(float64(v))
+	case int64:
+		return q.matchNumeric(float64(v))
 	case time.Time:
 		return q.matchTime(v)
+	case document.Field:
+		// A multi-valued field matches when any of its values is in range
+		for _, element := range v.Values() {
+			if q.Match(element) {
+				return true
+			}
+		}
+		return false
 	}
 
 	// Handle document case
@@ -169,16 +276,7 @@ func (q *RangeQueryImpl) Match(value interface{}) bool {
 	if err != nil {
 		return false
 	}
-
-	// Handle field value comparison
-	switch v := field.Value.(type) {
-	case float64:
-		return q.matchNumeric(v)
-	case time.Time:
-		return q.matchTime(v)
-	default:
-		return false
-	}
+	return q.Match(field)
 }
 
 // BooleanQueryImpl represents a boolean combination of queries
@@ -187,6 +285,7 @@ type BooleanQueryImpl struct {
 	must     []Query
 	should   []Query
 	mustNot  []Query
+	filter   []Query
 	minMatch int
 }
 
@@ -197,13 +296,27 @@ func NewBooleanQuery() *BooleanQueryImpl {
 func (q *BooleanQueryImpl) Type() QueryType { return BooleanQuery }
 func (q *BooleanQueryImpl) Field() string   { return q.field }
 
-func (q *BooleanQueryImpl) Must() []Query   { return q.must }
-func (q *BooleanQueryImpl) Should() []Query { return q.should }
+func (q *BooleanQueryImpl) Must() []Query    { return q.must }
+func (q *BooleanQueryImpl) Should() []Query  { return q.should }
+func (q *BooleanQueryImpl) MustNot() []Query { return q.mustNot }
+func (q *BooleanQueryImpl) Filter() []Query  { return q.filter }
+
+func (q *BooleanQueryImpl) MinimumShouldMatch() int { return q.minMatch }
+
+// SetMinimumShouldMatch sets how many should clauses a document must match
+// when the query has no must clauses. It defaults to 1.
+func (q *BooleanQueryImpl) SetMinimumShouldMatch(n int) {
+	q.minMatch = n
+}
 
 func (q *BooleanQueryImpl) AddMust(query Query)    { q.must = append(q.must, query) }
 func (q *BooleanQueryImpl) AddShould(query Query)  { q.should = append(q.should, query) }
 func (q *BooleanQueryImpl) AddMustNot(query Query) { q.mustNot = append(q.mustNot, query) }
 
+// AddFilter adds a clause documents must match, like a must clause, but
+// that doesn't contribute to their score
+func (q *BooleanQueryImpl) AddFilter(query Query) { q.filter = append(q.filter, query) }
+
 func (q *BooleanQueryImpl) Match(value interface{}) bool {
 	// Handle map values for field-specific queries
 	valueMap, ok := value.(map[string]string)
@@ -216,6 +329,13 @@ func (q *BooleanQueryImpl) Match(value interface{}) bool {
 			}
 		}
 
+		// Must match all FILTER queries
+		for _, filter := range q.filter {
+			if !filter.Match(value) {
+				return false
+			}
+		}
+
 		// Must not match any MUST NOT queries
 		for _, mustNot := range q.mustNot {
 			if mustNot.Match(value) {
@@ -249,6 +369,14 @@ func (q *BooleanQueryImpl) Match(value interface{}) bool {
 		}
 	}
 
+	// Must match all FILTER queries
+	for _, filter := range q.filter {
+		fieldValue, exists := valueMap[filter.Field()]
+		if !exists || !filter.Match(fieldValue) {
+			return false
+		}
+	}
+
 	// Must not match any MUST NOT queries
 	for _, mustNot := range q.mustNot {
 		fieldValue, exists := valueMap[mustNot.Field()]
@@ -275,19 +403,105 @@ func (q *BooleanQueryImpl) Match(value interface{}) bool {
 	return true
 }
 
+// Rewrite simplifies a query tree without changing what it matches, so the
+// executor does less work. Nested bools made only of must and must_not clauses
+// are flattened into their parent, empty bools are dropped from must clauses
+// (an empty bool matches everything), and a bool left with a single must
+// clause and nothing else is replaced by that clause. The input is not modified.
+func Rewrite(q Query) Query {
+	bq, ok := q.(*BooleanQueryImpl)
+	if !ok {
+		return q
+	}
+
+	rewritten := &BooleanQueryImpl{field: bq.field, minMatch: bq.minMatch}
+	for _, must := range bq.must {
+		if must == nil {
+			continue
+		}
+		must = Rewrite(must)
+		if inner, ok := must.(*BooleanQueryImpl); ok && len(inner.should) == 0 {
+			// A conjunction inside a conjunction can be merged into it
+			rewritten.must = append(rewritten.must, inner.must...)
+			rewritten.mustNot = append(rewritten.mustNot, inner.mustNot...)
+			rewritten.filter = append(rewritten.filter, inner.filter...)
+			continue
+		}
+		rewritten.must = append(rewritten.must, must)
+	}
+	for _, should := range bq.should {
+		if should != nil {
+			rewritten.should = append(rewritten.should, Rewrite(should))
+		}
+	}
+	for _, mustNot := range bq.mustNot {
+		if mustNot != nil {
+			rewritten.mustNot = append(rewritten.mustNot, Rewrite(mustNot))
+		}
+	}
+	for _, filter := range bq.filter {
+		if filter != nil {
+			rewritten.filter = append(rewritten.filter, Rewrite(filter))
+		}
+	}
+
+	if len(rewritten.must) == 1 && len(rewritten.should) == 0 && len(rewritten.mustNot) == 0 &&
+		len(rewritten.filter) == 0 {
+		return rewritten.must[0]
+	}
+	return rewritten
+}
+
+// MatchOperator controls how the terms of a multi-term match query combine
+type MatchOperator int
+
+const (
+	// MatchOr matches documents containing any of the terms
+	MatchOr MatchOperator = iota
+	// MatchAnd matches only documents containing all of the terms
+	MatchAnd
+)
+
+// ParseMatchOperator parses a DSL operator value ("or" or "and", any case)
+func ParseMatchOperator(value string) (MatchOperator, error) {
+	switch strings.ToLower(value) {
+	case "or":
+		return MatchOr, nil
+	case "and":
+		return MatchAnd, nil
+	default:
+		return MatchOr, fmt.Errorf("invalid match operator %q, expected \"and\" or \"or\"", value)
+	}
+}
+
 // MatchQueryImpl represents a match query that matches analyzed text
 type MatchQueryImpl struct {
-	field string
-	text  string
+	field    string
+	text     string
+	operator MatchOperator // How multiple terms combine, OR by default
+	boost    float64       // Multiplies the score of matching documents
 }
 
 func NewMatchQuery(field, text string) *MatchQueryImpl {
-	return &MatchQueryImpl{field: field, text: text}
+	return &MatchQueryImpl{field: field, text: text, boost: 1}
+}
+
+func (q *MatchQueryImpl) Type() QueryType         { return MatchQuery }
+func (q *MatchQueryImpl) Field() string           { return q.field }
+func (q *MatchQueryImpl) Text() string            { return q.text }
+func (q *MatchQueryImpl) Operator() MatchOperator { return q.operator }
+func (q *MatchQueryImpl) Boost() float64          { return q.boost }
+
+// SetOperator controls whether any or all of the terms must match
+func (q *MatchQueryImpl) SetOperator(operator MatchOperator) {
+	q.operator = operator
+}
+
+// SetBoost sets the factor the scores of matching documents are multiplied by
+func (q *MatchQueryImpl) SetBoost(boost float64) {
+	q.boost = boost
 }
 
-func (q *MatchQueryImpl) Type() QueryType { return MatchQuery }
-func (q *MatchQueryImpl) Field() string   { return q.field }
-func (q *MatchQueryImpl) Text() string    { return q.text }
 func (q *MatchQueryImpl) Match(value interface{}) bool {
 	if str, ok := value.(string); ok {
 		// For now, we'll do a simple case-insensitive contains check
@@ -301,6 +515,7 @@ func (q *MatchQueryImpl) Match(value interface{}) bool {
 type MatchPhraseQueryImpl struct {
 	field  string
 	phrase string
+	slop   int // How far the terms may be moved apart or reordered
 }
 
 func NewMatchPhraseQuery(field, phrase string) *MatchPhraseQueryImpl {
@@ -309,6 +524,14 @@ func NewMatchPhraseQuery(field, phrase string) *MatchPhraseQueryImpl {
 
 func (q *MatchPhraseQueryImpl) Type() QueryType { return MatchPhraseQuery }
 func (q *MatchPhraseQueryImpl) Field() string   { return q.field }
+func (q *MatchPhraseQueryImpl) Phrase() string  { return q.phrase }
+func (q *MatchPhraseQueryImpl) Slop() int       { return q.slop }
+
+// SetSlop sets how many positions the phrase terms may be moved to match.
+// A slop of 0 requires the terms to be adjacent and in order.
+func (q *MatchPhraseQueryImpl) SetSlop(slop int) {
+	q.slop = slop
+}
 func (q *MatchPhraseQueryImpl) Match(value interface{}) bool {
 	if str, ok := value.(string); ok {
 		// For now, we'll do a simple case-insensitive exact match
@@ -331,6 +554,299 @@ func (q *MatchAllQueryImpl) Match(value interface{}) bool {
 	return true
 }
 
+// ExistsQueryImpl represents an exists query that matches documents containing a field
+type ExistsQueryImpl struct {
+	field string
+}
+
+func NewExistsQuery(field string) *ExistsQueryImpl {
+	return &ExistsQueryImpl{field: field}
+}
+
+func (q *ExistsQueryImpl) Type() QueryType { return ExistsQuery }
+func (q *ExistsQueryImpl) Field() string   { return q.field }
+func (q *ExistsQueryImpl) Match(value interface{}) bool {
+	if doc, ok := value.(*document.Document); ok {
+		_, err := doc.GetField(q.field)
+		return err == nil
+	}
+	return value != nil
+}
+
+// ConstantScoreQueryImpl represents a constant_score query. It matches the
+// documents its filter matches and scores every one of them its boost.
+type ConstantScoreQueryImpl struct {
+	filter Query
+	boost  float64
+}
+
+func NewConstantScoreQuery(filter Query, boost float64) *ConstantScoreQueryImpl {
+	return &ConstantScoreQueryImpl{filter: filter, boost: boost}
+}
+
+func (q *ConstantScoreQueryImpl) Type() QueryType { return ConstantScoreQuery }
+func (q *ConstantScoreQueryImpl) Field() string   { return q.filter.Field() }
+func (q *ConstantScoreQueryImpl) Filter() Query   { return q.filter }
+func (q *ConstantScoreQueryImpl) Boost() float64  { return q.boost }
+func (q *ConstantScoreQueryImpl) Match(value interface{}) bool {
+	return q.filter.Match(value)
+}
+
+// MultiTermQuery is implemented by queries that match indexed terms directly,
+// such as prefix queries, instead of analyzing their text
+type MultiTermQuery interface {
+	Query
+	MatchTerm(term string) bool
+	Boost() float64 // Score of every matching document
+}
+
+// PrefixQueryImpl represents a prefix query that matches terms starting with a prefix
+type PrefixQueryImpl struct {
+	field  string
+	prefix string
+	boost  float64 // Score of every matching document
+}
+
+func NewPrefixQuery(field, prefix string) *PrefixQueryImpl {
+	return &PrefixQueryImpl{field: field, prefix: prefix, boost: 1}
+}
+
+func (q *PrefixQueryImpl) Type() QueryType { return PrefixQuery }
+func (q *PrefixQueryImpl) Field() string   { return q.field }
+func (q *PrefixQueryImpl) Prefix() string  { return q.prefix }
+func (q *PrefixQueryImpl) Boost() float64  { return q.boost }
+
+// SetBoost sets the score of every matching document
+func (q *PrefixQueryImpl) SetBoost(boost float64) {
+	q.boost = boost
+}
+
+// MatchTerm reports whether an indexed term starts with the prefix
+func (q *PrefixQueryImpl) MatchTerm(term string) bool {
+	return strings.HasPrefix(term, q.prefix)
+}
+
+func (q *PrefixQueryImpl) Match(value interface{}) bool {
+	if str, ok := value.(string); ok {
+		for _, word := range strings.Fields(strings.ToLower(str)) {
+			if strings.HasPrefix(word, strings.ToLower(q.prefix)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WildcardQueryImpl represents a wildcard query. In the pattern, * matches any
+// sequence of characters and ? matches a single character; a backslash makes
+// the next character literal, so \* matches an asterisk.
+type WildcardQueryImpl struct {
+	field   string
+	pattern string
+	re      *regexp.Regexp
+	boost   float64 // Score of every matching document
+}
+
+func NewWildcardQuery(field, pattern string) *WildcardQueryImpl {
+	return &WildcardQueryImpl{
+		field:   field,
+		pattern: pattern,
+		re:      regexp.MustCompile(wildcardToRegexp(pattern)),
+		boost:   1,
+	}
+}
+
+func (q *WildcardQueryImpl) Type() QueryType { return WildcardQuery }
+func (q *WildcardQueryImpl) Field() string   { return q.field }
+func (q *WildcardQueryImpl) Pattern() string { return q.pattern }
+func (q *WildcardQueryImpl) Boost() float64  { return q.boost }
+
+// SetBoost sets the score of every matching document
+func (q *WildcardQueryImpl) SetBoost(boost float64) {
+	q.boost = boost
+}
+
+// MatchTerm reports whether an indexed term matches the whole pattern
+func (q *WildcardQueryImpl) MatchTerm(term string) bool {
+	return q.re.MatchString(term)
+}
+
+func (q *WildcardQueryImpl) Match(value interface{}) bool {
+	if str, ok := value.(string); ok {
+		for _, word := range strings.Fields(str) {
+			if q.MatchTerm(word) || q.MatchTerm(strings.ToLower(word)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wildcardToRegexp converts a wildcard pattern to a regular expression
+// anchored at both ends. Every character other than an unescaped * or ? is
+// matched literally.
+func wildcardToRegexp(pattern string) string {
+	var b strings.Builder
+	b.WriteString("^(?s:")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	// A trailing backslash escapes nothing and is matched literally
+	if escaped {
+		b.WriteString(regexp.QuoteMeta("\\"))
+	}
+	b.WriteString(")$")
+	return b.String()
+}
+
+// FuzzinessAuto picks the maximum edit distance of a fuzzy query from the
+// length of its value, like Elasticsearch's AUTO fuzziness
+const FuzzinessAuto = -1
+
+// DefaultFuzziness is the maximum edit distance of a fuzzy query
+const DefaultFuzziness = 2
+
+// FuzzyQueryImpl represents a fuzzy query that matches terms within a maximum
+// Levenshtein edit distance of its value, so "quik" finds "quick"
+type FuzzyQueryImpl struct {
+	field     string
+	value     string
+	fuzziness int     // Maximum edit distance, or FuzzinessAuto
+	boost     float64 // Score of every matching document
+}
+
+func NewFuzzyQuery(field, value string) *FuzzyQueryImpl {
+	return &FuzzyQueryImpl{field: field, value: value, fuzziness: DefaultFuzziness, boost: 1}
+}
+
+func (q *FuzzyQueryImpl) Type() QueryType { return FuzzyQuery }
+func (q *FuzzyQueryImpl) Field() string   { return q.field }
+func (q *FuzzyQueryImpl) Value() string   { return q.value }
+func (q *FuzzyQueryImpl) Fuzziness() int  { return q.fuzziness }
+func (q *FuzzyQueryImpl) Boost() float64  { return q.boost }
+
+// SetBoost sets the score of every matching document
+func (q *FuzzyQueryImpl) SetBoost(boost float64) {
+	q.boost = boost
+}
+
+// SetFuzziness sets the maximum edit distance, or FuzzinessAuto
+func (q *FuzzyQueryImpl) SetFuzziness(fuzziness int) {
+	q.fuzziness = fuzziness
+}
+
+// MaxEdits returns the maximum edit distance for the value. With AUTO, values
+// of up to 2 characters must match exactly, values of 3 to 5 characters allow
+// one edit and longer values allow two.
+func (q *FuzzyQueryImpl) MaxEdits() int {
+	if q.fuzziness != FuzzinessAuto {
+		return q.fuzziness
+	}
+	switch length := len([]rune(q.value)); {
+	case length < 3:
+		return 0
+	case length < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// MatchTerm reports whether an indexed term is within the maximum edit
+// distance of the value
+func (q *FuzzyQueryImpl) MatchTerm(term string) bool {
+	return withinEditDistance([]rune(q.value), []rune(term), q.MaxEdits())
+}
+
+func (q *FuzzyQueryImpl) Match(value interface{}) bool {
+	if str, ok := value.(string); ok {
+		for _, word := range strings.Fields(strings.ToLower(str)) {
+			if withinEditDistance([]rune(strings.ToLower(q.value)), []rune(word), q.MaxEdits()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseFuzziness parses a DSL fuzziness value: 0, 1 or 2 as a number or
+// string, or "AUTO"
+func ParseFuzziness(value interface{}) (int, error) {
+	var n int
+	switch v := value.(type) {
+	case float64:
+		if v != float64(int(v)) {
+			return 0, fmt.Errorf("fuzziness must be a whole number, got %v", v)
+		}
+		n = int(v)
+	case int:
+		n = v
+	case string:
+		if strings.EqualFold(v, "auto") {
+			return FuzzinessAuto, nil
+		}
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fuzziness %q, expected 0, 1, 2 or AUTO", v)
+		}
+		n = parsed
+	default:
+		return 0, fmt.Errorf("fuzziness must be a number or \"AUTO\", got %T", value)
+	}
+	if n < 0 || n > 2 {
+		return 0, fmt.Errorf("fuzziness must be 0, 1 or 2, got %d", n)
+	}
+	return n, nil
+}
+
+// withinEditDistance reports whether the Levenshtein distance between a and b
+// is at most max. It gives up as soon as the distance is known to exceed max.
+func withinEditDistance(a, b []rune, max int) bool {
+	// Every extra character costs at least one edit
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return false
+	}
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		// Distances never shrink from one row to the next
+		if rowMin > max {
+			return false
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)] <= max
+}
+
 // QueryMapper maps ElasticSearch DSL queries to internal query representations
 type QueryMapper struct{}
 
@@ -338,34 +854,100 @@ func NewQueryMapper() *QueryMapper {
 	return &QueryMapper{}
 }
 
+// ParseError is a query mapping error annotated with the JSON path of the
+// offending clause, e.g. query.bool.must[1].range.age
+type ParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// WrapParseError attaches the path of a clause to err unless a nested clause
+// already did
+func WrapParseError(err error, path string) error {
+	if _, ok := err.(*ParseError); ok {
+		return err
+	}
+	return &ParseError{Path: path, Err: err}
+}
+
 // MapQuery maps an ElasticSearch DSL query to our internal query representation
 func (m *QueryMapper) MapQuery(dslQuery map[string]interface{}) (Query, error) {
+	return m.mapQueryAt(dslQuery, "query")
+}
+
+// mapQueryAt maps a DSL query found at the given JSON path, annotating errors
+// with the path of the clause that caused them
+func (m *QueryMapper) mapQueryAt(dslQuery map[string]interface{}, path string) (Query, error) {
 	if len(dslQuery) != 1 {
-		return nil, fmt.Errorf("invalid query structure: expected exactly one root query type")
+		return nil, &ParseError{Path: path, Err: fmt.Errorf("invalid query structure: expected exactly one root query type")}
 	}
 
 	for queryType, queryBody := range dslQuery {
+		var query Query
+		var err error
 		switch queryType {
 		case "term":
-			return m.mapTermQuery(queryBody)
+			query, err = m.mapTermQuery(queryBody)
+		case "terms":
+			query, err = m.mapTermsQuery(queryBody)
 		case "match":
-			return m.mapMatchQuery(queryBody)
+			query, err = m.mapMatchQuery(queryBody)
 		case "match_phrase":
-			return m.mapMatchPhraseQuery(queryBody)
+			query, err = m.mapMatchPhraseQuery(queryBody)
 		case "match_all":
-			return NewMatchAllQuery(), nil
+			query = NewMatchAllQuery()
+		case "exists":
+			query, err = m.mapExistsQuery(queryBody)
+		case "ids":
+			query, err = m.mapIdsQuery(queryBody)
 		case "range":
-			return m.mapRangeQuery(queryBody)
+			query, err = m.mapRangeQuery(queryBody)
+		case "prefix":
+			query, err = m.mapPrefixQuery(queryBody)
+		case "wildcard":
+			query, err = m.mapWildcardQuery(queryBody)
+		case "fuzzy":
+			query, err = m.mapFuzzyQuery(queryBody)
+		case "query_string":
+			query, err = m.mapQueryStringQuery(queryBody)
+		case "simple_query_string":
+			query, err = m.mapSimpleQueryStringQuery(queryBody)
 		case "bool":
-			return m.mapBoolQuery(queryBody)
+			query, err = m.mapBoolQuery(queryBody, path+".bool")
+		case "constant_score":
+			query, err = m.mapConstantScoreQuery(queryBody, path+".constant_score")
 		default:
-			return nil, fmt.Errorf("unsupported query type: %s", queryType)
+			err = fmt.Errorf("unsupported query type: %s", queryType)
 		}
+		if err != nil {
+			return nil, WrapParseError(err, ClausePath(path, queryType, queryBody))
+		}
+		return query, nil
 	}
 
 	return nil, fmt.Errorf("invalid query structure")
 }
 
+// ClausePath returns the path of a leaf clause, including its field name when
+// the clause body names exactly one field
+func ClausePath(base, queryType string, body interface{}) string {
+	path := base + "." + queryType
+	if fields, ok := body.(map[string]interface{}); ok && len(fields) == 1 && queryType != "bool" {
+		for field := range fields {
+			path += "." + field
+		}
+	}
+	return path
+}
+
 func (m *QueryMapper) mapTermQuery(body interface{}) (Query, error) {
 	termBody, ok := body.(map[string]interface{})
 	if !ok {
@@ -378,22 +960,203 @@ func (m *QueryMapper) mapTermQuery(body interface{}) (Query, error) {
 
 	for field, value := range termBody {
 		switch v := value.(type) {
-		case string:
-			return NewTermQuery(field, v), nil
+		case string, bool, float64:
+			term, _ := termString(v)
+			return NewTermQuery(field, term), nil
 		case map[string]interface{}:
-			if termValue, ok := v["value"].(string); ok {
-				return NewTermQuery(field, termValue), nil
+			termValue, ok := termString(v["value"])
+			if !ok {
+				termValue, ok = termString(v["term"])
 			}
-			if termValue, ok := v["term"].(string); ok {
-				return NewTermQuery(field, termValue), nil
+			if ok {
+				query := NewTermQuery(field, termValue)
+				if caseInsensitive, ok := v["case_insensitive"].(bool); ok {
+					query.SetCaseSensitive(!caseInsensitive)
+				}
+				if rawBoost, exists := v["boost"]; exists {
+					boost, err := parseBoost(rawBoost)
+					if err != nil {
+						return nil, err
+					}
+					query.SetBoost(boost)
+				}
+				return query, nil
 			}
 		}
-		return nil, fmt.Errorf("term query value must be a string or {value: string}")
+		return nil, fmt.Errorf("term query value must be a string, a number, a boolean or {value: ...}")
 	}
 
 	return nil, fmt.Errorf("invalid term query structure")
 }
 
+// termString returns the term a term query value searches for. Booleans and
+// numbers are searched as the terms they are indexed under, such as "true"
+// and "30".
+func termString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case float64:
+		return document.NumberToken(v), true
+	}
+	return "", false
+}
+
+func (m *QueryMapper) mapPrefixQuery(body interface{}) (Query, error) {
+	prefixBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid prefix query structure")
+	}
+
+	if len(prefixBody) != 1 {
+		return nil, fmt.Errorf("prefix query must specify exactly one field")
+	}
+
+	for field, value := range prefixBody {
+		prefix, ok := value.(string)
+		if obj, isMap := value.(map[string]interface{}); isMap {
+			prefix, ok = obj["value"].(string)
+		}
+		if !ok {
+			return nil, fmt.Errorf("prefix query value must be a string or {value: string}")
+		}
+		if prefix == "" {
+			return nil, fmt.Errorf("prefix value cannot be empty")
+		}
+		return NewPrefixQuery(field, prefix), nil
+	}
+
+	return nil, fmt.Errorf("invalid prefix query structure")
+}
+
+func (m *QueryMapper) mapWildcardQuery(body interface{}) (Query, error) {
+	wildcardBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid wildcard query structure")
+	}
+
+	if len(wildcardBody) != 1 {
+		return nil, fmt.Errorf("wildcard query must specify exactly one field")
+	}
+
+	for field, value := range wildcardBody {
+		pattern, ok := value.(string)
+		if obj, isMap := value.(map[string]interface{}); isMap {
+			pattern, ok = obj["value"].(string)
+			if !ok {
+				pattern, ok = obj["wildcard"].(string)
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("wildcard query value must be a string or {value: string}")
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("wildcard pattern cannot be empty")
+		}
+		return NewWildcardQuery(field, pattern), nil
+	}
+
+	return nil, fmt.Errorf("invalid wildcard query structure")
+}
+
+func (m *QueryMapper) mapFuzzyQuery(body interface{}) (Query, error) {
+	fuzzyBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid fuzzy query structure")
+	}
+
+	if len(fuzzyBody) != 1 {
+		return nil, fmt.Errorf("fuzzy query must specify exactly one field")
+	}
+
+	for field, value := range fuzzyBody {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				return nil, fmt.Errorf("fuzzy value cannot be empty")
+			}
+			return NewFuzzyQuery(field, v), nil
+		case map[string]interface{}:
+			text, ok := v["value"].(string)
+			if !ok || text == "" {
+				return nil, fmt.Errorf("fuzzy query must specify a non-empty value")
+			}
+			query := NewFuzzyQuery(field, text)
+			if rawFuzziness, exists := v["fuzziness"]; exists {
+				fuzziness, err := ParseFuzziness(rawFuzziness)
+				if err != nil {
+					return nil, err
+				}
+				query.SetFuzziness(fuzziness)
+			}
+			return query, nil
+		}
+		return nil, fmt.Errorf("fuzzy query value must be a string or {value: string}")
+	}
+
+	return nil, fmt.Errorf("invalid fuzzy query structure")
+}
+
+func (m *QueryMapper) mapTermsQuery(body interface{}) (Query, error) {
+	termsBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid terms query structure")
+	}
+
+	if len(termsBody) != 1 {
+		return nil, fmt.Errorf("terms query must specify exactly one field")
+	}
+
+	for field, value := range termsBody {
+		values, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("terms query value must be an array of strings")
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("terms query must specify at least one term")
+		}
+
+		terms := make([]string, 0, len(values))
+		for _, v := range values {
+			term, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("terms query values must be strings, got %T", v)
+			}
+			terms = append(terms, term)
+		}
+		return NewTermsQuery(field, terms), nil
+	}
+
+	return nil, fmt.Errorf("invalid terms query structure")
+}
+
+func (m *QueryMapper) mapIdsQuery(body interface{}) (Query, error) {
+	idsBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid ids query structure")
+	}
+
+	values, ok := idsBody["values"].([]interface{})
+	if !ok || len(idsBody) != 1 {
+		return nil, fmt.Errorf("ids query must specify an array of values")
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("ids query must specify at least one id")
+	}
+
+	ids := make([]string, 0, len(values))
+	for _, v := range values {
+		id, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("ids query values must be strings, got %T", v)
+		}
+		ids = append(ids, id)
+	}
+	return NewIdsQuery(ids), nil
+}
+
 func (m *QueryMapper) mapRangeQuery(body interface{}) (Query, error) {
 	rangeBody, ok := body.(map[string]interface{})
 	if !ok {
@@ -412,6 +1175,12 @@ func (m *QueryMapper) mapRangeQuery(body interface{}) (Query, error) {
 
 		query := NewRangeQuery(field)
 		for op, val := range condMap {
+			// Date bounds compare against date fields
+			if s, ok := val.(string); ok {
+				if date, ok := document.ParseDate(s); ok {
+					val = date
+				}
+			}
 			switch op {
 			case "gt":
 				query.GreaterThan(val)
@@ -431,7 +1200,7 @@ func (m *QueryMapper) mapRangeQuery(body interface{}) (Query, error) {
 	return nil, fmt.Errorf("invalid range query structure")
 }
 
-func (m *QueryMapper) mapBoolQuery(body interface{}) (Query, error) {
+func (m *QueryMapper) mapBoolQuery(body interface{}, path string) (Query, error) {
 	boolBody, ok := body.(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("invalid bool query structure")
@@ -440,20 +1209,30 @@ func (m *QueryMapper) mapBoolQuery(body interface{}) (Query, error) {
 	query := NewBooleanQuery()
 
 	for clause, queries := range boolBody {
+		if clause == "minimum_should_match" {
+			n, err := parseMinimumShouldMatch(queries)
+			if err != nil {
+				return nil, &ParseError{Path: path + "." + clause, Err: err}
+			}
+			query.SetMinimumShouldMatch(n)
+			continue
+		}
+
 		queryList, ok := queries.([]interface{})
 		if !ok {
 			return nil, fmt.Errorf("invalid bool clause structure for %s", clause)
 		}
 
-		for _, q := range queryList {
+		for i, q := range queryList {
+			itemPath := fmt.Sprintf("%s.%s[%d]", path, clause, i)
 			queryMap, ok := q.(map[string]interface{})
 			if !ok {
-				return nil, fmt.Errorf("invalid query in bool clause")
+				return nil, &ParseError{Path: itemPath, Err: fmt.Errorf("invalid query in bool clause")}
 			}
 
-			subQuery, err := m.MapQuery(queryMap)
+			subQuery, err := m.mapQueryAt(queryMap, itemPath)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse query in bool clause: %v", err)
+				return nil, err
 			}
 
 			switch clause {
@@ -463,6 +1242,8 @@ func (m *QueryMapper) mapBoolQuery(body interface{}) (Query, error) {
 				query.AddShould(subQuery)
 			case "must_not":
 				query.AddMustNot(subQuery)
+			case "filter":
+				query.AddFilter(subQuery)
 			default:
 				return nil, fmt.Errorf("unsupported bool clause: %s", clause)
 			}
@@ -472,6 +1253,16 @@ func (m *QueryMapper) mapBoolQuery(body interface{}) (Query, error) {
 	return query, nil
 }
 
+// parseMinimumShouldMatch parses a bool query's minimum_should_match, a
+// non-negative whole number
+func parseMinimumShouldMatch(value interface{}) (int, error) {
+	n, ok := value.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return 0, fmt.Errorf("minimum_should_match must be a non-negative integer")
+	}
+	return int(n), nil
+}
+
 func (m *QueryMapper) mapMatchQuery(body interface{}) (Query, error) {
 	matchBody, ok := body.(map[string]interface{})
 	if !ok {
@@ -485,13 +1276,34 @@ func (m *QueryMapper) mapMatchQuery(body interface{}) (Query, error) {
 	for field, value := range matchBody {
 		switch v := value.(type) {
 		case string:
-			return NewMatchQuery(field, v), nil
+			return newMatchOrMatchAll(field, v), nil
 		case map[string]interface{}:
-			if query, ok := v["query"].(string); ok {
-				return NewMatchQuery(field, query), nil
+			text, ok := v["query"].(string)
+			if !ok {
+				text, ok = v["value"].(string)
 			}
-			if query, ok := v["value"].(string); ok {
-				return NewMatchQuery(field, query), nil
+			if ok {
+				query := newMatchOrMatchAll(field, text)
+				if rawOperator, exists := v["operator"]; exists {
+					operatorStr, _ := rawOperator.(string)
+					operator, err := ParseMatchOperator(operatorStr)
+					if err != nil {
+						return nil, err
+					}
+					if mq, ok := query.(*MatchQueryImpl); ok {
+						mq.SetOperator(operator)
+					}
+				}
+				if rawBoost, exists := v["boost"]; exists {
+					boost, err := parseBoost(rawBoost)
+					if err != nil {
+						return nil, err
+					}
+					if mq, ok := query.(*MatchQueryImpl); ok {
+						mq.SetBoost(boost)
+					}
+				}
+				return query, nil
 			}
 		}
 		return nil, fmt.Errorf("match query value must be a string or {query: string}")
@@ -500,6 +1312,122 @@ func (m *QueryMapper) mapMatchQuery(body interface{}) (Query, error) {
 	return nil, fmt.Errorf("invalid match query structure")
 }
 
+// parseBoost parses the boost of a query clause, a non-negative number
+func parseBoost(value interface{}) (float64, error) {
+	boost, ok := value.(float64)
+	if !ok || boost < 0 {
+		return 0, fmt.Errorf("boost must be a non-negative number")
+	}
+	return boost, nil
+}
+
+// newMatchOrMatchAll creates a match query, treating empty or whitespace-only
+// text as match_all
+func newMatchOrMatchAll(field, text string) Query {
+	if strings.TrimSpace(text) == "" {
+		return NewMatchAllQuery()
+	}
+	return NewMatchQuery(field, text)
+}
+
+// mapConstantScoreQuery maps a constant_score clause, {"filter": {...},
+// "boost": 2.0}. The boost defaults to 1.
+func (m *QueryMapper) mapConstantScoreQuery(body interface{}, path string) (Query, error) {
+	constantBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid constant_score query structure")
+	}
+
+	boost := 1.0
+	for key, value := range constantBody {
+		switch key {
+		case "filter":
+		case "boost":
+			n, err := parseBoost(value)
+			if err != nil {
+				return nil, &ParseError{Path: path + ".boost", Err: err}
+			}
+			boost = n
+		default:
+			return nil, fmt.Errorf("unsupported constant_score parameter: %s", key)
+		}
+	}
+
+	filterMap, ok := constantBody["filter"].(map[string]interface{})
+	if !ok {
+		return nil, &ParseError{Path: path + ".filter", Err: fmt.Errorf("constant_score query must specify a filter query")}
+	}
+	filter, err := m.mapQueryAt(filterMap, path+".filter")
+	if err != nil {
+		return nil, err
+	}
+	return NewConstantScoreQuery(filter, boost), nil
+}
+
+func (m *QueryMapper) mapExistsQuery(body interface{}) (Query, error) {
+	existsBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid exists query structure")
+	}
+
+	field, ok := existsBody["field"].(string)
+	if !ok || field == "" {
+		return nil, fmt.Errorf("exists query must specify a field")
+	}
+	return NewExistsQuery(field), nil
+}
+
+// mapQueryStringQuery parses the Lucene-style query of a query_string clause.
+// Without a default_field, unscoped terms search all fields.
+func (m *QueryMapper) mapQueryStringQuery(body interface{}) (Query, error) {
+	qsBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid query_string query structure")
+	}
+
+	queryStr, ok := qsBody["query"].(string)
+	if !ok {
+		return nil, fmt.Errorf("query_string query must specify a query string")
+	}
+
+	defaultField := ""
+	if v, exists := qsBody["default_field"]; exists {
+		if defaultField, ok = v.(string); !ok {
+			return nil, fmt.Errorf("query_string default_field must be a string")
+		}
+	}
+
+	parser := NewParser(defaultField)
+	parsed, err := parser.Parse(queryStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query_string: %v", err)
+	}
+	return parser.ToQuery(parsed)
+}
+
+// mapSimpleQueryStringQuery leniently parses the query of a
+// simple_query_string clause, so malformed syntax never causes an error
+func (m *QueryMapper) mapSimpleQueryStringQuery(body interface{}) (Query, error) {
+	sqsBody, ok := body.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid simple_query_string query structure")
+	}
+
+	queryStr, ok := sqsBody["query"].(string)
+	if !ok {
+		return nil, fmt.Errorf("simple_query_string query must specify a query string")
+	}
+
+	defaultField := ""
+	if v, exists := sqsBody["default_field"]; exists {
+		if defaultField, ok = v.(string); !ok {
+			return nil, fmt.Errorf("simple_query_string default_field must be a string")
+		}
+	}
+
+	return NewParser(defaultField).ParseSimple(queryStr), nil
+}
+
 func (m *QueryMapper) mapMatchPhraseQuery(body interface{}) (Query, error) {
 	phraseBody, ok := body.(map[string]interface{})
 	if !ok {
@@ -516,7 +1444,15 @@ func (m *QueryMapper) mapMatchPhraseQuery(body interface{}) (Query, error) {
 			return NewMatchPhraseQuery(field, v), nil
 		case map[string]interface{}:
 			if query, ok := v["query"].(string); ok {
-				return NewMatchPhraseQuery(field, query), nil
+				phraseQuery := NewMatchPhraseQuery(field, query)
+				if rawSlop, ok := v["slop"]; ok {
+					slop, ok := rawSlop.(float64)
+					if !ok || slop < 0 || slop != float64(int(slop)) {
+						return nil, fmt.Errorf("match_phrase slop must be a non-negative integer")
+					}
+					phraseQuery.SetSlop(int(slop))
+				}
+				return phraseQuery, nil
 			}
 		}
 		return nil, fmt.Errorf("invalid match_phrase query value")
@@ -2,6 +2,7 @@ package query
 
 import (
 	"my-indexer/document"
+	"strings"
 	"testing"
 	"time"
 )
@@ -26,6 +27,55 @@ func TestTermQuery(t *testing.T) {
 			}
 		})
 	}
+
+	boolQuery := NewTermQuery("published", "true")
+	if !boolQuery.Match(true) || boolQuery.Match(false) {
+		t.Error("Expected term \"true\" to match only the boolean true")
+	}
+
+	numericQuery, err := NewQueryMapper().MapQuery(map[string]interface{}{
+		"term": map[string]interface{}{"age": 30.0},
+	})
+	if err != nil {
+		t.Fatalf("MapQuery() error = %v", err)
+	}
+	for _, value := range []interface{}{30, int64(30), 30.0} {
+		if !numericQuery.Match(value) {
+			t.Errorf("Expected term 30 to match %#v", value)
+		}
+	}
+	if numericQuery.Match(30.5) || numericQuery.Match("thirty") {
+		t.Error("Expected term 30 to match only the number 30")
+	}
+}
+
+func TestTermQueryCaseSensitivity(t *testing.T) {
+	insensitive := NewTermQuery("status", "Active")
+	if !insensitive.Match("active") {
+		t.Error("Case-insensitive term 'Active' should match 'active'")
+	}
+
+	sensitive := NewTermQuery("status", "Active")
+	sensitive.SetCaseSensitive(true)
+	if sensitive.Match("active") {
+		t.Error("Case-sensitive term 'Active' should not match 'active'")
+	}
+	if !sensitive.Match("Active") {
+		t.Error("Case-sensitive term 'Active' should match 'Active'")
+	}
+
+	// The DSL case_insensitive flag maps onto the query
+	mapped, err := NewQueryMapper().MapQuery(map[string]interface{}{
+		"term": map[string]interface{}{
+			"status": map[string]interface{}{"value": "Active", "case_insensitive": false},
+		},
+	})
+	if err != nil {
+		t.Fatalf("MapQuery() error = %v", err)
+	}
+	if !mapped.(*TermQueryImpl).CaseSensitive() {
+		t.Error("case_insensitive: false should produce a case-sensitive term query")
+	}
 }
 
 func TestRangeQuery(t *testing.T) {
@@ -131,6 +181,43 @@ func TestRangeQuery(t *testing.T) {
 	}
 }
 
+func TestRangeQueryTimeBoundaries(t *testing.T) {
+	boundary := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	before := boundary.Add(-time.Second)
+	after := boundary.Add(time.Second)
+
+	tests := []struct {
+		name     string
+		query    *RangeQueryImpl
+		value    time.Time
+		expected bool
+	}{
+		{"gt before boundary", &RangeQueryImpl{gt: boundary}, before, false},
+		{"gt on boundary", &RangeQueryImpl{gt: boundary}, boundary, false},
+		{"gt after boundary", &RangeQueryImpl{gt: boundary}, after, true},
+		{"gte before boundary", &RangeQueryImpl{gte: boundary}, before, false},
+		{"gte on boundary", &RangeQueryImpl{gte: boundary}, boundary, true},
+		{"gte after boundary", &RangeQueryImpl{gte: boundary}, after, true},
+		{"lt before boundary", &RangeQueryImpl{lt: boundary}, before, true},
+		{"lt on boundary", &RangeQueryImpl{lt: boundary}, boundary, false},
+		{"lt after boundary", &RangeQueryImpl{lt: boundary}, after, false},
+		{"lte before boundary", &RangeQueryImpl{lte: boundary}, before, true},
+		{"lte on boundary", &RangeQueryImpl{lte: boundary}, boundary, true},
+		{"lte after boundary", &RangeQueryImpl{lte: boundary}, after, false},
+		{"Same instant in another zone", &RangeQueryImpl{gt: boundary}, boundary.In(time.FixedZone("UTC+2", 2*60*60)), false},
+		{"Numeric gt bound ignores times", &RangeQueryImpl{gt: 10.0}, time.Time{}, true},
+		{"Numeric lt bound ignores times", &RangeQueryImpl{lt: 10.0}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.query.Match(tt.value); result != tt.expected {
+				t.Errorf("RangeQuery.Match(%v) = %v, want %v", tt.value, result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestBooleanQuery(t *testing.T) {
 	t.Run("Must queries", func(t *testing.T) {
 		query := NewBooleanQuery()
@@ -192,6 +279,86 @@ func TestBooleanQuery(t *testing.T) {
 		}
 	})
 
+	t.Run("Minimum should match", func(t *testing.T) {
+		query, err := NewQueryMapper().MapQuery(map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"title": "go"}},
+					map[string]interface{}{"term": map[string]interface{}{"category": "book"}},
+					map[string]interface{}{"term": map[string]interface{}{"status": "new"}},
+				},
+				"minimum_should_match": float64(2),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map bool query: %v", err)
+		}
+		if got := query.(*BooleanQueryImpl).MinimumShouldMatch(); got != 2 {
+			t.Fatalf("MinimumShouldMatch() = %d, want 2", got)
+		}
+
+		tests := []struct {
+			name  string
+			value map[string]string
+			want  bool
+		}{
+			{"Two conditions met", map[string]string{"title": "go", "category": "book", "status": "old"}, true},
+			{"All conditions met", map[string]string{"title": "go", "category": "book", "status": "new"}, true},
+			{"One condition met", map[string]string{"title": "go", "category": "film", "status": "old"}, false},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := query.Match(tt.value); got != tt.want {
+					t.Errorf("BooleanQuery.Match() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		if _, err := NewQueryMapper().MapQuery(map[string]interface{}{
+			"bool": map[string]interface{}{"minimum_should_match": float64(-1)},
+		}); err == nil {
+			t.Error("Expected an error for a negative minimum_should_match")
+		}
+	})
+
+	t.Run("Filter queries", func(t *testing.T) {
+		query, err := NewQueryMapper().MapQuery(map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"title": "go"}},
+				},
+				"filter": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"status": "active"}},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map bool query: %v", err)
+		}
+		if got := len(query.(*BooleanQueryImpl).Filter()); got != 1 {
+			t.Fatalf("Expected 1 filter clause, got %d", got)
+		}
+
+		tests := []struct {
+			name  string
+			value map[string]string
+			want  bool
+		}{
+			{"Filter met", map[string]string{"title": "go", "status": "active"}, true},
+			{"Filter not met", map[string]string{"title": "go", "status": "archived"}, false},
+			{"Filter field missing", map[string]string{"title": "go"}, false},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if got := query.Match(tt.value); got != tt.want {
+					t.Errorf("BooleanQuery.Match() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	})
+
 	t.Run("Must not queries", func(t *testing.T) {
 		query := NewBooleanQuery()
 		query.AddMustNot(NewTermQuery("status", "deleted"))
@@ -293,6 +460,54 @@ func TestQueryMapper(t *testing.T) {
 		}
 	})
 
+	t.Run("Empty match query mapping", func(t *testing.T) {
+		for _, text := range []interface{}{"", "   ", map[string]interface{}{"query": "\t"}} {
+			dslQuery := map[string]interface{}{
+				"match": map[string]interface{}{
+					"title": text,
+				},
+			}
+
+			query, err := mapper.MapQuery(dslQuery)
+			if err != nil {
+				t.Fatalf("MapQuery() error = %v", err)
+			}
+
+			if query.Type() != MatchAllQuery {
+				t.Errorf("Expected MatchAllQuery for %q, got %v", text, query.Type())
+			}
+		}
+	})
+
+	t.Run("Match query operator mapping", func(t *testing.T) {
+		dslQuery := map[string]interface{}{
+			"match": map[string]interface{}{
+				"title": map[string]interface{}{
+					"query":    "quick fox",
+					"operator": "and",
+				},
+			},
+		}
+
+		query, err := mapper.MapQuery(dslQuery)
+		if err != nil {
+			t.Fatalf("MapQuery() error = %v", err)
+		}
+
+		mq, ok := query.(*MatchQueryImpl)
+		if !ok {
+			t.Fatalf("Expected *MatchQueryImpl, got %T", query)
+		}
+		if mq.Operator() != MatchAnd {
+			t.Errorf("Expected MatchAnd operator, got %v", mq.Operator())
+		}
+
+		dslQuery["match"].(map[string]interface{})["title"].(map[string]interface{})["operator"] = "xor"
+		if _, err := mapper.MapQuery(dslQuery); err == nil {
+			t.Error("Expected error for invalid operator")
+		}
+	})
+
 	t.Run("Invalid query", func(t *testing.T) {
 		dslQuery := map[string]interface{}{
 			"invalid": map[string]interface{}{},
@@ -302,4 +517,338 @@ func TestQueryMapper(t *testing.T) {
 			t.Error("Expected error for invalid query type")
 		}
 	})
+
+	t.Run("Match phrase slop", func(t *testing.T) {
+		query, err := mapper.MapQuery(map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				"title": map[string]interface{}{"query": "quick fox", "slop": 2.0},
+			},
+		})
+		if err != nil {
+			t.Fatalf("MapQuery() error = %v", err)
+		}
+		pq, ok := query.(*MatchPhraseQueryImpl)
+		if !ok {
+			t.Fatalf("Expected *MatchPhraseQueryImpl, got %T", query)
+		}
+		if pq.Phrase() != "quick fox" || pq.Slop() != 2 {
+			t.Errorf("Expected phrase \"quick fox\" with slop 2, got %q with slop %d", pq.Phrase(), pq.Slop())
+		}
+
+		_, err = mapper.MapQuery(map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				"title": map[string]interface{}{"query": "quick fox", "slop": -1.0},
+			},
+		})
+		if err == nil {
+			t.Error("Expected error for negative slop")
+		}
+	})
+
+	t.Run("Boost mapping", func(t *testing.T) {
+		query, err := mapper.MapQuery(map[string]interface{}{
+			"match": map[string]interface{}{
+				"title": map[string]interface{}{"query": "go", "boost": 2.0},
+			},
+		})
+		if err != nil {
+			t.Fatalf("MapQuery() error = %v", err)
+		}
+		if mq, ok := query.(*MatchQueryImpl); !ok || mq.Boost() != 2 {
+			t.Errorf("Expected a match query with boost 2, got %#v", query)
+		}
+
+		query, err = mapper.MapQuery(map[string]interface{}{
+			"term": map[string]interface{}{
+				"status": map[string]interface{}{"value": "active", "boost": 1.5},
+			},
+		})
+		if err != nil {
+			t.Fatalf("MapQuery() error = %v", err)
+		}
+		if tq, ok := query.(*TermQueryImpl); !ok || tq.Boost() != 1.5 {
+			t.Errorf("Expected a term query with boost 1.5, got %#v", query)
+		}
+
+		if NewMatchQuery("title", "go").Boost() != 1 || NewTermQuery("status", "active").Boost() != 1 {
+			t.Error("Expected queries to default to boost 1")
+		}
+
+		_, err = mapper.MapQuery(map[string]interface{}{
+			"match": map[string]interface{}{
+				"title": map[string]interface{}{"query": "go", "boost": "high"},
+			},
+		})
+		if err == nil {
+			t.Error("Expected error for a non-numeric boost")
+		}
+	})
+
+	t.Run("Constant score query mapping", func(t *testing.T) {
+		query, err := mapper.MapQuery(map[string]interface{}{
+			"constant_score": map[string]interface{}{
+				"filter": map[string]interface{}{
+					"term": map[string]interface{}{"status": "active"},
+				},
+				"boost": 2.0,
+			},
+		})
+		if err != nil {
+			t.Fatalf("MapQuery() error = %v", err)
+		}
+		cq, ok := query.(*ConstantScoreQueryImpl)
+		if !ok {
+			t.Fatalf("Expected *ConstantScoreQueryImpl, got %T", query)
+		}
+		if cq.Boost() != 2.0 || cq.Filter().Type() != TermQuery || cq.Field() != "status" {
+			t.Errorf("Expected a term filter on status with boost 2, got %v on %s with boost %v",
+				cq.Filter().Type(), cq.Field(), cq.Boost())
+		}
+		if !cq.Match("active") || cq.Match("archived") {
+			t.Error("Expected the constant_score query to match like its filter")
+		}
+
+		_, err = mapper.MapQuery(map[string]interface{}{
+			"constant_score": map[string]interface{}{"boost": 2.0},
+		})
+		if err == nil {
+			t.Error("Expected error for a missing filter")
+		}
+	})
+
+	t.Run("Nested error path", func(t *testing.T) {
+		dslQuery := map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": []interface{}{
+					map[string]interface{}{
+						"term": map[string]interface{}{"status": "active"},
+					},
+					map[string]interface{}{
+						"bool": map[string]interface{}{
+							"should": []interface{}{
+								map[string]interface{}{
+									"range": map[string]interface{}{"age": "old"},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		_, err := mapper.MapQuery(dslQuery)
+		if err == nil {
+			t.Fatal("Expected error for malformed range clause")
+		}
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Fatalf("Expected *ParseError, got %T", err)
+		}
+		if want := "query.bool.must[1].bool.should[0].range.age"; parseErr.Path != want {
+			t.Errorf("Expected path %s, got %s", want, parseErr.Path)
+		}
+		if !strings.Contains(err.Error(), parseErr.Path) {
+			t.Errorf("Expected error message %q to contain the path", err.Error())
+		}
+	})
+}
+
+func TestRewrite(t *testing.T) {
+	values := []interface{}{"active", "deleted", "draft", "other"}
+
+	// assertSameMatches checks that rewriting did not change what the query matches
+	assertSameMatches := func(t *testing.T, original, rewritten Query) {
+		t.Helper()
+		for _, v := range values {
+			if original.Match(v) != rewritten.Match(v) {
+				t.Errorf("Match(%v) changed from %v to %v after rewrite", v, original.Match(v), rewritten.Match(v))
+			}
+		}
+	}
+
+	t.Run("Single must is unwrapped", func(t *testing.T) {
+		term := NewTermQuery("status", "active")
+		bq := NewBooleanQuery()
+		bq.AddMust(term)
+
+		rewritten := Rewrite(bq)
+		if rewritten != Query(term) {
+			t.Fatalf("Expected the inner term query, got %T", rewritten)
+		}
+		assertSameMatches(t, bq, rewritten)
+	})
+
+	t.Run("Nested musts are flattened", func(t *testing.T) {
+		inner := NewBooleanQuery()
+		inner.AddMust(NewMatchQuery("status", "act"))
+		inner.AddMustNot(NewTermQuery("status", "deleted"))
+		outer := NewBooleanQuery()
+		outer.AddMust(NewMatchQuery("status", "a"))
+		outer.AddMust(inner)
+
+		rewritten, ok := Rewrite(outer).(*BooleanQueryImpl)
+		if !ok {
+			t.Fatalf("Expected a boolean query, got %T", Rewrite(outer))
+		}
+		if len(rewritten.Must()) != 2 || len(rewritten.MustNot()) != 1 {
+			t.Errorf("Expected 2 must and 1 must_not clauses, got %d and %d", len(rewritten.Must()), len(rewritten.MustNot()))
+		}
+		for _, must := range rewritten.Must() {
+			if must.Type() == BooleanQuery {
+				t.Error("Expected no nested boolean query after flattening")
+			}
+		}
+		if len(outer.Must()) != 2 {
+			t.Error("Rewrite must not modify the original query")
+		}
+		assertSameMatches(t, outer, rewritten)
+	})
+
+	t.Run("Empty clauses are removed", func(t *testing.T) {
+		term := NewTermQuery("status", "draft")
+		outer := NewBooleanQuery()
+		outer.AddMust(NewBooleanQuery())
+		outer.AddMust(term)
+
+		rewritten := Rewrite(outer)
+		if rewritten != Query(term) {
+			t.Fatalf("Expected the empty bool to be removed and the term unwrapped, got %T", rewritten)
+		}
+		assertSameMatches(t, outer, rewritten)
+	})
+
+	t.Run("Filter clauses are kept", func(t *testing.T) {
+		term := NewTermQuery("status", "active")
+		bq := NewBooleanQuery()
+		bq.AddMust(term)
+		bq.AddFilter(NewTermQuery("status", "deleted"))
+
+		rewritten, ok := Rewrite(bq).(*BooleanQueryImpl)
+		if !ok || len(rewritten.Filter()) != 1 {
+			t.Fatalf("Expected the filter to be kept, got %#v", Rewrite(bq))
+		}
+		assertSameMatches(t, bq, rewritten)
+	})
+
+	t.Run("Should clauses are kept", func(t *testing.T) {
+		inner := NewBooleanQuery()
+		inner.AddShould(NewTermQuery("status", "active"))
+		inner.AddShould(NewTermQuery("status", "draft"))
+		outer := NewBooleanQuery()
+		outer.AddMust(inner)
+
+		rewritten := Rewrite(outer)
+		bq, ok := rewritten.(*BooleanQueryImpl)
+		if !ok || len(bq.Should()) != 2 {
+			t.Fatalf("Expected the disjunction to be kept, got %#v", rewritten)
+		}
+		assertSameMatches(t, outer, rewritten)
+	})
+}
+
+func TestWildcardQuery(t *testing.T) {
+	tests := []struct {
+		pattern string
+		term    string
+		want    bool
+	}{
+		{"te*t", "test", true},
+		{"te*t", "text", true},
+		{"te*t", "tet", true},
+		{"te*t", "tests", false},
+		{"te?t", "test", true},
+		{"te?t", "tet", false},
+		{"*ing", "searching", true}, // Leading wildcard
+		{"*ing", "search", false},
+		{"?", "a", true},
+		{"a.c", "abc", false}, // Regexp metacharacters are literal
+		{"a.c", "a.c", true},
+		{`what\?`, "what?", true}, // Escaped wildcards are literal
+		{`what\?`, "whatx", false},
+		{`5\*`, "5*", true},
+		{`5\*`, "50", false},
+		{"zzz*", "test", false},
+	}
+	for _, tt := range tests {
+		q := NewWildcardQuery("title", tt.pattern)
+		if got := q.MatchTerm(tt.term); got != tt.want {
+			t.Errorf("NewWildcardQuery(%q).MatchTerm(%q) = %v, want %v", tt.pattern, tt.term, got, tt.want)
+		}
+	}
+
+	q, err := NewQueryMapper().MapQuery(map[string]interface{}{
+		"wildcard": map[string]interface{}{"title": "te*t"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map wildcard query: %v", err)
+	}
+	if wq, ok := q.(*WildcardQueryImpl); !ok || wq.Field() != "title" || wq.Pattern() != "te*t" {
+		t.Errorf("Expected a title wildcard query for te*t, got %#v", q)
+	}
+	if !q.Match("A Test document") {
+		t.Error("Expected Match to find a word matching the pattern")
+	}
+}
+
+func TestFuzzyQuery(t *testing.T) {
+	tests := []struct {
+		value     string
+		fuzziness int
+		term      string
+		want      bool
+	}{
+		{"quick", 2, "quick", true},
+		{"quik", 1, "quick", true},   // One insertion
+		{"qiuck", 2, "quick", true},  // Two substitutions
+		{"qck", 2, "quick", true},    // Two insertions
+		{"qck", 1, "quick", false},   // Length differs by more than the distance
+		{"kwik", 2, "quick", false},  // Distance 3
+		{"quack", 0, "quick", false}, // Exact match only
+		{"quick", 0, "quick", true},
+		{"quik", FuzzinessAuto, "quick", true}, // 4 characters allow one edit
+		{"qi", FuzzinessAuto, "qu", false},     // 2 characters must match exactly
+		{"serch", FuzzinessAuto, "search", true},
+		{"sarchng", FuzzinessAuto, "searching", true}, // 7 characters allow two edits
+		{"日本", 1, "日本語", true},                        // Distance is counted in runes
+	}
+	for _, tt := range tests {
+		q := NewFuzzyQuery("title", tt.value)
+		q.SetFuzziness(tt.fuzziness)
+		if got := q.MatchTerm(tt.term); got != tt.want {
+			t.Errorf("fuzzy %q (fuzziness %d) MatchTerm(%q) = %v, want %v", tt.value, tt.fuzziness, tt.term, got, tt.want)
+		}
+	}
+
+	mapper := NewQueryMapper()
+	q, err := mapper.MapQuery(map[string]interface{}{
+		"fuzzy": map[string]interface{}{"title": map[string]interface{}{"value": "quik", "fuzziness": float64(1)}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map fuzzy query: %v", err)
+	}
+	if fq, ok := q.(*FuzzyQueryImpl); !ok || fq.Value() != "quik" || fq.Fuzziness() != 1 {
+		t.Errorf("Expected a fuzzy query for quik with fuzziness 1, got %#v", q)
+	}
+
+	q, err = mapper.MapQuery(map[string]interface{}{
+		"fuzzy": map[string]interface{}{"title": map[string]interface{}{"value": "quik", "fuzziness": "AUTO"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map AUTO fuzzy query: %v", err)
+	}
+	if fq := q.(*FuzzyQueryImpl); fq.Fuzziness() != FuzzinessAuto || fq.MaxEdits() != 1 {
+		t.Errorf("Expected AUTO fuzziness allowing 1 edit, got %d", fq.MaxEdits())
+	}
+
+	if q, err := mapper.MapQuery(map[string]interface{}{"fuzzy": map[string]interface{}{"title": "quik"}}); err != nil || q.(*FuzzyQueryImpl).Fuzziness() != DefaultFuzziness {
+		t.Errorf("Expected the default fuzziness for the short form, got %v", err)
+	}
+
+	for _, invalid := range []interface{}{float64(3), "two", float64(1.5), true} {
+		if _, err := mapper.MapQuery(map[string]interface{}{
+			"fuzzy": map[string]interface{}{"title": map[string]interface{}{"value": "quik", "fuzziness": invalid}},
+		}); err == nil {
+			t.Errorf("Expected an error for fuzziness %v", invalid)
+		}
+	}
 }
@@ -0,0 +1,82 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"my-indexer/analysis"
+)
+
+// analyzeToken is one token of an _analyze response
+type analyzeToken struct {
+	Token       string `json:"token"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Position    int    `json:"position"`
+}
+
+// handleAnalyze runs text through an analyzer and returns the tokens it
+// produces, for debugging analysis. The analyzer is chosen by name from the
+// registered analyzers, or by field from the index's field analyzers; the
+// index's default analyzer is used when neither is given.
+func (r *Router) handleAnalyze(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var analyzeRequest struct {
+		Analyzer string      `json:"analyzer"`
+		Field    string      `json:"field"`
+		Text     interface{} `json:"text"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&analyzeRequest); err != nil {
+		r.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	text, ok := analyzeRequest.Text.(string)
+	if !ok {
+		r.errorResponse(w, http.StatusBadRequest, "text is required and must be a string")
+		return
+	}
+	if analyzeRequest.Analyzer != "" && analyzeRequest.Field != "" {
+		r.errorResponse(w, http.StatusBadRequest, "analyzer and field cannot both be given")
+		return
+	}
+
+	indexName := pathIndexName(req)
+	live := r.lookupIndex(indexName)
+	if live == nil {
+		r.indexNotFound(w, indexName)
+		return
+	}
+	defer live.release()
+
+	analyzer := live.idx.Analyzer()
+	switch {
+	case analyzeRequest.Analyzer != "":
+		analyzer, ok = analysis.LookupAnalyzer(analyzeRequest.Analyzer)
+		if !ok {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("unknown analyzer %q, expected one of %s",
+				analyzeRequest.Analyzer, strings.Join(analysis.AnalyzerNames(), ", ")))
+			return
+		}
+	case analyzeRequest.Field != "":
+		analyzer = live.idx.FieldAnalyzer(analyzeRequest.Field)
+	}
+
+	tokens := make([]analyzeToken, 0)
+	for _, token := range analyzer.Analyze(text) {
+		tokens = append(tokens, analyzeToken{
+			Token:       token.Text,
+			StartOffset: token.StartByte,
+			EndOffset:   token.EndByte,
+			Position:    token.Position,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tokens": tokens})
+}
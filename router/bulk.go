@@ -3,128 +3,333 @@ package router
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
+	"time"
 
 	"my-indexer/document"
+	"my-indexer/index"
 )
 
 // handleBulk handles bulk operations
 func (r *Router) handleBulk(w http.ResponseWriter, req *http.Request) {
 	// Only allow POST method
 	if req.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
 	// Validate content type
 	if req.Header.Get("Content-Type") != "application/x-ndjson" {
-		http.Error(w, "Content-Type must be application/x-ndjson", http.StatusBadRequest)
+		r.errorResponse(w, http.StatusBadRequest, "Content-Type must be application/x-ndjson")
 		return
 	}
 
-	// Parse path to get index name
-	parts := strings.Split(req.URL.Path, "/")
-	if len(parts) < 2 || parts[1] == "" {
-		http.Error(w, "Invalid index name", http.StatusBadRequest)
-		return
-	}
-	indexName := parts[1]
+	// Actions write to the index named by the path unless they name their own
+	pathIndex := pathIndexName(req)
 
 	// Process bulk request
+	startTime := time.Now()
 	scanner := bufio.NewScanner(req.Body)
 	defer req.Body.Close()
 
 	var responses []map[string]interface{}
-	var currentAction map[string]interface{}
 	lineNum := 0
 
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			continue // Skip empty lines
+	// nextLine returns the next non-empty line from the request body
+	nextLine := func() (string, bool) {
+		for scanner.Scan() {
+			lineNum++
+			if line := scanner.Text(); line != "" {
+				return line, true
+			}
 		}
+		return "", false
+	}
 
-		lineNum++
-		if lineNum%2 == 1 {
-			// Action line
-			if err := json.Unmarshal([]byte(line), &currentAction); err != nil {
-				http.Error(w, fmt.Sprintf("Invalid JSON at line %d: %v", lineNum, err), http.StatusBadRequest)
-				return
-			}
+	for {
+		// Action line
+		line, ok := nextLine()
+		if !ok {
+			break
+		}
 
-			// Validate action
-			if len(currentAction) != 1 {
-				http.Error(w, fmt.Sprintf("Invalid action at line %d: exactly one action type expected", lineNum), http.StatusBadRequest)
-				return
-			}
+		var currentAction map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &currentAction); err != nil {
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid JSON at line %d: %v", lineNum, err))
+			return
+		}
 
-			// Check for valid action types
-			validAction := false
-			for _, actionType := range []string{"index", "create", "update", "delete"} {
-				if _, ok := currentAction[actionType]; ok {
-					validAction = true
-					break
-				}
-			}
-			if !validAction {
-				http.Error(w, fmt.Sprintf("Invalid action type at line %d: must be one of index, create, update, or delete", lineNum), http.StatusBadRequest)
-				return
-			}
-		} else {
-			// Document line (for index/create/update operations)
-			var doc map[string]interface{}
-			if err := json.Unmarshal([]byte(line), &doc); err != nil {
-				http.Error(w, fmt.Sprintf("Invalid JSON at line %d: %v", lineNum, err), http.StatusBadRequest)
-				return
-			}
+		// Validate action
+		if len(currentAction) != 1 {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid action at line %d: exactly one action type expected", lineNum))
+			return
+		}
 
-			// Process the action
-			response := make(map[string]interface{})
-			switch {
-			case currentAction["index"] != nil:
-				// Create a new document
-				newDoc := document.NewDocument()
-				for field, value := range doc {
-					newDoc.AddField(field, value)
-				}
-
-				// Add the document to the index
-				docID, err := r.index.AddDocument(newDoc)
-				if err != nil {
-					response["index"] = map[string]interface{}{
-						"_index":  indexName,
-						"_id":     fmt.Sprintf("%d", docID),
-						"status":  "error",
-						"message": err.Error(),
-					}
-				} else {
-					response["index"] = map[string]interface{}{
-						"_index": indexName,
-						"_id":    fmt.Sprintf("%d", docID),
-						"status": "success",
-					}
-				}
-			// Add other action types (create, update, delete) here
-			default:
-				http.Error(w, "Unsupported action type", http.StatusBadRequest)
-				return
+		// Check for valid action types
+		var actionType string
+		for _, t := range []string{"index", "create", "update", "delete"} {
+			if _, ok := currentAction[t]; ok {
+				actionType = t
+				break
 			}
-			responses = append(responses, response)
+		}
+		if actionType == "" {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid action type at line %d: must be one of index, create, update, or delete", lineNum))
+			return
+		}
+
+		indexName := bulkActionIndex(currentAction[actionType], pathIndex)
+
+		// Delete actions have no document line
+		if actionType == "delete" {
+			responses = append(responses, r.processBulkDelete(indexName, currentAction["delete"]))
+			continue
+		}
+
+		// Document line (for index/create/update operations)
+		line, ok = nextLine()
+		if !ok {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("missing document for %s action at line %d", actionType, lineNum))
+			return
+		}
+
+		var source interface{}
+		if err := json.Unmarshal([]byte(line), &source); err != nil {
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid JSON at line %d: %v", lineNum, err))
+			return
+		}
+
+		// A bad document fails its own item, not the whole request
+		id, _ := bulkActionID(currentAction[actionType])
+		doc, ok := source.(map[string]interface{})
+		if !ok {
+			responses = append(responses, bulkError(actionType, indexName, id, http.StatusBadRequest, "mapper_parsing_exception", fmt.Sprintf("document at line %d must be a JSON object", lineNum)))
+			continue
+		}
+
+		// Process the action
+		switch actionType {
+		case "index", "create":
+			responses = append(responses, r.processBulkWrite(actionType, indexName, currentAction[actionType], doc))
+		case "update":
+			responses = append(responses, r.processBulkUpdate(indexName, id, doc))
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		http.Error(w, fmt.Sprintf("Error reading request body: %v", err), http.StatusBadRequest)
+		r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("error reading request body: %v", err))
 		return
 	}
 
+	// An item failed if its status is not a 2xx code
+	hasErrors := false
+	for _, response := range responses {
+		for _, item := range response {
+			if status, _ := item.(map[string]interface{})["status"].(int); status >= 300 {
+				hasErrors = true
+			}
+		}
+	}
+
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"took":      0, // TODO: Add timing
-		"errors":    false,
-		"responses": responses,
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":   int(time.Since(startTime).Milliseconds()),
+		"errors": hasErrors,
+		"items":  responses,
 	})
 }
+
+// bulkItem builds the response item of a successful bulk action in the
+// Elasticsearch format
+func bulkItem(live *liveIndex, action, indexName string, docID int, result string, status int) map[string]interface{} {
+	item := map[string]interface{}{
+		"_index": indexName,
+		"_id":    strconv.Itoa(docID),
+		"result": result,
+		"status": status,
+	}
+	if version, ok := live.idx.GetDocumentVersion(docID); ok {
+		item["_version"] = version.Version
+		item["_seq_no"] = version.SeqNo
+	}
+	return map[string]interface{}{action: item}
+}
+
+// bulkError builds the response item of a failed bulk action in the
+// Elasticsearch format
+func bulkError(action, indexName, id string, status int, errType, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		action: map[string]interface{}{
+			"_index": indexName,
+			"_id":    id,
+			"status": status,
+			"error": map[string]interface{}{
+				"type":   errType,
+				"reason": reason,
+			},
+		},
+	}
+}
+
+// bulkActionID returns the _id of a bulk action's metadata, accepting both
+// string and numeric IDs. The second result is false when no _id was given.
+func bulkActionID(meta interface{}) (string, bool) {
+	metaMap, ok := meta.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	switch id := metaMap["_id"].(type) {
+	case string:
+		return id, id != ""
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// bulkActionIndex returns the _index of a bulk action's metadata, or
+// pathIndex when the action doesn't name one
+func bulkActionIndex(meta interface{}, pathIndex string) string {
+	if metaMap, ok := meta.(map[string]interface{}); ok {
+		if name, ok := metaMap["_index"].(string); ok && name != "" {
+			return name
+		}
+	}
+	return pathIndex
+}
+
+// processBulkWrite indexes the document of a bulk index or create action,
+// keeping the client-supplied _id when there is one. A create action fails if
+// a document with that _id exists. The index is created if needed.
+func (r *Router) processBulkWrite(action, indexName string, meta interface{}, doc map[string]interface{}) map[string]interface{} {
+	id, hasID := bulkActionID(meta)
+
+	live, err := r.writeIndex(indexName)
+	if err != nil {
+		return bulkError(action, indexName, id, http.StatusBadRequest, "invalid_index_name_exception", err.Error())
+	}
+	defer live.release()
+
+	newDoc := document.NewDocument()
+	for field, value := range doc {
+		if err := newDoc.AddField(field, value); errors.Is(err, document.ErrFieldValueTooLarge) {
+			return bulkError(action, indexName, id, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+		}
+	}
+
+	if !hasID {
+		docID, err := live.idx.AddDocument(newDoc)
+		if err != nil {
+			return bulkWriteError(action, indexName, "", err)
+		}
+		return bulkItem(live, action, indexName, docID, "created", http.StatusCreated)
+	}
+
+	docID, err := strconv.Atoi(id)
+	if err != nil || docID < 0 {
+		return bulkError(action, indexName, id, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("invalid document ID: %q", id))
+	}
+
+	if action == "create" {
+		err := live.idx.CreateDocument(docID, newDoc)
+		if errors.Is(err, index.ErrDocumentExists) {
+			return bulkError(action, indexName, id, http.StatusConflict, "version_conflict_engine_exception", fmt.Sprintf("[%s]: version conflict, document already exists", id))
+		}
+		if err != nil {
+			return bulkWriteError(action, indexName, id, err)
+		}
+		return bulkItem(live, action, indexName, docID, "created", http.StatusCreated)
+	}
+
+	created, err := live.idx.PutDocument(docID, newDoc)
+	if err != nil {
+		return bulkWriteError(action, indexName, id, err)
+	}
+	if created {
+		return bulkItem(live, action, indexName, docID, "created", http.StatusCreated)
+	}
+	return bulkItem(live, action, indexName, docID, "updated", http.StatusOK)
+}
+
+// bulkWriteError reports a failed bulk write. Values that don't fit their
+// field's mapping fail the item with a 400 mapper_parsing_exception.
+func bulkWriteError(action, indexName, id string, err error) map[string]interface{} {
+	if errors.Is(err, index.ErrInvalidFieldValue) {
+		return bulkError(action, indexName, id, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+	}
+	return bulkError(action, indexName, id, http.StatusInternalServerError, "index_failed_exception", err.Error())
+}
+
+// processBulkUpdate merges the partial document of a bulk update action into
+// the stored document
+func (r *Router) processBulkUpdate(indexName, id string, body map[string]interface{}) map[string]interface{} {
+	docID, err := strconv.Atoi(id)
+	if err != nil || docID < 0 {
+		return bulkError("update", indexName, id, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("invalid document ID: %q", id))
+	}
+	partial, err := parsePartialDocument(body)
+	if err != nil {
+		return bulkError("update", indexName, id, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+	}
+
+	live := r.lookupIndex(indexName)
+	if live == nil {
+		return bulkError("update", indexName, id, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", indexName))
+	}
+	defer live.release()
+	changed, err := live.idx.MergeDocument(docID, partial)
+	if errors.Is(err, index.ErrDocumentNotFound) {
+		return bulkError("update", indexName, id, http.StatusNotFound, "document_missing_exception", fmt.Sprintf("[%s]: document missing", id))
+	}
+	if errors.Is(err, index.ErrInvalidFieldValue) {
+		return bulkError("update", indexName, id, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+	}
+	if err != nil {
+		return bulkError("update", indexName, id, http.StatusInternalServerError, "update_failed_exception", err.Error())
+	}
+	return bulkItem(live, "update", indexName, docID, updateResult(changed), http.StatusOK)
+}
+
+// processBulkDelete deletes the document referenced by a bulk delete action
+func (r *Router) processBulkDelete(indexName string, meta interface{}) map[string]interface{} {
+	id, _ := bulkActionID(meta)
+	docID, err := strconv.Atoi(id)
+	if err != nil || docID < 0 {
+		return bulkError("delete", indexName, id, http.StatusBadRequest, "illegal_argument_exception", fmt.Sprintf("invalid document ID: %q", id))
+	}
+
+	// Deleting from an index that doesn't exist finds nothing
+	live := r.lookupIndex(indexName)
+	found := false
+	if live != nil {
+		defer live.release()
+		_, err = live.idx.GetDocument(docID)
+		found = err == nil
+	}
+	if !found {
+		return map[string]interface{}{
+			"delete": map[string]interface{}{
+				"_index": indexName,
+				"_id":    id,
+				"result": "not_found",
+				"status": http.StatusNotFound,
+			},
+		}
+	}
+
+	if err := live.idx.DeleteDocument(docID); err != nil {
+		return bulkError("delete", indexName, id, http.StatusInternalServerError, "delete_failed_exception", err.Error())
+	}
+
+	return map[string]interface{}{
+		"delete": map[string]interface{}{
+			"_index": indexName,
+			"_id":    id,
+			"result": "deleted",
+			"status": http.StatusOK,
+		},
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"my-indexer/document"
+	"my-indexer/logger"
+)
+
+// exportBatchSize is the number of documents loaded and written per chunk
+const exportBatchSize = 100
+
+// exportLine is one NDJSON line of an index export
+type exportLine struct {
+	ID     string             `json:"_id"`
+	Source *document.Document `json:"_source"`
+}
+
+// handleExport streams every document of the index as NDJSON, one
+// {"_id":..,"_source":{...}} object per line. Document IDs are gathered with
+// the index's streaming iterator and documents are then loaded and flushed in
+// batches, so memory stays flat and a slow client never holds the index lock.
+func (r *Router) handleExport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "only GET method is allowed")
+		return
+	}
+
+	indexName := strings.Split(strings.Trim(req.URL.Path, "/"), "/")[0]
+	if indexName == "" || indexName == "_export" {
+		r.errorResponse(w, http.StatusBadRequest, "invalid index path")
+		return
+	}
+
+	live := r.lookupIndex(indexName)
+	if live == nil {
+		r.indexNotFound(w, indexName)
+		return
+	}
+	defer live.release()
+
+	idx := live.idx
+	docIDs := make([]int, 0, idx.GetDocumentCount())
+	idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		docIDs = append(docIDs, docID)
+		return true
+	})
+	sort.Ints(docIDs)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	controller := http.NewResponseController(w)
+	for start := 0; start < len(docIDs); start += exportBatchSize {
+		end := start + exportBatchSize
+		if end > len(docIDs) {
+			end = len(docIDs)
+		}
+
+		batch := docIDs[start:end]
+		docs := idx.GetDocuments(batch)
+		for _, docID := range batch {
+			// Documents deleted since the scan are left out
+			doc, exists := docs[docID]
+			if !exists {
+				continue
+			}
+			if err := encoder.Encode(exportLine{ID: strconv.Itoa(docID), Source: doc}); err != nil {
+				logger.Error("Export of index %s stopped: %v", indexName, err)
+				return
+			}
+		}
+		controller.Flush()
+	}
+}
@@ -0,0 +1,313 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"my-indexer/analysis"
+	"my-indexer/index"
+	"my-indexer/logger"
+	"my-indexer/search"
+)
+
+// defaultIndexName keys the index serving requests that name no index, such
+// as POST /_index
+const defaultIndexName = ""
+
+// newLiveIndex serves idx with the search settings and async queue
+// configuration of template
+func newLiveIndex(idx *index.Index, template *liveIndex) *liveIndex {
+	s := search.NewSearch(idx, &IndexDocumentStore{idx: idx})
+	s.SetShardCount(template.search.ShardCount())
+	s.SetAggregationLimits(template.search.AggregationLimits())
+
+	live := &liveIndex{
+		idx:            idx,
+		search:         s,
+		queueCapacity:  template.queueCapacity,
+		queueBatchSize: template.queueBatchSize,
+		users:          &sync.WaitGroup{},
+	}
+	if template.queue != nil {
+		live.queue = index.NewIndexingQueue(idx, template.queueCapacity, template.queueBatchSize)
+	}
+	return live
+}
+
+// lookupIndex returns the index served under name, or nil if there is none.
+// The caller must release the index once done with it, which keeps it from
+// being closed by a concurrent delete until then.
+func (r *Router) lookupIndex(name string) *liveIndex {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	slot, ok := r.indices[name]
+	if !ok {
+		return nil
+	}
+	return acquire(slot)
+}
+
+// acquire returns the index held by slot, counting the caller as one of its
+// users. It must be called with r.mu held, so that a delete either sees the
+// new user or has already removed the slot.
+func acquire(slot *atomic.Pointer[liveIndex]) *liveIndex {
+	live := slot.Load()
+	live.users.Add(1)
+	return live
+}
+
+// writeIndex returns the index served under name, creating it on the first
+// write. New indices take their settings from the default index. Like
+// lookupIndex, the caller must release the index.
+func (r *Router) writeIndex(name string) (*liveIndex, error) {
+	if live := r.lookupIndex(name); live != nil {
+		return live, nil
+	}
+	if err := validateIndexName(name); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Another request may have created the index in the meantime
+	if slot, ok := r.indices[name]; ok {
+		return acquire(slot), nil
+	}
+
+	slot := &atomic.Pointer[liveIndex]{}
+	slot.Store(newLiveIndex(index.NewIndex(analysis.NewStandardAnalyzer()), r.indices[defaultIndexName].Load()))
+	r.indices[name] = slot
+	logger.Info("Created index %s", name)
+	return acquire(slot), nil
+}
+
+// createIndex registers a new, empty index under name with the given shard
+// count and mappings. It fails with ErrIndexExists when the name is taken and
+// ErrInvalidIndex when it isn't a valid index name.
+func (r *Router) createIndex(name string, shards int, mapping indexMapping) error {
+	if err := validateIndexName(name); err != nil {
+		return err
+	}
+
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	if err := mapping.apply(idx); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.indices[name]; ok {
+		return fmt.Errorf("%w: [%s]", ErrIndexExists, name)
+	}
+	live := newLiveIndex(idx, r.indices[defaultIndexName].Load())
+	if shards > 0 {
+		live.search.SetShardCount(shards)
+	}
+	slot := &atomic.Pointer[liveIndex]{}
+	slot.Store(live)
+	r.indices[name] = slot
+	logger.Info("Created index %s", name)
+	return nil
+}
+
+// deleteIndex stops serving the index under name, draining its indexing
+// queue and closing it once the requests using it are done. It reports false
+// when there is no such index.
+func (r *Router) deleteIndex(name string) (bool, error) {
+	r.mu.Lock()
+	slot, ok := r.indices[name]
+	if ok {
+		delete(r.indices, name)
+	}
+	r.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+
+	// Later lookups can't find the index, so only requests that already
+	// hold it are waited for
+	live := slot.Load()
+	live.users.Wait()
+	if live.queue != nil {
+		live.queue.Close()
+	}
+	logger.Info("Deleted index %s", name)
+	return true, live.idx.Close()
+}
+
+// handleIndexAdmin creates an index on PUT /{index}, optionally with a body of
+// {"settings": {"number_of_shards": n}, "mappings": {"date_detection": true,
+// "properties": {...}}},
+// and drops it on DELETE /{index}
+func (r *Router) handleIndexAdmin(w http.ResponseWriter, req *http.Request) {
+	name := strings.Trim(req.URL.Path, "/")
+
+	switch req.Method {
+	case http.MethodPut:
+		var body struct {
+			Settings map[string]interface{}     `json:"settings"`
+			Mappings map[string]json.RawMessage `json:"mappings"`
+		}
+		// The body is optional
+		data, err := validateRequestBody(req)
+		switch {
+		case errors.Is(err, ErrBodyTooLarge):
+			r.errorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", MaxRequestBodySize))
+			return
+		case errors.Is(err, ErrEmptyBody), errors.Is(err, ErrMissingBody):
+		case err != nil:
+			r.errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		default:
+			if err := json.Unmarshal(data, &body); err != nil {
+				r.errorResponse(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+		}
+		shards, err := parseIndexSettings(body.Settings)
+		if err != nil {
+			r.exceptionResponse(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+			return
+		}
+		var mapping indexMapping
+		if body.Mappings != nil {
+			mapping, err = parseMapping(body.Mappings)
+			if err != nil {
+				r.exceptionResponse(w, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+				return
+			}
+		}
+
+		if err := r.createIndex(name, shards, mapping); err != nil {
+			switch {
+			case errors.Is(err, ErrIndexExists):
+				r.exceptionResponse(w, http.StatusBadRequest, "resource_already_exists_exception", err.Error())
+			case errors.Is(err, ErrInvalidIndex):
+				r.exceptionResponse(w, http.StatusBadRequest, "invalid_index_name_exception", err.Error())
+			default:
+				r.exceptionResponse(w, http.StatusBadRequest, "mapper_parsing_exception", err.Error())
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"acknowledged": true,
+			"index":        name,
+		})
+	case http.MethodDelete:
+		found, err := r.deleteIndex(name)
+		if !found {
+			r.indexNotFound(w, name)
+			return
+		}
+		if err != nil {
+			r.errorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// parseIndexSettings returns the shard count of a create index request's
+// settings, given directly or under "index"; 0 when it isn't set
+func parseIndexSettings(settings map[string]interface{}) (int, error) {
+	if nested, ok := settings["index"].(map[string]interface{}); ok {
+		settings = nested
+	}
+	shards := 0
+	for key, value := range settings {
+		switch key {
+		case "number_of_shards":
+			n, ok := value.(float64)
+			if !ok || n < 1 || n != float64(int(n)) {
+				return 0, fmt.Errorf("number_of_shards must be a positive integer")
+			}
+			shards = int(n)
+		case "number_of_replicas":
+			// Accepted for compatibility; there is nothing to replicate to
+		default:
+			return 0, fmt.Errorf("unsupported index setting: %s", key)
+		}
+	}
+	return shards, nil
+}
+
+// isIndexPath reports whether a request path names only an index, as in
+// /books, rather than an API endpoint
+func isIndexPath(path string) bool {
+	name := strings.Trim(path, "/")
+	return name != "" && !strings.Contains(name, "/") && !strings.HasPrefix(name, "_")
+}
+
+// indexNames returns the names of the named indices in sorted order
+func (r *Router) indexNames() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.indices))
+	for name := range r.indices {
+		if name != defaultIndexName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// servedIndices returns every served index, including the default one. The
+// caller must release each of them.
+func (r *Router) servedIndices() []*liveIndex {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	served := make([]*liveIndex, 0, len(r.indices))
+	for _, slot := range r.indices {
+		served = append(served, acquire(slot))
+	}
+	return served
+}
+
+// pathIndexName returns the index named by the first segment of a request
+// path, or the default index name for paths such as /_search that start with
+// an API endpoint
+func pathIndexName(req *http.Request) string {
+	name := strings.Split(strings.Trim(req.URL.Path, "/"), "/")[0]
+	if strings.HasPrefix(name, "_") {
+		return defaultIndexName
+	}
+	return name
+}
+
+// multiIndexNames returns the indices a search path targets when its first
+// segment names more than one: a comma-separated list of names, or _all for
+// every named index. ok is false when the path targets a single index.
+func (r *Router) multiIndexNames(req *http.Request) (names []string, ok bool) {
+	segment := strings.Split(strings.Trim(req.URL.Path, "/"), "/")[0]
+	if segment == "_all" {
+		return r.indexNames(), true
+	}
+	if !strings.Contains(segment, ",") {
+		return nil, false
+	}
+	for _, name := range strings.Split(segment, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, true
+}
+
+// indexNotFound responds to a request against an index that doesn't exist
+func (r *Router) indexNotFound(w http.ResponseWriter, name string) {
+	r.exceptionResponse(w, http.StatusNotFound, "index_not_found_exception", fmt.Sprintf("no such index [%s]", name))
+}
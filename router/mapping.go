@@ -0,0 +1,144 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"my-indexer/index"
+)
+
+// indexMapping is a parsed mapping request body
+type indexMapping struct {
+	properties    map[string]index.FieldMapping
+	dateDetection *bool // Nil when the body doesn't set date_detection
+}
+
+// apply sets the date detection setting and field mappings on idx
+func (m indexMapping) apply(idx *index.Index) error {
+	if m.dateDetection != nil {
+		idx.SetDateDetection(*m.dateDetection)
+	}
+	if len(m.properties) == 0 {
+		return nil
+	}
+	return idx.PutMapping(m.properties)
+}
+
+// handleMapping reads or adds to the explicit field mappings of an index.
+// PUT takes {"properties": {"field": {"type": "..."}}} and optionally
+// "date_detection", creating the index if it doesn't exist yet; GET returns
+// the mappings in the same shape.
+func (r *Router) handleMapping(w http.ResponseWriter, req *http.Request) {
+	indexName := pathIndexName(req)
+
+	switch req.Method {
+	case http.MethodGet:
+		live := r.lookupIndex(indexName)
+		if live == nil {
+			r.indexNotFound(w, indexName)
+			return
+		}
+		defer live.release()
+		mappings := map[string]interface{}{"properties": live.idx.Mapping()}
+		if live.idx.DateDetection() {
+			mappings["date_detection"] = true
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			indexName: map[string]interface{}{"mappings": mappings},
+		})
+	case http.MethodPut, http.MethodPost:
+		var body map[string]json.RawMessage
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			r.errorResponse(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		mapping, err := parseMapping(body)
+		if err != nil {
+			r.errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		live, err := r.writeIndex(indexName)
+		if err != nil {
+			r.errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		defer live.release()
+		if err := mapping.apply(live.idx); err != nil {
+			r.errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// parseMapping parses a put mapping request body. Properties are required
+// unless the body sets date_detection.
+func parseMapping(body map[string]json.RawMessage) (indexMapping, error) {
+	var mapping indexMapping
+	for key, raw := range body {
+		switch key {
+		case "properties":
+		case "date_detection":
+			var enabled bool
+			if err := json.Unmarshal(raw, &enabled); err != nil {
+				return mapping, fmt.Errorf("date_detection must be a boolean")
+			}
+			mapping.dateDetection = &enabled
+		default:
+			return mapping, fmt.Errorf("unsupported mapping parameter: %s", key)
+		}
+	}
+	if _, ok := body["properties"]; !ok && mapping.dateDetection != nil {
+		return mapping, nil
+	}
+
+	var err error
+	mapping.properties, err = parseMappingProperties(body["properties"])
+	return mapping, err
+}
+
+// parseMappingProperties parses the properties of a put mapping request body
+func parseMappingProperties(raw json.RawMessage) (map[string]index.FieldMapping, error) {
+	var rawProperties map[string]map[string]interface{}
+	if err := json.Unmarshal(raw, &rawProperties); err != nil || rawProperties == nil {
+		return nil, fmt.Errorf("properties must be an object of field mappings")
+	}
+
+	properties := make(map[string]index.FieldMapping, len(rawProperties))
+	for field, raw := range rawProperties {
+		var mapping index.FieldMapping
+		for key, value := range raw {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("field %s: %s must be a string", field, key)
+			}
+			switch key {
+			case "type":
+				mapping.Type = s
+			case "analyzer":
+				mapping.Analyzer = s
+			default:
+				return nil, fmt.Errorf("field %s: unsupported mapping parameter: %s", field, key)
+			}
+		}
+		if mapping.Type == "" {
+			return nil, fmt.Errorf("field %s: type is required", field)
+		}
+		properties[field] = mapping
+	}
+	return properties, nil
+}
+
+// writeErrorStatus returns the status of a failed document write: values
+// that don't fit their field's mapping are the client's error
+func writeErrorStatus(err error) int {
+	if errors.Is(err, index.ErrInvalidFieldValue) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
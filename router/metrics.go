@@ -0,0 +1,186 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// searchLatencyBuckets are the upper bounds, in seconds, of the search latency
+// histogram's buckets
+var searchLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// requestKey identifies a request counter by endpoint and response status
+type requestKey struct {
+	endpoint string
+	status   int
+}
+
+// Metrics counts the requests served by the router and the latency of
+// searches, for exposition at /_metrics in the Prometheus text format
+type Metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]uint64
+	searchBuckets []uint64 // Searches per latency bucket, not cumulative
+	searchCount   uint64
+	searchSum     float64 // Total search latency in seconds
+}
+
+// NewMetrics returns an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:      make(map[requestKey]uint64),
+		searchBuckets: make([]uint64, len(searchLatencyBuckets)),
+	}
+}
+
+// EnableMetrics starts collecting request metrics and serving them at
+// /_metrics. Until it is called, requests are not counted and /_metrics
+// responds 404.
+func (r *Router) EnableMetrics() {
+	r.metrics.CompareAndSwap(nil, NewMetrics())
+}
+
+// observeRequest counts a served request, recording its latency as well when
+// it was a search
+func (m *Metrics) observeRequest(endpoint string, status int, took time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{endpoint: endpoint, status: status}]++
+	if endpoint != "_search" {
+		return
+	}
+	seconds := took.Seconds()
+	m.searchCount++
+	m.searchSum += seconds
+	if i := sort.SearchFloat64s(searchLatencyBuckets, seconds); i < len(m.searchBuckets) {
+		m.searchBuckets[i]++
+	}
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format, along
+// with the given document counts by index name
+func (m *Metrics) writeTo(w io.Writer, documents map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].endpoint != keys[j].endpoint {
+			return keys[i].endpoint < keys[j].endpoint
+		}
+		return keys[i].status < keys[j].status
+	})
+	fmt.Fprintln(w, "# HELP indexer_http_requests_total HTTP requests served, by endpoint and status.")
+	fmt.Fprintln(w, "# TYPE indexer_http_requests_total counter")
+	for _, key := range keys {
+		fmt.Fprintf(w, "indexer_http_requests_total{endpoint=%q,status=\"%d\"} %d\n", key.endpoint, key.status, m.requests[key])
+	}
+
+	fmt.Fprintln(w, "# HELP indexer_search_latency_seconds Latency of search requests.")
+	fmt.Fprintln(w, "# TYPE indexer_search_latency_seconds histogram")
+	var cumulative uint64
+	for i, bound := range searchLatencyBuckets {
+		cumulative += m.searchBuckets[i]
+		fmt.Fprintf(w, "indexer_search_latency_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "indexer_search_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.searchCount)
+	fmt.Fprintf(w, "indexer_search_latency_seconds_sum %g\n", m.searchSum)
+	fmt.Fprintf(w, "indexer_search_latency_seconds_count %d\n", m.searchCount)
+
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "# HELP indexer_index_documents Number of documents in each index, a count rather than a size in bytes.")
+	fmt.Fprintln(w, "# TYPE indexer_index_documents gauge")
+	for _, name := range names {
+		fmt.Fprintf(w, "indexer_index_documents{index=%q} %d\n", name, documents[name])
+	}
+}
+
+// handleMetrics serves the collected metrics, with the current document count
+// of every index
+func (r *Router) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "only GET method is allowed")
+		return
+	}
+	metrics := r.metrics.Load()
+	if metrics == nil {
+		r.errorResponse(w, http.StatusNotFound, "metrics are not enabled")
+		return
+	}
+
+	documents := make(map[string]int)
+	for _, name := range append(r.indexNames(), defaultIndexName) {
+		if live := r.lookupIndex(name); live != nil {
+			documents[name] = live.idx.GetDocumentCount()
+			live.release()
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	metrics.writeTo(w, documents)
+}
+
+// metricsEndpoints are the API segments requests are counted under
+var metricsEndpoints = map[string]bool{
+	"_update": true, "_doc": true, "_bulk": true, "_mget": true, "_search": true,
+	"_msearch": true, "_cat": true, "_scroll": true, "_index": true, "_refresh": true,
+	"_export": true, "_analyze": true, "_mapping": true, "_metrics": true,
+}
+
+// metricsEndpoint returns the endpoint a request path is counted under: its
+// first known API segment such as _search or _doc, "index" for index paths
+// such as /books, and "other" for anything else. Index names, document IDs
+// and unknown segments are left out to keep the number of counters bounded.
+func metricsEndpoint(path string) string {
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if metricsEndpoints[segment] {
+			return segment
+		}
+	}
+	if isIndexPath(path) {
+		return "index"
+	}
+	return "other"
+}
+
+// statusRecorder remembers the status code of a response for the metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status before sending it
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write records an implicit 200 status before writing the body
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can reach
+// optional interfaces such as http.Flusher
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
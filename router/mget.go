@@ -0,0 +1,127 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mgetRequest is the body of a multi-get request
+type mgetRequest struct {
+	Docs []struct {
+		Index string      `json:"_index"`
+		ID    interface{} `json:"_id"`
+	} `json:"docs"`
+	IDs []interface{} `json:"ids"`
+}
+
+// handleMget fetches several documents in one request. The body lists them as
+// {"docs": [{"_index": "i", "_id": "1"}, ...]}, where _index defaults to the
+// index in the path, or as {"ids": ["1", ...]} against the path's index.
+// Each requested document gets an entry in the response, with found false
+// when it doesn't exist.
+func (r *Router) handleMget(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	pathIndex := strings.Split(strings.Trim(req.URL.Path, "/"), "/")[0]
+	if strings.HasPrefix(pathIndex, "_") {
+		pathIndex = ""
+	}
+
+	var body mgetRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		r.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	type target struct {
+		index string
+		id    string
+	}
+	var targets []target
+	for i, doc := range body.Docs {
+		id, ok := mgetID(doc.ID)
+		if !ok {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("docs[%d]: _id must be a string or number", i))
+			return
+		}
+		indexName := doc.Index
+		if indexName == "" {
+			indexName = pathIndex
+		}
+		if indexName == "" {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("docs[%d]: _index is required without an index in the path", i))
+			return
+		}
+		targets = append(targets, target{index: indexName, id: id})
+	}
+	if len(body.IDs) > 0 && pathIndex == "" {
+		r.errorResponse(w, http.StatusBadRequest, "ids requires an index in the path")
+		return
+	}
+	for i, raw := range body.IDs {
+		id, ok := mgetID(raw)
+		if !ok {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("ids[%d] must be a string or number", i))
+			return
+		}
+		targets = append(targets, target{index: pathIndex, id: id})
+	}
+	if len(targets) == 0 {
+		r.errorResponse(w, http.StatusBadRequest, "docs or ids is required")
+		return
+	}
+
+	docs := make([]map[string]interface{}, 0, len(targets))
+	for _, t := range targets {
+		live := r.lookupIndex(t.index)
+		if live == nil {
+			docs = append(docs, map[string]interface{}{
+				"_index": t.index,
+				"_id":    t.id,
+				"found":  false,
+				"error": map[string]interface{}{
+					"type":   "index_not_found_exception",
+					"reason": fmt.Sprintf("no such index [%s]", t.index),
+				},
+			})
+			continue
+		}
+		defer live.release()
+
+		// IDs that aren't document numbers can't name an indexed document
+		docID, err := strconv.Atoi(t.id)
+		if err == nil && docID >= 0 {
+			if doc, err := live.idx.GetDocument(docID); err == nil && doc != nil {
+				resp := documentResponse(live, t.index, docID)
+				resp["found"] = true
+				resp["_source"] = doc
+				docs = append(docs, resp)
+				continue
+			}
+		}
+		docs = append(docs, map[string]interface{}{
+			"_index": t.index,
+			"_id":    t.id,
+			"found":  false,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"docs": docs})
+}
+
+// mgetID returns a requested document ID given as a string or number
+func mgetID(raw interface{}) (string, bool) {
+	switch v := raw.(type) {
+	case string:
+		return v, v != ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
@@ -0,0 +1,149 @@
+package router
+
+import (
+	"compress/gzip"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"my-indexer/logger"
+)
+
+// RecoveryMiddleware recovers from panics in downstream handlers, logs the
+// stack trace and responds with a 500 JSON error so the server keeps serving
+func RecoveryMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				// Let the server abort the response as it normally would
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				logger.Error("Panic handling %s %s: %v\n%s", r.Method, r.URL.Path, rec, debug.Stack())
+				writeError(w, http.StatusInternalServerError, "exception", "internal server error")
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GzipMiddleware decompresses request bodies sent with Content-Encoding: gzip
+// and compresses responses for clients sending Accept-Encoding: gzip.
+// Decompressed bodies are capped at MaxRequestBodySize, so the body limits
+// apply to the decompressed size rather than the bytes on the wire.
+func GzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "parse_exception", "invalid gzip request body")
+				return
+			}
+			defer gz.Close()
+			r.Body = http.MaxBytesReader(w, gz, MaxRequestBodySize)
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		}
+
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether a request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		// A quality of 0 refuses the encoding
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if q, err := strconv.ParseFloat(value, 64); key == "q" && err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the body of a response. Responses that carry
+// no body, such as 204 and 304, are passed through uncompressed.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+	compress    bool
+}
+
+// WriteHeader marks the response as gzip-encoded before sending the status
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.compress = code != http.StatusNoContent && code != http.StatusNotModified && code >= http.StatusOK
+	if w.compress {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write compresses b into the response body
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.compress {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.gz == nil {
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush sends the data compressed so far to the client
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the compressed body. A gzip-encoded response with nothing
+// written still gets a valid, empty gzip stream.
+func (w *gzipResponseWriter) Close() error {
+	if !w.wroteHeader {
+		return nil
+	}
+	if w.compress && w.gz == nil {
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can reach
+// optional interfaces it doesn't implement
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
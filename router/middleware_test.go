@@ -0,0 +1,167 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"my-indexer/search"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, req *http.Request) {
+		var doc map[string]interface{}
+		doc["field"] = "value" // nil map assignment panics
+	})
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(RecoveryMiddleware(mux))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/panic")
+	if err != nil {
+		t.Fatalf("request to panicking handler failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status %d but got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+
+	var body struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+		Status int `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("expected JSON error body: %v", err)
+	}
+	if body.Error.Reason == "" || body.Status != http.StatusInternalServerError {
+		t.Errorf("expected an error envelope in body, got %+v", body)
+	}
+
+	// The server keeps serving after a panic
+	resp, err = http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatalf("request after panic failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d after panic but got %d", http.StatusOK, resp.StatusCode)
+	}
+}
+
+// gzipBytes returns data compressed with gzip
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	server := httptest.NewServer(GzipMiddleware(NewRouter()))
+	defer server.Close()
+	// Read responses as sent rather than letting the transport decompress them
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+
+	bulk := `{"index": {"_index": "books", "_id": "1"}}
+{"title": "Dune"}
+{"index": {"_index": "books", "_id": "2"}}
+{"title": "Emma"}
+`
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/_bulk", bytes.NewReader(gzipBytes(t, []byte(bulk))))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("gzipped bulk request failed: %v", err)
+	}
+	var bulkResp struct {
+		Errors bool          `json:"errors"`
+		Items  []interface{} `json:"items"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&bulkResp)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || bulkResp.Errors || len(bulkResp.Items) != 2 {
+		t.Fatalf("expected the gzipped bulk body to index 2 documents, got %d %+v (%v)", resp.StatusCode, bulkResp, err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/books/_search", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("search request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip-encoded search response, got headers %v", resp.Header)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("expected a gzip response body: %v", err)
+	}
+	var searchResp search.ESResponse
+	if err := json.NewDecoder(gz).Decode(&searchResp); err != nil {
+		t.Fatalf("failed to decode the decompressed response: %v", err)
+	}
+	if len(searchResp.Hits.Hits) != 2 {
+		t.Errorf("expected 2 hits, got %d", len(searchResp.Hits.Hits))
+	}
+
+	// Clients that don't accept gzip, or refuse it, get plain responses
+	for _, acceptEncoding := range []string{"", "identity", "gzip;q=0"} {
+		req, _ = http.NewRequest(http.MethodGet, server.URL+"/books/_search", nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("search request failed: %v", err)
+		}
+		err = json.NewDecoder(resp.Body).Decode(&searchResp)
+		resp.Body.Close()
+		if resp.Header.Get("Content-Encoding") != "" || err != nil {
+			t.Errorf("Accept-Encoding %q: expected a plain JSON response, got %v (%v)", acceptEncoding, resp.Header, err)
+		}
+	}
+
+	// The body limit applies to the decompressed size
+	oversized := `{"title": "` + strings.Repeat("a", MaxRequestBodySize) + `"}`
+	req, _ = http.NewRequest(http.MethodPut, server.URL+"/books/_doc/3", bytes.NewReader(gzipBytes(t, []byte(oversized))))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("oversized document request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a body over %d bytes once decompressed to be rejected, got %d", MaxRequestBodySize, resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/_bulk", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("invalid gzip request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid gzip body, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,187 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handleMultiSearch handles the multi-search API. The body holds pairs of
+// NDJSON lines, a header naming the indices to search followed by a search
+// request body, and the response holds one search response per pair in the
+// same order. A search that fails reports its error in its own response
+// rather than failing the whole request. The body is limited to
+// MaxRequestBodySize.
+func (r *Router) handleMultiSearch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if req.Header.Get("Content-Type") != "application/x-ndjson" {
+		r.errorResponse(w, http.StatusBadRequest, "Content-Type must be application/x-ndjson")
+		return
+	}
+
+	body, err := validateRequestBody(req)
+	switch {
+	case errors.Is(err, ErrBodyTooLarge):
+		r.errorResponse(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", MaxRequestBodySize))
+		return
+	case err != nil:
+		r.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Searches run against the indices named by the path unless their header
+	// names its own
+	pathIndices := strings.Split(strings.Trim(req.URL.Path, "/"), "/")[0]
+	if strings.HasPrefix(pathIndices, "_") && pathIndices != "_all" {
+		pathIndices = ""
+	}
+
+	startTime := time.Now()
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), MaxRequestBodySize)
+	lineNum := 0
+
+	// nextLine returns the next non-empty line from the request body
+	nextLine := func() (string, bool) {
+		for scanner.Scan() {
+			lineNum++
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				return line, true
+			}
+		}
+		return "", false
+	}
+
+	responses := make([]map[string]interface{}, 0)
+	for {
+		// Header line
+		line, ok := nextLine()
+		if !ok {
+			break
+		}
+		var header map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &header); err != nil {
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid JSON at line %d: %v", lineNum, err))
+			return
+		}
+		indices, err := msearchHeaderIndices(header, pathIndices)
+		if err != nil {
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid header at line %d: %v", lineNum, err))
+			return
+		}
+
+		// Search request line
+		line, ok = nextLine()
+		if !ok {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("missing search request for the header at line %d", lineNum))
+			return
+		}
+
+		responses = append(responses, r.multiSearchItem(req, indices, line))
+	}
+
+	if err := scanner.Err(); err != nil {
+		r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("error reading request body: %v", err))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":      int(time.Since(startTime).Milliseconds()),
+		"responses": responses,
+	})
+}
+
+// msearchHeaderIndices returns the indices a multi-search header names, as a
+// comma-separated list, or pathIndices when it names none
+func msearchHeaderIndices(header map[string]interface{}, pathIndices string) (string, error) {
+	switch v := header["index"].(type) {
+	case nil:
+		return pathIndices, nil
+	case string:
+		return v, nil
+	case []interface{}:
+		names := make([]string, 0, len(v))
+		for _, item := range v {
+			name, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("index names must be strings")
+			}
+			names = append(names, name)
+		}
+		return strings.Join(names, ","), nil
+	}
+	return "", fmt.Errorf("index must be a string or array of strings")
+}
+
+// multiSearchItem runs one search of a multi-search request through the
+// search handler, returning its response with the status it was sent with
+func (r *Router) multiSearchItem(req *http.Request, indices, body string) map[string]interface{} {
+	path := "/_search"
+	if indices != "" {
+		path = "/" + indices + "/_search"
+	}
+	searchReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, path, strings.NewReader(body))
+	if err != nil {
+		return msearchError(http.StatusBadRequest, statusErrorType(http.StatusBadRequest), err.Error())
+	}
+	searchReq.Header.Set("Content-Type", "application/json")
+
+	recorder := newBufferedResponse()
+	r.handleSearch(recorder, searchReq)
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(recorder.body.Bytes(), &resp); err != nil {
+		return msearchError(http.StatusInternalServerError, "exception", fmt.Sprintf("invalid search response: %v", err))
+	}
+	resp["status"] = recorder.status
+	return resp
+}
+
+// msearchError builds a multi-search response reporting a failed search in
+// the Elasticsearch error envelope
+func msearchError(code int, errType, reason string) map[string]interface{} {
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":   errType,
+			"reason": reason,
+		},
+		"status": code,
+	}
+}
+
+// bufferedResponse collects a response in memory so it can be embedded in
+// another response
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+// newBufferedResponse returns an empty buffered response with status 200
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+// Header returns the response headers
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+// Write appends to the response body
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	return b.body.Write(p)
+}
+
+// WriteHeader records the response status
+func (b *bufferedResponse) WriteHeader(code int) {
+	b.status = code
+}
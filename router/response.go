@@ -0,0 +1,72 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// prettyResponseWriter marks a response whose JSON body should be indented,
+// as requested with the ?pretty query parameter
+type prettyResponseWriter struct {
+	http.ResponseWriter
+}
+
+// wantsPretty reports whether the request asked for indented JSON. Both a bare
+// ?pretty and ?pretty=true enable it.
+func wantsPretty(req *http.Request) bool {
+	values, ok := req.URL.Query()["pretty"]
+	if !ok {
+		return false
+	}
+	return len(values) == 0 || values[0] == "" || values[0] == "true"
+}
+
+// writeJSON writes v as a JSON response with the given status code, indenting
+// it when the client asked for pretty output
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	encoder := json.NewEncoder(w)
+	if _, ok := w.(*prettyResponseWriter); ok {
+		encoder.SetIndent("", "  ")
+	}
+	encoder.Encode(v)
+}
+
+// Unwrap returns the underlying writer, so http.ResponseController can reach
+// optional interfaces such as http.Flusher
+func (w *prettyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusErrorTypes are the error types reported for errors that don't name a
+// more specific type, by response status
+var statusErrorTypes = map[int]string{
+	http.StatusBadRequest:            "illegal_argument_exception",
+	http.StatusNotFound:              "resource_not_found_exception",
+	http.StatusMethodNotAllowed:      "method_not_allowed_exception",
+	http.StatusConflict:              "version_conflict_engine_exception",
+	http.StatusRequestEntityTooLarge: "content_too_long_exception",
+	http.StatusNotImplemented:        "unsupported_operation_exception",
+}
+
+// statusErrorType returns the error type reported for a status
+func statusErrorType(code int) string {
+	if errType, ok := statusErrorTypes[code]; ok {
+		return errType
+	}
+	return "exception"
+}
+
+// writeError writes an Elasticsearch-style error envelope,
+// {"error": {"type": ..., "reason": ...}, "status": code}
+func writeError(w http.ResponseWriter, code int, errType, reason string) {
+	writeJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":   errType,
+			"reason": reason,
+		},
+		"status": code,
+	})
+}
@@ -2,10 +2,14 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"my-indexer/logger"
@@ -26,29 +30,60 @@ func (s *IndexDocumentStore) LoadDocument(docID int) (*document.Document, error)
 	return s.idx.GetDocument(docID)
 }
 
+// LoadDocuments implements search.BatchDocumentStore, loading all documents
+// needed by a query from a single snapshot of the index
+func (s *IndexDocumentStore) LoadDocuments(docIDs []int) (map[int]*document.Document, error) {
+	return s.idx.GetDocuments(docIDs), nil
+}
+
 // LoadAllDocuments implements search.DocumentStore
 func (s *IndexDocumentStore) LoadAllDocuments() ([]*document.Document, error) {
 	return s.idx.GetAllDocuments()
 }
 
+// liveIndex groups the index served by the router with the search and
+// indexing queue built on it, so they are always swapped together
+type liveIndex struct {
+	idx    *index.Index
+	search *search.Search
+	queue  *index.IndexingQueue // Optional async indexing queue
+
+	queueCapacity  int // Capacity the queue was created with
+	queueBatchSize int // Batch size the queue was created with
+
+	users *sync.WaitGroup // Requests using the index, shared by the liveIndexes of one index
+}
+
+// release marks a request as done with an index it looked up
+func (l *liveIndex) release() {
+	l.users.Done()
+}
+
 // Router handles HTTP requests for the indexer
 type Router struct {
-	mux    *http.ServeMux
-	index  *index.Index
-	search *search.Search
+	mux *http.ServeMux
+
+	mu      sync.RWMutex
+	indices map[string]*atomic.Pointer[liveIndex] // Served indices by name, each replaced as a whole when reloaded
+
+	metrics atomic.Pointer[Metrics] // Request metrics, nil until EnableMetrics is called
 }
 
 // NewRouter creates a new Router instance
 func NewRouter() *Router {
 	analyzer := analysis.NewStandardAnalyzer()
 	idx := index.NewIndex(analyzer)
-	store := &IndexDocumentStore{idx: idx}
-	
+
 	router := &Router{
-		mux:    http.NewServeMux(),
-		index:  idx,
-		search: search.NewSearch(idx, store),
+		mux:     http.NewServeMux(),
+		indices: make(map[string]*atomic.Pointer[liveIndex]),
 	}
+	router.indices[defaultIndexName] = &atomic.Pointer[liveIndex]{}
+	router.indices[defaultIndexName].Store(&liveIndex{
+		idx:    idx,
+		search: search.NewSearch(idx, &IndexDocumentStore{idx: idx}),
+		users:  &sync.WaitGroup{},
+	})
 
 	// Initialize the logger
 	logger.Initialize()
@@ -59,8 +94,65 @@ func NewRouter() *Router {
 	return router
 }
 
+// EnableAsyncIndexing switches index requests to asynchronous mode. Writes are
+// queued (up to capacity) and applied in batches of batchSize; handlers respond
+// with 202 Accepted and clients call _refresh to wait for them to be applied.
+// The setting applies to every index, including those created later.
+func (r *Router) EnableAsyncIndexing(capacity, batchSize int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, slot := range r.indices {
+		old := slot.Load()
+		if old.queue != nil {
+			old.queue.Close()
+		}
+		slot.Store(&liveIndex{
+			idx:            old.idx,
+			search:         old.search,
+			queue:          index.NewIndexingQueue(old.idx, capacity, batchSize),
+			queueCapacity:  capacity,
+			queueBatchSize: batchSize,
+			users:          old.users,
+		})
+	}
+}
+
+// ReloadIndex atomically replaces the default index, for example after it was
+// rebuilt out of band. Requests already in flight finish against the old
+// index; later requests see the new one. Shard count and aggregation limits
+// carry over, and an async indexing queue is drained into the old index and
+// restarted on the new one.
+func (r *Router) ReloadIndex(idx *index.Index) {
+	r.mu.RLock()
+	slot := r.indices[defaultIndexName]
+	r.mu.RUnlock()
+
+	old := slot.Load()
+	slot.Store(newLiveIndex(idx, old))
+
+	if old.queue != nil {
+		old.queue.Close()
+	}
+}
+
+// current returns the default index with the search and queue serving it.
+// The default index is never deleted, so it needn't be released.
+func (r *Router) current() *liveIndex {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.indices[defaultIndexName].Load()
+}
+
 // Close performs cleanup of router resources
 func (r *Router) Close() {
+	for _, live := range r.servedIndices() {
+		if live.queue != nil {
+			live.queue.Close()
+		}
+		live.release()
+	}
 	logger.Close()
 }
 
@@ -69,7 +161,31 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Log the request
 	logger.Info("Received request: %s %s", req.Method, req.URL.Path)
 
+	// Count the request and its status once it has been served
+	if metrics := r.metrics.Load(); metrics != nil {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		w = recorder
+		defer func() {
+			status := recorder.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			metrics.observeRequest(metricsEndpoint(req.URL.Path), status, time.Since(start))
+		}()
+	}
+
+	// Indent JSON responses when the client asks for ?pretty
+	if wantsPretty(req) {
+		w = &prettyResponseWriter{ResponseWriter: w}
+	}
+
 	// Handle the request based on the path
+	if strings.Contains(req.URL.Path, "/_update/") {
+		r.handleUpdate(w, req)
+		return
+	}
+
 	if strings.Contains(req.URL.Path, "/_doc/") {
 		r.handleDocument(w, req)
 		return
@@ -80,6 +196,11 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if strings.HasSuffix(req.URL.Path, "/_mget") {
+		r.handleMget(w, req)
+		return
+	}
+
 	if strings.Contains(req.URL.Path, "/_search") {
 		r.handleSearch(w, req)
 		return
@@ -105,8 +226,38 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if strings.HasSuffix(req.URL.Path, "/_refresh") {
+		r.handleRefresh(w, req)
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/_export") {
+		r.handleExport(w, req)
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/_analyze") {
+		r.handleAnalyze(w, req)
+		return
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/_mapping") {
+		r.handleMapping(w, req)
+		return
+	}
+
+	if req.URL.Path == "/_metrics" {
+		r.handleMetrics(w, req)
+		return
+	}
+
+	if isIndexPath(req.URL.Path) {
+		r.handleIndexAdmin(w, req)
+		return
+	}
+
 	// Not found
-	http.NotFound(w, req)
+	r.errorResponse(w, http.StatusNotFound, fmt.Sprintf("no handler found for uri [%s] and method [%s]", req.URL.Path, req.Method))
 }
 
 // RegisterElasticSearchHandlers registers all ElasticSearch-compatible endpoints
@@ -115,10 +266,14 @@ func (r *Router) RegisterElasticSearchHandlers() {
 	r.mux.HandleFunc("/", r.handleDocument)                // Single document operations (matches /index/_doc/id)
 	r.mux.HandleFunc("/_index", r.handleIndex)            // Index API endpoint
 	r.mux.HandleFunc("/_bulk", r.handleBulk)              // Bulk operations
+	r.mux.HandleFunc("/_mget", r.handleMget)              // Multi-get
 	r.mux.HandleFunc("/_search", r.handleSearch)          // Search
 	r.mux.HandleFunc("/_msearch", r.handleMultiSearch)    // Multi-search
 	r.mux.HandleFunc("/_cat/indices", r.handleListIndices) // List indices
 	r.mux.HandleFunc("/_scroll", r.handleScroll)          // Scroll API
+	r.mux.HandleFunc("/_refresh", r.handleRefresh)        // Refresh (apply queued writes)
+	r.mux.HandleFunc("/_analyze", r.handleAnalyze)        // Analyze text
+	r.mux.HandleFunc("/_metrics", r.handleMetrics)        // Prometheus metrics
 }
 
 // ElasticSearchResponse represents a standard ES response format
@@ -134,12 +289,17 @@ type ElasticSearchResponse struct {
 	Status int    `json:"status,omitempty"`
 }
 
-// errorResponse sends an error response in JSON format
+// errorResponse sends an error response in the Elasticsearch error envelope,
+// typed by its status
 func (r *Router) errorResponse(w http.ResponseWriter, code int, message string) {
-	logger.Error("Error response: %s (code: %d)", message, code)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	r.exceptionResponse(w, code, statusErrorType(code), message)
+}
+
+// exceptionResponse sends an error response in the Elasticsearch error
+// envelope, naming the type of the error along with its reason
+func (r *Router) exceptionResponse(w http.ResponseWriter, code int, errType, reason string) {
+	logger.Error("Error response: %s: %s (code: %d)", errType, reason, code)
+	writeError(w, code, errType, reason)
 }
 
 // Handler functions for ElasticSearch-compatible endpoints
@@ -147,7 +307,7 @@ func (r *Router) handleDocument(w http.ResponseWriter, req *http.Request) {
 	logger.Info("Handling document request: %s %s", req.Method, req.URL.Path)
 
 	// Check method first
-	if req.Method != http.MethodPut && req.Method != http.MethodGet && req.Method != http.MethodDelete {
+	if req.Method != http.MethodPut && req.Method != http.MethodGet && req.Method != http.MethodHead && req.Method != http.MethodDelete {
 		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
@@ -161,58 +321,207 @@ func (r *Router) handleDocument(w http.ResponseWriter, req *http.Request) {
 	// Extract index name and document ID from path
 	parts := strings.Split(req.URL.Path, "/")
 	indexName := parts[1]
-	docID := parts[3]
+	id := parts[3]
+
+	docID, err := strconv.Atoi(id)
+	if err != nil || docID < 0 {
+		r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("%v: %q", ErrInvalidDocID, id))
+		return
+	}
 
 	switch req.Method {
 	case http.MethodPut:
-		// TODO: Implement document creation/update
-		logger.Info("Creating/updating document: index=%s, id=%s", indexName, docID)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"_index": indexName,
-			"_id":    docID,
-			"result": "created",
-			"status": http.StatusOK,
-		})
+		logger.Info("Creating/updating document: index=%s, id=%s", indexName, id)
+		r.putDocument(w, req, indexName, docID)
 
 	case http.MethodGet:
-		// TODO: Implement document retrieval
-		logger.Info("Retrieving document: index=%s, id=%s", indexName, docID)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
+		logger.Info("Retrieving document: index=%s, id=%s", indexName, id)
+		r.getDocument(w, indexName, docID)
+
+	case http.MethodHead:
+		logger.Info("Checking document exists: index=%s, id=%s", indexName, id)
+		r.headDocument(w, indexName, docID)
+
+	case http.MethodDelete:
+		logger.Info("Deleting document: index=%s, id=%s", indexName, id)
+		r.deleteDocument(w, indexName, docID)
+	}
+}
+
+// putDocument stores the request body as the document with the given ID,
+// creating the index if needed. It responds 201 for a new document and 200
+// when an existing one was replaced.
+func (r *Router) putDocument(w http.ResponseWriter, req *http.Request, indexName string, docID int) {
+	var source map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&source); err != nil {
+		r.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	live, err := r.writeIndex(indexName)
+	if err != nil {
+		r.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer live.release()
+
+	doc := document.NewDocument()
+	for field, value := range source {
+		if err := doc.AddField(field, value); err != nil {
+			r.errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	created, err := live.idx.PutDocument(docID, doc)
+	if err != nil {
+		r.errorResponse(w, writeErrorStatus(err), err.Error())
+		return
+	}
+
+	result, status := "updated", http.StatusOK
+	if created {
+		result, status = "created", http.StatusCreated
+	}
+	resp := documentResponse(live, indexName, docID)
+	resp["result"] = result
+	resp["status"] = status
+	writeJSON(w, status, resp)
+}
+
+// getDocument responds with the source of a document, or 404 when the index
+// or document doesn't exist
+func (r *Router) getDocument(w http.ResponseWriter, indexName string, docID int) {
+	var doc *document.Document
+	live := r.lookupIndex(indexName)
+	if live != nil {
+		defer live.release()
+		doc, _ = live.idx.GetDocument(docID)
+	}
+	if doc == nil {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
 			"_index": indexName,
-			"_id":    docID,
-			"found":  true,
-			"status": http.StatusOK,
+			"_id":    strconv.Itoa(docID),
+			"found":  false,
+			"status": http.StatusNotFound,
 		})
+		return
+	}
 
-	case http.MethodDelete:
-		// TODO: Implement document deletion
-		logger.Info("Deleting document: index=%s, id=%s", indexName, docID)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
+	resp := documentResponse(live, indexName, docID)
+	resp["found"] = true
+	resp["_source"] = doc
+	resp["status"] = http.StatusOK
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// headDocument responds 200 when a document exists and 404 when the index or
+// document doesn't, with no body either way
+func (r *Router) headDocument(w http.ResponseWriter, indexName string, docID int) {
+	status := http.StatusNotFound
+	if live := r.lookupIndex(indexName); live != nil {
+		defer live.release()
+		if doc, _ := live.idx.GetDocument(docID); doc != nil {
+			status = http.StatusOK
+		}
+	}
+	w.WriteHeader(status)
+}
+
+// deleteDocument removes a document, responding with result "not_found" and
+// 404 when the index or document doesn't exist
+func (r *Router) deleteDocument(w http.ResponseWriter, indexName string, docID int) {
+	live := r.lookupIndex(indexName)
+	found := false
+	if live != nil {
+		defer live.release()
+		_, err := live.idx.GetDocument(docID)
+		found = err == nil
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{
 			"_index": indexName,
-			"_id":    docID,
-			"result": "deleted",
-			"status": http.StatusOK,
+			"_id":    strconv.Itoa(docID),
+			"result": "not_found",
+			"status": http.StatusNotFound,
 		})
+		return
 	}
+
+	if err := live.idx.DeleteDocument(docID); err != nil {
+		r.errorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"_index": indexName,
+		"_id":    strconv.Itoa(docID),
+		"result": "deleted",
+		"status": http.StatusOK,
+	})
+}
+
+// documentResponse builds the metadata of a document API response, including
+// the document's version when it exists
+func documentResponse(live *liveIndex, indexName string, docID int) map[string]interface{} {
+	resp := map[string]interface{}{
+		"_index": indexName,
+		"_id":    strconv.Itoa(docID),
+	}
+	if version, ok := live.idx.GetDocumentVersion(docID); ok {
+		resp["_version"] = version.Version
+		resp["_seq_no"] = version.SeqNo
+	}
+	return resp
 }
 
 func (r *Router) handleSearch(w http.ResponseWriter, req *http.Request) {
 	// Only allow GET and POST methods
 	if req.Method != http.MethodGet && req.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
 		return
 	}
 
+	startTime := time.Now()
 	var queryMapObj map[string]interface{}
+	var collapseField string
+	var sortSpecs []search.SortSpec
+	var responseOpts search.ResponseOptions
+	var queryOpts search.QueryOptions
+	var highlightOpts *search.HighlightOptions
+	var aggs map[string]search.Aggregation
+	var trackTotalHits interface{}
+	var indicesBoost map[string]float64
+	size := -1
 	var err error
 
 	if req.Method == http.MethodGet {
-		// For GET requests without a query parameter, use match_all query
+		if raw := req.URL.Query().Get("terminate_after"); raw != "" {
+			queryOpts.TerminateAfter, err = strconv.Atoi(raw)
+			if err != nil {
+				r.errorResponse(w, http.StatusBadRequest, "terminate_after must be an integer")
+				return
+			}
+		}
+		if raw := req.URL.Query().Get("size"); raw != "" {
+			size, err = strconv.Atoi(raw)
+			if err != nil || size < 0 {
+				r.errorResponse(w, http.StatusBadRequest, "size must be a non-negative integer")
+				return
+			}
+		}
+		if raw := req.URL.Query().Get("track_total_hits"); raw != "" {
+			if track, err := strconv.ParseBool(raw); err == nil {
+				trackTotalHits = track
+			} else if track, err := strconv.ParseFloat(raw, 64); err == nil {
+				trackTotalHits = track
+			} else {
+				trackTotalHits = raw
+			}
+		}
+
+		// For GET requests without a (non-blank) query parameter, use match_all query
 		queryStr := req.URL.Query().Get("q")
-		if queryStr == "" {
+		if strings.TrimSpace(queryStr) == "" {
 			queryMapObj = map[string]interface{}{
 				"match_all": map[string]interface{}{},
 			}
@@ -228,26 +537,107 @@ func (r *Router) handleSearch(w http.ResponseWriter, req *http.Request) {
 		// Parse query from request body for POST
 		body, err := io.ReadAll(req.Body)
 		if err != nil {
-			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			r.errorResponse(w, http.StatusBadRequest, "failed to read request body")
 			return
 		}
 		defer req.Body.Close()
 
 		var searchRequest struct {
-			Query map[string]interface{} `json:"query"`
+			Query    map[string]interface{} `json:"query"`
+			Collapse *struct {
+				Field string `json:"field"`
+			} `json:"collapse"`
+			Sort           interface{} `json:"sort"`
+			Source         interface{} `json:"_source"`
+			Highlight      interface{} `json:"highlight"`
+			Aggs           interface{} `json:"aggs"`
+			Aggregations   interface{} `json:"aggregations"`
+			Version        bool        `json:"version"`
+			TerminateAfter int         `json:"terminate_after"`
+			Size           *int        `json:"size"`
+			TrackTotalHits interface{} `json:"track_total_hits"`
+			MinScore       float64     `json:"min_score"`
+			IndicesBoost   interface{} `json:"indices_boost"`
 		}
 
 		if err := json.Unmarshal(body, &searchRequest); err != nil {
-			http.Error(w, "Invalid JSON in request body", http.StatusBadRequest)
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid JSON in request body: %v", err))
 			return
 		}
 
 		if searchRequest.Query == nil {
-			http.Error(w, "Query object is required", http.StatusBadRequest)
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", "query object is required")
 			return
 		}
 
 		queryMapObj = searchRequest.Query
+		if searchRequest.Collapse != nil {
+			collapseField = searchRequest.Collapse.Field
+		}
+		if searchRequest.Sort != nil {
+			sortSpecs, err = parseSort(searchRequest.Sort)
+			if err != nil {
+				r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid sort: %v", err))
+				return
+			}
+		}
+		if searchRequest.Source != nil {
+			responseOpts.Source, err = parseSourceFilter(searchRequest.Source)
+			if err != nil {
+				r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid _source: %v", err))
+				return
+			}
+		}
+		if searchRequest.Highlight != nil {
+			highlightOpts, err = parseHighlight(searchRequest.Highlight)
+			if err != nil {
+				r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid highlight: %v", err))
+				return
+			}
+		}
+		if searchRequest.Aggs == nil {
+			searchRequest.Aggs = searchRequest.Aggregations
+		}
+		if searchRequest.Aggs != nil {
+			aggs, err = parseAggregations(searchRequest.Aggs)
+			if err != nil {
+				r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid aggs: %v", err))
+				return
+			}
+		}
+		responseOpts.Version = searchRequest.Version
+		queryOpts.TerminateAfter = searchRequest.TerminateAfter
+		if searchRequest.Size != nil {
+			size = *searchRequest.Size
+			if size < 0 {
+				r.errorResponse(w, http.StatusBadRequest, "size must be a non-negative integer")
+				return
+			}
+		}
+		trackTotalHits = searchRequest.TrackTotalHits
+		if searchRequest.MinScore < 0 {
+			r.errorResponse(w, http.StatusBadRequest, "min_score must not be negative")
+			return
+		}
+		queryOpts.MinScore = searchRequest.MinScore
+		indicesBoost, err = search.ParseIndicesBoost(searchRequest.IndicesBoost)
+		if err != nil {
+			r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("invalid indices_boost: %v", err))
+			return
+		}
+	}
+
+	if trackTotalHits != nil {
+		queryOpts.TrackTotalHits, err = parseTrackTotalHits(trackTotalHits, size)
+		if err != nil {
+			r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("invalid track_total_hits: %v", err))
+			return
+		}
+		// Sorting, collapsing and aggregating need every match, otherwise
+		// only the best size hits are held
+		if len(sortSpecs) == 0 && collapseField == "" && len(aggs) == 0 {
+			queryOpts.Size = size
+		}
 	}
 
 	// Initialize query mapper
@@ -259,7 +649,8 @@ func (r *Router) handleSearch(w http.ResponseWriter, req *http.Request) {
 	// If the query is a direct match/term/range/bool query
 	if queryType, ok := getQueryType(queryMapObj); ok {
 		switch queryType {
-		case "match", "term", "match_phrase", "match_all", "range", "bool":
+		case "match", "term", "terms", "match_phrase", "match_all", "range", "bool", "exists",
+			"prefix", "wildcard", "fuzzy", "query_string", "simple_query_string", "constant_score", "ids":
 			// For match queries, ensure proper structure
 			if queryType == "match" {
 				if fieldMap, ok := queryMapObj[queryType].(map[string]interface{}); ok {
@@ -285,20 +676,353 @@ processQuery:
 	// Pass the query object to the mapper
 	queryObj, err := queryMapper.MapQuery(queryWrapper)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to map query: %v", err), http.StatusBadRequest)
+		r.exceptionResponse(w, http.StatusBadRequest, "parsing_exception", fmt.Sprintf("failed to map query: %v", err))
+		return
+	}
+
+	if queryOpts.TerminateAfter < 0 {
+		r.errorResponse(w, http.StatusBadRequest, "terminate_after must not be negative")
+		return
+	}
+
+	// Searches over several indices merge their hits, reporting the shards of
+	// every index
+	if names, ok := r.multiIndexNames(req); ok {
+		if len(aggs) > 0 {
+			r.exceptionResponse(w, http.StatusBadRequest, "illegal_argument_exception", "aggregations are not supported across multiple indices")
+			return
+		}
+		targets := make(map[string]*search.Search, len(names))
+		for _, name := range names {
+			live := r.lookupIndex(name)
+			if live == nil {
+				r.indexNotFound(w, name)
+				return
+			}
+			defer live.release()
+			targets[name] = live.search
+		}
+
+		results, err := search.SearchIndices(targets, queryObj, indicesBoost, queryOpts)
+		if err != nil {
+			r.exceptionResponse(w, http.StatusInternalServerError, "search_phase_execution_exception", fmt.Sprintf("failed to execute search: %v", err))
+			return
+		}
+		results.SortBy(sortSpecs)
+		results.Collapse(collapseField)
+		results.Limit(size)
+		if highlightOpts != nil {
+			search.HighlightIndices(targets, results, queryObj, *highlightOpts)
+		}
+		writeJSON(w, http.StatusOK, search.FormatESResponseWithOptions(results, time.Since(startTime), "", responseOpts))
 		return
 	}
 
+	indexName := pathIndexName(req)
+	live := r.lookupIndex(indexName)
+	if live == nil {
+		r.indexNotFound(w, indexName)
+		return
+	}
+	defer live.release()
+
 	// Execute the query
-	results, err := r.search.SearchWithQuery(queryObj)
+	results, err := live.search.SearchWithQueryOptions(queryObj, queryOpts)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to execute search: %v", err), http.StatusInternalServerError)
+		r.exceptionResponse(w, http.StatusInternalServerError, "search_phase_execution_exception", fmt.Sprintf("failed to execute search: %v", err))
 		return
 	}
 
+	// Aggregations cover every matching document, before collapsing
+	var aggResults map[string]interface{}
+	if len(aggs) > 0 {
+		aggResults, err = live.search.NewAggregator().Aggregate(results, aggs)
+		if err != nil {
+			errType := "illegal_argument_exception"
+			var typed interface{ Type() string }
+			if errors.As(err, &typed) {
+				errType = typed.Type()
+			}
+			r.exceptionResponse(w, http.StatusBadRequest, errType, fmt.Sprintf("failed to compute aggregations: %v", err))
+			return
+		}
+	}
+
+	// Order hits by the requested sort keys, then keep only the first hit per
+	// collapse value
+	results.SortBy(sortSpecs)
+	results.Collapse(collapseField)
+	results.Limit(size)
+	if highlightOpts != nil {
+		live.search.Highlight(results, queryObj, *highlightOpts)
+	}
+
 	// Return results
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	resp := search.FormatESResponseWithOptions(results, time.Since(startTime), indexName, responseOpts)
+	resp.Aggregations = aggResults
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parseSort parses a search request's sort clause. It accepts a field name, an
+// object such as {"age": "desc"} or {"age": {"order": "desc"}}, or an array of
+// these. Fields sort ascending by default and _score descending.
+func parseSort(raw interface{}) ([]search.SortSpec, error) {
+	items, ok := raw.([]interface{})
+	if !ok {
+		items = []interface{}{raw}
+	}
+
+	specs := make([]search.SortSpec, 0, len(items))
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			specs = append(specs, search.SortSpec{Field: v, Descending: v == "_score"})
+		case map[string]interface{}:
+			if len(v) != 1 {
+				return nil, fmt.Errorf("sort object must name exactly one field")
+			}
+			for field, value := range v {
+				order, ok := value.(string)
+				if options, isMap := value.(map[string]interface{}); isMap {
+					order, ok = options["order"].(string)
+				}
+				if !ok {
+					return nil, fmt.Errorf("sort order for %s must be \"asc\" or \"desc\"", field)
+				}
+				switch strings.ToLower(order) {
+				case "asc":
+					specs = append(specs, search.SortSpec{Field: field})
+				case "desc":
+					specs = append(specs, search.SortSpec{Field: field, Descending: true})
+				default:
+					return nil, fmt.Errorf("invalid sort order %q for %s, expected \"asc\" or \"desc\"", order, field)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("sort must be a field name or object, got %T", item)
+		}
+	}
+	return specs, nil
+}
+
+// parseTrackTotalHits parses a search request's track_total_hits into the
+// number of matches counted exactly. true counts every match, false counts
+// only up to size, and a number counts up to that many. Every match is still
+// scored, so the hits held are the best ones; only the total is capped.
+func parseTrackTotalHits(raw interface{}, size int) (int, error) {
+	switch v := raw.(type) {
+	case bool:
+		if v || size < 0 {
+			return 0, nil
+		}
+		// With no hits to return, one match is enough to report that some exist
+		if size == 0 {
+			return 1, nil
+		}
+		return size, nil
+	case float64:
+		if v < 1 || v != float64(int(v)) {
+			return 0, fmt.Errorf("must be a positive integer, got %v", v)
+		}
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("must be a boolean or integer, got %v", raw)
+}
+
+// parseSourceFilter parses a search request's _source clause: true or false,
+// a field name or array of field names to include, or an object with includes
+// and excludes lists
+func parseSourceFilter(raw interface{}) (search.SourceFilter, error) {
+	switch v := raw.(type) {
+	case bool:
+		return search.SourceFilter{Disabled: !v}, nil
+	case string, []interface{}:
+		includes, err := parseSourceFields(v)
+		return search.SourceFilter{Includes: includes}, err
+	case map[string]interface{}:
+		var filter search.SourceFilter
+		for key, value := range v {
+			fields, err := parseSourceFields(value)
+			if err != nil {
+				return search.SourceFilter{}, fmt.Errorf("%s: %v", key, err)
+			}
+			switch key {
+			case "includes", "include":
+				filter.Includes = append(filter.Includes, fields...)
+			case "excludes", "exclude":
+				filter.Excludes = append(filter.Excludes, fields...)
+			default:
+				return search.SourceFilter{}, fmt.Errorf("unsupported _source option: %s", key)
+			}
+		}
+		return filter, nil
+	}
+	return search.SourceFilter{}, fmt.Errorf("_source must be a boolean, string, array or object, got %T", raw)
+}
+
+// defaultFragmentSize is the highlight fragment length used when a request
+// doesn't set fragment_size
+const defaultFragmentSize = 100
+
+// parseHighlight parses a search request's highlight clause. Fields are given
+// as an object keyed by field name, as in {"fields": {"title": {}}}, or as an
+// array of names. pre_tags and post_tags take a tag or an array whose first
+// entry is used, and a fragment_size of 0 highlights whole field values.
+func parseHighlight(raw interface{}) (*search.HighlightOptions, error) {
+	clause, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("highlight must be an object, got %T", raw)
+	}
+
+	opts := &search.HighlightOptions{FragmentSize: defaultFragmentSize}
+	for key, value := range clause {
+		switch key {
+		case "fields":
+			switch fields := value.(type) {
+			case map[string]interface{}:
+				for field := range fields {
+					opts.Fields = append(opts.Fields, field)
+				}
+			default:
+				names, err := parseSourceFields(fields)
+				if err != nil {
+					return nil, fmt.Errorf("fields: %v", err)
+				}
+				opts.Fields = names
+			}
+		case "pre_tags", "post_tags":
+			tags, err := parseSourceFields(value)
+			if err != nil || len(tags) == 0 {
+				return nil, fmt.Errorf("%s must be a tag or array of tags", key)
+			}
+			if key == "pre_tags" {
+				opts.PreTag = tags[0]
+			} else {
+				opts.PostTag = tags[0]
+			}
+		case "fragment_size":
+			size, ok := value.(float64)
+			if !ok || size < 0 || size != float64(int(size)) {
+				return nil, fmt.Errorf("fragment_size must be a non-negative integer")
+			}
+			opts.FragmentSize = int(size)
+		default:
+			return nil, fmt.Errorf("unsupported highlight option: %s", key)
+		}
+	}
+
+	if len(opts.Fields) == 0 {
+		return nil, fmt.Errorf("highlight requires at least one field")
+	}
+	return opts, nil
+}
+
+// parseAggregations parses a search request's aggs clause, an object mapping
+// each aggregation name to an object with a single aggregation type such as
+// {"by_status": {"terms": {"field": "status", "size": 5}}}
+func parseAggregations(raw interface{}) (map[string]search.Aggregation, error) {
+	clause, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("aggs must be an object, got %T", raw)
+	}
+
+	aggs := make(map[string]search.Aggregation, len(clause))
+	for name, value := range clause {
+		definition, ok := value.(map[string]interface{})
+		if !ok || len(definition) != 1 {
+			return nil, fmt.Errorf("aggregation %s must be an object with exactly one aggregation type", name)
+		}
+		for aggType, body := range definition {
+			params, ok := body.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("aggregation %s: %s must be an object", name, aggType)
+			}
+			agg, err := parseAggregation(aggType, params)
+			if err != nil {
+				return nil, fmt.Errorf("aggregation %s: %v", name, err)
+			}
+			aggs[name] = agg
+		}
+	}
+	return aggs, nil
+}
+
+// parseAggregation parses the parameters of one aggregation of the given type
+func parseAggregation(aggType string, params map[string]interface{}) (search.Aggregation, error) {
+	field, ok := params["field"].(string)
+	if !ok || field == "" {
+		return nil, fmt.Errorf("%s requires a field", aggType)
+	}
+
+	switch aggType {
+	case "terms":
+		agg := search.TermsAggregation{Field: field}
+		for key, value := range params {
+			switch key {
+			case "field":
+			case "size":
+				size, ok := value.(float64)
+				if !ok || size < 1 || size != float64(int(size)) {
+					return nil, fmt.Errorf("size must be a positive integer")
+				}
+				agg.Size = int(size)
+			default:
+				return nil, fmt.Errorf("unsupported terms option: %s", key)
+			}
+		}
+		return agg, nil
+	case search.MetricAvg, search.MetricSum, search.MetricMin, search.MetricMax:
+		for key := range params {
+			if key != "field" {
+				return nil, fmt.Errorf("unsupported %s option: %s", aggType, key)
+			}
+		}
+		return search.MetricAggregation{Metric: aggType, Field: field}, nil
+	case "date_histogram":
+		agg := search.DateHistogramAggregation{Field: field}
+		for key, value := range params {
+			var err error
+			switch key {
+			case "field":
+			case "calendar_interval":
+				interval, _ := value.(string)
+				agg.CalendarInterval, err = search.ParseCalendarInterval(interval)
+			case "fixed_interval":
+				interval, _ := value.(string)
+				agg.FixedInterval, err = search.ParseFixedInterval(interval)
+			default:
+				err = fmt.Errorf("unsupported date_histogram option: %s", key)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if (agg.CalendarInterval == "") == (agg.FixedInterval == 0) {
+			return nil, fmt.Errorf("date_histogram requires exactly one of calendar_interval or fixed_interval")
+		}
+		return agg, nil
+	}
+	return nil, fmt.Errorf("unsupported aggregation type: %s", aggType)
+}
+
+// parseSourceFields parses a field name or an array of field names
+func parseSourceFields(raw interface{}) ([]string, error) {
+	if field, ok := raw.(string); ok {
+		return []string{field}, nil
+	}
+	items, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a field name or array of field names, got %T", raw)
+	}
+	fields := make([]string, 0, len(items))
+	for _, item := range items {
+		field, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("field names must be strings, got %T", item)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
 }
 
 func getQueryType(query map[string]interface{}) (string, bool) {
@@ -308,23 +1032,18 @@ func getQueryType(query map[string]interface{}) (string, bool) {
 	return "", false
 }
 
-func (r *Router) handleMultiSearch(w http.ResponseWriter, req *http.Request) {
-	// TODO: Implement multi-search
-	http.Error(w, "Not implemented", http.StatusNotImplemented)
-}
-
 func (r *Router) handleListIndices(w http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodGet {
 		r.errorResponse(w, http.StatusMethodNotAllowed, "only GET method is allowed")
 		return
 	}
 	// TODO: Implement list indices
-	http.Error(w, "Not implemented", http.StatusNotImplemented)
+	r.errorResponse(w, http.StatusNotImplemented, "not implemented")
 }
 
 func (r *Router) handleScroll(w http.ResponseWriter, req *http.Request) {
 	// TODO: Implement scroll API
-	http.Error(w, "Not implemented", http.StatusNotImplemented)
+	r.errorResponse(w, http.StatusNotImplemented, "not implemented")
 }
 
 func (r *Router) handleIndex(w http.ResponseWriter, req *http.Request) {
@@ -340,24 +1059,38 @@ func (r *Router) handleIndex(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// Extract index name and document ID from URL path
-	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
-	if len(parts) < 1 {
-		r.errorResponse(w, http.StatusBadRequest, "invalid index path")
+	// Write to the index named by the path, creating it if needed
+	indexName := pathIndexName(req)
+	live, err := r.writeIndex(indexName)
+	if err != nil {
+		r.errorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
+	defer live.release()
 
-	indexName := parts[0]
-	docID := ""
-	if len(parts) > 1 {
-		docID = parts[1]
+	// In async mode, queue the write and acknowledge it immediately
+	if live.queue != nil {
+		if err := live.queue.Enqueue(indexName, "", doc); err != nil {
+			if err == index.ErrQueueFull {
+				r.errorResponse(w, http.StatusTooManyRequests, err.Error())
+				return
+			}
+			r.errorResponse(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"_index": indexName,
+			"result": "queued",
+			"status": http.StatusAccepted,
+		})
+		return
 	}
 
 	// Index the document
 	startTime := time.Now()
-	err := r.index.IndexDocument(indexName, docID, doc)
-	if err != nil {
-		r.errorResponse(w, http.StatusInternalServerError, err.Error())
+	if err := live.idx.IndexDocument(indexName, "", doc); err != nil {
+		r.errorResponse(w, writeErrorStatus(err), err.Error())
 		return
 	}
 
@@ -370,8 +1103,8 @@ func (r *Router) handleIndex(w http.ResponseWriter, req *http.Request) {
 			Successful int `json:"successful"`
 			Failed     int `json:"failed"`
 		}{
-			Total:      1,
-			Successful: 1,
+			Total:      live.search.ShardCount(),
+			Successful: live.search.ShardCount(),
 			Failed:     0,
 		},
 		Result: "created",
@@ -379,7 +1112,51 @@ func (r *Router) handleIndex(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(resp)
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// handleRefresh waits for queued asynchronous writes to be applied
+func (r *Router) handleRefresh(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodGet {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Refresh the index named by the path, or every index for /_refresh
+	var served []*liveIndex
+	if indexName := pathIndexName(req); indexName != defaultIndexName {
+		live := r.lookupIndex(indexName)
+		if live == nil {
+			r.indexNotFound(w, indexName)
+			return
+		}
+		served = []*liveIndex{live}
+	} else {
+		served = r.servedIndices()
+	}
+	defer func() {
+		for _, live := range served {
+			live.release()
+		}
+	}()
+
+	total, failed := 0, 0
+	for _, live := range served {
+		shards := live.search.ShardCount()
+		total += shards
+		if live.queue != nil {
+			if err := live.queue.Refresh(); err != nil {
+				logger.Error("Refresh found failed writes: %v", err)
+				failed += shards
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"_shards": map[string]int{
+			"total":      total,
+			"successful": total - failed,
+			"failed":     failed,
+		},
+	})
 }
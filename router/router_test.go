@@ -1,13 +1,38 @@
 package router
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"my-indexer/analysis"
+	"my-indexer/document"
+	"my-indexer/index"
+	"my-indexer/search"
 )
 
+// namedIndex returns the router's index with the given name, creating it if
+// needed
+func namedIndex(t *testing.T, router *Router, name string) *index.Index {
+	t.Helper()
+	live, err := router.writeIndex(name)
+	if err != nil {
+		t.Fatalf("failed to create index %s: %v", name, err)
+	}
+	defer live.release()
+	return live.idx
+}
+
 func TestValidateDocumentRequest(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -105,7 +130,7 @@ func TestDocumentEndpoint(t *testing.T) {
 			method:         http.MethodPut,
 			path:          "/test-index/_doc/1",
 			body:          `{"field": "value"}`,
-			expectedStatus: http.StatusOK,
+			expectedStatus: http.StatusCreated,
 		},
 		{
 			name:           "Valid GET request",
@@ -121,6 +146,34 @@ func TestDocumentEndpoint(t *testing.T) {
 			body:          "",
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "GET deleted document",
+			method:         http.MethodGet,
+			path:          "/test-index/_doc/1",
+			body:          "",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "DELETE missing document",
+			method:         http.MethodDelete,
+			path:          "/test-index/_doc/1",
+			body:          "",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "GET from missing index",
+			method:         http.MethodGet,
+			path:          "/missing-index/_doc/1",
+			body:          "",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Non-numeric document ID",
+			method:         http.MethodGet,
+			path:          "/test-index/_doc/abc",
+			body:          "",
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "Invalid method",
 			method:         http.MethodPost,
@@ -150,6 +203,53 @@ func TestDocumentEndpoint(t *testing.T) {
 	}
 }
 
+func TestDocumentRoundTrip(t *testing.T) {
+	router := NewRouter()
+
+	serve := func(method, body string) map[string]interface{} {
+		req := httptest.NewRequest(method, "/test-index/_doc/5", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode %s response: %v", method, err)
+		}
+		if status, _ := resp["status"].(float64); int(status) != w.Code {
+			t.Errorf("%s: body status %v does not match response code %d", method, resp["status"], w.Code)
+		}
+		return resp
+	}
+
+	if resp := serve(http.MethodPut, `{"title": "first draft", "pages": 3}`); resp["result"] != "created" {
+		t.Fatalf("expected result created, got %v", resp)
+	}
+	resp := serve(http.MethodGet, "")
+	source, _ := resp["_source"].(map[string]interface{})
+	if resp["found"] != true || resp["_id"] != "5" || source["title"] != "first draft" || source["pages"] != float64(3) {
+		t.Fatalf("expected the stored document back, got %v", resp)
+	}
+
+	if resp := serve(http.MethodPut, `{"title": "second draft"}`); resp["result"] != "updated" || resp["_version"] != float64(2) {
+		t.Fatalf("expected result updated at version 2, got %v", resp)
+	}
+	resp = serve(http.MethodGet, "")
+	source, _ = resp["_source"].(map[string]interface{})
+	if source["title"] != "second draft" || source["pages"] != nil {
+		t.Errorf("expected the replaced document back, got %v", resp)
+	}
+
+	if resp := serve(http.MethodDelete, ""); resp["result"] != "deleted" {
+		t.Errorf("expected result deleted, got %v", resp)
+	}
+	if resp := serve(http.MethodDelete, ""); resp["result"] != "not_found" {
+		t.Errorf("expected result not_found, got %v", resp)
+	}
+	if resp := serve(http.MethodGet, ""); resp["found"] != false {
+		t.Errorf("expected found false after delete, got %v", resp)
+	}
+}
+
 func TestBulkEndpoint(t *testing.T) {
 	router := NewRouter()
 
@@ -198,14 +298,305 @@ func TestBulkEndpoint(t *testing.T) {
 	}
 }
 
+func TestBulkInterleavedDeletes(t *testing.T) {
+	router := NewRouter()
+
+	body := `{"index": {"_index": "test"}}
+{"field1": "value1"}
+{"delete": {"_index": "test", "_id": "0"}}
+{"index": {"_index": "test"}}
+{"field1": "value2"}
+{"delete": {"_index": "test", "_id": "42"}}
+{"index": {"_index": "test"}}
+{"field1": "value3"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/test/_bulk", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	expected := []struct {
+		action string
+		status float64
+	}{
+		{"index", http.StatusCreated},
+		{"delete", http.StatusOK},
+		{"index", http.StatusCreated},
+		{"delete", http.StatusNotFound}, // document 42 does not exist
+		{"index", http.StatusCreated},
+	}
+	if len(resp.Items) != len(expected) {
+		t.Fatalf("expected %d items but got %d", len(expected), len(resp.Items))
+	}
+	for i, exp := range expected {
+		item, ok := resp.Items[i][exp.action]
+		if !ok {
+			t.Errorf("item %d: expected %s action, got %v", i, exp.action, resp.Items[i])
+			continue
+		}
+		if item["status"] != exp.status {
+			t.Errorf("item %d: expected status %v but got %v", i, exp.status, item["status"])
+		}
+	}
+	if !resp.Errors {
+		t.Error("expected errors to be reported for the missing document")
+	}
+
+	if count := namedIndex(t, router, "test").GetDocumentCount(); count != 2 {
+		t.Errorf("expected 2 documents after bulk, got %d", count)
+	}
+}
+
+func TestBulkItemShape(t *testing.T) {
+	router := NewRouter()
+
+	body := `{"index": {"_index": "test", "_id": "7"}}
+{"title": "first"}
+{"index": {"_index": "test", "_id": "7"}}
+{"title": "second"}
+{"index": {"_index": "test", "_id": "abc"}}
+{"title": "bad id"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/test/_bulk", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Items) != 3 {
+		t.Fatalf("expected 3 items but got %d", len(resp.Items))
+	}
+
+	expected := []map[string]interface{}{
+		{"_index": "test", "_id": "7", "_version": float64(1), "result": "created", "status": float64(http.StatusCreated)},
+		{"_index": "test", "_id": "7", "_version": float64(2), "result": "updated", "status": float64(http.StatusOK)},
+	}
+	for i, exp := range expected {
+		item := resp.Items[i]["index"]
+		for key, value := range exp {
+			if item[key] != value {
+				t.Errorf("item %d: expected %s=%v but got %v", i, key, value, item[key])
+			}
+		}
+	}
+
+	failed := resp.Items[2]["index"]
+	if failed["status"] != float64(http.StatusBadRequest) || failed["error"] == nil {
+		t.Errorf("expected a 400 error item for a non-numeric _id, got %v", failed)
+	}
+	if !resp.Errors {
+		t.Error("expected errors to be true when an item fails")
+	}
+
+	doc, err := namedIndex(t, router, "test").GetDocument(7)
+	if err != nil {
+		t.Fatalf("expected document stored under the client-supplied _id: %v", err)
+	}
+	if field, _ := doc.GetField("title"); field.Value != "second" {
+		t.Errorf("expected the second index action to replace the document, got %v", field.Value)
+	}
+}
+
+func TestBulkPartialFailures(t *testing.T) {
+	router := NewRouter()
+
+	body := `{"index": {"_id": "1"}}
+{"title": "kept"}
+{"create": {"_id": "1"}}
+{"title": "conflicting"}
+{"index": {"_id": "2"}}
+["not", "an", "object"]
+{"update": {"_id": "9"}}
+{"doc": {"title": "patched"}}
+{"create": {"_id": "3"}}
+{"title": "also kept"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/test/_bulk", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Took   *int                                `json:"took"`
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Took == nil {
+		t.Error("expected took to be reported")
+	}
+	if !resp.Errors {
+		t.Error("expected errors to be true when an item fails")
+	}
+
+	expected := []struct {
+		action    string
+		id        string
+		status    float64
+		errorType string
+	}{
+		{"index", "1", http.StatusCreated, ""},
+		{"create", "1", http.StatusConflict, "version_conflict_engine_exception"},
+		{"index", "2", http.StatusBadRequest, "mapper_parsing_exception"},
+		{"update", "9", http.StatusNotFound, "document_missing_exception"},
+		{"create", "3", http.StatusCreated, ""},
+	}
+	if len(resp.Items) != len(expected) {
+		t.Fatalf("expected %d items but got %d", len(expected), len(resp.Items))
+	}
+	for i, exp := range expected {
+		item, ok := resp.Items[i][exp.action]
+		if !ok {
+			t.Errorf("item %d: expected %s action, got %v", i, exp.action, resp.Items[i])
+			continue
+		}
+		if item["_index"] != "test" || item["_id"] != exp.id || item["status"] != exp.status {
+			t.Errorf("item %d: expected test/%s with status %v, got %v", i, exp.id, exp.status, item)
+		}
+		errObj, _ := item["error"].(map[string]interface{})
+		if exp.errorType == "" && errObj != nil {
+			t.Errorf("item %d: expected no error, got %v", i, errObj)
+		}
+		if exp.errorType != "" && (errObj == nil || errObj["type"] != exp.errorType || errObj["reason"] == "") {
+			t.Errorf("item %d: expected a %s error, got %v", i, exp.errorType, item["error"])
+		}
+	}
+
+	idx := namedIndex(t, router, "test")
+	if count := idx.GetDocumentCount(); count != 2 {
+		t.Errorf("expected 2 documents after bulk, got %d", count)
+	}
+	if doc, err := idx.GetDocument(1); err != nil {
+		t.Errorf("expected document 1 to be stored: %v", err)
+	} else if field, _ := doc.GetField("title"); field.Value != "kept" {
+		t.Errorf("expected the failed create to leave document 1 alone, got %v", field.Value)
+	}
+}
+
+func TestPartialUpdate(t *testing.T) {
+	router := NewRouter()
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	source := func(id string) map[string]interface{} {
+		w := serve(http.MethodGet, "/test-index/_doc/"+id, "", "")
+		var resp struct {
+			Source map[string]interface{} `json:"_source"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp.Source
+	}
+
+	for _, id := range []string{"1", "2"} {
+		if w := serve(http.MethodPut, "/test-index/_doc/"+id, "", `{"title": "draft", "author": "alice", "pages": 10}`); w.Code != http.StatusCreated {
+			t.Fatalf("failed to set up test data: %d", w.Code)
+		}
+	}
+
+	w := serve(http.MethodPost, "/test-index/_update/1", "", `{"doc": {"title": "published"}}`)
+	var resp map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if w.Code != http.StatusOK || resp["result"] != "updated" || resp["_version"] != float64(2) {
+		t.Fatalf("expected an updated result at version 2, got %d %v", w.Code, resp)
+	}
+	want := map[string]interface{}{"title": "published", "author": "alice", "pages": float64(10)}
+	if got := source("1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after the update, got %v", want, got)
+	}
+
+	// The update is searchable under its new value only
+	w = serve(http.MethodPost, "/test-index/_search", "", `{"query": {"match": {"title": "published"}}}`)
+	var searchResp search.ESResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &searchResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(searchResp.Hits.Hits) != 1 || searchResp.Hits.Hits[0].ID != "1" {
+		t.Errorf("expected document 1 to match the updated title, got %v", searchResp.Hits.Hits)
+	}
+
+	// Repeating the update changes nothing
+	w = serve(http.MethodPost, "/test-index/_update/1", "", `{"doc": {"title": "published"}}`)
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["result"] != "noop" {
+		t.Errorf("expected a noop result, got %v", resp)
+	}
+
+	// Bulk update actions merge the same way
+	bulk := `{"update": {"_id": "2"}}
+{"doc": {"pages": 12}}
+`
+	if w := serve(http.MethodPost, "/test-index/_bulk", "application/x-ndjson", bulk); w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"errors":true`) {
+		t.Fatalf("bulk update failed: %d %s", w.Code, w.Body.String())
+	}
+	want = map[string]interface{}{"title": "draft", "author": "alice", "pages": float64(12)}
+	if got := source("2"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v after the bulk update, got %v", want, got)
+	}
+
+	errorCases := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"Missing document", "/test-index/_update/9", `{"doc": {"title": "x"}}`, http.StatusNotFound},
+		{"Missing index", "/missing/_update/1", `{"doc": {"title": "x"}}`, http.StatusNotFound},
+		{"Missing doc object", "/test-index/_update/1", `{"title": "x"}`, http.StatusBadRequest},
+		{"Invalid ID", "/test-index/_update/abc", `{"doc": {"title": "x"}}`, http.StatusBadRequest},
+	}
+	for _, tt := range errorCases {
+		if w := serve(http.MethodPost, tt.target, "", tt.body); w.Code != tt.status {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.status, w.Code)
+		}
+	}
+}
+
 func TestSearchEndpoint(t *testing.T) {
 	router := NewRouter()
 
 	// Add test data
-	req := httptest.NewRequest(http.MethodPut, "/test-index/_doc/1", strings.NewReader(`{"field": "value"}`))
+	req := httptest.NewRequest(http.MethodPost, "/test-index/_index", strings.NewReader(`{"field": "value"}`))
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
-	if w.Code != http.StatusOK {
+	if w.Code != http.StatusCreated {
 		t.Fatalf("failed to set up test data: %d", w.Code)
 	}
 
@@ -281,6 +672,30 @@ func TestSearchEndpoint(t *testing.T) {
 			body:          `{"query": {"bool": {"must": [{"match": {"field": "value"}}]}}}`,
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name:           "Exists query",
+			method:         http.MethodPost,
+			body:          `{"query": {"exists": {"field": "field"}}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Prefix query",
+			method:         http.MethodPost,
+			body:          `{"query": {"prefix": {"field": "val"}}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Query string query",
+			method:         http.MethodPost,
+			body:          `{"query": {"query_string": {"query": "field:value"}}}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Exists query without field",
+			method:         http.MethodPost,
+			body:          `{"query": {"exists": {}}}`,
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 
 	for _, tt := range tests {
@@ -298,3 +713,1908 @@ func TestSearchEndpoint(t *testing.T) {
 		})
 	}
 }
+
+func TestEmptyMatchReturnsAllDocuments(t *testing.T) {
+	router := NewRouter()
+
+	for _, title := range []string{"first document", "second document"} {
+		req := httptest.NewRequest(http.MethodPost, "/test-index/_index", strings.NewReader(`{"title": "`+title+`"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("failed to set up test data: %d", w.Code)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"Empty q parameter", http.MethodGet, "/test-index/_search?q=", ""},
+		{"Whitespace q parameter", http.MethodGet, "/test-index/_search?q=%20%20", ""},
+		{"Empty match value", http.MethodPost, "/test-index/_search", `{"query": {"match": {"title": ""}}}`},
+		{"Blank match query object", http.MethodPost, "/test-index/_search", `{"query": {"match": {"title": {"query": " "}}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp search.ESResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(resp.Hits.Hits) != 2 {
+				t.Errorf("expected 2 hits, got %d", len(resp.Hits.Hits))
+			}
+		})
+	}
+}
+
+func TestMatchAllDuringConcurrentWrites(t *testing.T) {
+	router := NewRouter()
+	idx := namedIndex(t, router, "test-index")
+
+	for i := 0; i < 20; i++ {
+		doc := document.NewDocument()
+		doc.AddField("title", fmt.Sprintf("document %d", i))
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("failed to set up test data: %v", err)
+		}
+	}
+
+	// Delete and add documents while searches run
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for docID := 0; ; docID++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			idx.DeleteDocument(docID)
+			doc := document.NewDocument()
+			doc.AddField("title", "replacement")
+			idx.AddDocument(doc)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(`{"query": {"match_all": {}}}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if resp.Hits.Total.Value != len(resp.Hits.Hits) {
+			t.Errorf("total %d does not match %d returned hits", resp.Hits.Total.Value, len(resp.Hits.Hits))
+		}
+		for _, hit := range resp.Hits.Hits {
+			if _, ok := hit.Source["title"]; !ok {
+				t.Errorf("hit %s has no source", hit.ID)
+			}
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+func TestPrettyResponses(t *testing.T) {
+	router := NewRouter()
+
+	tests := []struct {
+		name   string
+		target string
+		pretty bool
+	}{
+		{"Compact by default", "/test-index/_search", false},
+		{"Bare pretty parameter", "/test-index/_search?pretty", true},
+		{"Pretty true", "/test-index/_search?pretty=true", true},
+		{"Pretty false", "/test-index/_search?pretty=false", false},
+		{"Pretty error response", "/_refresh?pretty", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := http.MethodGet
+			if strings.HasPrefix(tt.target, "/_refresh") {
+				method = http.MethodDelete
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			body := strings.TrimSuffix(w.Body.String(), "\n")
+			if !json.Valid([]byte(body)) {
+				t.Fatalf("expected a JSON response, got %q", body)
+			}
+			indented := strings.Contains(body, "\n  \"")
+			if indented != tt.pretty {
+				t.Errorf("expected pretty=%v output, got %q", tt.pretty, body)
+			}
+		})
+	}
+}
+
+func TestNamedIndices(t *testing.T) {
+	router := NewRouter()
+	defer router.Close()
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Indices are created by their first write, through either endpoint
+	if w := serve(http.MethodPost, "/products/_index", "", `{"name": "shared widget"}`); w.Code != http.StatusCreated {
+		t.Fatalf("failed to index into products: %d %s", w.Code, w.Body.String())
+	}
+	bulk := `{"index": {"_index": "users", "_id": "0"}}
+{"name": "shared account"}
+{"index": {}}
+{"name": "another user"}
+`
+	if w := serve(http.MethodPost, "/users/_bulk", "application/x-ndjson", bulk); w.Code != http.StatusOK {
+		t.Fatalf("failed to bulk index into users: %d %s", w.Code, w.Body.String())
+	}
+
+	hitNames := func(target string) []string {
+		w := serve(http.MethodPost, target, "application/json", `{"query": {"term": {"name": "shared"}}}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d from %s but got %d: %s", http.StatusOK, target, w.Code, w.Body.String())
+		}
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var names []string
+		for _, hit := range resp.Hits.Hits {
+			if hit.Index != strings.Split(strings.Trim(target, "/"), "/")[0] {
+				t.Errorf("hit from %s reports index %q", target, hit.Index)
+			}
+			names = append(names, hit.Source["name"].(string))
+		}
+		return names
+	}
+
+	if names := hitNames("/products/_search"); len(names) != 1 || names[0] != "shared widget" {
+		t.Errorf("expected only the products document, got %v", names)
+	}
+	if names := hitNames("/users/_search"); len(names) != 1 || names[0] != "shared account" {
+		t.Errorf("expected only the users document, got %v", names)
+	}
+	if names := router.indexNames(); len(names) != 2 || names[0] != "products" || names[1] != "users" {
+		t.Errorf("expected indices [products users], got %v", names)
+	}
+
+	if w := serve(http.MethodGet, "/missing/_search", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d searching a missing index, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := serve(http.MethodPost, "/Products/_index", "", `{"name": "bad"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an uppercase index name, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestAsyncIndexing(t *testing.T) {
+	router := NewRouter()
+	router.EnableAsyncIndexing(10, 5)
+	defer router.Close()
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/_index", strings.NewReader(`{"title": "queued document"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("expected status %d but got %d", http.StatusAccepted, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/_refresh", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d from refresh but got %d", http.StatusOK, w.Code)
+	}
+
+	if count := router.current().idx.GetDocumentCount(); count != 3 {
+		t.Errorf("expected 3 documents after refresh, got %d", count)
+	}
+}
+
+func TestReloadIndex(t *testing.T) {
+	router := NewRouter()
+	defer router.Close()
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	searchHits := func(q string) int {
+		resp, err := http.Get(server.URL + "/_search?q=" + q)
+		if err != nil {
+			t.Fatalf("search request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		var result search.ESResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return len(result.Hits.Hits)
+	}
+
+	doc := document.NewDocument()
+	doc.AddField("title", "original")
+	if _, err := router.current().idx.AddDocument(doc); err != nil {
+		t.Fatalf("failed to add document: %v", err)
+	}
+	if hits := searchHits("original"); hits != 1 {
+		t.Fatalf("expected 1 hit before reload, got %d", hits)
+	}
+
+	// Build a replacement index out of band and swap it in
+	rebuilt := index.NewIndex(analysis.NewStandardAnalyzer())
+	for _, title := range []string{"rebuilt one", "rebuilt two"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		if _, err := rebuilt.AddDocument(doc); err != nil {
+			t.Fatalf("failed to add document: %v", err)
+		}
+	}
+	router.ReloadIndex(rebuilt)
+
+	if hits := searchHits("original"); hits != 0 {
+		t.Errorf("expected 0 hits for the old index after reload, got %d", hits)
+	}
+	if hits := searchHits("rebuilt"); hits != 2 {
+		t.Errorf("expected 2 hits from the new index after reload, got %d", hits)
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	router := NewRouter()
+	defer router.Close()
+
+	titles := []string{"first", "second", "third"}
+	for _, title := range titles {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		if _, err := namedIndex(t, router, "test-index").AddDocument(doc); err != nil {
+			t.Fatalf("failed to add document: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test-index/_export", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON content type, got %q", ct)
+	}
+
+	var lines []exportLine
+	scanner := bufio.NewScanner(w.Body)
+	for scanner.Scan() {
+		var line exportLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("failed to decode line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) != len(titles) {
+		t.Fatalf("expected %d lines, got %d", len(titles), len(lines))
+	}
+	for i, line := range lines {
+		if line.ID != fmt.Sprint(i) {
+			t.Errorf("line %d: expected _id %d, got %q", i, i, line.ID)
+		}
+		if title, err := line.Source.GetField("title"); err != nil || title.Value != titles[i] {
+			t.Errorf("line %d: expected title %q, got %v", i, titles[i], title.Value)
+		}
+	}
+}
+
+func TestSearchSort(t *testing.T) {
+	router := NewRouter()
+	defer router.Close()
+
+	people := []struct {
+		name string
+		age  float64
+	}{{"carol", 35}, {"alice", 28}, {"bob", 41}}
+	for _, person := range people {
+		doc := document.NewDocument()
+		doc.AddField("name", person.name)
+		doc.AddField("age", person.age)
+		if _, err := namedIndex(t, router, "test-index").AddDocument(doc); err != nil {
+			t.Fatalf("failed to add document: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		sort string
+		want string
+	}{
+		{"Ascending numeric", `[{"age": "asc"}]`, "alice,carol,bob"},
+		{"Descending string", `[{"name": {"order": "desc"}}]`, "carol,bob,alice"},
+		{"Field name", `["age"]`, "alice,carol,bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"query": {"match_all": {}}, "sort": ` + tt.sort + `}`
+			req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var response search.ESResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			var names []string
+			for _, hit := range response.Hits.Hits {
+				names = append(names, hit.Source["name"].(string))
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("expected order %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(`{"query": {"match_all": {}}, "sort": [{"age": "sideways"}]}`))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid sort order, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestSearchSourceFiltering(t *testing.T) {
+	router := NewRouter()
+	defer router.Close()
+
+	doc := document.NewDocument()
+	doc.AddField("title", "large document")
+	doc.AddField("body", "a very long body")
+	doc.AddField("author", "alice")
+	if _, err := namedIndex(t, router, "test-index").AddDocument(doc); err != nil {
+		t.Fatalf("failed to add document: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		source     string
+		wantFields string
+	}{
+		{"Includes", `{"includes": ["title"]}`, "title"},
+		{"Excludes", `{"excludes": ["body"]}`, "author,title"},
+		{"Excludes win", `{"includes": ["title", "body"], "excludes": ["body"]}`, "title"},
+		{"Array shorthand", `["title", "author"]`, "author,title"},
+		{"Disabled", `false`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"query": {"match_all": {}}, "_source": ` + tt.source + `}`
+			req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var response struct {
+				Hits struct {
+					Hits []map[string]json.RawMessage `json:"hits"`
+				} `json:"hits"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(response.Hits.Hits) != 1 {
+				t.Fatalf("expected 1 hit, got %d", len(response.Hits.Hits))
+			}
+
+			rawSource, ok := response.Hits.Hits[0]["_source"]
+			if tt.wantFields == "" {
+				if ok {
+					t.Errorf("expected _source to be omitted, got %s", rawSource)
+				}
+				return
+			}
+			var source map[string]interface{}
+			if err := json.Unmarshal(rawSource, &source); err != nil {
+				t.Fatalf("failed to decode _source: %v", err)
+			}
+			var fields []string
+			for field := range source {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if got := strings.Join(fields, ","); got != tt.wantFields {
+				t.Errorf("expected _source fields %s, got %s", tt.wantFields, got)
+			}
+		})
+	}
+}
+
+func TestSearchHighlight(t *testing.T) {
+	router := NewRouter()
+	defer router.Close()
+
+	doc := document.NewDocument()
+	doc.AddField("title", "The Quick brown fox")
+	doc.AddField("body", "Nothing to see here")
+	if _, err := namedIndex(t, router, "test-index").AddDocument(doc); err != nil {
+		t.Fatalf("failed to add document: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		highlight  string
+		wantStatus int
+		want       []string
+	}{
+		{"Default tags", `{"fields": {"title": {}}}`, http.StatusOK, []string{"The <em>Quick</em> brown <em>fox</em>"}},
+		{"Custom tags", `{"pre_tags": ["["], "post_tags": ["]"], "fields": ["title"]}`, http.StatusOK, []string{"The [Quick] brown [fox]"}},
+		{"Fragment size", `{"pre_tags": "[", "post_tags": "]", "fragment_size": 10, "fields": {"title": {}}}`, http.StatusOK, []string{"[Quick] brown", "[fox]"}},
+		{"No fields", `{"pre_tags": ["["]}`, http.StatusBadRequest, nil},
+		{"Bad fragment size", `{"fragment_size": -1, "fields": ["title"]}`, http.StatusBadRequest, nil},
+		{"Unknown option", `{"fields": ["title"], "order": "score"}`, http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"query": {"match": {"title": "quick fox"}}, "highlight": ` + tt.highlight + `}`
+			req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d but got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Hits struct {
+					Hits []struct {
+						Highlight map[string][]string `json:"highlight"`
+					} `json:"hits"`
+				} `json:"hits"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if len(response.Hits.Hits) != 1 {
+				t.Fatalf("expected 1 hit, got %d", len(response.Hits.Hits))
+			}
+			highlight := response.Hits.Hits[0].Highlight
+			if len(highlight) != 1 || strings.Join(highlight["title"], "|") != strings.Join(tt.want, "|") {
+				t.Errorf("expected title highlight %q, got %q", tt.want, highlight)
+			}
+		})
+	}
+}
+
+func TestAnalyzeEndpoint(t *testing.T) {
+	router := NewRouter()
+	namedIndex(t, router, "test-index").SetFieldAnalyzer("tag", analysis.NewKeywordAnalyzer())
+
+	type token struct {
+		Token       string `json:"token"`
+		StartOffset int    `json:"start_offset"`
+		EndOffset   int    `json:"end_offset"`
+		Position    int    `json:"position"`
+	}
+	analyze := func(target, body string) ([]token, int) {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var resp struct {
+			Tokens []token `json:"tokens"`
+		}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		return resp.Tokens, w.Code
+	}
+
+	text := "Hello, World! It's (really) 2024."
+	var expected []token
+	for _, tok := range analysis.NewStandardAnalyzer().Analyze(text) {
+		expected = append(expected, token{tok.Text, tok.StartByte, tok.EndByte, tok.Position})
+	}
+
+	for _, target := range []string{"/_analyze", "/test-index/_analyze"} {
+		tokens, code := analyze(target, `{"analyzer": "standard", "text": "`+text+`"}`)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d", target, http.StatusOK, code)
+		}
+		if !reflect.DeepEqual(tokens, expected) {
+			t.Errorf("%s: expected tokens %v, got %v", target, expected, tokens)
+		}
+	}
+
+	tokens, _ := analyze("/test-index/_analyze", `{"field": "tag", "text": "Hello, World!"}`)
+	if len(tokens) != 1 || tokens[0] != (token{"Hello, World!", 0, 13, 0}) {
+		t.Errorf("expected the field's keyword analyzer to be used, got %v", tokens)
+	}
+
+	errorCases := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{"Unknown analyzer", "/_analyze", `{"analyzer": "missing", "text": "x"}`, http.StatusBadRequest},
+		{"Missing text", "/_analyze", `{"analyzer": "standard"}`, http.StatusBadRequest},
+		{"Missing index", "/missing/_analyze", `{"text": "x"}`, http.StatusNotFound},
+	}
+	for _, tt := range errorCases {
+		if _, code := analyze(tt.target, tt.body); code != tt.status {
+			t.Errorf("%s: expected status %d but got %d", tt.name, tt.status, code)
+		}
+	}
+}
+
+func TestSearchAggregations(t *testing.T) {
+	router := NewRouter()
+	idx := namedIndex(t, router, "test-index")
+	for i, status := range []string{"open", "closed", "open", "merged", "open", "closed"} {
+		doc := document.NewDocument()
+		doc.AddField("title", fmt.Sprintf("ticket %d", i))
+		doc.AddField("status", status)
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("failed to add document: %v", err)
+		}
+	}
+
+	type bucket struct {
+		Key      string `json:"key"`
+		DocCount int    `json:"doc_count"`
+	}
+	tests := []struct {
+		name  string
+		body  string
+		want  []bucket
+		other int
+	}{
+		{
+			name: "All documents",
+			body: `{"query": {"match_all": {}}, "aggs": {"by_status": {"terms": {"field": "status"}}}}`,
+			want: []bucket{{"open", 3}, {"closed", 2}, {"merged", 1}},
+		},
+		{
+			name:  "Size",
+			body:  `{"query": {"match_all": {}}, "aggregations": {"by_status": {"terms": {"field": "status", "size": 2}}}}`,
+			want:  []bucket{{"open", 3}, {"closed", 2}},
+			other: 1,
+		},
+		{
+			name: "Matching documents only",
+			body: `{"query": {"term": {"status": "closed"}}, "aggs": {"by_status": {"terms": {"field": "status"}}}}`,
+			want: []bucket{{"closed", 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+			var resp struct {
+				Aggregations map[string]struct {
+					SumOtherDocCount int      `json:"sum_other_doc_count"`
+					Buckets          []bucket `json:"buckets"`
+				} `json:"aggregations"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			agg := resp.Aggregations["by_status"]
+			if !reflect.DeepEqual(agg.Buckets, tt.want) || agg.SumOtherDocCount != tt.other {
+				t.Errorf("expected buckets %v with %d others, got %+v", tt.want, tt.other, agg)
+			}
+		})
+	}
+
+	for _, body := range []string{
+		`{"query": {"match_all": {}}, "aggs": {"by_status": {"terms": {}}}}`,
+		`{"query": {"match_all": {}}, "aggs": {"by_status": {"terms": {"field": "status", "size": 0}}}}`,
+		`{"query": {"match_all": {}}, "aggs": {"by_status": {"unknown": {"field": "status"}}}}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, body, w.Code)
+		}
+	}
+}
+
+func TestSearchMetricAggregations(t *testing.T) {
+	router := NewRouter()
+	idx := namedIndex(t, router, "test-index")
+	for i, age := range []interface{}{30.0, 25.0, nil, 41.0} {
+		doc := document.NewDocument()
+		doc.AddField("name", fmt.Sprintf("person %d", i))
+		if age != nil {
+			doc.AddField("age", age)
+		}
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("failed to add document: %v", err)
+		}
+	}
+
+	body := `{"query": {"match_all": {}}, "aggs": {
+		"avg_age": {"avg": {"field": "age"}},
+		"sum_age": {"sum": {"field": "age"}},
+		"min_age": {"min": {"field": "age"}},
+		"max_age": {"max": {"field": "age"}},
+		"avg_height": {"avg": {"field": "height"}}
+	}}`
+	req := httptest.NewRequest(http.MethodPost, "/test-index/_search", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp struct {
+		Aggregations map[string]struct {
+			Value *float64 `json:"value"`
+		} `json:"aggregations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+
+	for name, want := range map[string]float64{"avg_age": 32, "sum_age": 96, "min_age": 25, "max_age": 41} {
+		if value := resp.Aggregations[name].Value; value == nil || *value != want {
+			t.Errorf("%s: expected %v, got %v", name, want, value)
+		}
+	}
+	if agg, ok := resp.Aggregations["avg_height"]; !ok || agg.Value != nil {
+		t.Errorf("expected a null average for a missing field, got %+v", agg)
+	}
+}
+
+func TestSearchDateHistogram(t *testing.T) {
+	router := NewRouter()
+	body := `{"index": {}}
+{"event": "login", "timestamp": "2024-05-01T08:00:00Z"}
+{"index": {}}
+{"event": "logout", "timestamp": "2024-05-01T17:30:00Z"}
+{"index": {}}
+{"event": "login", "timestamp": "2024-05-02T09:15:00+02:00"}
+`
+	req := httptest.NewRequest(http.MethodPost, "/events/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	search := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/events/_search", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = search(`{"query": {"match_all": {}}, "aggs": {"per_day": {"date_histogram": {"field": "timestamp", "calendar_interval": "day"}}}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp struct {
+		Aggregations map[string]struct {
+			Buckets []struct {
+				KeyAsString string `json:"key_as_string"`
+				DocCount    int    `json:"doc_count"`
+			} `json:"buckets"`
+		} `json:"aggregations"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	buckets := resp.Aggregations["per_day"].Buckets
+	if len(buckets) != 2 ||
+		buckets[0].KeyAsString != "2024-05-01T00:00:00.000Z" || buckets[0].DocCount != 2 ||
+		buckets[1].KeyAsString != "2024-05-02T00:00:00.000Z" || buckets[1].DocCount != 1 {
+		t.Errorf("expected 2 documents on May 1st and 1 on May 2nd, got %+v", buckets)
+	}
+
+	for _, agg := range []string{
+		`{"date_histogram": {"field": "timestamp"}}`,
+		`{"date_histogram": {"field": "timestamp", "calendar_interval": "day", "fixed_interval": "1d"}}`,
+		`{"date_histogram": {"field": "timestamp", "calendar_interval": "fortnight"}}`,
+		`{"date_histogram": {"field": "timestamp", "fixed_interval": "0h"}}`,
+	} {
+		if w := search(`{"query": {"match_all": {}}, "aggs": {"per_day": ` + agg + `}}`); w.Code != http.StatusBadRequest {
+			t.Errorf("expected status %d for %s, got %d", http.StatusBadRequest, agg, w.Code)
+		}
+	}
+}
+
+func TestSearchDateRange(t *testing.T) {
+	router := NewRouter()
+	create := httptest.NewRecorder()
+	router.ServeHTTP(create, httptest.NewRequest(http.MethodPut, "/events", strings.NewReader(`{"mappings": {"date_detection": true}}`)))
+	if create.Code != http.StatusOK {
+		t.Fatalf("failed to create index: %d %s", create.Code, create.Body.String())
+	}
+	body := `{"index": {"_id": "1"}}
+{"event": "login", "timestamp": "2021-03-01T10:00:00Z"}
+{"index": {"_id": "2"}}
+{"event": "logout", "timestamp": "2021-03-05T18:30:00Z"}
+{"index": {"_id": "3"}}
+{"event": "login", "timestamp": "2021-03-06T01:00:00+02:00"}
+{"index": {"_id": "4"}}
+{"event": "login", "timestamp": "2021-04-01T00:00:00Z"}
+`
+	req := httptest.NewRequest(http.MethodPost, "/events/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{
+			name:  "Window",
+			query: `{"range": {"timestamp": {"gte": "2021-03-01T00:00:00Z", "lt": "2021-03-06T00:00:00Z"}}}`,
+			// Document 3 is 2021-03-05T23:00:00Z in UTC
+			want: []string{"1", "2", "3"},
+		},
+		{
+			name:  "Exclusive lower bound",
+			query: `{"range": {"timestamp": {"gt": "2021-03-01T10:00:00Z"}}}`,
+			want:  []string{"2", "3", "4"},
+		},
+		{
+			name:  "Empty window",
+			query: `{"range": {"timestamp": {"gte": "2022-01-01T00:00:00Z"}}}`,
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/events/_search", strings.NewReader(`{"query": `+tt.query+`}`))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+			}
+
+			var resp search.ESResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			ids := make([]string, 0, len(resp.Hits.Hits))
+			for _, hit := range resp.Hits.Hits {
+				ids = append(ids, hit.ID)
+			}
+			sort.Strings(ids)
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("expected documents %v, got %v", tt.want, ids)
+			}
+		})
+	}
+}
+
+func TestMappingEndpoint(t *testing.T) {
+	router := NewRouter()
+
+	serve := func(method, target, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/people/_mapping", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for a missing index but got %d", http.StatusNotFound, w.Code)
+	}
+
+	mapping := `{"properties": {"age": {"type": "integer"}, "title": {"type": "text"}, "city": {"type": "keyword"}}}`
+	if w := serve(http.MethodPut, "/people/_mapping", "", mapping); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	w := serve(http.MethodGet, "/people/_mapping", "", "")
+	var got map[string]interface{}
+	var want map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	json.Unmarshal([]byte(`{"people": {"mappings": `+mapping+`}}`), &want)
+	if w.Code != http.StatusOK || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected mapping %v, got %d %s", want, w.Code, w.Body.String())
+	}
+
+	// Values are coerced to the mapped types, and rejected when they can't be
+	if w := serve(http.MethodPut, "/people/_doc/1", "", `{"age": "30", "title": "Moving to New York", "city": "New York"}`); w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	if w := serve(http.MethodPut, "/people/_doc/2", "", `{"age": "thirty", "city": "Boston"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a non-numeric age but got %d", http.StatusBadRequest, w.Code)
+	}
+	bulk := `{"index": {"_id": "3"}}
+{"age": true}
+`
+	w = serve(http.MethodPost, "/people/_bulk", "application/x-ndjson", bulk)
+	if !strings.Contains(w.Body.String(), "mapper_parsing_exception") {
+		t.Errorf("expected a mapper_parsing_exception for a boolean age, got %s", w.Body.String())
+	}
+
+	w = serve(http.MethodGet, "/people/_doc/1", "", "")
+	var doc struct {
+		Source map[string]interface{} `json:"_source"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &doc)
+	if doc.Source["age"] != float64(30) {
+		t.Errorf("expected age to be stored as the number 30, got %#v", doc.Source["age"])
+	}
+
+	// The keyword field only matches its whole value
+	searchCases := []struct {
+		query string
+		hits  int
+	}{
+		{`{"term": {"city": "New York"}}`, 1},
+		{`{"term": {"city": "new"}}`, 0},
+		{`{"term": {"title": "new"}}`, 1},
+	}
+	for _, tt := range searchCases {
+		w := serve(http.MethodPost, "/people/_search", "", `{"query": `+tt.query+`}`)
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(resp.Hits.Hits) != tt.hits {
+			t.Errorf("%s: expected %d hits, got %d", tt.query, tt.hits, len(resp.Hits.Hits))
+		}
+	}
+
+	errorCases := []struct {
+		name string
+		body string
+	}{
+		{"Conflicting type", `{"properties": {"age": {"type": "keyword"}}}`},
+		{"Unknown type", `{"properties": {"location": {"type": "geo_point"}}}`},
+		{"Missing type", `{"properties": {"location": {}}}`},
+		{"Missing properties", `{"dynamic": false}`},
+	}
+	for _, tt := range errorCases {
+		if w := serve(http.MethodPut, "/people/_mapping", "", tt.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d but got %d", tt.name, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestConstantScoreQuery(t *testing.T) {
+	router := NewRouter()
+	body := `{"index": {"_id": "1"}}
+{"title": "fox fox fox", "status": "active"}
+{"index": {"_id": "2"}}
+{"title": "quick brown fox", "status": "archived"}
+{"index": {"_id": "3"}}
+{"title": "lazy fox", "status": "active"}
+`
+	req := httptest.NewRequest(http.MethodPost, "/animals/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	query := `{"query": {"constant_score": {"filter": {"term": {"status": "active"}}, "boost": 2.0}}}`
+	req = httptest.NewRequest(http.MethodPost, "/animals/_search", strings.NewReader(query))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp search.ESResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Hits.Hits) != 2 {
+		t.Fatalf("expected 2 active documents, got %d", len(resp.Hits.Hits))
+	}
+	for _, hit := range resp.Hits.Hits {
+		if hit.Score != 2.0 {
+			t.Errorf("expected document %s to score the boost 2.0, got %f", hit.ID, hit.Score)
+		}
+	}
+	if resp.Hits.MaxScore != 2.0 {
+		t.Errorf("expected max_score 2.0, got %f", resp.Hits.MaxScore)
+	}
+}
+
+func TestNumericTermQuery(t *testing.T) {
+	router := NewRouter()
+	body := `{"index": {"_id": "1"}}
+{"name": "alice", "age": 30}
+{"index": {"_id": "2"}}
+{"name": "bob", "age": 30.5}
+{"index": {"_id": "3"}}
+{"name": "carol", "age": 41}
+`
+	req := httptest.NewRequest(http.MethodPost, "/people/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := map[string]string{
+		`{"query": {"term": {"age": 30}}}`:              "1",
+		`{"query": {"term": {"age": 30.0}}}`:            "1",
+		`{"query": {"term": {"age": {"value": 30.5}}}}`: "2",
+	}
+	for query, want := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/people/_search", strings.NewReader(query))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(resp.Hits.Hits) != 1 || resp.Hits.Hits[0].ID != want {
+			t.Errorf("%s: expected only document %s, got %+v", query, want, resp.Hits.Hits)
+		}
+	}
+}
+
+func TestTrackTotalHits(t *testing.T) {
+	router := NewRouter()
+	var body strings.Builder
+	for i := 1; i <= 10; i++ {
+		fmt.Fprintf(&body, "{\"index\": {\"_id\": \"%d\"}}\n{\"title\": \"shared term %d\"}\n", i, i)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/docs/_bulk", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		hits     int
+		total    int
+		relation string
+	}{
+		{"exact", `{"query": {"match": {"title": "shared"}}, "size": 2, "track_total_hits": true}`, 2, 10, "eq"},
+		{"default", `{"query": {"match": {"title": "shared"}}, "size": 2}`, 2, 10, "eq"},
+		{"capped", `{"query": {"match": {"title": "shared"}}, "size": 2, "track_total_hits": 4}`, 2, 4, "gte"},
+		{"capped below size", `{"query": {"match": {"title": "shared"}}, "size": 5, "track_total_hits": 3}`, 5, 3, "gte"},
+		{"cap above matches", `{"query": {"match": {"title": "shared"}}, "size": 2, "track_total_hits": 20}`, 2, 10, "eq"},
+		{"untracked", `{"query": {"match": {"title": "shared"}}, "size": 3, "track_total_hits": false}`, 3, 3, "gte"},
+		{"untracked without size", `{"query": {"match": {"title": "shared"}}, "track_total_hits": false}`, 10, 10, "eq"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/docs/_search", strings.NewReader(tt.query))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d: %s", tt.name, http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", tt.name, err)
+		}
+		if len(resp.Hits.Hits) != tt.hits {
+			t.Errorf("%s: expected %d hits, got %d", tt.name, tt.hits, len(resp.Hits.Hits))
+		}
+		if resp.Hits.Total.Value != tt.total || resp.Hits.Total.Relation != tt.relation {
+			t.Errorf("%s: expected total %d (%s), got %+v", tt.name, tt.total, tt.relation, resp.Hits.Total)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/docs/_search?size=1&track_total_hits=false", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp search.ESResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Hits.Hits) != 1 || resp.Hits.Total.Relation != "gte" {
+		t.Errorf("expected 1 hit with a lower bound total from GET, got %d hits and %+v", len(resp.Hits.Hits), resp.Hits.Total)
+	}
+
+	for _, query := range []string{
+		`{"query": {"match_all": {}}, "track_total_hits": "lots"}`,
+		`{"query": {"match_all": {}}, "track_total_hits": 0}`,
+		`{"query": {"match_all": {}}, "size": -1}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/docs/_search", strings.NewReader(query))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d but got %d", query, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestMinScore(t *testing.T) {
+	router := NewRouter()
+	body := `{"index": {"_id": "1"}}
+{"title": "fox fox fox"}
+{"index": {"_id": "2"}}
+{"title": "the fox jumped over the lazy brown dog by the river"}
+{"index": {"_id": "3"}}
+{"title": "fox fox"}
+{"index": {"_id": "4"}}
+{"title": "a long story about a dog and a cat and finally a fox"}
+{"index": {"_id": "5"}}
+{"title": "lazy dog"}
+`
+	req := httptest.NewRequest(http.MethodPost, "/animals/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	runSearch := func(query string) search.ESResponse {
+		req := httptest.NewRequest(http.MethodPost, "/animals/_search", strings.NewReader(query))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	// Place the threshold between the short and long documents' scores
+	all := runSearch(`{"query": {"match": {"title": "fox"}}}`)
+	if len(all.Hits.Hits) != 4 {
+		t.Fatalf("expected 4 documents to mention fox, got %d", len(all.Hits.Hits))
+	}
+	minScore, maxScore := all.Hits.Hits[0].Score, all.Hits.Hits[0].Score
+	for _, hit := range all.Hits.Hits {
+		minScore = math.Min(minScore, hit.Score)
+		maxScore = math.Max(maxScore, hit.Score)
+	}
+	threshold := (minScore + maxScore) / 2
+	want := 0
+	for _, hit := range all.Hits.Hits {
+		if hit.Score >= threshold {
+			want++
+		}
+	}
+	if want == 0 || want == len(all.Hits.Hits) {
+		t.Fatalf("expected the threshold %f to split the scores of %+v", threshold, all.Hits.Hits)
+	}
+
+	resp := runSearch(fmt.Sprintf(`{"query": {"match": {"title": "fox"}}, "min_score": %f}`, threshold))
+	if len(resp.Hits.Hits) != want {
+		t.Errorf("expected %d hits scoring at least %f, got %d", want, threshold, len(resp.Hits.Hits))
+	}
+	for _, hit := range resp.Hits.Hits {
+		if hit.Score < threshold {
+			t.Errorf("expected document %s scoring %f to be dropped", hit.ID, hit.Score)
+		}
+	}
+	if resp.Hits.Total.Value != want || resp.Hits.Total.Relation != "eq" {
+		t.Errorf("expected the total to count only the %d kept hits, got %+v", want, resp.Hits.Total)
+	}
+
+	resp = runSearch(fmt.Sprintf(`{"query": {"match": {"title": "fox"}}, "min_score": %f, "size": 1}`, threshold))
+	if len(resp.Hits.Hits) != 1 || resp.Hits.Total.Value != want {
+		t.Errorf("expected min_score to apply before size, got %d hits and %+v", len(resp.Hits.Hits), resp.Hits.Total)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/animals/_search", strings.NewReader(`{"query": {"match_all": {}}, "min_score": -1}`))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a negative min_score but got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestMultiGet(t *testing.T) {
+	router := NewRouter()
+	body := `{"index": {"_index": "books", "_id": "1"}}
+{"title": "Dune"}
+{"index": {"_index": "books", "_id": "2"}}
+{"title": "Emma"}
+{"index": {"_index": "films", "_id": "1"}}
+{"title": "Alien"}
+`
+	req := httptest.NewRequest(http.MethodPost, "/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	type mgetDoc struct {
+		Index  string                 `json:"_index"`
+		ID     string                 `json:"_id"`
+		Found  bool                   `json:"found"`
+		Source map[string]interface{} `json:"_source"`
+	}
+	mget := func(path, body string) []mgetDoc {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d: %s", path, http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Docs []mgetDoc `json:"docs"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", path, err)
+		}
+		return resp.Docs
+	}
+
+	docs := mget("/_mget", `{"docs": [
+		{"_index": "books", "_id": "2"},
+		{"_index": "films", "_id": "1"},
+		{"_index": "books", "_id": "9"},
+		{"_index": "music", "_id": "1"}
+	]}`)
+	want := []mgetDoc{
+		{Index: "books", ID: "2", Found: true, Source: map[string]interface{}{"title": "Emma"}},
+		{Index: "films", ID: "1", Found: true, Source: map[string]interface{}{"title": "Alien"}},
+		{Index: "books", ID: "9"},
+		{Index: "music", ID: "1"},
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Errorf("expected docs %+v, got %+v", want, docs)
+	}
+
+	// The path's index is the default for docs and required for ids
+	docs = mget("/books/_mget", `{"ids": ["1", "missing", 2]}`)
+	if len(docs) != 3 || !docs[0].Found || docs[1].Found || !docs[2].Found {
+		t.Fatalf("expected documents 1 and 2 found and missing not found, got %+v", docs)
+	}
+	if docs[0].Source["title"] != "Dune" || docs[2].ID != "2" {
+		t.Errorf("expected the ids' sources in request order, got %+v", docs)
+	}
+	docs = mget("/books/_mget", `{"docs": [{"_id": "1"}, {"_index": "films", "_id": "1"}]}`)
+	if len(docs) != 2 || docs[0].Source["title"] != "Dune" || docs[1].Source["title"] != "Alien" {
+		t.Errorf("expected _index to default to the path's index, got %+v", docs)
+	}
+
+	for _, tt := range []struct{ path, body string }{
+		{"/_mget", `{"ids": ["1"]}`},
+		{"/_mget", `{"docs": [{"_id": "1"}]}`},
+		{"/books/_mget", `{}`},
+		{"/books/_mget", `{"ids": [true]}`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected status %d but got %d", tt.path, tt.body, http.StatusBadRequest, w.Code)
+		}
+	}
+}
+
+func TestHeadDocument(t *testing.T) {
+	router := NewRouter()
+	req := httptest.NewRequest(http.MethodPut, "/books/_doc/1", strings.NewReader(`{"title": "Dune"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+
+	tests := map[string]int{
+		"/books/_doc/1": http.StatusOK,
+		"/books/_doc/2": http.StatusNotFound,
+		"/films/_doc/1": http.StatusNotFound,
+	}
+	for path, want := range tests {
+		req := httptest.NewRequest(http.MethodHead, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("HEAD %s: expected status %d but got %d", path, want, w.Code)
+		}
+		if w.Body.Len() != 0 {
+			t.Errorf("HEAD %s: expected an empty body, got %q", path, w.Body.String())
+		}
+	}
+}
+
+func TestCreateAndDeleteIndex(t *testing.T) {
+	router := NewRouter()
+	do := func(method, path, body string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s: failed to decode response %q: %v", method, path, w.Body.String(), err)
+		}
+		return w.Code, resp
+	}
+	errorType := func(resp map[string]interface{}) interface{} {
+		errObj, _ := resp["error"].(map[string]interface{})
+		return errObj["type"]
+	}
+
+	code, resp := do(http.MethodPut, "/books", `{"settings": {"number_of_shards": 3}, "mappings": {"properties": {"year": {"type": "integer"}}}}`)
+	if code != http.StatusOK || resp["acknowledged"] != true {
+		t.Fatalf("expected the index to be created, got %d %v", code, resp)
+	}
+	code, resp = do(http.MethodGet, "/books/_mapping", "")
+	if code != http.StatusOK || !strings.Contains(fmt.Sprint(resp), "integer") {
+		t.Errorf("expected the created index to have the year mapping, got %d %v", code, resp)
+	}
+	code, resp = do(http.MethodGet, "/books/_search", "")
+	if code != http.StatusOK {
+		t.Fatalf("expected to search the empty index, got %d %v", code, resp)
+	}
+	shards, _ := resp["_shards"].(map[string]interface{})
+	if hits, _ := resp["hits"].(map[string]interface{}); len(hits["hits"].([]interface{})) != 0 || shards["total"] != float64(3) {
+		t.Errorf("expected no hits from 3 shards, got %v", resp)
+	}
+	if code, _ := do(http.MethodPut, "/films", ""); code != http.StatusOK {
+		t.Errorf("expected an index to be created without a body, got %d", code)
+	}
+
+	code, resp = do(http.MethodPut, "/books", "")
+	if code != http.StatusBadRequest || errorType(resp) != "resource_already_exists_exception" {
+		t.Errorf("expected creating books twice to fail, got %d %v", code, resp)
+	}
+	for _, tt := range []struct{ path, body, errType string }{
+		{"/Books", "", "invalid_index_name_exception"},
+		{"/music", `{"settings": {"number_of_shards": 0}}`, "illegal_argument_exception"},
+		{"/music", `{"mappings": {"properties": {"year": {"type": "geo_shape"}}}}`, "mapper_parsing_exception"},
+	} {
+		code, resp := do(http.MethodPut, tt.path, tt.body)
+		if code != http.StatusBadRequest || errorType(resp) != tt.errType {
+			t.Errorf("PUT %s %s: expected a %s, got %d %v", tt.path, tt.body, tt.errType, code, resp)
+		}
+	}
+	oversized := `{"settings": {"number_of_replicas": "` + strings.Repeat("a", MaxRequestBodySize) + `"}}`
+	if code, _ := do(http.MethodPut, "/music", oversized); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected an oversized body to be rejected with %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+	if router.lookupIndex("music") != nil {
+		t.Errorf("expected failed creations not to register an index")
+	}
+
+	code, resp = do(http.MethodDelete, "/books", "")
+	if code != http.StatusOK || resp["acknowledged"] != true {
+		t.Fatalf("expected the index to be deleted, got %d %v", code, resp)
+	}
+	code, resp = do(http.MethodGet, "/books/_search", "")
+	if code != http.StatusNotFound || errorType(resp) != "index_not_found_exception" {
+		t.Errorf("expected searching the deleted index to fail, got %d %v", code, resp)
+	}
+	code, resp = do(http.MethodDelete, "/books", "")
+	if code != http.StatusNotFound || errorType(resp) != "index_not_found_exception" {
+		t.Errorf("expected deleting a missing index to 404, got %d %v", code, resp)
+	}
+	if code, _ := do(http.MethodPut, "/books", ""); code != http.StatusOK {
+		t.Errorf("expected a deleted index to be creatable again, got %d", code)
+	}
+}
+
+func TestDeleteIndexWaitsForRequests(t *testing.T) {
+	router := NewRouter()
+	idx := namedIndex(t, router, "books")
+
+	// A request in flight holds the index until it releases it
+	live := router.lookupIndex("books")
+	deleted := make(chan error, 1)
+	go func() {
+		_, err := router.deleteIndex("books")
+		deleted <- err
+	}()
+
+	select {
+	case err := <-deleted:
+		t.Fatalf("expected the delete to wait for the request, it returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	doc := document.NewDocument()
+	doc.AddField("title", "Dune")
+	if _, err := idx.AddDocument(doc); err != nil {
+		t.Errorf("expected the index to stay open while in use, got %v", err)
+	}
+
+	live.release()
+	select {
+	case err := <-deleted:
+		if err != nil {
+			t.Errorf("failed to close the deleted index: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the delete to finish once the request released the index")
+	}
+	if router.lookupIndex("books") != nil {
+		t.Error("expected the deleted index not to be served")
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	router := NewRouter()
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := serve(http.MethodGet, "/_metrics", ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d before metrics are enabled, got %d", http.StatusNotFound, w.Code)
+	}
+
+	router.EnableMetrics()
+	serve(http.MethodPut, "/books/_doc/1", `{"title": "Dune"}`)
+	serve(http.MethodPut, "/books/_doc/2", `{"title": "Emma"}`)
+	serve(http.MethodGet, "/books/_search", "")
+	serve(http.MethodGet, "/books/_search?q=dune", "")
+	serve(http.MethodGet, "/films/_search", "")
+	serve(http.MethodGet, "/_nope1", "")
+	serve(http.MethodGet, "/books/_nope2", "")
+
+	w := serve(http.MethodGet, "/_metrics", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("expected a text/plain exposition, got %q", contentType)
+	}
+	for _, line := range []string{
+		`indexer_http_requests_total{endpoint="_doc",status="201"} 2`,
+		`indexer_http_requests_total{endpoint="_search",status="200"} 2`,
+		`indexer_http_requests_total{endpoint="_search",status="404"} 1`,
+		`indexer_search_latency_seconds_bucket{le="+Inf"} 3`,
+		`indexer_search_latency_seconds_count 3`,
+		`indexer_index_documents{index="books"} 2`,
+		`indexer_http_requests_total{endpoint="other",status="404"} 2`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", line, w.Body.String())
+		}
+	}
+	if strings.Contains(w.Body.String(), "_nope") {
+		t.Errorf("expected unknown segments to be counted as other, got:\n%s", w.Body.String())
+	}
+
+	// Counters keep advancing, including for scrapes themselves
+	serve(http.MethodGet, "/books/_search", "")
+	w = serve(http.MethodGet, "/_metrics", "")
+	for _, line := range []string{
+		`indexer_http_requests_total{endpoint="_metrics",status="200"} 1`,
+		`indexer_http_requests_total{endpoint="_search",status="200"} 3`,
+		`indexer_search_latency_seconds_count 4`,
+	} {
+		if !strings.Contains(w.Body.String(), line+"\n") {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", line, w.Body.String())
+		}
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	router := NewRouter()
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		errType     string
+	}{
+		{"malformed search JSON", http.MethodPost, "/_search", "application/json", `{"query": {"match": `, http.StatusBadRequest, "parsing_exception"},
+		{"malformed query", http.MethodPost, "/_search", "application/json", `{"query": {"range": {"age": "old"}}}`, http.StatusBadRequest, "parsing_exception"},
+		{"missing query", http.MethodPost, "/_search", "application/json", `{"size": 1}`, http.StatusBadRequest, "parsing_exception"},
+		{"invalid search option", http.MethodPost, "/_search", "application/json", `{"query": {"match_all": {}}, "size": -1}`, http.StatusBadRequest, "illegal_argument_exception"},
+		{"search method", http.MethodDelete, "/_search", "", "", http.StatusMethodNotAllowed, "method_not_allowed_exception"},
+		{"malformed bulk line", http.MethodPost, "/_bulk", "application/x-ndjson", "{\"index\": \n", http.StatusBadRequest, "parsing_exception"},
+		{"bulk content type", http.MethodPost, "/_bulk", "application/json", "{}\n", http.StatusBadRequest, "illegal_argument_exception"},
+		{"unknown endpoint", http.MethodGet, "/books/_unknown", "", "", http.StatusNotFound, "resource_not_found_exception"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d but got %d: %s", tt.name, tt.status, w.Code, w.Body.String())
+			continue
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s: expected a JSON error, got Content-Type %q", tt.name, contentType)
+		}
+		var resp struct {
+			Error struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+			Status int `json:"status"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Errorf("%s: expected an error envelope, got %q: %v", tt.name, w.Body.String(), err)
+			continue
+		}
+		if resp.Error.Type != tt.errType || resp.Error.Reason == "" || resp.Status != tt.status {
+			t.Errorf("%s: expected a %s error with status %d, got %+v", tt.name, tt.errType, tt.status, resp)
+		}
+	}
+}
+
+// searchHitIDs runs a search request body against path and returns the sorted
+// IDs of the hits
+func searchHitIDs(t *testing.T, router *Router, path, body string) []string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: expected status %d but got %d: %s", body, http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp search.ESResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: failed to decode response: %v", body, err)
+	}
+	ids := make([]string, 0, len(resp.Hits.Hits))
+	for _, hit := range resp.Hits.Hits {
+		ids = append(ids, hit.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// bulkIndexTitles indexes one document per title into indexName, with IDs
+// counting from 1
+func bulkIndexTitles(t *testing.T, router *Router, indexName string, titles ...string) {
+	t.Helper()
+	var body strings.Builder
+	for i, title := range titles {
+		fmt.Fprintf(&body, "{\"index\": {\"_id\": \"%d\"}}\n{\"title\": %q}\n", i+1, title)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/"+indexName+"/_bulk", strings.NewReader(body.String()))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), `"errors":true`) {
+		t.Fatalf("failed to set up test data: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestQueryStringSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "animals", "quick brown fox", "quick rabbit", "lazy fox")
+
+	tests := map[string][]string{
+		`{"query": {"query_string": {"query": "quick AND fox"}}}`:                                 {"1"},
+		`{"query": {"query_string": {"query": "rabbit OR lazy"}}}`:                                {"2", "3"},
+		`{"query": {"query_string": {"query": "fox AND NOT quick"}}}`:                             {"3"},
+		`{"query": {"query_string": {"query": "title:quick AND title:rabbit"}}}`:                  {"2"},
+		`{"query": {"query_string": {"query": "quick", "default_field": "title"}}}`:               {"1", "2"},
+		`{"query": {"constant_score": {"filter": {"query_string": {"query": "quick AND fox"}}}}}`: {"1"},
+	}
+	for body, want := range tests {
+		if got := searchHitIDs(t, router, "/animals/_search", body); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected hits %v, got %v", body, want, got)
+		}
+	}
+}
+
+func TestSimpleQueryStringAndMustNotSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "animals", "quick brown fox", "quick rabbit", "lazy fox", "sleepy cat")
+
+	tests := map[string][]string{
+		`{"query": {"simple_query_string": {"query": "quick +fox"}}}`:                                                 {"1", "3"},
+		`{"query": {"simple_query_string": {"query": "fox -lazy"}}}`:                                                  {"1"},
+		`{"query": {"simple_query_string": {"query": "quick | cat"}}}`:                                                {"1", "2", "4"},
+		`{"query": {"bool": {"must_not": [{"match": {"title": "fox"}}]}}}`:                                            {"2", "4"},
+		`{"query": {"bool": {"must": [{"match": {"title": "quick"}}], "must_not": [{"term": {"title": "rabbit"}}]}}}`: {"1"},
+	}
+	for body, want := range tests {
+		if got := searchHitIDs(t, router, "/animals/_search", body); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected hits %v, got %v", body, want, got)
+		}
+	}
+}
+
+func TestMinimumShouldMatchSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "animals", "quick brown fox", "quick rabbit", "lazy fox", "quick lazy brown dog")
+
+	tests := map[string][]string{
+		`{"query": {"bool": {"should": [{"match": {"title": "quick"}}, {"match": {"title": "fox"}}, {"match": {"title": "brown"}}], "minimum_should_match": 2}}}`: {"1", "4"},
+		`{"query": {"bool": {"should": [{"match": {"title": "quick"}}, {"match": {"title": "fox"}}, {"match": {"title": "brown"}}], "minimum_should_match": 3}}}`: {"1"},
+	}
+	for body, want := range tests {
+		if got := searchHitIDs(t, router, "/animals/_search", body); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected hits %v, got %v", body, want, got)
+		}
+	}
+}
+
+func TestBoolFilterSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "animals", "quick brown fox", "quick rabbit", "lazy fox", "quick quick fox")
+
+	hitScores := func(body string) map[string]float64 {
+		req := httptest.NewRequest(http.MethodPost, "/animals/_search", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d: %s", body, http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", body, err)
+		}
+		scores := make(map[string]float64, len(resp.Hits.Hits))
+		for _, hit := range resp.Hits.Hits {
+			scores[hit.ID] = hit.Score
+		}
+		return scores
+	}
+
+	plain := hitScores(`{"query": {"match": {"title": "quick"}}}`)
+	filtered := hitScores(`{"query": {"bool": {"must": [{"match": {"title": "quick"}}], "filter": [{"term": {"title": "fox"}}]}}}`)
+	if len(filtered) != 2 {
+		t.Fatalf("expected 2 filtered hits, got %v", filtered)
+	}
+	for _, id := range []string{"1", "4"} {
+		score, ok := filtered[id]
+		if !ok {
+			t.Errorf("expected document %s in filtered hits, got %v", id, filtered)
+			continue
+		}
+		if math.Abs(score-plain[id]) > 1e-9 {
+			t.Errorf("document %s: filter changed score from %v to %v", id, plain[id], score)
+		}
+	}
+
+	if got := searchHitIDs(t, router, "/animals/_search", `{"query": {"bool": {"filter": [{"term": {"title": "fox"}}]}}}`); !reflect.DeepEqual(got, []string{"1", "3", "4"}) {
+		t.Errorf("expected filter-only hits [1 3 4], got %v", got)
+	}
+}
+
+func TestTrackTotalHitsKeepsBestHits(t *testing.T) {
+	router := NewRouter()
+	titles := make([]string, 30)
+	for i := range titles {
+		titles[i] = "fox"
+	}
+	titles[16] = "fox fox fox"
+	bulkIndexTitles(t, router, "animals", titles...)
+
+	for _, track := range []string{"false", "5"} {
+		body := `{"query": {"match": {"title": "fox"}}, "size": 1, "track_total_hits": ` + track + `}`
+		req := httptest.NewRequest(http.MethodPost, "/animals/_search", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d: %s", body, http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", body, err)
+		}
+		if len(resp.Hits.Hits) != 1 || resp.Hits.Hits[0].ID != "17" {
+			t.Errorf("%s: expected the top hit to be document 17, got %+v", body, resp.Hits.Hits)
+		}
+		if resp.Hits.Total.Relation != "gte" {
+			t.Errorf("%s: expected a lower bound total, got %+v", body, resp.Hits.Total)
+		}
+	}
+}
+
+func TestMatchOperatorSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "animals", "quick brown fox", "quick rabbit", "lazy fox")
+
+	tests := map[string][]string{
+		`{"query": {"match": {"title": {"query": "quick fox", "operator": "and"}}}}`: {"1"},
+		`{"query": {"match": {"title": {"query": "quick fox", "operator": "or"}}}}`:  {"1", "2", "3"},
+		`{"query": {"match": {"title": {"query": "quick fox"}}}}`:                    {"1", "2", "3"},
+		`{"query": {"match": {"title": {"query": "quick cat", "operator": "and"}}}}`: {},
+	}
+	for body, want := range tests {
+		if got := searchHitIDs(t, router, "/animals/_search", body); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected hits %v, got %v", body, want, got)
+		}
+	}
+}
+
+func TestTermCaseSensitivitySearch(t *testing.T) {
+	router := NewRouter()
+	docs := []string{
+		`{"status": "active", "code": 7}`,
+		`{"status": "Active", "code": 7}`,
+		`{"status": "ACTIVE", "code": 8}`,
+	}
+	for i, doc := range docs {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/accounts/_doc/%d", i+1), strings.NewReader(doc))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("failed to index document %d: %d %s", i+1, w.Code, w.Body.String())
+		}
+	}
+
+	tests := map[string][]string{
+		`{"query": {"term": {"status": {"value": "Active", "case_insensitive": false}}}}`: {"2"},
+		`{"query": {"term": {"status": {"value": "Active", "case_insensitive": true}}}}`:  {"1", "2", "3"},
+		`{"query": {"term": {"status": "Active"}}}`:                                       {"1", "2", "3"},
+		`{"query": {"term": {"code": {"value": 7, "case_insensitive": false}}}}`:          {"1", "2"},
+	}
+	for body, want := range tests {
+		if got := searchHitIDs(t, router, "/accounts/_search", body); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected hits %v, got %v", body, want, got)
+		}
+	}
+}
+
+func TestMultiIndexSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "index-a", "go search")
+	bulkIndexTitles(t, router, "index-b", "search search search")
+
+	doSearch := func(path, body string) (int, search.ESResponse) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var resp search.ESResponse
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s: failed to decode response: %v", path, err)
+			}
+		}
+		return w.Code, resp
+	}
+	hitIndices := func(resp search.ESResponse) []string {
+		indices := make([]string, 0, len(resp.Hits.Hits))
+		for _, hit := range resp.Hits.Hits {
+			indices = append(indices, hit.Index)
+		}
+		return indices
+	}
+
+	query := `{"query": {"term": {"title": "search"}}}`
+	for _, path := range []string{"/index-a,index-b/_search", "/_all/_search"} {
+		code, resp := doSearch(path, query)
+		if code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d", path, http.StatusOK, code)
+		}
+		if got := hitIndices(resp); !reflect.DeepEqual(got, []string{"index-b", "index-a"}) {
+			t.Errorf("%s: expected hits from index-b then index-a, got %v", path, got)
+		}
+	}
+
+	// Boosting index-a moves its hit to the top
+	code, resp := doSearch("/index-a,index-b/_search", `{"query": {"term": {"title": "search"}}, "indices_boost": [{"index-a": 10.0}]}`)
+	if code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d", http.StatusOK, code)
+	}
+	if got := hitIndices(resp); !reflect.DeepEqual(got, []string{"index-a", "index-b"}) {
+		t.Errorf("expected boosted index-a to rank first, got %v", got)
+	}
+
+	if code, _ := doSearch("/index-a,missing/_search", query); code != http.StatusNotFound {
+		t.Errorf("expected status %d for a missing index but got %d", http.StatusNotFound, code)
+	}
+	if code, _ := doSearch("/index-a,index-b/_search", `{"query": {"match_all": {}}, "indices_boost": "index-a"}`); code != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid indices_boost but got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestBoolRangeSearch(t *testing.T) {
+	router := NewRouter()
+	for _, id := range []string{"5", "9", "12"} {
+		req := httptest.NewRequest(http.MethodPut, "/products/_doc/"+id, strings.NewReader(`{"price": `+id+`}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("failed to index document %s: %d %s", id, w.Code, w.Body.String())
+		}
+	}
+
+	body := `{"query": {"bool": {"must": [{"range": {"price": {"gte": 6}}}, {"exists": {"field": "price"}}]}}}`
+	if got := searchHitIDs(t, router, "/products/_search", body); !reflect.DeepEqual(got, []string{"12", "9"}) {
+		t.Errorf("expected hits [12 9], got %v", got)
+	}
+}
+
+func TestMultiIndexSearchShards(t *testing.T) {
+	router := NewRouter()
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodPut, "/events", `{"settings": {"number_of_shards": 2}, "mappings": {"date_detection": true}}`},
+		{http.MethodPut, "/metrics", `{"settings": {"number_of_shards": 3}}`},
+		{http.MethodPut, "/events/_doc/1", `{"created": "2024-01-01T00:00:00Z"}`},
+		{http.MethodPut, "/metrics/_doc/1", `{"created": 5}`},
+	}
+	for _, r := range requests {
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK && w.Code != http.StatusCreated {
+			t.Fatalf("%s %s: failed to set up test data: %d %s", r.method, r.path, w.Code, w.Body.String())
+		}
+	}
+
+	doSearch := func(body string) search.ESResponse {
+		req := httptest.NewRequest(http.MethodPost, "/events,metrics/_search", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d but got %d: %s", body, http.StatusOK, w.Code, w.Body.String())
+		}
+		var resp search.ESResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", body, err)
+		}
+		return resp
+	}
+
+	resp := doSearch(`{"query": {"match_all": {}}}`)
+	if resp.Shards.Total != 5 || resp.Shards.Successful != 5 || resp.Shards.Failed != 0 {
+		t.Errorf("expected 5 successful shards, got %+v", resp.Shards)
+	}
+
+	// A numeric range over a date field fails in events only, leaving the
+	// hit from metrics
+	resp = doSearch(`{"query": {"bool": {"must": [{"range": {"created": {"gte": 1}}}, {"exists": {"field": "created"}}]}}}`)
+	if resp.Shards.Total != 5 || resp.Shards.Successful != 3 || resp.Shards.Failed != 2 {
+		t.Errorf("expected 3 successful and 2 failed shards, got %+v", resp.Shards)
+	}
+	if len(resp.Shards.Failures) != 1 || resp.Shards.Failures[0].Index != "events" {
+		t.Errorf("expected a shard failure for events, got %+v", resp.Shards.Failures)
+	}
+	if len(resp.Hits.Hits) != 1 || resp.Hits.Hits[0].Index != "metrics" {
+		t.Errorf("expected the partial hit from metrics, got %+v", resp.Hits.Hits)
+	}
+}
+
+func TestMultiSearch(t *testing.T) {
+	router := NewRouter()
+	bulkIndexTitles(t, router, "index-a", "quick brown fox", "lazy dog")
+	bulkIndexTitles(t, router, "index-b", "quick rabbit")
+
+	msearch := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"index": "index-a"}
+{"query": {"match": {"title": "quick"}}}
+{"index": ["index-a", "index-b"]}
+{"query": {"match": {"title": "quick"}}}
+{}
+{"query": {"match_all": {}}}
+{"index": "index-a"}
+{"query": {"no_such_query": {}}}
+{"index": "missing"}
+{"query": {"match_all": {}}}
+`
+	w := msearch("/index-b/_msearch", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var resp struct {
+		Responses []struct {
+			Status int `json:"status"`
+			Hits   struct {
+				Hits []struct {
+					Index string `json:"_index"`
+				} `json:"hits"`
+			} `json:"hits"`
+			Error *struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		} `json:"responses"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Responses) != 5 {
+		t.Fatalf("expected 5 responses, got %d: %s", len(resp.Responses), w.Body.String())
+	}
+
+	wantHits := []int{1, 2, 1}
+	for i, want := range wantHits {
+		item := resp.Responses[i]
+		if item.Status != http.StatusOK || len(item.Hits.Hits) != want {
+			t.Errorf("response %d: expected status 200 with %d hits, got status %d with %d hits", i, want, item.Status, len(item.Hits.Hits))
+		}
+	}
+	if hits := resp.Responses[2].Hits.Hits; len(hits) == 1 && hits[0].Index != "index-b" {
+		t.Errorf("expected a header without an index to search the path index, got %s", hits[0].Index)
+	}
+	if item := resp.Responses[3]; item.Status != http.StatusBadRequest || item.Error == nil || item.Error.Type != "parsing_exception" {
+		t.Errorf("expected a parsing_exception for the malformed query, got status %d and error %+v", item.Status, item.Error)
+	}
+	if item := resp.Responses[4]; item.Status != http.StatusNotFound || item.Error == nil || item.Error.Type != "index_not_found_exception" {
+		t.Errorf("expected index_not_found_exception for the missing index, got status %d and error %+v", item.Status, item.Error)
+	}
+
+	// A header without a search request fails the whole request
+	if w := msearch("/_msearch", `{"index": "index-a"}`+"\n"); w.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for a missing search request but got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Bodies over the size limit are rejected
+	oversized := `{"index": "index-a"}` + "\n" + `{"query": {"match": {"title": "` + strings.Repeat("a", MaxRequestBodySize) + `"}}}` + "\n"
+	if w := msearch("/_msearch", oversized); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status %d for an oversized body but got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+}
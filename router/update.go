@@ -0,0 +1,101 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"my-indexer/document"
+	"my-indexer/index"
+)
+
+// handleUpdate applies a partial update to a document. The request body's
+// doc object is merged into the stored document, so fields it doesn't name
+// are kept. The result is "noop" when the document already held the values.
+func (r *Router) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		r.errorResponse(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	// Path is /{index}/_update/{id}
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[1] != "_update" {
+		r.errorResponse(w, http.StatusBadRequest, "invalid update path")
+		return
+	}
+	indexName, id := parts[0], parts[2]
+
+	docID, err := strconv.Atoi(id)
+	if err != nil || docID < 0 {
+		r.errorResponse(w, http.StatusBadRequest, fmt.Sprintf("%v: %q", ErrInvalidDocID, id))
+		return
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		r.errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	partial, err := parsePartialDocument(body)
+	if err != nil {
+		r.errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	live := r.lookupIndex(indexName)
+	if live == nil {
+		r.indexNotFound(w, indexName)
+		return
+	}
+	defer live.release()
+
+	changed, err := live.idx.MergeDocument(docID, partial)
+	if errors.Is(err, index.ErrDocumentNotFound) {
+		r.errorResponse(w, http.StatusNotFound, fmt.Sprintf("[%s]: document missing", id))
+		return
+	}
+	if err != nil {
+		r.errorResponse(w, writeErrorStatus(err), err.Error())
+		return
+	}
+
+	resp := documentResponse(live, indexName, docID)
+	resp["result"] = updateResult(changed)
+	resp["status"] = http.StatusOK
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// parsePartialDocument builds the partial document of an update request
+// body, {"doc": {...}}
+func parsePartialDocument(body map[string]interface{}) (*document.Document, error) {
+	for key := range body {
+		if key != "doc" {
+			return nil, fmt.Errorf("unsupported update option: %s", key)
+		}
+	}
+	fields, ok := body["doc"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("update requires a doc object")
+	}
+
+	partial := document.NewDocument()
+	for field, value := range fields {
+		if err := partial.AddField(field, value); err != nil {
+			return nil, err
+		}
+	}
+	return partial, nil
+}
+
+// updateResult returns the result reported for an update that did or didn't
+// change the document
+func updateResult(changed bool) string {
+	if changed {
+		return "updated"
+	}
+	return "noop"
+}
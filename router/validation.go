@@ -2,6 +2,7 @@ package router
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +24,7 @@ var (
 	ErrInvalidIndex    = errors.New("invalid index name")
 	ErrInvalidDocID    = errors.New("invalid document ID")
 	ErrInvalidBulkData = errors.New("invalid bulk request data")
+	ErrIndexExists     = errors.New("index already exists")
 )
 
 // validateRequestBody checks if the request body is present and not too large
@@ -59,6 +61,28 @@ func validateJSONBody(body []byte) error {
 	return nil
 }
 
+// maxIndexNameLength is the longest index name accepted, in bytes
+const maxIndexNameLength = 255
+
+// validateIndexName checks a name for a new index against the Elasticsearch
+// rules: lowercase, not starting with _, - or +, and free of path and pattern
+// characters
+func validateIndexName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("%w: %q", ErrInvalidIndex, name)
+	case len(name) > maxIndexNameLength:
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidIndex, maxIndexNameLength)
+	case strings.ContainsAny(name[:1], "_-+"):
+		return fmt.Errorf("%w: %q must not start with _, - or +", ErrInvalidIndex, name)
+	case strings.ToLower(name) != name:
+		return fmt.Errorf("%w: %q must be lowercase", ErrInvalidIndex, name)
+	case strings.ContainsAny(name, "\\/*?\"<>| ,#:"):
+		return fmt.Errorf("%w: %q must not contain \\, /, *, ?, \", <, >, |, space, comma, # or :", ErrInvalidIndex, name)
+	}
+	return nil
+}
+
 // validateDocumentRequest validates a document API request
 func validateDocumentRequest(r *http.Request) error {
 	// Extract and validate index name and document ID from path
@@ -104,6 +128,9 @@ func validateDocumentRequest(r *http.Request) error {
 		if !json.Valid(body) {
 			return ErrInvalidJSON
 		}
+
+		// Leave the body for the handler to decode
+		r.Body = io.NopCloser(bytes.NewReader(body))
 	}
 
 	return nil
@@ -243,6 +270,7 @@ func validateSearchRequest(r *http.Request) error {
 				"term":      true,
 				"range":     true,
 				"bool":      true,
+				"exists":    true,
 			}
 
 			// Helper function to validate field values
@@ -327,6 +355,20 @@ func validateSearchRequest(r *http.Request) error {
 								}
 							}
 						}
+					case "exists":
+						existsMap, ok := value.(map[string]interface{})
+						if !ok {
+							return &validationError{
+								status:  http.StatusBadRequest,
+								message: "exists query must be an object",
+							}
+						}
+						if field, ok := existsMap["field"].(string); !ok || field == "" {
+							return &validationError{
+								status:  http.StatusBadRequest,
+								message: "exists query must specify a non-empty field",
+							}
+						}
 					case "bool":
 						boolMap, ok := value.(map[string]interface{})
 						if !ok {
@@ -0,0 +1,509 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"my-indexer/index"
+)
+
+// AggregationLimits caps how many buckets aggregations may create, so a
+// high-cardinality field can't exhaust memory
+type AggregationLimits struct {
+	MaxBuckets      int // Maximum buckets per aggregation; 0 means no limit
+	MaxTotalBuckets int // Maximum buckets across all aggregations of a request; 0 means no limit
+}
+
+// DefaultAggregationLimits matches Elasticsearch's default search.max_buckets
+var DefaultAggregationLimits = AggregationLimits{
+	MaxBuckets:      65536,
+	MaxTotalBuckets: 65536,
+}
+
+// TooManyBucketsError is returned when an aggregation would create more
+// buckets than allowed
+type TooManyBucketsError struct {
+	Aggregation string // Aggregation that hit the limit
+	Limit       int    // Limit that was exceeded
+	Total       bool   // Whether the per-request limit was exceeded
+}
+
+// Error implements the error interface
+func (e *TooManyBucketsError) Error() string {
+	scope := fmt.Sprintf("aggregation [%s]", e.Aggregation)
+	if e.Total {
+		scope = "request"
+	}
+	return fmt.Sprintf("too_many_buckets_exception: trying to create too many buckets for %s, must be less than or equal to [%d]", scope, e.Limit)
+}
+
+// Type returns the Elasticsearch error type
+func (e *TooManyBucketsError) Type() string {
+	return "too_many_buckets_exception"
+}
+
+// BucketBudget counts the buckets created while computing the aggregations of
+// one request. Aggregations call AddBucket before creating each bucket and
+// stop as soon as it fails.
+type BucketBudget struct {
+	mu     sync.Mutex
+	limits AggregationLimits
+	perAgg map[string]int
+	total  int
+}
+
+// NewBucketBudget creates a bucket budget for one request
+func NewBucketBudget(limits AggregationLimits) *BucketBudget {
+	return &BucketBudget{
+		limits: limits,
+		perAgg: make(map[string]int),
+	}
+}
+
+// AddBucket records a new bucket for the named aggregation, returning a
+// TooManyBucketsError if it would exceed either limit
+func (b *BucketBudget) AddBucket(aggregation string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.limits.MaxBuckets > 0 && b.perAgg[aggregation] >= b.limits.MaxBuckets {
+		return &TooManyBucketsError{Aggregation: aggregation, Limit: b.limits.MaxBuckets}
+	}
+	if b.limits.MaxTotalBuckets > 0 && b.total >= b.limits.MaxTotalBuckets {
+		return &TooManyBucketsError{Aggregation: aggregation, Limit: b.limits.MaxTotalBuckets, Total: true}
+	}
+	b.perAgg[aggregation]++
+	b.total++
+	return nil
+}
+
+// Buckets returns the number of buckets created so far across all aggregations
+func (b *BucketBudget) Buckets() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total
+}
+
+// SetAggregationLimits sets the bucket limits applied to each request
+func (s *Search) SetAggregationLimits(limits AggregationLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aggLimits = limits
+}
+
+// AggregationLimits returns the bucket limits applied to each request
+func (s *Search) AggregationLimits() AggregationLimits {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.aggLimits
+}
+
+// NewBucketBudget creates a bucket budget for one request using the
+// configured limits
+func (s *Search) NewBucketBudget() *BucketBudget {
+	return NewBucketBudget(s.AggregationLimits())
+}
+
+// Aggregation computes a summary of the documents matching a query
+type Aggregation interface {
+	// Aggregate computes the aggregation, registered under name, over the
+	// given documents. The result is rendered as JSON in the response.
+	Aggregate(a *Aggregator, name string, docIDs []int) (interface{}, error)
+}
+
+// Aggregator computes aggregations for one request. Field values come from
+// the index's field data and every bucket is charged to the request's budget.
+type Aggregator struct {
+	idx    *index.Index
+	budget *BucketBudget
+}
+
+// NewAggregator creates an aggregator for one request using the configured
+// aggregation limits
+func (s *Search) NewAggregator() *Aggregator {
+	return &Aggregator{idx: s.idx, budget: s.NewBucketBudget()}
+}
+
+// Aggregate computes each named aggregation over the hits of results,
+// stopping at the first that fails
+func (a *Aggregator) Aggregate(results *Results, aggs map[string]Aggregation) (map[string]interface{}, error) {
+	docIDs := make([]int, 0, len(results.hits))
+	for _, hit := range results.hits {
+		docIDs = append(docIDs, hit.DocID)
+	}
+
+	computed := make(map[string]interface{}, len(aggs))
+	for name, agg := range aggs {
+		result, err := agg.Aggregate(a, name, docIDs)
+		if err != nil {
+			return nil, err
+		}
+		computed[name] = result
+	}
+	return computed, nil
+}
+
+// FieldValues returns the values of a field in a document. Array values
+// yield one value per element.
+func (a *Aggregator) FieldValues(fd *index.FieldData, docID int) []interface{} {
+	value, ok := fd.Value(docID)
+	if !ok || value == nil {
+		return nil
+	}
+	if values, ok := value.([]interface{}); ok {
+		return values
+	}
+	return []interface{}{value}
+}
+
+// DefaultTermsSize is the number of buckets a terms aggregation returns when
+// no size is given
+const DefaultTermsSize = 10
+
+// TermsAggregation buckets documents by the distinct values of a field
+type TermsAggregation struct {
+	Field string
+	Size  int // Maximum buckets returned; DefaultTermsSize when 0
+}
+
+// TermsBucket is one distinct field value and the number of documents with it
+type TermsBucket struct {
+	Key      interface{} `json:"key"`
+	DocCount int         `json:"doc_count"`
+}
+
+// TermsResult is the result of a terms aggregation
+type TermsResult struct {
+	DocCountErrorUpperBound int           `json:"doc_count_error_upper_bound"`
+	SumOtherDocCount        int           `json:"sum_other_doc_count"` // Documents in buckets left out by size
+	Buckets                 []TermsBucket `json:"buckets"`
+}
+
+// Aggregate implements Aggregation. Buckets are ordered by document count,
+// most frequent first, with ties broken by key.
+func (t TermsAggregation) Aggregate(a *Aggregator, name string, docIDs []int) (interface{}, error) {
+	fd := a.idx.FieldData(t.Field)
+
+	counts := make(map[interface{}]int)
+	for _, docID := range docIDs {
+		// A document counts once per distinct value
+		seen := make(map[interface{}]bool)
+		for _, value := range a.FieldValues(fd, docID) {
+			if !isBucketKey(value) || seen[value] {
+				continue
+			}
+			seen[value] = true
+			if _, exists := counts[value]; !exists {
+				if err := a.budget.AddBucket(name); err != nil {
+					return nil, err
+				}
+			}
+			counts[value]++
+		}
+	}
+
+	buckets := make([]TermsBucket, 0, len(counts))
+	for key, count := range counts {
+		buckets = append(buckets, TermsBucket{Key: key, DocCount: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].DocCount != buckets[j].DocCount {
+			return buckets[i].DocCount > buckets[j].DocCount
+		}
+		return compareSortValues(buckets[i].Key, buckets[j].Key) < 0
+	})
+
+	size := t.Size
+	if size <= 0 {
+		size = DefaultTermsSize
+	}
+	result := &TermsResult{Buckets: buckets}
+	if len(buckets) > size {
+		for _, bucket := range buckets[size:] {
+			result.SumOtherDocCount += bucket.DocCount
+		}
+		result.Buckets = buckets[:size]
+	}
+	return result, nil
+}
+
+// isBucketKey reports whether a field value can key a bucket. Nested objects
+// and arrays can't.
+func isBucketKey(value interface{}) bool {
+	return value != nil && reflect.TypeOf(value).Comparable()
+}
+
+// Single-value metric aggregation types
+const (
+	MetricAvg = "avg"
+	MetricSum = "sum"
+	MetricMin = "min"
+	MetricMax = "max"
+)
+
+// MetricAggregation computes a single value from the numeric values of a
+// field. Missing and non-numeric values are skipped; each element of an array
+// value counts on its own.
+type MetricAggregation struct {
+	Metric string // One of MetricAvg, MetricSum, MetricMin or MetricMax
+	Field  string
+}
+
+// MetricResult is the result of a single-value metric aggregation. Value is
+// nil when no document has a numeric value, except for sums, which are 0.
+type MetricResult struct {
+	Value *float64 `json:"value"`
+}
+
+// Aggregate implements Aggregation
+func (m MetricAggregation) Aggregate(a *Aggregator, name string, docIDs []int) (interface{}, error) {
+	switch m.Metric {
+	case MetricAvg, MetricSum, MetricMin, MetricMax:
+	default:
+		return nil, fmt.Errorf("aggregation [%s]: unknown metric %q", name, m.Metric)
+	}
+
+	fd := a.idx.FieldData(m.Field)
+	var sum, min, max float64
+	count := 0
+	for _, docID := range docIDs {
+		for _, value := range a.FieldValues(fd, docID) {
+			f, ok := toFloat64(value)
+			if !ok {
+				continue
+			}
+			if count == 0 || f < min {
+				min = f
+			}
+			if count == 0 || f > max {
+				max = f
+			}
+			sum += f
+			count++
+		}
+	}
+
+	if count == 0 && m.Metric != MetricSum {
+		return &MetricResult{}, nil
+	}
+	value := sum
+	switch m.Metric {
+	case MetricAvg:
+		value = sum / float64(count)
+	case MetricMin:
+		value = min
+	case MetricMax:
+		value = max
+	}
+	return &MetricResult{Value: &value}, nil
+}
+
+// Calendar intervals of a date histogram
+const (
+	IntervalMinute  = "minute"
+	IntervalHour    = "hour"
+	IntervalDay     = "day"
+	IntervalWeek    = "week"
+	IntervalMonth   = "month"
+	IntervalQuarter = "quarter"
+	IntervalYear    = "year"
+)
+
+// calendarIntervalUnits maps the single-unit shorthands Elasticsearch accepts
+// for calendar intervals to their names
+var calendarIntervalUnits = map[string]string{
+	"1m": IntervalMinute,
+	"1h": IntervalHour,
+	"1d": IntervalDay,
+	"1w": IntervalWeek,
+	"1M": IntervalMonth,
+	"1q": IntervalQuarter,
+	"1y": IntervalYear,
+}
+
+// ParseCalendarInterval parses a calendar_interval such as "day" or "1d",
+// returning the interval's name
+func ParseCalendarInterval(s string) (string, error) {
+	if name, ok := calendarIntervalUnits[s]; ok {
+		return name, nil
+	}
+	switch s {
+	case IntervalMinute, IntervalHour, IntervalDay, IntervalWeek, IntervalMonth, IntervalQuarter, IntervalYear:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid calendar interval %q", s)
+}
+
+// fixedIntervalUnits maps the units of a fixed_interval to their durations
+var fixedIntervalUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+}
+
+// ParseFixedInterval parses a fixed_interval such as "12h" or "30m". Days
+// are always 24 hours long.
+func ParseFixedInterval(s string) (time.Duration, error) {
+	digits := strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	unit, ok := fixedIntervalUnits[s[len(digits):]]
+	n, err := strconv.Atoi(digits)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid fixed interval %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// DateHistogramAggregation buckets documents by the time in a field, truncated
+// to a calendar or fixed interval in UTC. Fields may hold time.Time values,
+// RFC 3339 strings or epoch milliseconds. Empty buckets between the first and
+// last are included.
+type DateHistogramAggregation struct {
+	Field            string
+	CalendarInterval string        // One of the Interval names; weeks start on Monday
+	FixedInterval    time.Duration // Used when CalendarInterval is empty; aligned to the Unix epoch
+}
+
+// DateHistogramBucket is the number of documents in one interval
+type DateHistogramBucket struct {
+	KeyAsString string `json:"key_as_string"`
+	Key         int64  `json:"key"` // Start of the interval in epoch milliseconds
+	DocCount    int    `json:"doc_count"`
+}
+
+// DateHistogramResult is the result of a date histogram aggregation
+type DateHistogramResult struct {
+	Buckets []DateHistogramBucket `json:"buckets"`
+}
+
+// Aggregate implements Aggregation
+func (h DateHistogramAggregation) Aggregate(a *Aggregator, name string, docIDs []int) (interface{}, error) {
+	if h.CalendarInterval == "" && h.FixedInterval < time.Millisecond {
+		return nil, fmt.Errorf("aggregation [%s]: a calendar interval or fixed interval of at least 1ms is required", name)
+	}
+	if h.CalendarInterval != "" {
+		interval, err := ParseCalendarInterval(h.CalendarInterval)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation [%s]: %v", name, err)
+		}
+		h.CalendarInterval = interval
+	}
+
+	fd := a.idx.FieldData(h.Field)
+	counts := make(map[int64]int)
+	var first, last time.Time
+	for _, docID := range docIDs {
+		// A document counts once per interval
+		seen := make(map[int64]bool)
+		for _, value := range a.FieldValues(fd, docID) {
+			t, ok := toTime(value)
+			if !ok {
+				continue
+			}
+			start := h.intervalStart(t)
+			key := start.UnixMilli()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if len(counts) == 0 || start.Before(first) {
+				first = start
+			}
+			if len(counts) == 0 || start.After(last) {
+				last = start
+			}
+			counts[key]++
+		}
+	}
+
+	result := &DateHistogramResult{Buckets: make([]DateHistogramBucket, 0, len(counts))}
+	if len(counts) == 0 {
+		return result, nil
+	}
+	for start := first; !start.After(last); start = h.nextInterval(start) {
+		if err := a.budget.AddBucket(name); err != nil {
+			return nil, err
+		}
+		result.Buckets = append(result.Buckets, DateHistogramBucket{
+			KeyAsString: start.Format("2006-01-02T15:04:05.000Z"),
+			Key:         start.UnixMilli(),
+			DocCount:    counts[start.UnixMilli()],
+		})
+	}
+	return result, nil
+}
+
+// intervalStart truncates a time to the start of its interval in UTC
+func (h DateHistogramAggregation) intervalStart(t time.Time) time.Time {
+	t = t.UTC()
+	if h.CalendarInterval == "" {
+		size := h.FixedInterval.Milliseconds()
+		ms := t.UnixMilli()
+		start := ms - ms%size
+		if ms%size < 0 {
+			start -= size
+		}
+		return time.UnixMilli(start).UTC()
+	}
+
+	year, month, day := t.Date()
+	switch h.CalendarInterval {
+	case IntervalMinute:
+		return t.Truncate(time.Minute)
+	case IntervalHour:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, time.UTC)
+	case IntervalWeek:
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, time.UTC)
+	case IntervalMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	case IntervalQuarter:
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	case IntervalYear:
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// nextInterval returns the start of the interval following the one starting
+// at start
+func (h DateHistogramAggregation) nextInterval(start time.Time) time.Time {
+	switch h.CalendarInterval {
+	case "":
+		return start.Add(h.FixedInterval)
+	case IntervalMinute:
+		return start.Add(time.Minute)
+	case IntervalHour:
+		return start.Add(time.Hour)
+	case IntervalWeek:
+		return start.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return start.AddDate(0, 1, 0)
+	case IntervalQuarter:
+		return start.AddDate(0, 3, 0)
+	case IntervalYear:
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// toTime converts a date field value to a time: a time.Time, an RFC 3339
+// string or a number of epoch milliseconds
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		parsed, err := time.Parse(time.RFC3339, t)
+		return parsed, err == nil
+	}
+	if ms, ok := toFloat64(v); ok {
+		return time.UnixMilli(int64(ms)), true
+	}
+	return time.Time{}, false
+}
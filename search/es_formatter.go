@@ -1,23 +1,34 @@
 package search
 
 import (
+	"my-indexer/elastic"
+	"path"
 	"time"
 )
 
 // ESResponse represents an ElasticSearch-compatible response
 type ESResponse struct {
-	Took     int        `json:"took"`
-	TimedOut bool       `json:"timed_out"`
-	Shards   ESShards   `json:"_shards"`
-	Hits     ESHits     `json:"hits"`
+	Took            int                    `json:"took"`
+	TimedOut        bool                   `json:"timed_out"`
+	TerminatedEarly bool                   `json:"terminated_early,omitempty"`
+	Shards          ESShards               `json:"_shards"`
+	Hits            ESHits                 `json:"hits"`
+	Aggregations    map[string]interface{} `json:"aggregations,omitempty"`
 }
 
 // ESShards represents shard information in an ES response
 type ESShards struct {
-	Total      int `json:"total"`
-	Successful int `json:"successful"`
-	Skipped    int `json:"skipped"`
-	Failed     int `json:"failed"`
+	Total      int              `json:"total"`
+	Successful int              `json:"successful"`
+	Skipped    int              `json:"skipped"`
+	Failed     int              `json:"failed"`
+	Failures   []ESShardFailure `json:"failures,omitempty"`
+}
+
+// ESShardFailure describes a failed shard in an ES response
+type ESShardFailure struct {
+	Index  string `json:"index"`
+	Reason string `json:"reason"`
 }
 
 // ESHits represents the hits section of an ES response
@@ -35,14 +46,54 @@ type ESTotal struct {
 
 // ESHit represents a single hit in an ES response
 type ESHit struct {
-	Index  string                 `json:"_index"`
-	ID     string                 `json:"_id"`
-	Score  float64               `json:"_score"`
-	Source map[string]interface{} `json:"_source"`
+	Index   string                 `json:"_index"`
+	ID      string                 `json:"_id"`
+	Score   float64               `json:"_score"`
+	Version *int64                 `json:"_version,omitempty"`
+	SeqNo   *int64                 `json:"_seq_no,omitempty"`
+	Source  map[string]interface{} `json:"_source,omitempty"`
+	Highlight map[string][]string  `json:"highlight,omitempty"`
+}
+
+// ResponseOptions controls optional parts of a formatted search response
+type ResponseOptions struct {
+	Version bool         // Include _version and _seq_no in each hit
+	Source  SourceFilter // Fields of each hit's _source to return
+}
+
+// SourceFilter selects the fields returned in each hit's _source. Patterns
+// may use * wildcards. A field matching both lists is excluded.
+type SourceFilter struct {
+	Disabled bool     // Omit _source entirely
+	Includes []string // Fields to return; empty returns every field
+	Excludes []string // Fields to leave out
+}
+
+// keep reports whether a field passes the filter
+func (f SourceFilter) keep(field string) bool {
+	if matchesAnyPattern(f.Excludes, field) {
+		return false
+	}
+	return len(f.Includes) == 0 || matchesAnyPattern(f.Includes, field)
+}
+
+// matchesAnyPattern reports whether a field name matches one of the patterns
+func matchesAnyPattern(patterns []string, field string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, field); err == nil && matched {
+			return true
+		}
+	}
+	return false
 }
 
 // FormatESResponse formats search results into an ElasticSearch-compatible response
 func FormatESResponse(results *Results, took time.Duration, index string) *ESResponse {
+	return FormatESResponseWithOptions(results, took, index, ResponseOptions{})
+}
+
+// FormatESResponseWithOptions formats search results using the given response options
+func FormatESResponseWithOptions(results *Results, took time.Duration, index string, opts ResponseOptions) *ESResponse {
 	hits := make([]ESHit, 0, len(results.hits))
 	var maxScore float64
 
@@ -51,33 +102,64 @@ func FormatESResponse(results *Results, took time.Duration, index string) *ESRes
 			maxScore = hit.Score
 		}
 
-		// Convert document fields to map
-		source := make(map[string]interface{})
-		for name, field := range hit.Source.GetFields() {
-			source[name] = field.Value
+		// Convert the fields passing the source filter to a map
+		var source map[string]interface{}
+		if !opts.Source.Disabled {
+			source = make(map[string]interface{})
+			for name, field := range hit.Source.GetFields() {
+				if opts.Source.keep(name) {
+					source[name] = field.Value
+				}
+			}
+		}
+
+		// Hits from a multi-index search carry their own index name
+		hitIndex := hit.Index
+		if hitIndex == "" {
+			hitIndex = index
 		}
 
-		hits = append(hits, ESHit{
-			Index:  index,
+		esHit := ESHit{
+			Index:  hitIndex,
 			ID:     hit.ID,
 			Score:  hit.Score,
 			Source: source,
-		})
+			Highlight: hit.Highlight,
+		}
+		if opts.Version {
+			version, seqNo := hit.Version, hit.SeqNo
+			esHit.Version = &version
+			esHit.SeqNo = &seqNo
+		}
+		hits = append(hits, esHit)
+	}
+
+	shardStats := results.Shards()
+	shards := ESShards{
+		Total:      shardStats.Total,
+		Successful: shardStats.Successful,
+		Skipped:    0,
+		Failed:     shardStats.Failed,
+	}
+	for _, failure := range shardStats.Failures {
+		shards.Failures = append(shards.Failures, ESShardFailure{Index: failure.Index, Reason: failure.Reason})
+	}
+
+	total, lowerBound := results.TotalHits()
+	relation := elastic.TotalRelationEq
+	if lowerBound {
+		relation = elastic.TotalRelationGte
 	}
 
 	return &ESResponse{
-		Took:     int(took.Milliseconds()),
-		TimedOut: false,
-		Shards: ESShards{
-			Total:      1,
-			Successful: 1,
-			Skipped:    0,
-			Failed:     0,
-		},
+		Took:            int(took.Milliseconds()),
+		TimedOut:        false,
+		TerminatedEarly: results.terminatedEarly,
+		Shards:          shards,
 		Hits: ESHits{
 			Total: ESTotal{
-				Value:    len(hits),
-				Relation: "eq",
+				Value:    total,
+				Relation: string(relation),
 			},
 			MaxScore: maxScore,
 			Hits:     hits,
@@ -0,0 +1,233 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"my-indexer/analysis"
+	"my-indexer/query"
+)
+
+// Default tags wrapped around highlighted terms
+const (
+	DefaultHighlightPreTag  = "<em>"
+	DefaultHighlightPostTag = "</em>"
+)
+
+// HighlightOptions configures search result highlighting
+type HighlightOptions struct {
+	Fields       []string // Fields to highlight; patterns may use * wildcards
+	PreTag       string   // Inserted before each matching term; defaults to <em>
+	PostTag      string   // Inserted after each matching term; defaults to </em>
+	FragmentSize int      // Approximate fragment length in bytes; 0 returns the whole value
+}
+
+// Highlighter wraps the query terms found in a field value in highlight tags.
+// Values are analyzed with the field's analyzer, so a term is marked wherever
+// the index would have matched it, using the tokens' byte offsets.
+type Highlighter struct {
+	analyzer     analysis.Analyzer
+	terms        map[string]bool
+	preTag       string
+	postTag      string
+	fragmentSize int
+}
+
+// NewHighlighter creates a Highlighter marking the given analyzed terms
+func NewHighlighter(analyzer analysis.Analyzer, terms []string, opts HighlightOptions) *Highlighter {
+	h := &Highlighter{
+		analyzer:     analyzer,
+		terms:        make(map[string]bool, len(terms)),
+		preTag:       opts.PreTag,
+		postTag:      opts.PostTag,
+		fragmentSize: opts.FragmentSize,
+	}
+	if h.preTag == "" {
+		h.preTag = DefaultHighlightPreTag
+	}
+	if h.postTag == "" {
+		h.postTag = DefaultHighlightPostTag
+	}
+	for _, term := range terms {
+		h.terms[term] = true
+	}
+	return h
+}
+
+// Highlight returns the fragments of value that contain query terms, with the
+// terms wrapped in the highlight tags. It returns nil when no term matches.
+func (h *Highlighter) Highlight(value string) []string {
+	matches := h.matchSpans(value)
+	if len(matches) == 0 {
+		return nil
+	}
+	if h.fragmentSize <= 0 || len(value) <= h.fragmentSize {
+		return []string{h.wrap(value, 0, len(value), matches)}
+	}
+
+	var fragments []string
+	for i := 0; i < len(matches); {
+		start := wordStart(value, matches[i].StartByte)
+		end := wordEnd(value, start+h.fragmentSize)
+		if end < matches[i].EndByte {
+			end = matches[i].EndByte
+		}
+
+		j := i
+		for j < len(matches) && matches[j].EndByte <= end {
+			j++
+		}
+		fragments = append(fragments, h.wrap(value, start, end, matches[i:j]))
+		i = j
+	}
+	return fragments
+}
+
+// matchSpans returns the tokens of value matching a query term, ordered by
+// offset. Tokens sharing a span, such as synonyms, are only marked once.
+func (h *Highlighter) matchSpans(value string) []analysis.Token {
+	var matches []analysis.Token
+	for _, token := range h.analyzer.Analyze(value) {
+		if h.terms[token.Text] && token.EndByte > token.StartByte {
+			matches = append(matches, token)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].StartByte != matches[j].StartByte {
+			return matches[i].StartByte < matches[j].StartByte
+		}
+		return matches[i].EndByte > matches[j].EndByte
+	})
+
+	kept := matches[:0]
+	end := 0
+	for _, match := range matches {
+		if match.StartByte < end {
+			continue
+		}
+		kept = append(kept, match)
+		end = match.EndByte
+	}
+	return kept
+}
+
+// wrap returns value[start:end] with each match wrapped in the highlight tags
+func (h *Highlighter) wrap(value string, start, end int, matches []analysis.Token) string {
+	var b strings.Builder
+	pos := start
+	for _, match := range matches {
+		b.WriteString(value[pos:match.StartByte])
+		b.WriteString(h.preTag)
+		b.WriteString(value[match.StartByte:match.EndByte])
+		b.WriteString(h.postTag)
+		pos = match.EndByte
+	}
+	b.WriteString(value[pos:end])
+	return b.String()
+}
+
+// wordStart moves a byte offset back to the start of the word containing it
+func wordStart(value string, offset int) int {
+	for offset > 0 {
+		r, size := utf8.DecodeLastRuneInString(value[:offset])
+		if unicode.IsSpace(r) {
+			break
+		}
+		offset -= size
+	}
+	return offset
+}
+
+// wordEnd moves a byte offset forward to the end of the word containing it,
+// so fragments don't cut words in half
+func wordEnd(value string, offset int) int {
+	if offset >= len(value) {
+		return len(value)
+	}
+	for offset > 0 && !utf8.RuneStart(value[offset]) {
+		offset--
+	}
+	for offset < len(value) {
+		r, size := utf8.DecodeRuneInString(value[offset:])
+		if unicode.IsSpace(r) {
+			break
+		}
+		offset += size
+	}
+	return offset
+}
+
+// Highlight adds highlighted fragments of the requested fields to each hit,
+// marking the terms of q found in them. Only string fields are highlighted.
+func (s *Search) Highlight(results *Results, q query.Query, opts HighlightOptions) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	queryTerms := make(map[string][]string)
+	s.collectHighlightTerms(query.Rewrite(q), queryTerms)
+	if len(queryTerms) == 0 {
+		return
+	}
+
+	highlighters := make(map[string]*Highlighter)
+	for _, hit := range results.hits {
+		if hit.Source == nil {
+			continue
+		}
+		for name, field := range hit.Source.GetFields() {
+			value, ok := field.Value.(string)
+			if !ok || !matchesAnyPattern(opts.Fields, name) {
+				continue
+			}
+
+			h, ok := highlighters[name]
+			if !ok {
+				// Queries on no particular field apply to every field
+				terms := append(append(append([]string{}, queryTerms[name]...), queryTerms[""]...), queryTerms["_all"]...)
+				h = NewHighlighter(s.idx.FieldAnalyzer(name), terms, opts)
+				highlighters[name] = h
+			}
+
+			if fragments := h.Highlight(value); len(fragments) > 0 {
+				if hit.Highlight == nil {
+					hit.Highlight = make(map[string][]string)
+				}
+				hit.Highlight[name] = fragments
+			}
+		}
+	}
+}
+
+// collectHighlightTerms gathers the analyzed terms of q by field. Terms under
+// a bool query's must_not clauses are left out since they never match a hit.
+func (s *Search) collectHighlightTerms(q query.Query, terms map[string][]string) {
+	switch tq := q.(type) {
+	case *query.TermQueryImpl:
+		if analyzed := analysis.AnalyzeToTerms(s.idx.FieldAnalyzer(tq.Field()), tq.Term()); len(analyzed) > 0 {
+			terms[tq.Field()] = append(terms[tq.Field()], analyzed[0])
+		}
+	case *query.TermsQueryImpl:
+		for _, value := range tq.Terms() {
+			if analyzed := analysis.AnalyzeToTerms(s.idx.FieldAnalyzer(tq.Field()), value); len(analyzed) > 0 {
+				terms[tq.Field()] = append(terms[tq.Field()], analyzed[0])
+			}
+		}
+	case *query.MatchQueryImpl:
+		terms[tq.Field()] = append(terms[tq.Field()], analysis.AnalyzeToTerms(s.idx.FieldAnalyzer(tq.Field()), tq.Text())...)
+	case *query.MatchPhraseQueryImpl:
+		terms[tq.Field()] = append(terms[tq.Field()], analysis.AnalyzeToTerms(s.idx.FieldAnalyzer(tq.Field()), tq.Phrase())...)
+	case query.MultiTermQuery:
+		terms[tq.Field()] = append(terms[tq.Field()], s.idx.MatchingTerms(tq.MatchTerm)...)
+	case *query.ConstantScoreQueryImpl:
+		s.collectHighlightTerms(tq.Filter(), terms)
+	case *query.BooleanQueryImpl:
+		for _, clause := range tq.Must() {
+			s.collectHighlightTerms(clause, terms)
+		}
+		for _, clause := range tq.Should() {
+			s.collectHighlightTerms(clause, terms)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package search
+
+import (
+	"sort"
+
+	"my-indexer/index"
+)
+
+// docIDIterator walks an ascending list of document IDs
+type docIDIterator struct {
+	docIDs []int
+	pos    int
+}
+
+// newDocIDIterator returns an iterator over docIDs, which must be sorted
+func newDocIDIterator(docIDs []int) *docIDIterator {
+	return &docIDIterator{docIDs: docIDs}
+}
+
+// done reports whether the iterator is exhausted
+func (it *docIDIterator) done() bool {
+	return it.pos >= len(it.docIDs)
+}
+
+// docID returns the current document ID
+func (it *docIDIterator) docID() int {
+	return it.docIDs[it.pos]
+}
+
+// advance moves to the first document ID not below target, galloping ahead
+// in doubling steps and then binary searching the last step, so skipping far
+// ahead costs O(log distance). It reports whether such an ID exists.
+func (it *docIDIterator) advance(target int) bool {
+	if it.done() || it.docID() >= target {
+		return !it.done()
+	}
+
+	lo, step := it.pos, 1
+	for lo+step < len(it.docIDs) && it.docIDs[lo+step] < target {
+		lo += step
+		step *= 2
+	}
+	hi := lo + step
+	if hi > len(it.docIDs) {
+		hi = len(it.docIDs)
+	}
+	it.pos = lo + sort.SearchInts(it.docIDs[lo:hi], target)
+	return !it.done()
+}
+
+// intersectDocIDs streams the document IDs common to all of the sorted
+// lists to fn, in ascending order, until fn returns false. The shortest list
+// drives the intersection; the others are advanced to each of its IDs.
+func intersectDocIDs(lists [][]int, fn func(docID int) bool) {
+	if len(lists) == 0 {
+		return
+	}
+
+	iters := make([]*docIDIterator, len(lists))
+	for i, docIDs := range lists {
+		iters[i] = newDocIDIterator(docIDs)
+	}
+	sort.Slice(iters, func(i, j int) bool { return len(iters[i].docIDs) < len(iters[j].docIDs) })
+
+	lead, others := iters[0], iters[1:]
+	for !lead.done() {
+		candidate := lead.docID()
+		matched := true
+		for _, it := range others {
+			if !it.advance(candidate) {
+				return
+			}
+			if it.docID() != candidate {
+				// Skip the lead ahead to the next possible match
+				matched = false
+				if !lead.advance(it.docID()) {
+					return
+				}
+				break
+			}
+		}
+		if matched {
+			if !fn(candidate) {
+				return
+			}
+			lead.pos++
+		}
+	}
+}
+
+// forEachPosting calls fn with the posting entries of the documents in
+// candidates, or with every entry when candidates is nil. Candidates are
+// looked up one by one, so a short candidate list skips the rest of a long
+// posting list.
+func forEachPosting(postings map[int]*index.PostingEntry, candidates []int, fn func(docID int, posting *index.PostingEntry)) {
+	if candidates == nil {
+		for docID, posting := range postings {
+			fn(docID, posting)
+		}
+		return
+	}
+	for _, docID := range candidates {
+		if posting, ok := postings[docID]; ok {
+			fn(docID, posting)
+		}
+	}
+}
+
+// keepCommonHits returns the hits whose document IDs are common to all of the
+// sorted lists, in their original order
+func keepCommonHits(hits []*Result, lists [][]int) []*Result {
+	var common []int
+	intersectDocIDs(lists, func(docID int) bool {
+		common = append(common, docID)
+		return true
+	})
+
+	kept := make([]*Result, 0, len(common))
+	for _, hit := range hits {
+		if i := sort.SearchInts(common, hit.DocID); i < len(common) && common[i] == hit.DocID {
+			kept = append(kept, hit)
+		}
+	}
+	return kept
+}
+
+// sortedHitIDs returns the document IDs of results in ascending order
+func sortedHitIDs(results *Results) []int {
+	docIDs := make([]int, len(results.hits))
+	for i, hit := range results.hits {
+		docIDs[i] = hit.DocID
+	}
+	sort.Ints(docIDs)
+	return docIDs
+}
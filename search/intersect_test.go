@@ -0,0 +1,165 @@
+package search
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"my-indexer/analysis"
+	"my-indexer/document"
+	"my-indexer/index"
+)
+
+// randomDocIDs returns n distinct sorted document IDs below max
+func randomDocIDs(r *rand.Rand, n, max int) []int {
+	seen := make(map[int]bool, n)
+	for len(seen) < n {
+		seen[r.Intn(max)] = true
+	}
+	docIDs := make([]int, 0, n)
+	for docID := range seen {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	return docIDs
+}
+
+// mapIntersect intersects document ID lists through maps, like must clauses
+// were executed before the streaming intersection
+func mapIntersect(lists [][]int) []int {
+	result := lists[0]
+	for _, list := range lists[1:] {
+		inList := make(map[int]bool, len(list))
+		for _, docID := range list {
+			inList[docID] = true
+		}
+		kept := make([]int, 0)
+		for _, docID := range result {
+			if inList[docID] {
+				kept = append(kept, docID)
+			}
+		}
+		result = kept
+	}
+	return result
+}
+
+func TestIntersectDocIDs(t *testing.T) {
+	tests := []struct {
+		name  string
+		lists [][]int
+		want  []int
+	}{
+		{"Overlapping", [][]int{{1, 3, 5, 7, 9}, {3, 4, 5, 9, 10}}, []int{3, 5, 9}},
+		{"Three lists", [][]int{{1, 2, 3, 4, 5, 6}, {2, 4, 6}, {4, 5, 6}}, []int{4, 6}},
+		{"Disjoint", [][]int{{1, 2, 3}, {4, 5, 6}}, nil},
+		{"Empty list", [][]int{{1, 2, 3}, {}}, nil},
+		{"Single list", [][]int{{2, 8}}, []int{2, 8}},
+		{"Far apart", [][]int{{0, 500, 1000, 100000}, {1000, 100000}}, []int{1000, 100000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			intersectDocIDs(tt.lists, func(docID int) bool {
+				got = append(got, docID)
+				return true
+			})
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	// Random lists agree with the map-based intersection
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		lists := [][]int{
+			randomDocIDs(r, 1+r.Intn(200), 1000),
+			randomDocIDs(r, 1+r.Intn(200), 1000),
+			randomDocIDs(r, 1+r.Intn(500), 1000),
+		}
+		var got []int
+		intersectDocIDs(lists, func(docID int) bool {
+			got = append(got, docID)
+			return true
+		})
+		if want := mapIntersect(lists); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+	}
+
+	// Returning false stops the intersection
+	var got []int
+	intersectDocIDs([][]int{{1, 2, 3}, {1, 2, 3}}, func(docID int) bool {
+		got = append(got, docID)
+		return len(got) < 2
+	})
+	if len(got) != 2 {
+		t.Errorf("Expected the intersection to stop after 2 IDs, got %v", got)
+	}
+}
+
+func TestIntersectPostingLists(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	for i := 0; i < 30; i++ {
+		doc := document.NewDocument()
+		title := "common"
+		if i%2 == 0 {
+			title += " even"
+		}
+		if i%3 == 0 {
+			title += " triple"
+		}
+		doc.AddField("title", title)
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	var lists [][]int
+	for _, term := range []string{"common", "even", "triple"} {
+		pl, err := idx.GetPostingList(term)
+		if err != nil {
+			t.Fatalf("Failed to get posting list for %q: %v", term, err)
+		}
+		docIDs := pl.SortedDocIDs()
+		if !sort.IntsAreSorted(docIDs) || len(docIDs) != pl.DocFreq {
+			t.Fatalf("Expected %d sorted IDs for %q, got %v", pl.DocFreq, term, docIDs)
+		}
+		lists = append(lists, docIDs)
+	}
+
+	var got []int
+	intersectDocIDs(lists, func(docID int) bool {
+		got = append(got, docID)
+		return true
+	})
+	if want := "[0 6 12 18 24]"; fmt.Sprint(got) != want {
+		t.Errorf("Expected documents %s, got %v", want, got)
+	}
+}
+
+func BenchmarkIntersect(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	lists := [][]int{
+		randomDocIDs(r, 100000, 1000000),
+		randomDocIDs(r, 1000, 1000000),
+	}
+
+	b.Run("Map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mapIntersect(lists)
+		}
+	})
+
+	b.Run("Galloping", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			count := 0
+			intersectDocIDs(lists, func(docID int) bool {
+				count++
+				return true
+			})
+		}
+	})
+}
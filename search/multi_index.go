@@ -0,0 +1,124 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+
+	"my-indexer/query"
+)
+
+// SearchIndices executes a query against several named indices and merges the
+// hits into a single ranking. Scores of hits from an index listed in
+// indicesBoost are multiplied by its boost before the merge. An index whose
+// search fails is reported as failed shards and the hits of the remaining
+// indices are still returned; an error is returned only if every index fails.
+// The total is tracked over the merged hits rather than per index.
+func SearchIndices(targets map[string]*Search, q query.Query, indicesBoost map[string]float64, opts QueryOptions) (*Results, error) {
+	// Visit indices in a stable order so ties merge deterministically
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	perIndex := opts
+	perIndex.TrackTotalHits = 0
+
+	merged := &Results{hits: make([]*Result, 0)}
+	var firstErr error
+	for _, name := range names {
+		shardCount := targets[name].ShardCount()
+		merged.shards.Total += shardCount
+
+		results, err := targets[name].SearchWithQueryOptions(q, perIndex)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("search on index %s failed: %w", name, err)
+			}
+			merged.shards.Failed += shardCount
+			merged.shards.Failures = append(merged.shards.Failures, ShardFailure{Index: name, Reason: err.Error()})
+			continue
+		}
+		merged.shards.Successful += shardCount
+		merged.terminatedEarly = merged.terminatedEarly || results.terminatedEarly
+
+		boost, boosted := indicesBoost[name]
+		for _, hit := range results.hits {
+			hit.Index = name
+			if boosted {
+				hit.Score *= boost
+			}
+			merged.hits = append(merged.hits, hit)
+		}
+	}
+
+	if firstErr != nil && merged.shards.Successful == 0 {
+		return nil, firstErr
+	}
+
+	sort.Stable(merged)
+	opts.trackTotal(merged, len(merged.hits))
+	return merged, nil
+}
+
+// HighlightIndices highlights the hits of a multi-index search, each with the
+// analyzers of the index it came from
+func HighlightIndices(targets map[string]*Search, results *Results, q query.Query, opts HighlightOptions) {
+	byIndex := make(map[string]*Results)
+	for _, hit := range results.hits {
+		if _, ok := targets[hit.Index]; !ok {
+			continue
+		}
+		if byIndex[hit.Index] == nil {
+			byIndex[hit.Index] = &Results{}
+		}
+		byIndex[hit.Index].hits = append(byIndex[hit.Index].hits, hit)
+	}
+	for name, hits := range byIndex {
+		targets[name].Highlight(hits, q, opts)
+	}
+}
+
+// ParseIndicesBoost parses the indices_boost search option. Both the array
+// form [{"index-a": 2.0}] and the legacy object form {"index-a": 2.0} are
+// accepted.
+func ParseIndicesBoost(raw interface{}) (map[string]float64, error) {
+	boosts := make(map[string]float64)
+
+	addBoosts := func(entry map[string]interface{}) error {
+		for name, value := range entry {
+			boost, ok := value.(float64)
+			if !ok {
+				return fmt.Errorf("indices_boost value for %s must be a number", name)
+			}
+			if boost < 0 {
+				return fmt.Errorf("indices_boost value for %s must not be negative", name)
+			}
+			boosts[name] = boost
+		}
+		return nil
+	}
+
+	switch v := raw.(type) {
+	case nil:
+		return boosts, nil
+	case []interface{}:
+		for _, item := range v {
+			entry, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("indices_boost entries must be objects")
+			}
+			if err := addBoosts(entry); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		if err := addBoosts(v); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("indices_boost must be an array or object")
+	}
+
+	return boosts, nil
+}
@@ -0,0 +1,137 @@
+package search
+
+import (
+	"encoding/json"
+	"testing"
+
+	"my-indexer/analysis"
+	"my-indexer/document"
+	"my-indexer/index"
+	"my-indexer/query"
+)
+
+// newTestSearch builds a Search over an index containing the given titles
+func newTestSearch(t *testing.T, titles ...string) *Search {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	for _, title := range titles {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+	return NewSearch(idx, store)
+}
+
+func TestSearchIndicesBoost(t *testing.T) {
+	targets := map[string]*Search{
+		"index-a": newTestSearch(t, "go search"),
+		"index-b": newTestSearch(t, "search search search"),
+	}
+	q := query.NewTermQuery("title", "search")
+
+	// Without a boost index-b ranks first on term frequency
+	results, err := SearchIndices(targets, q, nil, QueryOptions{})
+	if err != nil {
+		t.Fatalf("SearchIndices failed: %v", err)
+	}
+	hits := results.GetHits()
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits, got %d", len(hits))
+	}
+	if hits[0].Index != "index-b" {
+		t.Fatalf("Expected index-b to rank first without boost, got %s", hits[0].Index)
+	}
+	unboosted := hits[1].Score
+
+	// Boosting index-a moves its hit to the top
+	var raw interface{}
+	json.Unmarshal([]byte(`[{"index-a": 10.0}]`), &raw)
+	boosts, err := ParseIndicesBoost(raw)
+	if err != nil {
+		t.Fatalf("ParseIndicesBoost failed: %v", err)
+	}
+
+	results, err = SearchIndices(targets, q, boosts, QueryOptions{})
+	if err != nil {
+		t.Fatalf("SearchIndices failed: %v", err)
+	}
+	hits = results.GetHits()
+	if hits[0].Index != "index-a" {
+		t.Errorf("Expected boosted index-a to rank first, got %s", hits[0].Index)
+	}
+	if hits[0].Score != unboosted*10 {
+		t.Errorf("Expected boosted score %f, got %f", unboosted*10, hits[0].Score)
+	}
+}
+
+func TestSearchIndicesPartialFailure(t *testing.T) {
+	broken := newTestSearch(t, "search engine")
+	broken.store = newMockStore() // Documents can no longer be loaded
+	targets := map[string]*Search{
+		"index-a": newTestSearch(t, "go search"),
+		"index-b": broken,
+	}
+
+	results, err := SearchIndices(targets, query.NewTermQuery("title", "search"), nil, QueryOptions{})
+	if err != nil {
+		t.Fatalf("SearchIndices failed: %v", err)
+	}
+
+	resp := FormatESResponse(results, 0, "")
+	if resp.Shards.Total != 2 || resp.Shards.Successful != 1 || resp.Shards.Failed != 1 {
+		t.Errorf("Expected 2 total, 1 successful and 1 failed shard, got %+v", resp.Shards)
+	}
+	if len(resp.Shards.Failures) != 1 || resp.Shards.Failures[0].Index != "index-b" {
+		t.Errorf("Expected a shard failure for index-b, got %+v", resp.Shards.Failures)
+	}
+	if len(resp.Hits.Hits) != 1 || resp.Hits.Hits[0].Index != "index-a" {
+		t.Errorf("Expected the partial hit from index-a, got %+v", resp.Hits.Hits)
+	}
+
+	// With every index failing the search reports an error
+	if _, err := SearchIndices(map[string]*Search{"index-b": broken}, query.NewTermQuery("title", "search"), nil, QueryOptions{}); err == nil {
+		t.Error("Expected an error when every index fails")
+	}
+}
+
+func TestParseIndicesBoost(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"Array form", `[{"a": 2.0}, {"b": 1.5}]`, map[string]float64{"a": 2.0, "b": 1.5}, false},
+		{"Object form", `{"a": 3}`, map[string]float64{"a": 3}, false},
+		{"Non-numeric boost", `[{"a": "high"}]`, nil, true},
+		{"Invalid type", `"a"`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := json.Unmarshal([]byte(tt.input), &raw); err != nil {
+				t.Fatalf("invalid test input: %v", err)
+			}
+			got, err := ParseIndicesBoost(raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseIndicesBoost() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseIndicesBoost() = %v, want %v", got, tt.want)
+			}
+			for name, boost := range tt.want {
+				if got[name] != boost {
+					t.Errorf("boost for %s = %f, want %f", name, got[name], boost)
+				}
+			}
+		})
+	}
+}
@@ -2,20 +2,34 @@ package search
 
 import (
 	"fmt"
-	"math"
+	"my-indexer/analysis"
+	"my-indexer/document"
+	"my-indexer/index"
 	"my-indexer/query"
 	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
 // QueryExecutor executes internal queries and returns search results
 type QueryExecutor struct {
-	search *Search
+	search       *Search
+	opts         QueryOptions
+	collectLimit int   // Matches a leaf query may collect before stopping; 0 is unlimited
+	candidates   []int // Sorted IDs term and match queries are restricted to; nil is unrestricted
 }
 
 // NewQueryExecutor creates a new query executor
 func NewQueryExecutor(search *Search) *QueryExecutor {
+	return NewQueryExecutorWithOptions(search, QueryOptions{})
+}
+
+// NewQueryExecutorWithOptions creates a query executor using the given options
+func NewQueryExecutorWithOptions(search *Search, opts QueryOptions) *QueryExecutor {
 	return &QueryExecutor{
 		search: search,
+		opts:   opts,
 	}
 }
 
@@ -24,11 +38,62 @@ func (e *QueryExecutor) Execute(q query.Query) (*Results, error) {
 	e.search.mu.RLock()
 	defer e.search.mu.RUnlock()
 
+	return e.run(query.Rewrite(q))
+}
+
+// run executes a rewritten query, applying the executor's options to its
+// results
+// Note: Caller must hold read lock
+func (e *QueryExecutor) run(q query.Query) (*Results, error) {
+	// Leaf queries stop collecting once terminate_after matches are found.
+	// Bool queries need complete clause results, so they are cut afterwards.
+	if q.Type() != query.BooleanQuery {
+		e.collectLimit = e.opts.TerminateAfter
+		defer func() { e.collectLimit = 0 }()
+	}
+
+	results, err := e.execute(q)
+	if err != nil {
+		return nil, err
+	}
+	e.opts.applyMinScore(results)
+	if limit := e.opts.TerminateAfter; limit > 0 && len(results.hits) > limit {
+		results.hits = results.hits[:limit]
+		results.terminatedEarly = true
+	}
+
+	// Every match is scored, so the best hits are held when the search is
+	// bounded
+	matches := len(results.hits)
+	if held := e.opts.heldHits(); held > 0 && matches > held {
+		sort.Sort(results)
+		results.hits = results.hits[:held]
+	}
+	e.opts.trackTotal(results, matches)
+	results.shards = e.search.successfulShards()
+	return results, nil
+}
+
+// limitReached reports whether results already hold as many matches as may be
+// collected, recording that collection stopped when another match is found
+func (e *QueryExecutor) limitReached(results *Results) bool {
+	if e.collectLimit > 0 && len(results.hits) >= e.collectLimit {
+		results.terminatedEarly = true
+		return true
+	}
+	return false
+}
+
+// execute dispatches a query to the executor for its type
+// Note: Caller must hold read lock
+func (e *QueryExecutor) execute(q query.Query) (*Results, error) {
 	// Handle different query types
 	switch q.Type() {
 	case query.TermQuery:
 		return e.executeTermQuery(q)
-	case query.PhraseQuery:
+	case query.TermsQuery:
+		return e.executeTermsQuery(q)
+	case query.PhraseQuery, query.MatchPhraseQuery:
 		return e.executePhraseQuery(q)
 	case query.RangeQuery:
 		return e.executeRangeQuery(q)
@@ -36,6 +101,16 @@ func (e *QueryExecutor) Execute(q query.Query) (*Results, error) {
 		return e.executeBooleanQuery(q)
 	case query.MatchQuery:
 		return e.executeMatchQuery(q)
+	case query.MatchAllQuery:
+		return e.executeMatchAllQuery()
+	case query.ExistsQuery:
+		return e.executeExistsQuery(q)
+	case query.ConstantScoreQuery:
+		return e.executeConstantScoreQuery(q)
+	case query.IdsQuery:
+		return e.executeIdsQuery(q)
+	case query.PrefixQuery, query.WildcardQuery, query.FuzzyQuery:
+		return e.executeMultiTermQuery(q)
 	default:
 		return nil, fmt.Errorf("unsupported query type: %v", q.Type())
 	}
@@ -48,51 +123,55 @@ func (e *QueryExecutor) executeTermQuery(q query.Query) (*Results, error) {
 		return nil, fmt.Errorf("invalid term query type")
 	}
 
-	// Get the analyzer from the search instance
-	tokens := e.search.idx.Analyzer().Analyze(tq.Term())
-	if len(tokens) == 0 {
+	// Normalize the term exactly as the index did
+	terms := e.search.termQueryTerms(tq)
+	if len(terms) == 0 {
 		return &Results{hits: make([]*Result, 0)}, nil
 	}
 	
-	// Use the first token's text as our search term
-	term := tokens[0].Text
+	// Use the first term as our search term
+	term := terms[0]
 	
-	// Get posting list for the term
-	postings := e.search.idx.GetPostings(term)
+	// Collect the documents with the term in the specified field, reading
+	// the posting list in place
+	hits := make(map[int]termHit)
+	e.search.idx.ViewPostings(term, func(postings map[int]*index.PostingEntry) {
+		forEachPosting(postings, e.candidates, func(docID int, posting *index.PostingEntry) {
+			for _, field := range posting.Fields {
+				if field == tq.Field() {
+					hits[docID] = termHit{tf: posting.TermFreq, df: len(postings)}
+					break
+				}
+			}
+		})
+	})
 	
 	// Create results
 	results := &Results{
-		hits: make([]*Result, 0, len(postings)),
+		hits: make([]*Result, 0, len(hits)),
 	}
+	scorer := e.search.newScorer()
 
 	// Process each document
-	for docID, posting := range postings {
-		// Check if the term appears in the specified field
-		fieldFound := false
-		for _, field := range posting.Fields {
-			if field == tq.Field() {
-				fieldFound = true
-				break
-			}
-		}
-		if !fieldFound {
-			continue
-		}
-
+	for docID, hit := range hits {
 		// Load document
 		doc, err := e.search.store.LoadDocument(docID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
 		}
 
-		// Calculate score using TF-IDF
-		score := e.calculateScore(docID, []string{term})
+		// The index is case-folded, so verify case-sensitive terms against the source
+		if tq.CaseSensitive() && !containsExactWord(doc, tq.Field(), tq.Term()) {
+			continue
+		}
+		if e.limitReached(results) {
+			break
+		}
 
-		results.hits = append(results.hits, &Result{
-			ID:     fmt.Sprintf("%d", docID),
-			Score:  score,
-			Source: doc,
-		})
+		// Calculate score using BM25, scaled by the query's boost
+		score := scorer.scoreHits(docID, []termHit{hit}) * tq.Boost()
+
+		results.hits = append(results.hits, e.search.newResult(docID, score, doc))
 	}
 
 	// Sort results by score
@@ -101,25 +180,131 @@ func (e *QueryExecutor) executeTermQuery(q query.Query) (*Results, error) {
 	return results, nil
 }
 
-// executePhraseQuery executes a phrase query
+// executeTermsQuery returns the documents containing any of the query's terms
+// in its field, unioning the posting lists of the analyzed terms. Every match
+// scores 1, like a filter.
+func (e *QueryExecutor) executeTermsQuery(q query.Query) (*Results, error) {
+	tq, ok := q.(*query.TermsQueryImpl)
+	if !ok {
+		return nil, fmt.Errorf("invalid terms query type")
+	}
+
+	docIDs := e.search.termsQueryDocs(tq)
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		hits: make([]*Result, 0, len(docIDs)),
+	}
+	for _, docID := range docIDs {
+		doc, exists := docs[docID]
+		if !exists {
+			continue
+		}
+		if e.limitReached(results) {
+			break
+		}
+		results.hits = append(results.hits, e.search.newResult(docID, 1.0, doc))
+	}
+	return results, nil
+}
+
+// containsExactWord reports whether a document field contains word with its
+// original case, ignoring surrounding punctuation. Only text has case, so a
+// word without cased letters, such as a number or date matched by its
+// canonical token, is always contained.
+func containsExactWord(doc *document.Document, fieldName, word string) bool {
+	if doc == nil {
+		return false
+	}
+	if strings.ToLower(word) == strings.ToUpper(word) {
+		return true
+	}
+	field, err := doc.GetField(fieldName)
+	if err != nil {
+		return false
+	}
+	for _, text := range field.Strings() {
+		for _, w := range strings.Fields(text) {
+			if strings.TrimFunc(w, func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }) == word {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// executePhraseQuery executes a phrase query, matching documents where the
+// phrase's terms appear at consecutive positions within one field, or close
+// enough given the query's slop
 func (e *QueryExecutor) executePhraseQuery(q query.Query) (*Results, error) {
-	// For now, treat phrase queries as term queries
-	// TODO: Implement proper phrase query execution using term positions
-	return e.executeTermQuery(q)
+	pq, ok := q.(*query.MatchPhraseQueryImpl)
+	if !ok {
+		return nil, fmt.Errorf("invalid phrase query type")
+	}
+
+	docIDs, terms := e.search.phraseQueryDocs(pq)
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		hits: make([]*Result, 0, len(docIDs)),
+	}
+	scorer := e.search.newScorer()
+	for _, docID := range docIDs {
+		doc, exists := docs[docID]
+		if !exists {
+			continue
+		}
+		if e.limitReached(results) {
+			break
+		}
+
+		// Calculate score using BM25 over the phrase terms
+		score := scorer.score(docID, terms)
+
+		results.hits = append(results.hits, e.search.newResult(docID, score, doc))
+	}
+
+	// Sort results by score
+	sort.Sort(results)
+
+	return results, nil
 }
 
 // executeRangeQuery executes a range query
 func (e *QueryExecutor) executeRangeQuery(q query.Query) (*Results, error) {
-	// Get all documents and filter by range
-	results := &Results{
-		hits: make([]*Result, 0),
+	rq, ok := q.(*query.RangeQueryImpl)
+	if !ok {
+		return nil, fmt.Errorf("invalid range query type")
 	}
 
-	// Scan all documents (inefficient, but works for now)
+	// Scan every indexed document and filter by range. Document IDs need not
+	// be contiguous, so they are taken from the index rather than counted.
 	// TODO: Implement field indexing for efficient range queries
-	for docID := 0; docID < e.search.idx.GetDocumentCount(); docID++ {
-		doc, err := e.search.store.LoadDocument(docID)
-		if err != nil {
+	docIDs := make([]int, 0, e.search.idx.GetDocumentCount())
+	e.search.idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		docIDs = append(docIDs, docID)
+		return true
+	})
+	sort.Ints(docIDs)
+
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		hits: make([]*Result, 0),
+	}
+	for _, docID := range docIDs {
+		// Documents deleted since the scan are left out
+		doc, exists := docs[docID]
+		if !exists {
 			continue
 		}
 
@@ -129,64 +314,87 @@ func (e *QueryExecutor) executeRangeQuery(q query.Query) (*Results, error) {
 			continue
 		}
 
-		// Convert field value to float64 for comparison
-		var fieldValue float64
-		switch v := field.Value.(type) {
-		case int:
-			fieldValue = float64(v)
-		case int32:
-			fieldValue = float64(v)
-		case int64:
-			if v > 9223372036854775807 || v < -9223372036854775808 {
-				return nil, fmt.Errorf("int64 value %d exceeds float64 range", v)
+		// A multi-valued field matches when any of its values is in range
+		matched := false
+		for _, value := range field.Values() {
+			inRange, err := rangeContains(rq, value)
+			if err != nil {
+				return nil, err
 			}
-			fieldValue = float64(v)
-		case float32:
-			fieldValue = float64(v)
-		case float64:
-			fieldValue = v
-		case uint:
-			fieldValue = float64(v)
-		case uint32:
-			fieldValue = float64(v)
-		case uint64:
-			if v > 18446744073709551615 {
-				return nil, fmt.Errorf("uint64 value %d exceeds float64 range", v)
+			if inRange {
+				matched = true
+				break
 			}
-			fieldValue = float64(v)
-		default:
-			// Skip non-numeric fields
+		}
+		if !matched {
 			continue
 		}
 
-		rq := q.(*query.RangeQueryImpl)
-		if rq.Gt() != nil {
-			if gt, ok := rq.Gt().(float64); ok {
-				if fieldValue <= gt {
-					continue
-				}
-			} else {
-				return nil, fmt.Errorf("gt value is not a float64")
-			}
+		if e.limitReached(results) {
+			break
 		}
-		if rq.Lt() != nil {
-			if lt, ok := rq.Lt().(float64); ok {
-				if fieldValue >= lt {
-					continue
-				}
-			} else {
-				return nil, fmt.Errorf("lt value is not a float64")
+
+		// Default score for range queries
+		results.hits = append(results.hits, e.search.newResult(docID, 1.0, doc))
+	}
+
+	return results, nil
+}
+
+// rangeContains reports whether value falls within the bounds of a range
+// query. Non-numeric values never match.
+func rangeContains(rq *query.RangeQueryImpl, value interface{}) (bool, error) {
+	if _, ok := value.(time.Time); ok {
+		for _, bound := range []interface{}{rq.Gt(), rq.Gte(), rq.Lt(), rq.Lte()} {
+			if _, isDate := bound.(time.Time); bound != nil && !isDate {
+				return false, fmt.Errorf("range value %v is not a date", bound)
 			}
 		}
+		return rq.Match(value), nil
+	}
 
-		results.hits = append(results.hits, &Result{
-			ID:     fmt.Sprintf("%d", docID),
-			Score:  1.0, // Default score for range queries
-			Source: doc,
-		})
+	fieldValue, ok := toFloat64(value)
+	if !ok {
+		return false, nil
 	}
 
-	return results, nil
+	if rq.Gt() != nil {
+		if gt, ok := rq.Gt().(float64); ok {
+			if fieldValue <= gt {
+				return false, nil
+			}
+		} else {
+			return false, fmt.Errorf("gt value is not a float64")
+		}
+	}
+	if rq.Gte() != nil {
+		if gte, ok := rq.Gte().(float64); ok {
+			if fieldValue < gte {
+				return false, nil
+			}
+		} else {
+			return false, fmt.Errorf("gte value is not a float64")
+		}
+	}
+	if rq.Lt() != nil {
+		if lt, ok := rq.Lt().(float64); ok {
+			if fieldValue >= lt {
+				return false, nil
+			}
+		} else {
+			return false, fmt.Errorf("lt value is not a float64")
+		}
+	}
+	if rq.Lte() != nil {
+		if lte, ok := rq.Lte().(float64); ok {
+			if fieldValue > lte {
+				return false, nil
+			}
+		} else {
+			return false, fmt.Errorf("lte value is not a float64")
+		}
+	}
+	return true, nil
 }
 
 // executeBooleanQuery executes a boolean query
@@ -206,23 +414,67 @@ func (e *QueryExecutor) executeBooleanQuery(q query.Query) (*Results, error) {
 		}
 	}
 
-	// Execute should queries
+	// Execute should queries. Without must clauses, documents have to match
+	// the minimum number of should clauses.
 	var shouldResults *Results
 	if len(bq.Should()) > 0 {
+		minMatch := 1
+		if mustResults == nil {
+			minMatch = bq.MinimumShouldMatch()
+		}
 		var err error
-		shouldResults, err = e.executeShouldClauses(bq.Should())
+		shouldResults, err = e.executeShouldClauses(bq.Should(), minMatch)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	// If both must and should clauses are empty, return empty results
+	// unless must_not or filter clauses narrow down all documents
 	if mustResults == nil && shouldResults == nil {
-		return &Results{hits: make([]*Result, 0)}, nil
+		if len(bq.MustNot()) == 0 && len(bq.Filter()) == 0 {
+			return &Results{hits: make([]*Result, 0)}, nil
+		}
+		var err error
+		mustResults, err = e.executeMatchAllQuery()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Combine results
-	return e.combineResults(mustResults, shouldResults), nil
+	results := e.combineResults(mustResults, shouldResults)
+
+	// Keep only documents matched by every filter clause. Filters don't
+	// contribute to the score.
+	if len(bq.Filter()) > 0 {
+		filtered, err := e.executeMustClauses(bq.Filter())
+		if err != nil {
+			return nil, err
+		}
+		results.hits = keepCommonHits(results.hits, [][]int{sortedHitIDs(results), sortedHitIDs(filtered)})
+	}
+
+	// Remove documents matched by must_not clauses
+	if len(bq.MustNot()) > 0 {
+		excluded, err := e.executeShouldClauses(bq.MustNot(), 1)
+		if err != nil {
+			return nil, err
+		}
+		excludedIDs := make(map[string]bool, len(excluded.hits))
+		for _, hit := range excluded.hits {
+			excludedIDs[hit.ID] = true
+		}
+		kept := make([]*Result, 0, len(results.hits))
+		for _, hit := range results.hits {
+			if !excludedIDs[hit.ID] {
+				kept = append(kept, hit)
+			}
+		}
+		results.hits = kept
+	}
+
+	return results, nil
 }
 
 // executeMatchQuery executes a match query
@@ -232,65 +484,182 @@ func (e *QueryExecutor) executeMatchQuery(q query.Query) (*Results, error) {
 		return nil, fmt.Errorf("invalid match query type")
 	}
 
-	// Get the analyzer from the search instance
-	tokens := e.search.idx.Analyzer().Analyze(mq.Text())
-	if len(tokens) == 0 {
+	// Empty text matches everything, like match_all
+	if strings.TrimSpace(mq.Text()) == "" {
+		return e.executeMatchAllQuery()
+	}
+
+	// Normalize the text exactly as the index did
+	analyzed := analysis.AnalyzeToTerms(e.search.idx.FieldAnalyzer(mq.Field()), mq.Text())
+	if len(analyzed) == 0 {
 		return &Results{hits: make([]*Result, 0)}, nil
 	}
 
-	// Process each token
+	// Collect the terms matched by each document in the field. With the AND
+	// operator every term must appear in the document.
+	matchedTerms := e.search.fieldTermHits(mq.Field(), analyzed, mq.Operator() == query.MatchAnd, e.candidates)
+
 	results := &Results{
-		hits: make([]*Result, 0),
+		hits: make([]*Result, 0, len(matchedTerms)),
 	}
+	scorer := e.search.newScorer()
+	for docID, matched := range matchedTerms {
+		if e.limitReached(results) {
+			break
+		}
 
-	// Track seen documents to avoid duplicates
-	seenDocs := make(map[int]bool)
+		// Load document
+		doc, err := e.search.store.LoadDocument(docID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
+		}
 
-	for _, token := range tokens {
-		// Get posting list for the term
-		postings := e.search.idx.GetPostings(token.Text)
+		// Calculate score using BM25 over the matched terms, scaled by the
+		// query's boost
+		score := scorer.scoreHits(docID, matched) * mq.Boost()
 
-		// Process each document
-		for docID, posting := range postings {
-			// Skip if we've already processed this document
-			if seenDocs[docID] {
-				continue
-			}
+		results.hits = append(results.hits, e.search.newResult(docID, score, doc))
+	}
 
-			// Check if the term appears in the specified field
-			fieldFound := false
-			for _, field := range posting.Fields {
-				if field == mq.Field() {
-					fieldFound = true
-					break
-				}
-			}
-			if !fieldFound {
-				continue
-			}
+	// Sort results by score
+	sort.Sort(results)
 
-			// Load document
-			doc, err := e.search.store.LoadDocument(docID)
-			if err != nil {
-				return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
-			}
+	return results, nil
+}
+
+// executeExistsQuery returns the documents containing the query's field, taken
+// from the index's field presence sets, with a constant score
+func (e *QueryExecutor) executeExistsQuery(q query.Query) (*Results, error) {
+	docIDs := e.search.idx.DocsWithField(q.Field())
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		hits: make([]*Result, 0, len(docIDs)),
+	}
+	for _, docID := range docIDs {
+		doc, exists := docs[docID]
+		if !exists {
+			continue
+		}
+		if e.limitReached(results) {
+			break
+		}
+		results.hits = append(results.hits, e.search.newResult(docID, 1.0, doc))
+	}
+	return results, nil
+}
 
-			// Calculate score using TF-IDF
-			score := e.calculateScore(docID, []string{token.Text})
+// executeIdsQuery loads the documents named by an ids query, skipping IDs
+// that don't exist, with a constant score
+func (e *QueryExecutor) executeIdsQuery(q query.Query) (*Results, error) {
+	iq, ok := q.(*query.IdsQueryImpl)
+	if !ok {
+		return nil, fmt.Errorf("invalid ids query type")
+	}
 
-			results.hits = append(results.hits, &Result{
-				ID:     fmt.Sprintf("%d", docID),
-				Score:  score,
-				Source: doc,
-			})
+	docIDs := e.search.idsQueryDocs(iq)
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
 
-			// Mark document as seen
-			seenDocs[docID] = true
+	results := &Results{
+		hits: make([]*Result, 0, len(docIDs)),
+	}
+	for _, docID := range docIDs {
+		doc, exists := docs[docID]
+		if !exists {
+			continue
+		}
+		if e.limitReached(results) {
+			break
 		}
+		results.hits = append(results.hits, e.search.newResult(docID, 1.0, doc))
+	}
+	return results, nil
+}
+
+// executeConstantScoreQuery returns the documents matching the query's filter,
+// each scored the query's boost
+func (e *QueryExecutor) executeConstantScoreQuery(q query.Query) (*Results, error) {
+	cq, ok := q.(*query.ConstantScoreQueryImpl)
+	if !ok {
+		return nil, fmt.Errorf("invalid constant score query type")
 	}
 
-	// Sort results by score
+	results, err := e.execute(cq.Filter())
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range results.hits {
+		hit.Score = cq.Boost()
+	}
 	sort.Sort(results)
+	return results, nil
+}
+
+// executeMultiTermQuery returns the documents containing any indexed term the
+// query accepts in its field, such as the terms sharing a prefix. Like
+// Elasticsearch's constant score rewrite, every match scores the query's boost.
+func (e *QueryExecutor) executeMultiTermQuery(q query.Query) (*Results, error) {
+	mtq, ok := q.(query.MultiTermQuery)
+	if !ok {
+		return nil, fmt.Errorf("invalid multi-term query type")
+	}
+
+	docIDs := e.search.multiTermDocs(mtq)
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		hits: make([]*Result, 0, len(docIDs)),
+	}
+	for _, docID := range docIDs {
+		doc, exists := docs[docID]
+		if !exists {
+			continue
+		}
+		if e.limitReached(results) {
+			break
+		}
+		results.hits = append(results.hits, e.search.newResult(docID, mtq.Boost(), doc))
+	}
+	return results, nil
+}
+
+// executeMatchAllQuery returns every document with a constant score
+func (e *QueryExecutor) executeMatchAllQuery() (*Results, error) {
+	docIDs := make([]int, 0, e.search.idx.GetDocumentCount())
+	e.search.idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		docIDs = append(docIDs, docID)
+		return true
+	})
+	sort.Ints(docIDs)
+
+	docs, err := e.search.loadDocuments(docIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &Results{
+		hits: make([]*Result, 0, len(docIDs)),
+	}
+	for _, docID := range docIDs {
+		// Documents deleted since the scan are left out
+		doc, exists := docs[docID]
+		if !exists {
+			continue
+		}
+		if e.limitReached(results) {
+			break
+		}
+		results.hits = append(results.hits, e.search.newResult(docID, 1.0, doc))
+	}
 
 	return results, nil
 }
@@ -301,55 +670,109 @@ func (e *QueryExecutor) executeMustClauses(queries []query.Query) (*Results, err
 		return nil, nil
 	}
 
+	// Intersect the term postings of the clauses first, so the clauses only
+	// score and load the documents that can match all of them
+	if candidates, ok := e.mustCandidates(queries); ok {
+		if len(candidates) == 0 {
+			return &Results{hits: make([]*Result, 0)}, nil
+		}
+		outer := e.candidates
+		e.candidates = candidates
+		defer func() { e.candidates = outer }()
+	}
+
 	// Execute first query
-	results, err := e.Execute(queries[0])
+	results, err := e.execute(queries[0])
 	if err != nil {
 		return nil, err
 	}
+	if len(queries) == 1 {
+		return results, nil
+	}
 
-	// Filter results through remaining queries
+	// Intersect the sorted document IDs matched by every query
+	lists := [][]int{sortedHitIDs(results)}
 	for _, q := range queries[1:] {
-		nextResults, err := e.Execute(q)
+		nextResults, err := e.execute(q)
 		if err != nil {
 			return nil, err
 		}
+		lists = append(lists, sortedHitIDs(nextResults))
+	}
 
-		// Keep only documents that appear in both result sets
-		filteredHits := make([]*Result, 0)
-		docMap := make(map[string]*Result)
-		for _, hit := range nextResults.hits {
-			docMap[hit.ID] = hit
-		}
+	// Keep the hits of the first query in the intersection, in their order
+	results.hits = keepCommonHits(results.hits, lists)
 
-		for _, hit := range results.hits {
-			if _, exists := docMap[hit.ID]; exists {
-				filteredHits = append(filteredHits, hit)
-			}
-		}
+	return results, nil
+}
 
-		results.hits = filteredHits
+// mustCandidates returns the sorted IDs of the documents containing the
+// terms every term and match clause requires, streamed from the intersection
+// of their posting lists. It reports false when no clause requires terms.
+// Note: Caller must hold read lock
+func (e *QueryExecutor) mustCandidates(queries []query.Query) ([]int, bool) {
+	var lists [][]int
+	for _, q := range queries {
+		for _, term := range e.requiredTerms(q) {
+			lists = append(lists, e.search.idx.TermDocIDs(term))
+		}
+	}
+	if len(lists) == 0 {
+		return nil, false
+	}
+	if e.candidates != nil {
+		lists = append(lists, e.candidates)
 	}
 
-	return results, nil
+	candidates := make([]int, 0)
+	intersectDocIDs(lists, func(docID int) bool {
+		candidates = append(candidates, docID)
+		return true
+	})
+	return candidates, true
+}
+
+// requiredTerms returns the indexed terms a document must contain to match a
+// term query or a match query needing all of its terms, and nil for other
+// queries
+func (e *QueryExecutor) requiredTerms(q query.Query) []string {
+	switch q := q.(type) {
+	case *query.TermQueryImpl:
+		if terms := e.search.termQueryTerms(q); len(terms) > 0 {
+			return terms[:1]
+		}
+	case *query.MatchQueryImpl:
+		if strings.TrimSpace(q.Text()) == "" {
+			return nil
+		}
+		terms := analysis.AnalyzeToTerms(e.search.idx.FieldAnalyzer(q.Field()), q.Text())
+		if len(terms) == 1 || q.Operator() == query.MatchAnd {
+			return terms
+		}
+	}
+	return nil
 }
 
-// executeShouldClauses executes should clauses of a boolean query
-func (e *QueryExecutor) executeShouldClauses(queries []query.Query) (*Results, error) {
+// executeShouldClauses executes should clauses of a boolean query, keeping
+// the documents that match at least minMatch of them
+func (e *QueryExecutor) executeShouldClauses(queries []query.Query, minMatch int) (*Results, error) {
 	if len(queries) == 0 {
 		return nil, nil
 	}
 
 	// Create a map to track unique documents and their highest scores
 	docMap := make(map[string]*Result)
+	matches := make(map[string]int)
 
 	// Execute each query and merge results
 	for _, q := range queries {
-		results, err := e.Execute(q)
+		results, err := e.execute(q)
 		if err != nil {
 			return nil, err
 		}
 
 		for _, hit := range results.hits {
+			matches[hit.ID]++
 			if existing, exists := docMap[hit.ID]; exists {
 				// Keep the higher score
 				if hit.Score > existing.Score {
@@ -365,8 +788,10 @@ func (e *QueryExecutor) executeShouldClauses(queries []query.Query) (*Results, e
 	results := &Results{
 		hits: make([]*Result, 0, len(docMap)),
 	}
-	for _, hit := range docMap {
-		results.hits = append(results.hits, hit)
+	for id, hit := range docMap {
+		if matches[id] >= minMatch {
+			results.hits = append(results.hits, hit)
+		}
 	}
 
 	// Sort by score
@@ -419,26 +844,3 @@ func (e *QueryExecutor) combineResults(must, should *Results) *Results {
 	return results
 }
 
-// calculateScore calculates TF-IDF score for a document
-func (e *QueryExecutor) calculateScore(docID int, terms []string) float64 {
-	var score float64
-
-	// Calculate TF-IDF score for each term
-	for _, term := range terms {
-		postings := e.search.idx.GetPostings(term)
-		if entry, exists := postings[docID]; exists {
-			tf := float64(entry.TermFreq)  // Using TermFreq field from PostingEntry
-			df := float64(len(postings))
-			if df > 0 {
-				// TF-IDF scoring: tf * idf
-				// idf = log(1 + N/df) where N is total number of documents
-				// Adding 1 ensures IDF is always positive
-				N := float64(e.search.idx.GetDocumentCount())
-				idf := math.Log1p(N / df)
-				score += tf * idf
-			}
-		}
-	}
-
-	return score
-}
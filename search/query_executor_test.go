@@ -1,6 +1,8 @@
 package search
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 	"testing"
 
@@ -124,4 +126,1373 @@ func TestQueryExecutor(t *testing.T) {
 			t.Errorf("Expected 1 result, got %d", len(results.hits))
 		}
 	})
+
+	t.Run("Empty Match Query", func(t *testing.T) {
+		results, err := executor.Execute(query.NewMatchQuery("title", "  "))
+		if err != nil {
+			t.Errorf("Failed to execute match query: %v", err)
+		}
+		if len(results.hits) != 3 {
+			t.Errorf("Expected all 3 documents, got %d", len(results.hits))
+		}
+	})
+}
+
+func TestMatchQueryOperator(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	for _, title := range []string{"The quick brown fox", "A quick rabbit", "The sly fox"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	q := query.NewMatchQuery("title", "quick fox")
+	results, err := executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute OR match query: %v", err)
+	}
+	if len(results.hits) != 3 {
+		t.Errorf("Expected 3 results with OR, got %d", len(results.hits))
+	}
+
+	q.SetOperator(query.MatchAnd)
+	results, err = executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute AND match query: %v", err)
+	}
+	if len(results.hits) != 1 {
+		t.Fatalf("Expected 1 result with AND, got %d", len(results.hits))
+	}
+	if results.hits[0].DocID != 0 {
+		t.Errorf("Expected document 0 to match, got document %d", results.hits[0].DocID)
+	}
+}
+
+func TestTerminateAfter(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+	for i := 0; i < 10; i++ {
+		doc := document.NewDocument()
+		doc.AddField("title", "shared term")
+		doc.AddField("rank", fmt.Sprintf("doc %d", i))
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	bq := query.NewBooleanQuery()
+	bq.AddShould(query.NewTermQuery("title", "shared"))
+	bq.AddShould(query.NewTermQuery("title", "term"))
+
+	for _, q := range []query.Query{query.NewTermQuery("title", "shared"), query.NewMatchAllQuery(), bq} {
+		executor := NewQueryExecutorWithOptions(search, QueryOptions{TerminateAfter: 3})
+		results, err := executor.Execute(q)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if results.Len() != 3 {
+			t.Errorf("%T: expected 3 hits, got %d", q, results.Len())
+		}
+		if !results.TerminatedEarly() {
+			t.Errorf("%T: expected results to be terminated early", q)
+		}
+	}
+
+	// The flag stays unset when the corpus fits within the limit
+	results, err := NewQueryExecutorWithOptions(search, QueryOptions{TerminateAfter: 20}).Execute(query.NewMatchAllQuery())
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if results.Len() != 10 || results.TerminatedEarly() {
+		t.Errorf("Expected 10 hits without early termination, got %d (terminated=%v)", results.Len(), results.TerminatedEarly())
+	}
+
+	// SearchWithQueryOptions honors the limit and the formatter reports it
+	results, err = search.SearchWithQueryOptions(query.NewMatchQuery("title", "shared"), QueryOptions{TerminateAfter: 4})
+	if err != nil {
+		t.Fatalf("SearchWithQueryOptions failed: %v", err)
+	}
+	resp := FormatESResponse(results, 0, "test")
+	if len(resp.Hits.Hits) != 4 || !resp.TerminatedEarly {
+		t.Errorf("Expected 4 hits with terminated_early, got %d (terminated_early=%v)", len(resp.Hits.Hits), resp.TerminatedEarly)
+	}
+}
+
+func TestTrackTotalHits(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+	best := 0
+	for i := 0; i < 10; i++ {
+		doc := document.NewDocument()
+		title := "shared term"
+		if i == 7 {
+			title = "shared shared shared term"
+		}
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		if i == 7 {
+			best = docID
+		}
+	}
+
+	shared := query.NewMatchQuery("title", "shared")
+	boolQuery := query.NewBooleanQuery()
+	boolQuery.AddMust(shared)
+	boolQuery.AddMust(query.NewMatchQuery("title", "term"))
+	for name, run := range map[string]func(QueryOptions) (*Results, error){
+		"search":        func(opts QueryOptions) (*Results, error) { return search.SearchWithQueryOptions(shared, opts) },
+		"executor":      func(opts QueryOptions) (*Results, error) { return NewQueryExecutorWithOptions(search, opts).Execute(shared) },
+		"executor bool": func(opts QueryOptions) (*Results, error) { return NewQueryExecutorWithOptions(search, opts).Execute(boolQuery) },
+	} {
+		results, err := run(QueryOptions{})
+		if err != nil {
+			t.Fatalf("%s: failed to search: %v", name, err)
+		}
+		if total, lowerBound := results.TotalHits(); total != 10 || lowerBound {
+			t.Errorf("%s: expected an exact total of 10, got %d (lower bound %v)", name, total, lowerBound)
+		}
+
+		results, err = run(QueryOptions{TrackTotalHits: 4})
+		if err != nil {
+			t.Fatalf("%s: failed to search: %v", name, err)
+		}
+		if len(results.GetHits()) != 10 {
+			t.Errorf("%s: expected every match to be collected and scored, got %d", name, len(results.GetHits()))
+		}
+		if total, lowerBound := results.TotalHits(); total != 4 || !lowerBound {
+			t.Errorf("%s: expected a lower bound total of 4, got %d (lower bound %v)", name, total, lowerBound)
+		}
+		if results.TerminatedEarly() {
+			t.Errorf("%s: expected track_total_hits not to report terminated_early", name)
+		}
+
+		results.Limit(2)
+		if total, lowerBound := results.TotalHits(); len(results.GetHits()) != 2 || total != 4 || !lowerBound {
+			t.Errorf("%s: expected limiting hits to keep the total, got %d hits and total %d", name, len(results.GetHits()), total)
+		}
+
+		// A bounded search only holds the best hits, still counting the total
+		for _, tt := range []struct {
+			track, total int
+			lowerBound   bool
+		}{{4, 4, true}, {20, 10, false}} {
+			results, err = run(QueryOptions{TrackTotalHits: tt.track, Size: 1})
+			if err != nil {
+				t.Fatalf("%s: failed to search: %v", name, err)
+			}
+			if hits := results.GetHits(); len(hits) != 1 || hits[0].DocID != best {
+				t.Errorf("%s: expected only the best hit %d to be held, got %v", name, best, sortedDocIDs(results))
+			}
+			if total, lowerBound := results.TotalHits(); total != tt.total || lowerBound != tt.lowerBound {
+				t.Errorf("%s: expected a total of %d (lower bound %v) tracking %d, got %d (lower bound %v)",
+					name, tt.total, tt.lowerBound, tt.track, total, lowerBound)
+			}
+		}
+	}
+}
+
+func TestTermQueryCaseInsensitive(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	doc1 := document.NewDocument()
+	doc1.AddField("status", "active")
+	docID, _ := idx.AddDocument(doc1)
+	store.docs[docID] = doc1
+
+	doc2 := document.NewDocument()
+	doc2.AddField("status", "Active")
+	docID, _ = idx.AddDocument(doc2)
+	store.docs[docID] = doc2
+
+	results, err := executor.Execute(query.NewTermQuery("status", "Active"))
+	if err != nil {
+		t.Fatalf("Failed to execute term query: %v", err)
+	}
+	if len(results.hits) != 2 {
+		t.Errorf("Expected term 'Active' to match 2 documents, got %d", len(results.hits))
+	}
+
+	sensitive := query.NewTermQuery("status", "Active")
+	sensitive.SetCaseSensitive(true)
+	results, err = executor.Execute(sensitive)
+	if err != nil {
+		t.Fatalf("Failed to execute term query: %v", err)
+	}
+	if len(results.hits) != 1 {
+		t.Errorf("Expected case-sensitive term 'Active' to match 1 document, got %d", len(results.hits))
+	}
+}
+
+func TestBooleanTermQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	published := map[int]bool{}
+	for _, value := range []bool{true, false, true} {
+		doc := document.NewDocument()
+		if err := doc.AddField("published", value); err != nil {
+			t.Fatalf("Failed to add boolean field: %v", err)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		published[docID] = value
+	}
+
+	for _, term := range []interface{}{true, "true", map[string]interface{}{"value": true}} {
+		q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+			"term": map[string]interface{}{"published": term},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map term query for %v: %v", term, err)
+		}
+
+		results, err := executor.Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute term query: %v", err)
+		}
+		if len(results.hits) != 2 {
+			t.Errorf("Expected term %#v to match 2 published documents, got %d", term, len(results.hits))
+		}
+		for _, hit := range results.hits {
+			if !published[hit.DocID] {
+				t.Errorf("Expected term %#v to match only published documents, got %d", term, hit.DocID)
+			}
+		}
+	}
+
+	results, err := executor.Execute(query.NewTermQuery("published", "false"))
+	if err != nil {
+		t.Fatalf("Failed to execute term query: %v", err)
+	}
+	if len(results.hits) != 1 || published[results.hits[0].DocID] {
+		t.Errorf("Expected term false to match the unpublished document, got %d hits", len(results.hits))
+	}
+}
+
+func TestMinimumShouldMatch(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	tagged := map[int]int{}
+	for _, tags := range [][]string{
+		{"go", "search", "index"},
+		{"go", "search"},
+		{"go"},
+		{"rust"},
+	} {
+		doc := document.NewDocument()
+		if err := doc.AddField("tags", tags); err != nil {
+			t.Fatalf("Failed to add tags field: %v", err)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		tagged[docID] = len(tags)
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"tags": "go"}},
+				map[string]interface{}{"term": map[string]interface{}{"tags": "search"}},
+				map[string]interface{}{"term": map[string]interface{}{"tags": "index"}},
+			},
+			"minimum_should_match": float64(2),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map bool query: %v", err)
+	}
+
+	results, err := executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute bool query: %v", err)
+	}
+	if len(results.hits) != 2 {
+		t.Errorf("Expected 2 documents matching at least 2 should clauses, got %d", len(results.hits))
+	}
+	for _, hit := range results.hits {
+		if tagged[hit.DocID] < 2 {
+			t.Errorf("Expected only documents with at least 2 matching tags, got %d", hit.DocID)
+		}
+	}
+
+	// With a must clause, should clauses only add to the score
+	bq := q.(*query.BooleanQueryImpl)
+	bq.AddMust(query.NewTermQuery("tags", "go"))
+	results, err = executor.Execute(bq)
+	if err != nil {
+		t.Fatalf("Failed to execute bool query: %v", err)
+	}
+	if len(results.hits) != 3 {
+		t.Errorf("Expected 3 documents matching the must clause, got %d", len(results.hits))
+	}
+}
+
+func TestBooleanFilter(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	active := map[int]bool{}
+	for _, d := range []struct {
+		title  string
+		status string
+	}{
+		{"fox fox fox", "active"},
+		{"quick brown fox", "archived"},
+		{"fox and fox", "active"},
+		{"lazy dog", "active"},
+	} {
+		doc := document.NewDocument()
+		doc.AddField("title", d.title)
+		doc.AddField("status", d.status)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		active[docID] = d.status == "active"
+	}
+
+	unfiltered, err := executor.Execute(query.NewMatchQuery("title", "fox"))
+	if err != nil {
+		t.Fatalf("Failed to execute match query: %v", err)
+	}
+	scores := map[int]float64{}
+	for _, hit := range unfiltered.hits {
+		scores[hit.DocID] = hit.Score
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"must": []interface{}{
+				map[string]interface{}{"match": map[string]interface{}{"title": "fox"}},
+			},
+			"filter": []interface{}{
+				map[string]interface{}{"term": map[string]interface{}{"status": "active"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map bool query: %v", err)
+	}
+	filtered, err := executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute bool query: %v", err)
+	}
+
+	if len(unfiltered.hits) != 3 || len(filtered.hits) != 2 {
+		t.Fatalf("Expected the filter to narrow 3 hits to 2, got %d and %d", len(unfiltered.hits), len(filtered.hits))
+	}
+	for i, hit := range filtered.hits {
+		if !active[hit.DocID] {
+			t.Errorf("Expected only active documents, got %d", hit.DocID)
+		}
+		if hit.Score != scores[hit.DocID] {
+			t.Errorf("Expected the filter not to change the score of %d, got %f want %f", hit.DocID, hit.Score, scores[hit.DocID])
+		}
+		if i > 0 && hit.Score > filtered.hits[i-1].Score {
+			t.Errorf("Expected filtered hits to stay sorted by score")
+		}
+	}
+
+	// A bool query of only filters matches every document they allow
+	filterOnly := query.NewBooleanQuery()
+	filterOnly.AddFilter(query.NewTermQuery("status", "active"))
+	results, err := executor.Execute(filterOnly)
+	if err != nil {
+		t.Fatalf("Failed to execute bool query: %v", err)
+	}
+	if len(results.hits) != 3 {
+		t.Errorf("Expected 3 active documents, got %d", len(results.hits))
+	}
+}
+
+func TestBoostedClauses(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	var titleDoc, bodyDoc int
+	for i, fields := range []map[string]string{
+		{"title": "go tips", "body": "rust notes"},
+		{"title": "rust tips", "body": "go notes"},
+	} {
+		doc := document.NewDocument()
+		doc.AddField("title", fields["title"])
+		doc.AddField("body", fields["body"])
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		if i == 0 {
+			titleDoc = docID
+		} else {
+			bodyDoc = docID
+		}
+	}
+
+	// search runs a disjunction over title and body, boosting one field
+	search := func(titleBoost, bodyBoost float64) map[int]float64 {
+		t.Helper()
+		q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"match": map[string]interface{}{
+						"title": map[string]interface{}{"query": "go", "boost": titleBoost},
+					}},
+					map[string]interface{}{"match": map[string]interface{}{
+						"body": map[string]interface{}{"query": "go", "boost": bodyBoost},
+					}},
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map bool query: %v", err)
+		}
+		results, err := executor.Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute bool query: %v", err)
+		}
+		if len(results.hits) != 2 {
+			t.Fatalf("Expected both documents to match, got %d", len(results.hits))
+		}
+		scores := map[int]float64{}
+		for _, hit := range results.hits {
+			scores[hit.DocID] = hit.Score
+		}
+		return scores
+	}
+
+	unboosted := search(1, 1)
+	if unboosted[titleDoc] != unboosted[bodyDoc] {
+		t.Fatalf("Expected equal scores without boosts, got %f and %f", unboosted[titleDoc], unboosted[bodyDoc])
+	}
+
+	titleBoosted := search(2, 1)
+	if titleBoosted[titleDoc] <= titleBoosted[bodyDoc] {
+		t.Errorf("Expected the title match to rank first with a title boost, got %f and %f",
+			titleBoosted[titleDoc], titleBoosted[bodyDoc])
+	}
+	if titleBoosted[titleDoc] != 2*unboosted[titleDoc] {
+		t.Errorf("Expected the boost to double the title score, got %f want %f", titleBoosted[titleDoc], 2*unboosted[titleDoc])
+	}
+
+	bodyBoosted := search(1, 2)
+	if bodyBoosted[bodyDoc] <= bodyBoosted[titleDoc] {
+		t.Errorf("Expected the body match to rank first with a body boost, got %f and %f",
+			bodyBoosted[bodyDoc], bodyBoosted[titleDoc])
+	}
+}
+
+func TestConstantScoreQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	for _, title := range []string{"fox fox fox", "quick brown fox", "lazy dog"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"constant_score": map[string]interface{}{
+			"filter": map[string]interface{}{"match": map[string]interface{}{"title": "fox"}},
+			"boost":  2.5,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map constant_score query: %v", err)
+	}
+
+	results, err := executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute constant_score query: %v", err)
+	}
+	if len(results.hits) != 2 {
+		t.Fatalf("Expected 2 documents matching the filter, got %d", len(results.hits))
+	}
+	for _, hit := range results.hits {
+		if hit.Score != 2.5 {
+			t.Errorf("Expected document %d to score exactly the boost 2.5, got %f", hit.DocID, hit.Score)
+		}
+	}
+}
+
+func TestNumericTermQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	ages := map[int]float64{}
+	for _, age := range []float64{30, 30.5, 42} {
+		doc := document.NewDocument()
+		if err := doc.AddField("age", age); err != nil {
+			t.Fatalf("Failed to add age field: %v", err)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		ages[docID] = age
+	}
+
+	for _, tt := range []struct {
+		term interface{}
+		want float64
+	}{
+		{30.0, 30},
+		{30.5, 30.5},
+		{"30", 30},
+		{map[string]interface{}{"value": 42.0}, 42},
+	} {
+		q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+			"term": map[string]interface{}{"age": tt.term},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map term query for %v: %v", tt.term, err)
+		}
+
+		results, err := executor.Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute term query: %v", err)
+		}
+		if len(results.hits) != 1 || ages[results.hits[0].DocID] != tt.want {
+			t.Errorf("Expected term %#v to match only the document with age %v, got %d hits", tt.term, tt.want, len(results.hits))
+		}
+	}
+}
+
+func TestDateRangeQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	idx.SetDateDetection(true)
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	ids := map[string]int{}
+	for _, created := range []string{"2021-02-28T23:59:59Z", "2021-03-01T10:00:00Z", "2021-03-15T00:00:00Z", "2021-04-01T00:00:00Z"} {
+		doc := document.NewDocument()
+		doc.AddField("created", created)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		// The index stores the detected date
+		store.docs[docID], _ = idx.GetDocument(docID)
+		ids[created] = docID
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"range": map[string]interface{}{
+			"created": map[string]interface{}{"gte": "2021-03-01T00:00:00Z", "lt": "2021-04-01T00:00:00Z"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map range query: %v", err)
+	}
+	results, err := executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute range query: %v", err)
+	}
+	want := fmt.Sprint([]int{ids["2021-03-01T10:00:00Z"], ids["2021-03-15T00:00:00Z"]})
+	if got := fmt.Sprint(sortedDocIDs(results)); got != want {
+		t.Errorf("Expected the March documents %s, got %s", want, got)
+	}
+
+	// Numeric bounds can't be compared with dates
+	numeric := query.NewRangeQuery("created")
+	numeric.GreaterThan(5.0)
+	if _, err := executor.Execute(numeric); err == nil {
+		t.Error("Expected an error for a numeric bound on a date field")
+	}
+
+	// Dates are indexed under a canonical token, whatever their time zone
+	results, err = executor.Execute(query.NewTermQuery("created", "2021-03-01T12:00:00+02:00"))
+	if err != nil {
+		t.Fatalf("Failed to execute term query: %v", err)
+	}
+	if len(results.hits) != 1 || results.hits[0].DocID != ids["2021-03-01T10:00:00Z"] {
+		t.Errorf("Expected the date term to match one document, got %d hits", len(results.hits))
+	}
+}
+
+func TestRangeQueryInclusiveBounds(t *testing.T) {
+	idx := index.NewIndex(&mockAnalyzer{})
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	for _, age := range []int{4, 5, 6, 7, 8} {
+		doc := document.NewDocument()
+		doc.AddField("age", age)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"range": map[string]interface{}{
+			"age": map[string]interface{}{"gte": 5.0, "lte": 7.0},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map range query: %v", err)
+	}
+
+	results, err := executor.Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute range query: %v", err)
+	}
+
+	ages := make(map[int]bool)
+	for _, hit := range results.hits {
+		field, _ := hit.Doc.GetField("age")
+		ages[field.Value.(int)] = true
+	}
+	for _, age := range []int{5, 6, 7} {
+		if !ages[age] {
+			t.Errorf("Expected age %d to be included", age)
+		}
+	}
+	for _, age := range []int{4, 8} {
+		if ages[age] {
+			t.Errorf("Expected age %d to be excluded", age)
+		}
+	}
+}
+
+func TestQueryStringQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	for _, fields := range []map[string]string{
+		{"title": "quick brown fox", "content": "jumps over the lazy dog"},
+		{"title": "lazy dog", "content": "sleeps in the sun"},
+		{"title": "quick rabbit", "content": "runs past the fox"},
+	} {
+		doc := document.NewDocument()
+		for name, value := range fields {
+			doc.AddField(name, value)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	tests := []struct {
+		name     string
+		body     map[string]interface{}
+		expected []int
+	}{
+		{
+			name:     "Field-scoped AND default field",
+			body:     map[string]interface{}{"query": "title:quick AND fox", "default_field": "content"},
+			expected: []int{2},
+		},
+		{
+			name:     "Field-scoped OR",
+			body:     map[string]interface{}{"query": "title:rabbit OR content:sleeps"},
+			expected: []int{1, 2},
+		},
+		{
+			name:     "Unscoped terms search all fields",
+			body:     map[string]interface{}{"query": "lazy"},
+			expected: []int{0, 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{"query_string": tt.body})
+			if err != nil {
+				t.Fatalf("Failed to map query_string: %v", err)
+			}
+			results, err := executor.Execute(q)
+			if err != nil {
+				t.Fatalf("Failed to execute query_string: %v", err)
+			}
+
+			got := make([]int, 0, len(results.hits))
+			for _, hit := range results.hits {
+				got = append(got, hit.DocID)
+			}
+			sort.Ints(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected documents %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSimpleQueryStringQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	for _, title := range []string{"foo bar", "foo baz", "bar only", "unrelated"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []int
+	}{
+		{"Must not excludes", "foo + bar -baz", []int{0, 2}},
+		{"Required term", "+foo bar", []int{0, 1}},
+		{"Purely negative", "-foo", []int{2, 3}},
+		{"Malformed operators are ignored", "+ - | \"((bar", []int{0, 2}},
+		{"Only operators", "+ - |", []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+				"simple_query_string": map[string]interface{}{"query": tt.query, "default_field": "title"},
+			})
+			if err != nil {
+				t.Fatalf("Expected lenient parsing, got error: %v", err)
+			}
+			results, err := executor.Execute(q)
+			if err != nil {
+				t.Fatalf("Failed to execute simple_query_string: %v", err)
+			}
+
+			got := make([]int, 0, len(results.hits))
+			for _, hit := range results.hits {
+				got = append(got, hit.DocID)
+			}
+			sort.Ints(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected documents %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestExistsQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	for _, withTag := range []bool{true, false, true} {
+		doc := document.NewDocument()
+		doc.AddField("title", "document")
+		if withTag {
+			doc.AddField("tag", "tagged")
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"exists": map[string]interface{}{"field": "tag"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map exists query: %v", err)
+	}
+
+	results, err := NewQueryExecutor(search).Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute exists query: %v", err)
+	}
+	if len(results.hits) != 2 || results.hits[0].DocID != 0 || results.hits[1].DocID != 2 {
+		t.Errorf("Expected documents 0 and 2, got %d hits", len(results.hits))
+	}
+
+	results, err = search.SearchWithQuery(q)
+	if err != nil {
+		t.Fatalf("SearchWithQuery failed: %v", err)
+	}
+	if results.Len() != 2 {
+		t.Errorf("Expected SearchWithQuery to find 2 documents, got %d", results.Len())
+	}
+
+	// A field no document has matches nothing
+	results, err = NewQueryExecutor(search).Execute(query.NewExistsQuery("author"))
+	if err != nil {
+		t.Fatalf("Failed to execute exists query: %v", err)
+	}
+	if len(results.hits) != 0 {
+		t.Errorf("Expected no documents with an author field, got %d", len(results.hits))
+	}
+
+	// An empty index matches nothing
+	empty := NewSearch(index.NewIndex(analysis.NewStandardAnalyzer()), newMockDocumentStore())
+	results, err = NewQueryExecutor(empty).Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute exists query on an empty index: %v", err)
+	}
+	if len(results.hits) != 0 {
+		t.Errorf("Expected no hits from an empty index, got %d", len(results.hits))
+	}
+}
+
+func TestPrefixQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	for _, title := range []string{"search", "sea", "searching", "season", "apple"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		doc.AddField("body", "sealed")
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"prefix": map[string]interface{}{"title": map[string]interface{}{"value": "sear"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to map prefix query: %v", err)
+	}
+	results, err := NewQueryExecutor(search).Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute prefix query: %v", err)
+	}
+	if len(results.hits) != 2 || results.hits[0].DocID != 0 || results.hits[1].DocID != 2 {
+		t.Errorf("Expected documents 0 and 2 for prefix \"sear\", got %d hits", len(results.hits))
+	}
+
+	// A shorter prefix unions the postings of every matching term
+	q = query.NewPrefixQuery("title", "sea")
+	results, err = NewQueryExecutor(search).Execute(q)
+	if err != nil {
+		t.Fatalf("Failed to execute prefix query: %v", err)
+	}
+	var titles []string
+	for _, hit := range results.hits {
+		field, _ := hit.Doc.GetField("title")
+		titles = append(titles, field.Value.(string))
+	}
+	sort.Strings(titles)
+	if strings.Join(titles, ",") != "sea,search,searching,season" {
+		t.Errorf("Expected prefix \"sea\" to match sea, search, searching and season, got %v", titles)
+	}
+
+	results, err = search.SearchWithQuery(q)
+	if err != nil {
+		t.Fatalf("SearchWithQuery failed: %v", err)
+	}
+	if results.Len() != 4 {
+		t.Errorf("Expected SearchWithQuery to find 4 documents, got %d", results.Len())
+	}
+
+	// Terms in other fields don't match
+	results, err = NewQueryExecutor(search).Execute(query.NewPrefixQuery("title", "seal"))
+	if err != nil {
+		t.Fatalf("Failed to execute prefix query: %v", err)
+	}
+	if len(results.hits) != 0 {
+		t.Errorf("Expected no title matches for prefix \"seal\", got %d", len(results.hits))
+	}
+
+	if _, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+		"prefix": map[string]interface{}{"title": ""},
+	}); err == nil {
+		t.Error("Expected an error for an empty prefix")
+	}
+}
+
+func TestWildcardQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	for _, title := range []string{"test", "text", "toast", "searching"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	tests := []struct {
+		pattern string
+		want    []int
+	}{
+		{"te*t", []int{0, 1}},
+		{"t??t", []int{0, 1}},
+		{"*ing", []int{3}},
+		{"*", []int{0, 1, 2, 3}},
+		{"nothing*", nil},
+	}
+	for _, tt := range tests {
+		q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+			"wildcard": map[string]interface{}{"title": map[string]interface{}{"value": tt.pattern}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map wildcard query %q: %v", tt.pattern, err)
+		}
+		results, err := NewQueryExecutor(search).Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute wildcard query %q: %v", tt.pattern, err)
+		}
+		var got []int
+		for _, hit := range results.hits {
+			got = append(got, hit.DocID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Wildcard %q matched %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestFuzzyQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	for _, title := range []string{"quick", "quack", "brown"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	tests := []struct {
+		value     string
+		fuzziness interface{}
+		want      []int
+	}{
+		{"quik", float64(1), []int{0}},
+		{"quik", float64(2), []int{0, 1}},
+		{"qwck", float64(2), []int{0, 1}},
+		{"brwn", "AUTO", []int{2}},
+		{"kwik", float64(2), nil}, // Distance 3 from quick
+	}
+	for _, tt := range tests {
+		q, err := query.NewQueryMapper().MapQuery(map[string]interface{}{
+			"fuzzy": map[string]interface{}{"title": map[string]interface{}{"value": tt.value, "fuzziness": tt.fuzziness}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map fuzzy query %q: %v", tt.value, err)
+		}
+		results, err := NewQueryExecutor(search).Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute fuzzy query %q: %v", tt.value, err)
+		}
+		var got []int
+		for _, hit := range results.hits {
+			got = append(got, hit.DocID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Fuzzy %q (fuzziness %v) matched %v, want %v", tt.value, tt.fuzziness, got, tt.want)
+		}
+	}
+}
+
+func TestTermsQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	for _, status := range []string{"active", "pending", "closed", "active"} {
+		doc := document.NewDocument()
+		doc.AddField("status", status)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	mapper := query.NewQueryMapper()
+	tests := []struct {
+		values []interface{}
+		want   []int
+	}{
+		{[]interface{}{"active", "pending"}, []int{0, 1, 3}},
+		{[]interface{}{"Closed"}, []int{2}}, // Values are analyzed like the field
+		{[]interface{}{"archived", "deleted"}, nil},
+		{[]interface{}{"archived", "closed"}, []int{2}},
+	}
+	for _, tt := range tests {
+		q, err := mapper.MapQuery(map[string]interface{}{
+			"terms": map[string]interface{}{"status": tt.values},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map terms query %v: %v", tt.values, err)
+		}
+		results, err := NewQueryExecutor(search).Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute terms query %v: %v", tt.values, err)
+		}
+		var got []int
+		for _, hit := range results.hits {
+			got = append(got, hit.DocID)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Terms %v matched %v, want %v", tt.values, got, tt.want)
+		}
+
+		results, err = search.SearchWithQuery(q)
+		if err != nil {
+			t.Fatalf("SearchWithQuery failed: %v", err)
+		}
+		if results.Len() != len(tt.want) {
+			t.Errorf("Expected SearchWithQuery to find %d documents for %v, got %d", len(tt.want), tt.values, results.Len())
+		}
+	}
+
+	for _, invalid := range []interface{}{[]interface{}{}, "active", []interface{}{"active", float64(1)}} {
+		if _, err := mapper.MapQuery(map[string]interface{}{
+			"terms": map[string]interface{}{"status": invalid},
+		}); err == nil {
+			t.Errorf("Expected an error for terms value %v", invalid)
+		}
+	}
+}
+
+func TestIdsQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	for _, title := range []string{"zero", "one", "two", "three"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	mapper := query.NewQueryMapper()
+	tests := []struct {
+		values []interface{}
+		want   []int
+	}{
+		{[]interface{}{"3", "1"}, []int{1, 3}},
+		{[]interface{}{"1", "7", "3"}, []int{1, 3}}, // Missing IDs are skipped
+		{[]interface{}{"42", "abc"}, nil},
+	}
+	for _, tt := range tests {
+		q, err := mapper.MapQuery(map[string]interface{}{
+			"ids": map[string]interface{}{"values": tt.values},
+		})
+		if err != nil {
+			t.Fatalf("Failed to map ids query %v: %v", tt.values, err)
+		}
+		results, err := NewQueryExecutor(search).Execute(q)
+		if err != nil {
+			t.Fatalf("Failed to execute ids query %v: %v", tt.values, err)
+		}
+		var got []int
+		for _, hit := range results.hits {
+			got = append(got, hit.DocID)
+			if hit.Score != 1.0 {
+				t.Errorf("Expected score 1.0 for document %d, got %f", hit.DocID, hit.Score)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("Ids %v matched %v, want %v", tt.values, got, tt.want)
+		}
+
+		results, err = search.SearchWithQuery(q)
+		if err != nil {
+			t.Fatalf("SearchWithQuery failed: %v", err)
+		}
+		if results.Len() != len(tt.want) {
+			t.Errorf("Expected SearchWithQuery to find %d documents for %v, got %d", len(tt.want), tt.values, results.Len())
+		}
+	}
+
+	for _, invalid := range []interface{}{[]interface{}{}, "1", []interface{}{"1", float64(2)}} {
+		if _, err := mapper.MapQuery(map[string]interface{}{
+			"ids": map[string]interface{}{"values": invalid},
+		}); err == nil {
+			t.Errorf("Expected an error for ids values %v", invalid)
+		}
+	}
+}
+
+func TestPhraseQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	search := NewSearch(idx, store)
+
+	docs := []map[string]string{
+		{"title": "The quick brown fox"},
+		{"title": "Brown bears are quick to anger"},
+		{"title": "A quick, very brown dog"},
+		{"title": "quick", "body": "brown"},
+		{"title": "Brown quick"},
+	}
+	for _, fields := range docs {
+		doc := document.NewDocument()
+		for name, value := range fields {
+			doc.AddField(name, value)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	tests := []struct {
+		name   string
+		field  string
+		phrase string
+		slop   int
+		want   []int
+	}{
+		{"Adjacent terms", "title", "quick brown", 0, []int{0}},
+		{"Punctuation between terms", "title", "QUICK, brown!", 0, []int{0}},
+		{"One word between", "title", "quick brown", 1, []int{0, 2}},
+		{"Swapped terms need slop 2", "title", "quick brown", 2, []int{0, 2, 4}},
+		{"Reversed phrase", "title", "brown quick", 0, []int{4}},
+		{"Reversed phrase with slop", "title", "brown quick", 2, []int{0, 1, 4}},
+		{"Terms far apart need more slop", "title", "brown quick", 3, []int{0, 1, 2, 4}},
+		{"Terms in different fields", "", "quick brown", 0, []int{0}},
+		{"Single term", "title", "fox", 0, []int{0}},
+		{"Missing term", "title", "quick cat", 5, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := query.NewMatchPhraseQuery(tt.field, tt.phrase)
+			q.SetSlop(tt.slop)
+
+			results, err := NewQueryExecutor(search).Execute(q)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			var got []int
+			for _, hit := range results.hits {
+				got = append(got, hit.DocID)
+			}
+			sort.Ints(got)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Executor matched %v, want %v", got, tt.want)
+			}
+
+			results, err = search.SearchWithQuery(q)
+			if err != nil {
+				t.Fatalf("SearchWithQuery failed: %v", err)
+			}
+			if got := fmt.Sprint(sortedDocIDs(results)); got != fmt.Sprint(tt.want) {
+				t.Errorf("SearchWithQuery matched %s, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkMatchQueryLargeTerm runs a match query for a term found in every
+// document of a 10k document index, comparing the executor's in-place read
+// of the posting list with iterating a GetPostings copy.
+func BenchmarkMatchQueryLargeTerm(b *testing.B) {
+	const numDocs = 10000
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	for i := 0; i < numDocs; i++ {
+		doc := document.NewDocument()
+		doc.AddField("title", fmt.Sprintf("common term %d", i))
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			b.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	b.Run("GetPostings", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			matched := 0
+			for _, posting := range idx.GetPostings("common") {
+				if postingInField(posting, "title") {
+					matched++
+				}
+			}
+		}
+	})
+
+	b.Run("ViewPostings", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			matched := 0
+			idx.ViewPostings("common", func(postings map[int]*index.PostingEntry) {
+				for _, posting := range postings {
+					if postingInField(posting, "title") {
+						matched++
+					}
+				}
+			})
+		}
+	})
+
+	b.Run("MatchQuery", func(b *testing.B) {
+		q := query.NewMatchQuery("title", "common")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			results, err := executor.Execute(q)
+			if err != nil {
+				b.Fatalf("Failed to execute match query: %v", err)
+			}
+			if len(results.hits) != numDocs {
+				b.Fatalf("Expected %d results, got %d", numDocs, len(results.hits))
+			}
+		}
+	})
+
+	b.Run("SearchWithQuery", func(b *testing.B) {
+		q := query.NewMatchQuery("title", "common")
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			results, err := executor.search.SearchWithQuery(q)
+			if err != nil {
+				b.Fatalf("Failed to search: %v", err)
+			}
+			if len(results.hits) != numDocs {
+				b.Fatalf("Expected %d results, got %d", numDocs, len(results.hits))
+			}
+		}
+	})
+}
+
+func TestMustClausesIntersectPostings(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	for _, title := range []string{"quick fox", "quick dog", "lazy fox"} {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	mustAll := func(clauses ...query.Query) query.Query {
+		bq := query.NewBooleanQuery()
+		for _, clause := range clauses {
+			bq.AddMust(clause)
+		}
+		return bq
+	}
+	either := query.NewBooleanQuery()
+	either.AddShould(query.NewTermQuery("title", "dog"))
+	either.AddShould(query.NewTermQuery("title", "fox"))
+	nested := query.NewBooleanQuery()
+	nested.AddMust(query.NewTermQuery("title", "quick"))
+	nested.AddMust(either)
+
+	tests := []struct {
+		name string
+		q    query.Query
+		want []int
+	}{
+		{"term and match", mustAll(query.NewTermQuery("title", "quick"), query.NewMatchQuery("title", "fox")), []int{0}},
+		{"nested should", nested, []int{0, 1}},
+		{"missing term", mustAll(query.NewTermQuery("title", "quick"), query.NewTermQuery("title", "missing")), []int{}},
+		{"restricted nested must", mustAll(query.NewTermQuery("title", "fox"), mustAll(query.NewTermQuery("title", "quick"))), []int{0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := executor.Execute(tt.q)
+			if err != nil {
+				t.Fatalf("Failed to execute query: %v", err)
+			}
+			if got := fmt.Sprint(sortedDocIDs(results)); got != fmt.Sprint(tt.want) {
+				t.Errorf("Expected documents %v, got %s", tt.want, got)
+			}
+			if executor.candidates != nil {
+				t.Errorf("Expected the candidates to be cleared after the query, got %v", executor.candidates)
+			}
+		})
+	}
+}
+
+// BenchmarkMustQuery runs a bool query with must clauses on a common and a
+// rare term through the full executor path, over a 10k document index.
+func BenchmarkMustQuery(b *testing.B) {
+	const numDocs = 10000
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockDocumentStore()
+	for i := 0; i < numDocs; i++ {
+		doc := document.NewDocument()
+		title := "common term"
+		if i%100 == 0 {
+			title += " rare"
+		}
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			b.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+	executor := NewQueryExecutor(NewSearch(idx, store))
+
+	bq := query.NewBooleanQuery()
+	bq.AddMust(query.NewMatchQuery("title", "common"))
+	bq.AddMust(query.NewTermQuery("title", "rare"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		results, err := executor.Execute(bq)
+		if err != nil {
+			b.Fatalf("Failed to execute bool query: %v", err)
+		}
+		if len(results.hits) != numDocs/100 {
+			b.Fatalf("Expected %d results, got %d", numDocs/100, len(results.hits))
+		}
+	}
 }
@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"my-indexer/analysis"
 	"my-indexer/document"
 	"my-indexer/index"
 	"my-indexer/query"
@@ -30,12 +34,80 @@ type Result struct {
 	Score  float64            `json:"_score"`
 	Source *document.Document `json:"_source"`
 	Doc    *document.Document `json:"doc"` // Alias for Source for backward compatibility
+	Version int64             `json:"_version,omitempty"`
+	SeqNo   int64             `json:"_seq_no,omitempty"`
+	Highlight map[string][]string `json:"highlight,omitempty"` // Highlighted fragments by field
+
+	idx *index.Index // Index the hit came from, whose field data sorting reads
+}
+
+// ShardFailure describes a shard whose part of a search failed
+type ShardFailure struct {
+	Index  string // Index the shard belongs to
+	Reason string // Error that caused the failure
+}
+
+// ShardStats reports how many shards took part in a search and how many failed
+type ShardStats struct {
+	Total      int
+	Successful int
+	Failed     int
+	Failures   []ShardFailure
 }
 
 // Results represents a sorted list of search results
 type Results struct {
-	hits   []*Result
-	maxDoc int
+	hits            []*Result
+	maxDoc          int
+	shards          ShardStats
+	terminatedEarly bool // Collection stopped at QueryOptions.TerminateAfter
+	total           int  // Matches counted, when more than the hits held
+	totalLowerBound bool // total is a lower bound, capped at QueryOptions.TrackTotalHits
+}
+
+// QueryOptions tunes how a query is executed
+type QueryOptions struct {
+	TerminateAfter int // Stop collecting after this many matches; 0 collects all
+	TrackTotalHits int // Report the total exactly up to this many matches, then as a lower bound; 0 reports all
+	Size           int // Hits the caller keeps; with TrackTotalHits only the Size best are held, so leave 0 to sort, collapse or aggregate
+	MinScore       float64 // Drop hits scoring below this; 0 keeps all
+}
+
+// applyMinScore drops the hits scoring below MinScore, keeping their order
+func (o QueryOptions) applyMinScore(results *Results) {
+	if o.MinScore <= 0 {
+		return
+	}
+	kept := results.hits[:0]
+	for _, hit := range results.hits {
+		if hit.Score >= o.MinScore {
+			kept = append(kept, hit)
+		}
+	}
+	results.hits = kept
+}
+
+// heldHits returns how many of the best scoring hits a search holds, or 0 to
+// hold every match. Every match is still scored, so the held hits are the true
+// best ones; only a search whose total is capped by TrackTotalHits is bounded.
+func (o QueryOptions) heldHits() int {
+	if o.TrackTotalHits > 0 && o.Size > 0 {
+		return o.Size
+	}
+	return 0
+}
+
+// trackTotal records the number of matches as the total of results, capped
+// at TrackTotalHits as a lower bound when more were found
+func (o QueryOptions) trackTotal(results *Results, matches int) {
+	if o.TrackTotalHits > 0 && matches > o.TrackTotalHits {
+		results.total = o.TrackTotalHits
+		results.totalLowerBound = true
+		return
+	}
+	if matches > len(results.hits) {
+		results.total = matches
+	}
 }
 
 // Len returns the number of results
@@ -55,90 +127,490 @@ func (r *Results) GetHits() []*Result {
 	return r.hits
 }
 
+// TerminatedEarly reports whether collection stopped at the terminate_after limit
+func (r *Results) TerminatedEarly() bool {
+	return r.terminatedEarly
+}
+
+// TotalHits returns the number of matching documents, which may exceed the
+// hits held once they are limited, and whether it is only a lower bound
+// because counting stopped at track_total_hits
+func (r *Results) TotalHits() (int, bool) {
+	if r.totalLowerBound || r.total > len(r.hits) {
+		return r.total, r.totalLowerBound
+	}
+	return len(r.hits), false
+}
+
+// Limit keeps only the first size hits, remembering how many matched for
+// TotalHits. A negative size keeps every hit.
+func (r *Results) Limit(size int) {
+	if size < 0 || size >= len(r.hits) {
+		return
+	}
+	if !r.totalLowerBound && r.total < len(r.hits) {
+		r.total = len(r.hits)
+	}
+	r.hits = r.hits[:size]
+}
+
+// Shards returns the shard statistics of the search that produced the results.
+// Results built without shard information report a single successful shard.
+func (r *Results) Shards() ShardStats {
+	if r.shards.Total == 0 {
+		return ShardStats{Total: 1, Successful: 1}
+	}
+	return r.shards
+}
+
+// Collapse keeps only the top-ranked hit for each distinct value of field.
+// It must run after ranking, since the first hit seen for a value is kept.
+// Hits without the field are collapsed together, as in Elasticsearch, and
+// numbers collapse by value whatever their Go type, so 1 and 1.0 share a group.
+func (r *Results) Collapse(field string) {
+	if field == "" {
+		return
+	}
+
+	seen := make(map[string]bool)
+	collapsed := make([]*Result, 0, len(r.hits))
+	for _, hit := range r.hits {
+		key := "<missing>"
+		if hit.Source != nil {
+			if f, err := hit.Source.GetField(field); err == nil {
+				key = collapseKey(f.Value)
+			}
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		collapsed = append(collapsed, hit)
+	}
+	r.hits = collapsed
+}
+
+// collapseKey returns the key grouping a field value when collapsing. Numbers
+// are keyed as float64 so equal values of different types group together.
+func collapseKey(value interface{}) string {
+	if n, ok := toFloat64(value); ok {
+		value = n
+	}
+	return fmt.Sprintf("%T:%v", value, value)
+}
+
+// SortSpec orders search hits by a document field. The special field _score
+// orders by relevance.
+type SortSpec struct {
+	Field      string
+	Descending bool
+}
+
+// SortBy orders hits by each sort key in turn, breaking remaining ties by
+// score. Numbers, strings and times compare by value; hits missing a field
+// sort after the hits that have it, whatever the order. Field values are read
+// from the field data of each hit's index rather than from its document.
+func (r *Results) SortBy(specs []SortSpec) {
+	if len(specs) == 0 {
+		return
+	}
+
+	// Look up every hit's sort values once, rather than on each comparison
+	fieldData := make(map[fieldDataKey]*index.FieldData)
+	values := make(map[*Result][]sortValue, len(r.hits))
+	for _, hit := range r.hits {
+		keys := make([]sortValue, len(specs))
+		for k, spec := range specs {
+			if spec.Field != "_score" {
+				keys[k].value, keys[k].ok = hitFieldValue(hit, spec.Field, fieldData)
+			}
+		}
+		values[hit] = keys
+	}
+
+	sort.SliceStable(r.hits, func(i, j int) bool {
+		a, b := r.hits[i], r.hits[j]
+		for k, spec := range specs {
+			if c := compareHits(a, b, values[a][k], values[b][k], spec); c != 0 {
+				return c < 0
+			}
+		}
+		return a.Score > b.Score
+	})
+}
+
+// sortValue is a hit's value for one sort key, if it has one
+type sortValue struct {
+	value interface{}
+	ok    bool
+}
+
+// fieldDataKey identifies the field data of one field in one index
+type fieldDataKey struct {
+	idx   *index.Index
+	field string
+}
+
+// compareHits compares two hits on one sort key, given their values for it,
+// returning a negative number when a sorts first, a positive number when b
+// sorts first and 0 on a tie
+func compareHits(a, b *Result, av, bv sortValue, spec SortSpec) int {
+	var c int
+	if spec.Field == "_score" {
+		c = compareFloats(a.Score, b.Score)
+	} else {
+		switch {
+		case !av.ok && !bv.ok:
+			return 0
+		case !av.ok:
+			return 1
+		case !bv.ok:
+			return -1
+		}
+		c = compareSortValues(av.value, bv.value)
+	}
+	if spec.Descending {
+		return -c
+	}
+	return c
+}
+
+// hitFieldValue returns the value of a field for a hit, read from the field
+// data of the hit's index, which is looked up once per field and index in
+// fieldData. Hits built without an index fall back to their document.
+func hitFieldValue(hit *Result, field string, fieldData map[fieldDataKey]*index.FieldData) (interface{}, bool) {
+	if hit.idx == nil {
+		if hit.Source == nil {
+			return nil, false
+		}
+		f, err := hit.Source.GetField(field)
+		if err != nil || f.Value == nil {
+			return nil, false
+		}
+		return f.Value, true
+	}
+
+	key := fieldDataKey{idx: hit.idx, field: field}
+	fd, ok := fieldData[key]
+	if !ok {
+		fd = hit.idx.FieldData(field)
+		fieldData[key] = fd
+	}
+	value, ok := fd.Value(hit.DocID)
+	if !ok || value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// compareSortValues compares two field values. Values of different kinds
+// order numbers before times before strings.
+func compareSortValues(a, b interface{}) int {
+	ak, bk := sortKind(a), sortKind(b)
+	if ak != bk {
+		return ak - bk
+	}
+
+	switch ak {
+	case 0:
+		af, _ := toFloat64(a)
+		bf, _ := toFloat64(b)
+		return compareFloats(af, bf)
+	case 1:
+		return a.(time.Time).Compare(b.(time.Time))
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+// sortKind ranks a value's kind for sorting: numbers, times, then the rest
+func sortKind(v interface{}) int {
+	if _, ok := toFloat64(v); ok {
+		return 0
+	}
+	if _, ok := v.(time.Time); ok {
+		return 1
+	}
+	return 2
+}
+
+// toFloat64 converts a numeric field value to float64
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// compareFloats compares two floats, returning -1, 0 or 1
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
 // Search performs a search operation on the index
 type Search struct {
-	idx    *index.Index
-	mu     sync.RWMutex
-	store  DocumentStore
-	maxDoc int
+	idx        *index.Index
+	mu         sync.RWMutex
+	store      DocumentStore
+	maxDoc     int
+	shardCount int               // Number of shards reported for the index
+	aggLimits  AggregationLimits // Bucket limits applied to each request
+	scoring    ScoringParams     // BM25 parameters used to score hits
+}
+
+// ScoringParams tunes BM25 relevance scoring
+type ScoringParams struct {
+	K1 float64 // Term frequency saturation; higher values let repeated terms count for more
+	B  float64 // Document length normalization, from 0 (none) to 1 (full)
 }
 
+// DefaultScoringParams are the standard BM25 parameters, also used by Elasticsearch
+var DefaultScoringParams = ScoringParams{K1: 1.2, B: 0.75}
+
 // DocumentStore is an interface for loading documents
 type DocumentStore interface {
 	LoadDocument(docID int) (*document.Document, error)
 	LoadAllDocuments() ([]*document.Document, error)
 }
 
+// BatchDocumentStore is implemented by stores that can load several documents
+// from one consistent snapshot. Documents that no longer exist are left out of
+// the result instead of failing the load.
+type BatchDocumentStore interface {
+	LoadDocuments(docIDs []int) (map[int]*document.Document, error)
+}
+
 // NewSearch creates a new search instance
 func NewSearch(idx *index.Index, store DocumentStore) *Search {
 	return &Search{
-		idx:   idx,
-		store: store,
+		idx:        idx,
+		store:      store,
+		shardCount: 1,
+		aggLimits:  DefaultAggregationLimits,
+		scoring:    DefaultScoringParams,
 	}
 }
 
-// calculateScore calculates the score for a document based on term frequencies
-func (s *Search) calculateScore(docID int, terms []string) float64 {
-	var score float64
+// SetShardCount sets the number of shards reported for the index in search
+// and write responses. Values below 1 are treated as 1.
+func (s *Search) SetShardCount(count int) {
+	if count < 1 {
+		count = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shardCount = count
+}
 
-	// Calculate TF-IDF score for each term
-	for _, term := range terms {
-		tf, err := s.idx.GetTermFrequency(term, docID)
+// ShardCount returns the number of shards reported for the index
+func (s *Search) ShardCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.shardCount
+}
+
+// SetScoringParams sets the BM25 parameters used to score hits. Negative
+// values are treated as 0 and B is capped at 1.
+func (s *Search) SetScoringParams(params ScoringParams) {
+	params.K1 = math.Max(params.K1, 0)
+	params.B = math.Min(math.Max(params.B, 0), 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scoring = params
+}
+
+// ScoringParams returns the BM25 parameters used to score hits
+func (s *Search) ScoringParams() ScoringParams {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.scoring
+}
+
+// loadDocuments loads the documents a query needs in one step, so a single
+// query sees one consistent view of the store. Stores without batch support
+// load documents one by one and fail on missing documents.
+func (s *Search) loadDocuments(docIDs []int) (map[int]*document.Document, error) {
+	if batch, ok := s.store.(BatchDocumentStore); ok {
+		return batch.LoadDocuments(docIDs)
+	}
+
+	docs := make(map[int]*document.Document, len(docIDs))
+	for _, docID := range docIDs {
+		doc, err := s.store.LoadDocument(docID)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
 		}
-		df, err := s.idx.GetDocumentFrequency(term)
-		if err != nil {
+		docs[docID] = doc
+	}
+	return docs, nil
+}
+
+// successfulShards returns the shard statistics of a search that succeeded
+// Note: Caller must hold read lock
+func (s *Search) successfulShards() ShardStats {
+	return ShardStats{Total: s.shardCount, Successful: s.shardCount}
+}
+
+// newResult builds a search hit for a document, including its version metadata
+func (s *Search) newResult(docID int, score float64, doc *document.Document) *Result {
+	result := &Result{
+		ID:     fmt.Sprintf("%d", docID),
+		DocID:  docID,
+		Score:  score,
+		Source: doc,
+		Doc:    doc,
+		idx:    s.idx,
+	}
+	if version, ok := s.idx.GetDocumentVersion(docID); ok {
+		result.Version = version.Version
+		result.SeqNo = version.SeqNo
+	}
+	return result
+}
+
+// scorer calculates BM25 scores over one query execution. The document count
+// and average document length are looked up once and reused for every
+// document, rather than per document.
+type scorer struct {
+	s      *Search
+	N      float64
+	avgLen float64
+}
+
+// newScorer returns a scorer over the current state of the index
+func (s *Search) newScorer() *scorer {
+	return &scorer{
+		s:      s,
+		N:      float64(s.idx.GetDocumentCount()),
+		avgLen: s.idx.AverageDocumentLength(),
+	}
+}
+
+// score calculates the BM25 score of a document for the given terms, reading
+// each term's posting list in place
+func (sc *scorer) score(docID int, terms []string) float64 {
+	var score float64
+	docLen := -1.0
+	for _, term := range terms {
+		var tf, df int
+		sc.s.idx.ViewPostings(term, func(postings map[int]*index.PostingEntry) {
+			if entry, exists := postings[docID]; exists {
+				tf, df = entry.TermFreq, len(postings)
+			}
+		})
+		if tf == 0 {
 			continue
 		}
-		if df > 0 {
-			// TF-IDF scoring: tf * idf
-			// idf = log(1 + N/df) where N is total number of documents
-			// Adding 1 ensures IDF is always positive
-			N := float64(s.idx.GetDocumentCount())
-			idf := math.Log1p(N / float64(df))
-			score += float64(tf) * idf
+		if docLen < 0 {
+			docLen = float64(sc.s.idx.DocumentLength(docID))
 		}
+		score += sc.s.termScore(float64(tf), float64(df), sc.N, docLen, sc.avgLen)
 	}
 
 	return score
 }
 
+// termHit records a term found in a document, with its frequency there and
+// its document frequency, so the document can be scored without holding on
+// to the term's posting list
+type termHit struct {
+	tf int
+	df int
+}
+
+// scoreHits calculates the BM25 score of a document from its term hits
+func (sc *scorer) scoreHits(docID int, hits []termHit) float64 {
+	if len(hits) == 0 {
+		return 0
+	}
+	var score float64
+	docLen := float64(sc.s.idx.DocumentLength(docID))
+	for _, hit := range hits {
+		score += sc.s.termScore(float64(hit.tf), float64(hit.df), sc.N, docLen, sc.avgLen)
+	}
+	return score
+}
+
+// termScore returns the BM25 score contribution of one term:
+//
+//	idf * tf * (k1 + 1) / (tf + k1 * (1 - b + b * docLen / avgLen))
+//
+// where idf = log(1 + (N - df + 0.5) / (df + 0.5)) stays positive even for
+// terms found in most documents
+// Note: Caller must hold read lock
+func (s *Search) termScore(tf, df, N, docLen, avgLen float64) float64 {
+	if tf <= 0 || df <= 0 {
+		return 0
+	}
+	idf := math.Log1p((N - df + 0.5) / (df + 0.5))
+
+	norm := 1.0
+	if avgLen > 0 {
+		norm = 1 - s.scoring.B + s.scoring.B*docLen/avgLen
+	}
+	return idf * tf * (s.scoring.K1 + 1) / (tf + s.scoring.K1*norm)
+}
+
 // Search performs a search with the given terms and operator
 func (s *Search) Search(terms []string, op Operator) (*Results, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	if len(terms) == 0 {
-		return &Results{}, nil
+		return &Results{shards: s.successfulShards()}, nil
 	}
 
 	// Get document IDs based on operator
 	docIDs := make(map[int]bool)
-	
-	// Handle first term
-	postings := s.idx.GetPostings(terms[0])
-	for docID := range postings {
-		docIDs[docID] = true
-	}
-
-	// Process remaining terms based on operator
-	for _, term := range terms[1:] {
-		postings := s.idx.GetPostings(term)
-		
-		switch op {
-		case AND:
-			// Remove documents that don't contain the term
-			for docID := range docIDs {
-				if _, exists := postings[docID]; !exists {
-					delete(docIDs, docID)
+	switch op {
+	case AND:
+		// Intersect the sorted document IDs of every term
+		lists := make([][]int, len(terms))
+		for i, term := range terms {
+			lists[i] = s.idx.TermDocIDs(term)
+		}
+		intersectDocIDs(lists, func(docID int) bool {
+			docIDs[docID] = true
+			return true
+		})
+	case OR:
+		// Add documents that contain any term
+		for _, term := range terms {
+			s.idx.ViewPostings(term, func(postings map[int]*index.PostingEntry) {
+				for docID := range postings {
+					docIDs[docID] = true
 				}
-			}
-		case OR:
-			// Add documents that contain the term
-			for docID := range postings {
-				docIDs[docID] = true
-			}
+			})
 		}
 	}
 
@@ -147,159 +619,489 @@ func (s *Search) Search(terms []string, op Operator) (*Results, error) {
 		hits: make([]*Result, 0, len(docIDs)),
 	}
 
+	scorer := s.newScorer()
 	for docID := range docIDs {
-		score := s.calculateScore(docID, terms)
+		score := scorer.score(docID, terms)
 		doc, err := s.store.LoadDocument(docID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
 		}
 
-		results.hits = append(results.hits, &Result{
-			Index:  "",
-			Type:   "",
-			ID:     fmt.Sprintf("%d", docID),
-			DocID:  docID,
-			Score:  score,
-			Source: doc,
-			Doc:    doc,
-		})
+		results.hits = append(results.hits, s.newResult(docID, score, doc))
 	}
 
 	// Sort results by score
 	sort.Sort(results)
+	results.shards = s.successfulShards()
 
 	return results, nil
 }
 
 // SearchWithQuery performs a search using a Query object
-func (s *Search) SearchWithQuery(query query.Query) (*Results, error) {
+func (s *Search) SearchWithQuery(q query.Query) (*Results, error) {
+	return s.SearchWithQueryOptions(q, QueryOptions{})
+}
+
+// SearchWithQueryOptions performs a search using a Query object and options
+func (s *Search) SearchWithQueryOptions(q query.Query, opts QueryOptions) (*Results, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// Simplify redundant bool wrappers before matching
+	q = query.Rewrite(q)
+
+	// A constant_score query matches like its filter, with every hit scored
+	// the query's boost
+	constantScore, isConstantScore := q.(*query.ConstantScoreQueryImpl)
+	matched := q
+	if isConstantScore {
+		matched = query.Rewrite(constantScore.Filter())
+	}
+
+	// Bool queries, including those parsed from query_string and
+	// simple_query_string, combine their clauses in the query executor
+	if matched.Type() == query.BooleanQuery {
+		return NewQueryExecutorWithOptions(s, opts).run(q)
+	}
+	q = matched
+
 	// Get matching document IDs based on query type
 	docIDs := make(map[int]bool)
-	
-	qType := query.Type()
-	switch qType {
-	case 0: // TermQuery
-		// For term queries, use the inverted index directly
-		// Get the term value from the query match against an empty string
-		if query.Match("") {
-			// If it matches empty string, skip
-			return &Results{}, nil
-		}
-		// Find first non-empty string that doesn't match
-		for i := 'a'; i <= 'z'; i++ {
-			testStr := string(i)
-			if !query.Match(testStr) {
-				postings := s.idx.GetPostings(testStr)
-				for docID, posting := range postings {
-					if query.Field() == "" || posting.FieldName == query.Field() {
-						docIDs[docID] = true
-					}
+	docs := make(map[int]*document.Document)
+	stopped := false
+
+	// collect records a match, returning false once terminate_after matches
+	// are collected
+	collect := func(docID int) bool {
+		if docIDs[docID] {
+			return true
+		}
+		if opts.TerminateAfter > 0 && len(docIDs) >= opts.TerminateAfter {
+			stopped = true
+			return false
+		}
+		docIDs[docID] = true
+		return true
+	}
+
+	// Extract the query terms through the same analysis path as indexing,
+	// along with the boost their scores are scaled by
+	var terms []string
+	boost := 1.0
+	requireAll := false
+	switch tq := q.(type) {
+	case *query.TermQueryImpl:
+		if analyzed := s.termQueryTerms(tq); len(analyzed) > 0 {
+			terms = analyzed[:1]
+		}
+		boost = tq.Boost()
+	case *query.MatchQueryImpl:
+		terms = analysis.AnalyzeToTerms(s.idx.FieldAnalyzer(tq.Field()), tq.Text())
+		boost = tq.Boost()
+		requireAll = tq.Operator() == query.MatchAnd
+	case query.MultiTermQuery:
+		boost = tq.Boost()
+	}
+
+	var termHits map[int][]termHit
+	var phraseDocs []int
+	if pq, ok := q.(*query.MatchPhraseQueryImpl); ok {
+		phraseDocs, terms = s.phraseQueryDocs(pq)
+	}
+
+	switch q.Type() {
+	case query.TermQuery, query.MatchQuery:
+		// For term and match queries, read the field's postings in place
+		termHits = s.fieldTermHits(q.Field(), terms, requireAll, nil)
+		tq, caseSensitive := q.(*query.TermQueryImpl)
+		caseSensitive = caseSensitive && tq.CaseSensitive()
+		for docID := range termHits {
+			var doc *document.Document
+			if caseSensitive {
+				// The index is case-folded, so verify the term against the source
+				loaded, err := s.store.LoadDocument(docID)
+				if err != nil {
+					return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
 				}
+				if !containsExactWord(loaded, tq.Field(), tq.Term()) {
+					continue
+				}
+				doc = loaded
+			}
+			if !collect(docID) {
 				break
 			}
+			if doc != nil {
+				docs[docID] = doc
+			}
 		}
-	case 6: // MatchQuery
-		// For match queries, analyze the text and search for each term
-		analyzer := s.idx.Analyzer()
-		// Get sample text that would match
-		sampleText := "test"
-		if query.Match(sampleText) {
-			tokens := analyzer.Analyze(sampleText)
-			for _, token := range tokens {
-				postings := s.idx.GetPostings(token.Text)
-				for docID, posting := range postings {
-					if query.Field() == "" || posting.FieldName == query.Field() {
-						docIDs[docID] = true
-					}
+	case query.MatchAllQuery:
+		// For match_all queries, take every document from a single pass over
+		// the index so concurrent writes can't remove them before loading
+		s.idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+			if !collect(docID) {
+				return false
+			}
+			docs[docID] = doc
+			return true
+		})
+	case query.MatchPhraseQuery:
+		// For phrase queries, use the term positions recorded in the postings
+		for _, docID := range phraseDocs {
+			if !collect(docID) {
+				break
+			}
+		}
+	case query.IdsQuery:
+		// For ids queries, take the named documents that exist
+		if iq, ok := q.(*query.IdsQueryImpl); ok {
+			for _, docID := range s.idsQueryDocs(iq) {
+				if !collect(docID) {
+					break
 				}
 			}
 		}
-	case 8: // MatchAllQuery
-		// For match_all queries, get all documents
-		docs, err := s.store.LoadAllDocuments()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get all documents: %w", err)
+	case query.ExistsQuery:
+		// For exists queries, use the index's field presence sets
+		for _, docID := range s.idx.DocsWithField(q.Field()) {
+			if !collect(docID) {
+				break
+			}
 		}
-		for _, doc := range docs {
-			docIDs[doc.ID] = true
+	case query.TermsQuery:
+		// For terms queries, union the postings of each term
+		if tq, ok := q.(*query.TermsQueryImpl); ok {
+			for _, docID := range s.termsQueryDocs(tq) {
+				if !collect(docID) {
+					break
+				}
+			}
 		}
-	default:
-		// For other query types, fall back to loading and filtering documents
-		docs, err := s.store.LoadAllDocuments()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get documents: %w", err)
+	case query.PrefixQuery, query.WildcardQuery, query.FuzzyQuery:
+		// For multi-term queries, union the postings of the matching terms
+		if mtq, ok := q.(query.MultiTermQuery); ok {
+			for _, docID := range s.multiTermDocs(mtq) {
+				if !collect(docID) {
+					break
+				}
+			}
 		}
-		for _, doc := range docs {
+	default:
+		// For other query types, fall back to filtering every document. The
+		// index's document IDs are used since stored documents don't carry them.
+		s.idx.ForEachDocument(func(docID int, doc *document.Document) bool {
 			for field, value := range doc.GetFields() {
-				if query.Field() == "" || query.Field() == field {
-					if query.Match(value) {
-						docIDs[doc.ID] = true
+				if q.Field() == "" || q.Field() == field {
+					if q.Match(value) {
+						if !collect(docID) {
+							return false
+						}
+						docs[docID] = doc
 						break
 					}
 				}
 			}
+			return true
+		})
+	}
+
+	scorer := s.newScorer()
+	scoreDoc := func(docID int) float64 {
+		switch {
+		case isConstantScore:
+			return constantScore.Boost()
+		case termHits != nil:
+			return scorer.scoreHits(docID, termHits[docID]) * boost
+		case len(terms) > 0:
+			return scorer.score(docID, terms) * boost
+		}
+		return boost
+	}
+
+	// Score every match, holding only the best ones when the search is
+	// bounded, and load the held documents not loaded while matching
+	held := opts.heldHits()
+	if held == 0 {
+		held = len(docIDs)
+	}
+	top := newTopDocs(held)
+	matches := 0
+	for docID := range docIDs {
+		score := scoreDoc(docID)
+		if opts.MinScore > 0 && score < opts.MinScore {
+			continue
+		}
+		matches++
+		top.offer(docID, score)
+	}
+	var missing []int
+	for _, sd := range top.docs {
+		if _, ok := docs[sd.docID]; !ok {
+			missing = append(missing, sd.docID)
+		}
+	}
+	if len(missing) > 0 {
+		loaded, err := s.loadDocuments(missing)
+		if err != nil {
+			return nil, err
+		}
+		for docID, doc := range loaded {
+			docs[docID] = doc
 		}
 	}
 
-	// Create results from matching documents
+	// Create results from the held documents
 	results := &Results{
-		hits: make([]*Result, 0, len(docIDs)),
+		hits: make([]*Result, 0, len(top.docs)),
+	}
+	for _, sd := range top.docs {
+		results.hits = append(results.hits, s.newResult(sd.docID, sd.score, docs[sd.docID]))
 	}
 
-	// Get the search terms for scoring
-	var terms []string
-	switch qType {
-	case 0: // TermQuery
-		// Use same technique as above to extract term
-		for i := 'a'; i <= 'z'; i++ {
-			testStr := string(i)
-			if !query.Match(testStr) {
-				terms = []string{testStr}
-				break
+	// Sort results by score
+	sort.Sort(results)
+	results.shards = s.successfulShards()
+	results.terminatedEarly = stopped
+	opts.trackTotal(results, matches)
+
+	return results, nil
+}
+
+// fieldTermHits returns the hits of each distinct term in a field, by
+// document, reading the terms' posting lists in place. With requireAll only
+// documents holding every term are returned, found by intersecting the terms'
+// sorted document IDs. Non-nil candidates restrict the hits to those sorted
+// document IDs.
+// Note: Caller must hold read lock
+func (s *Search) fieldTermHits(field string, terms []string, requireAll bool, candidates []int) map[int][]termHit {
+	hits := make(map[int][]termHit)
+	var lists [][]int
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		var docIDs []int
+		s.idx.ViewPostings(term, func(postings map[int]*index.PostingEntry) {
+			forEachPosting(postings, candidates, func(docID int, posting *index.PostingEntry) {
+				if postingInField(posting, field) {
+					hits[docID] = append(hits[docID], termHit{tf: posting.TermFreq, df: len(postings)})
+					if requireAll {
+						docIDs = append(docIDs, docID)
+					}
+				}
+			})
+		})
+		if requireAll {
+			sort.Ints(docIDs)
+			lists = append(lists, docIDs)
+		}
+	}
+	if len(lists) < 2 {
+		return hits
+	}
+
+	all := make(map[int][]termHit)
+	intersectDocIDs(lists, func(docID int) bool {
+		all[docID] = hits[docID]
+		return true
+	})
+	return all
+}
+
+// termQueryTerms analyzes the term of a term query as the index analyzed the
+// field. Dates and numbers are looked up by the canonical tokens they are
+// indexed under; numeric terms fall back to analysis when no numeric value
+// was indexed under the token, for fields holding numbers as text.
+func (s *Search) termQueryTerms(tq *query.TermQueryImpl) []string {
+	if s.idx.IndexesDates(tq.Field()) {
+		if date, ok := document.ParseDate(tq.Term()); ok {
+			return []string{document.DateToken(date)}
+		}
+	}
+	if n, err := strconv.ParseFloat(tq.Term(), 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+		token := document.NumberToken(n)
+		for _, posting := range s.idx.GetPostings(token) {
+			if postingInField(posting, tq.Field()) {
+				return []string{token}
 			}
 		}
-	case 6: // MatchQuery
-		analyzer := s.idx.Analyzer()
-		sampleText := "test"
-		if query.Match(sampleText) {
-			tokens := analyzer.Analyze(sampleText)
-			terms = make([]string, len(tokens))
-			for i, token := range tokens {
-				terms[i] = token.Text
+	}
+	return analysis.AnalyzeToTerms(s.idx.FieldAnalyzer(tq.Field()), tq.Term())
+}
+
+// idsQueryDocs returns the sorted IDs of the indexed documents an ids query
+// names. IDs that aren't document IDs or don't exist are skipped.
+func (s *Search) idsQueryDocs(q *query.IdsQueryImpl) []int {
+	requested := make([]int, 0, len(q.IDs()))
+	for _, id := range q.IDs() {
+		if docID, err := strconv.Atoi(id); err == nil && docID >= 0 {
+			requested = append(requested, docID)
+		}
+	}
+
+	existing := s.idx.GetDocuments(requested)
+	docIDs := make([]int, 0, len(existing))
+	for docID := range existing {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	return docIDs
+}
+
+// termsQueryDocs returns the sorted IDs of documents containing any of the
+// terms query's values in its field. Each value is analyzed like a term query.
+func (s *Search) termsQueryDocs(q *query.TermsQueryImpl) []int {
+	analyzer := s.idx.FieldAnalyzer(q.Field())
+	matched := make(map[int]bool)
+	for _, value := range q.Terms() {
+		analyzed := analysis.AnalyzeToTerms(analyzer, value)
+		if len(analyzed) == 0 {
+			continue
+		}
+		for docID, posting := range s.idx.GetPostings(analyzed[0]) {
+			if postingInField(posting, q.Field()) {
+				matched[docID] = true
 			}
 		}
-	default:
-		terms = []string{}
 	}
 
-	for docID := range docIDs {
-		doc, err := s.store.LoadDocument(docID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load document %d: %w", docID, err)
+	docIDs := make([]int, 0, len(matched))
+	for docID := range matched {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	return docIDs
+}
+
+// multiTermDocs returns the sorted IDs of documents containing, in the query's
+// field, any indexed term accepted by the query
+func (s *Search) multiTermDocs(q query.MultiTermQuery) []int {
+	matched := make(map[int]bool)
+	for _, term := range s.idx.MatchingTerms(q.MatchTerm) {
+		for docID, posting := range s.idx.GetPostings(term) {
+			if postingInField(posting, q.Field()) {
+				matched[docID] = true
+			}
 		}
+	}
 
-		score := 1.0
-		if len(terms) > 0 {
-			score = s.calculateScore(docID, terms)
+	docIDs := make([]int, 0, len(matched))
+	for docID := range matched {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Ints(docIDs)
+	return docIDs
+}
+
+// phraseTerm is one term of an analyzed phrase and its offset from the first
+type phraseTerm struct {
+	text   string
+	offset int
+}
+
+// phraseTerms analyzes a phrase with the field's analyzer. Only the first token
+// at each position is kept, so expanded variants such as synonyms don't become
+// extra required terms.
+func (s *Search) phraseTerms(q *query.MatchPhraseQueryImpl) []phraseTerm {
+	var terms []phraseTerm
+	first, last := 0, 0
+	for _, token := range s.idx.FieldAnalyzer(q.Field()).Analyze(q.Phrase()) {
+		if token.Text == "" || (len(terms) > 0 && token.Position == last) {
+			continue
 		}
+		if len(terms) == 0 {
+			first = token.Position
+		}
+		terms = append(terms, phraseTerm{text: token.Text, offset: token.Position - first})
+		last = token.Position
+	}
+	return terms
+}
 
-		result := &Result{
-			Index:  "",
-			Type:   "",
-			ID:     fmt.Sprintf("%d", docID),
-			DocID:  docID,
-			Score:  score,
-			Source: doc,
-			Doc:    doc,
+// phraseQueryDocs returns the sorted IDs of documents where the phrase's terms
+// occur within one field at the phrase's relative positions, give or take the
+// query's slop, along with the phrase terms for scoring
+func (s *Search) phraseQueryDocs(q *query.MatchPhraseQueryImpl) ([]int, []string) {
+	terms := s.phraseTerms(q)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	postings := make([]map[int]*index.PostingEntry, len(terms))
+	texts := make([]string, len(terms))
+	for i, term := range terms {
+		postings[i] = s.idx.GetPostings(term.text)
+		texts[i] = term.text
+	}
+
+	var docIDs []int
+	for docID, first := range postings[0] {
+		fields := []string{q.Field()}
+		if q.Field() == "" || q.Field() == "_all" {
+			fields = first.Fields
+		}
+		for _, field := range fields {
+			if phraseInField(postings, terms, docID, field, q.Slop()) {
+				docIDs = append(docIDs, docID)
+				break
+			}
 		}
-		results.hits = append(results.hits, result)
 	}
+	sort.Ints(docIDs)
+	return docIDs, texts
+}
 
-	// Sort results by score
-	sort.Sort(results)
+// phraseInField reports whether a document has the phrase's terms in a field
+// with their positions, shifted back by each term's offset in the phrase, no
+// more than slop apart. With a slop of 0 the terms must be adjacent and in
+// order; a slop of 2 also allows two terms to swap places.
+func phraseInField(postings []map[int]*index.PostingEntry, terms []phraseTerm, docID int, field string, slop int) bool {
+	positions := make([][]int, len(terms))
+	for i := range terms {
+		entry, exists := postings[i][docID]
+		if !exists || len(entry.FieldPositions[field]) == 0 {
+			return false
+		}
+		positions[i] = entry.FieldPositions[field]
+	}
 
-	return results, nil
+	// Find the smallest window holding one shifted position of every term by
+	// repeatedly advancing the term whose position is lowest
+	next := make([]int, len(terms))
+	for {
+		lowTerm := 0
+		low, high := 0, 0
+		for i, term := range terms {
+			start := positions[i][next[i]] - term.offset
+			if i == 0 || start < low {
+				low, lowTerm = start, i
+			}
+			if i == 0 || start > high {
+				high = start
+			}
+		}
+		if high-low <= slop {
+			return true
+		}
+		next[lowTerm]++
+		if next[lowTerm] == len(positions[lowTerm]) {
+			return false
+		}
+	}
+}
+
+// postingInField reports whether a posting occurs in the given field. An
+// empty field or _all matches any field.
+func postingInField(posting *index.PostingEntry, field string) bool {
+	if field == "" || field == "_all" || posting.FieldName == field {
+		return true
+	}
+	for _, f := range posting.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
 }
@@ -1,12 +1,16 @@
 package search
 
 import (
+	"errors"
+	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"my-indexer/analysis"
 	"my-indexer/document"
 	"my-indexer/index"
+	"my-indexer/query"
 	"fmt"
 )
 
@@ -203,7 +207,7 @@ func TestSearchScoring(t *testing.T) {
 		}(),
 		func() *document.Document {
 			doc := document.NewDocument()
-			doc.AddField("content", "test") // 1 occurrence of "test"
+			doc.AddField("content", "test case") // 1 occurrence of "test"
 			return doc
 		}(),
 	}
@@ -282,3 +286,957 @@ func TestConcurrentSearch(t *testing.T) {
 		<-done
 	}
 }
+
+func TestResultsCollapse(t *testing.T) {
+	newDoc := func(userID string) *document.Document {
+		doc := document.NewDocument()
+		if userID != "" {
+			doc.AddField("user_id", userID)
+		}
+		return doc
+	}
+
+	results := &Results{
+		hits: []*Result{
+			{ID: "0", Score: 3.0, Source: newDoc("alice")},
+			{ID: "1", Score: 2.5, Source: newDoc("bob")},
+			{ID: "2", Score: 2.0, Source: newDoc("alice")},
+			{ID: "3", Score: 1.5, Source: newDoc("")},
+			{ID: "4", Score: 1.0, Source: newDoc("bob")},
+			{ID: "5", Score: 0.5, Source: newDoc("")},
+		},
+	}
+
+	results.Collapse("user_id")
+
+	hits := results.GetHits()
+	expectedIDs := []string{"0", "1", "3"}
+	if len(hits) != len(expectedIDs) {
+		t.Fatalf("Expected %d hits after collapse, got %d", len(expectedIDs), len(hits))
+	}
+	for i, id := range expectedIDs {
+		if hits[i].ID != id {
+			t.Errorf("Hit %d: expected ID %s, got %s", i, id, hits[i].ID)
+		}
+	}
+
+	response := FormatESResponse(results, 0, "test")
+	if response.Hits.Total.Value != len(expectedIDs) {
+		t.Errorf("Expected total %d after collapse, got %d", len(expectedIDs), response.Hits.Total.Value)
+	}
+}
+
+func TestResultsCollapseNumbers(t *testing.T) {
+	newDoc := func(group interface{}) *document.Document {
+		doc := document.NewDocument()
+		doc.AddField("group", group)
+		return doc
+	}
+
+	// Equal numbers collapse together whatever their type; strings don't
+	// collapse with numbers
+	results := &Results{
+		hits: []*Result{
+			{ID: "0", Score: 3.0, Source: newDoc(1)},
+			{ID: "1", Score: 2.5, Source: newDoc(1.0)},
+			{ID: "2", Score: 2.0, Source: newDoc(int64(2))},
+			{ID: "3", Score: 1.5, Source: newDoc(2.0)},
+			{ID: "4", Score: 1.0, Source: newDoc("1")},
+		},
+	}
+	results.Collapse("group")
+
+	var ids []string
+	for _, hit := range results.GetHits() {
+		ids = append(ids, hit.ID)
+	}
+	if fmt.Sprint(ids) != "[0 2 4]" {
+		t.Errorf("Collapse() kept %v, want [0 2 4]", ids)
+	}
+}
+
+func TestResultsSortBy(t *testing.T) {
+	newDoc := func(name string, age interface{}) *document.Document {
+		doc := document.NewDocument()
+		doc.AddField("name", name)
+		if age != nil {
+			doc.AddField("age", age)
+		}
+		return doc
+	}
+	newResults := func() *Results {
+		return &Results{
+			hits: []*Result{
+				{ID: "0", Score: 1.0, Source: newDoc("carol", 35.0)},
+				{ID: "1", Score: 3.0, Source: newDoc("alice", 28)},
+				{ID: "2", Score: 2.0, Source: newDoc("bob", nil)},
+				{ID: "3", Score: 4.0, Source: newDoc("dave", 28.0)},
+				{ID: "4", Score: 0.5, Source: newDoc("erin", 19.5)},
+			},
+		}
+	}
+	ids := func(results *Results) string {
+		var ids []string
+		for _, hit := range results.GetHits() {
+			ids = append(ids, hit.ID)
+		}
+		return fmt.Sprint(ids)
+	}
+
+	tests := []struct {
+		name  string
+		specs []SortSpec
+		want  string
+	}{
+		// Equal ages fall back to score; the missing age sorts last
+		{"Ascending numeric", []SortSpec{{Field: "age"}}, "[4 3 1 0 2]"},
+		{"Descending numeric", []SortSpec{{Field: "age", Descending: true}}, "[0 3 1 4 2]"},
+		{"Descending string", []SortSpec{{Field: "name", Descending: true}}, "[4 3 0 2 1]"},
+		{"Multiple keys", []SortSpec{{Field: "age"}, {Field: "name", Descending: true}}, "[4 3 1 0 2]"},
+		{"Score", []SortSpec{{Field: "_score", Descending: true}}, "[3 1 2 0 4]"},
+		{"Missing field", []SortSpec{{Field: "unknown"}}, "[3 1 2 0 4]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := newResults()
+			results.SortBy(tt.specs)
+			if got := ids(results); got != tt.want {
+				t.Errorf("SortBy() order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Times compare chronologically
+	early := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	results := &Results{
+		hits: []*Result{
+			{ID: "late", Source: newDoc("x", early.Add(time.Hour))},
+			{ID: "early", Source: newDoc("y", early)},
+		},
+	}
+	results.SortBy([]SortSpec{{Field: "age"}})
+	if got := ids(results); got != "[early late]" {
+		t.Errorf("SortBy() on times = %s, want [early late]", got)
+	}
+}
+
+func TestResultsSortByFieldData(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	for _, price := range []int{30, 10, 20} {
+		doc := document.NewDocument()
+		doc.AddField("title", "item")
+		doc.AddField("price", price)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	results, err := NewSearch(idx, store).SearchWithQuery(query.NewMatchQuery("title", "item"))
+	if err != nil {
+		t.Fatalf("Failed to search: %v", err)
+	}
+
+	// Sorting reads the index's field data, not the hits' documents
+	for _, hit := range results.GetHits() {
+		hit.Source = nil
+	}
+	results.SortBy([]SortSpec{{Field: "price"}})
+	var order []int
+	for _, hit := range results.GetHits() {
+		value, _ := idx.FieldData("price").Value(hit.DocID)
+		order = append(order, value.(int))
+	}
+	if fmt.Sprint(order) != "[10 20 30]" {
+		t.Errorf("SortBy() prices = %v, want [10 20 30]", order)
+	}
+}
+
+func TestSourceFilter(t *testing.T) {
+	doc := document.NewDocument()
+	doc.AddField("title", "Filtering")
+	doc.AddField("body", "A long body")
+	doc.AddField("meta_author", "alice")
+	doc.AddField("meta_date", "2024-01-01")
+	results := &Results{hits: []*Result{{ID: "0", Score: 1.0, Source: doc}}}
+
+	tests := []struct {
+		name   string
+		filter SourceFilter
+		want   []string
+	}{
+		{"No filter", SourceFilter{}, []string{"body", "meta_author", "meta_date", "title"}},
+		{"Include only", SourceFilter{Includes: []string{"title"}}, []string{"title"}},
+		{"Exclude only", SourceFilter{Excludes: []string{"body"}}, []string{"meta_author", "meta_date", "title"}},
+		{"Wildcard include", SourceFilter{Includes: []string{"meta_*"}}, []string{"meta_author", "meta_date"}},
+		{"Exclude wins", SourceFilter{Includes: []string{"title", "body"}, Excludes: []string{"body"}}, []string{"title"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := FormatESResponseWithOptions(results, 0, "test", ResponseOptions{Source: tt.filter})
+			var fields []string
+			for field := range response.Hits.Hits[0].Source {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			if fmt.Sprint(fields) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected source fields %v, got %v", tt.want, fields)
+			}
+		})
+	}
+
+	response := FormatESResponseWithOptions(results, 0, "test", ResponseOptions{Source: SourceFilter{Disabled: true}})
+	if response.Hits.Hits[0].Source != nil {
+		t.Errorf("Expected no source when disabled, got %v", response.Hits.Hits[0].Source)
+	}
+}
+
+func TestSearchHitVersion(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	doc := document.NewDocument()
+	doc.AddField("title", "draft")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	updated := document.NewDocument()
+	updated.AddField("title", "published")
+	if err := idx.UpdateDocument(docID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	store.docs[docID] = updated
+
+	results, err := search.Search([]string{"published"}, OR)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	// Version metadata is only included when requested
+	response := FormatESResponse(results, 0, "test")
+	if len(response.Hits.Hits) != 1 {
+		t.Fatalf("Expected 1 hit, got %d", len(response.Hits.Hits))
+	}
+	if response.Hits.Hits[0].Version != nil {
+		t.Error("Expected no _version without the version option")
+	}
+
+	response = FormatESResponseWithOptions(results, 0, "test", ResponseOptions{Version: true})
+	hit := response.Hits.Hits[0]
+	if hit.Version == nil || *hit.Version != 2 {
+		t.Errorf("Expected _version 2, got %v", hit.Version)
+	}
+	if hit.SeqNo == nil || *hit.SeqNo != 1 {
+		t.Errorf("Expected _seq_no 1, got %v", hit.SeqNo)
+	}
+}
+
+func TestIndexAndQueryNormalization(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	doc := document.NewDocument()
+	doc.AddField("title", "Running!")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	store.docs[docID] = doc
+	search := NewSearch(idx, store)
+
+	for _, term := range []string{"running", "RUNNING!"} {
+		for _, q := range []query.Query{query.NewTermQuery("title", term), query.NewMatchQuery("title", term)} {
+			results, err := NewQueryExecutor(search).Execute(q)
+			if err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+			if results.Len() != 1 {
+				t.Errorf("Expected executor to find %q with %T, got %d hits", term, q, results.Len())
+			}
+
+			results, err = search.SearchWithQuery(q)
+			if err != nil {
+				t.Fatalf("SearchWithQuery failed: %v", err)
+			}
+			if results.Len() != 1 {
+				t.Errorf("Expected SearchWithQuery to find %q with %T, got %d hits", term, q, results.Len())
+			}
+		}
+	}
+}
+
+func TestBucketLimits(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	for i := 0; i < 100; i++ {
+		doc := document.NewDocument()
+		doc.AddField("user_id", fmt.Sprintf("user-%d", i))
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+	search := NewSearch(idx, newMockStore())
+
+	// bucketUserIDs creates one bucket per distinct user_id, as a terms
+	// aggregation would
+	bucketUserIDs := func(budget *BucketBudget, name string) error {
+		fd := idx.FieldData("user_id")
+		buckets := make(map[interface{}]int)
+		for docID := 0; docID < idx.GetNextDocID(); docID++ {
+			value, ok := fd.Value(docID)
+			if !ok {
+				continue
+			}
+			if _, exists := buckets[value]; !exists {
+				if err := budget.AddBucket(name); err != nil {
+					return err
+				}
+			}
+			buckets[value]++
+		}
+		return nil
+	}
+
+	if err := bucketUserIDs(search.NewBucketBudget(), "by_user"); err != nil {
+		t.Fatalf("Expected default limits to allow 100 buckets, got %v", err)
+	}
+
+	search.SetAggregationLimits(AggregationLimits{MaxBuckets: 10})
+	budget := search.NewBucketBudget()
+	var tooMany *TooManyBucketsError
+	if err := bucketUserIDs(budget, "by_user"); !errors.As(err, &tooMany) {
+		t.Fatalf("Expected TooManyBucketsError, got %v", err)
+	}
+	if tooMany.Limit != 10 || tooMany.Total || tooMany.Type() != "too_many_buckets_exception" {
+		t.Errorf("Unexpected error details: %+v", tooMany)
+	}
+	if budget.Buckets() != 10 {
+		t.Errorf("Expected bucketing to stop at 10 buckets, got %d", budget.Buckets())
+	}
+
+	// The per-request cap applies across aggregations
+	search.SetAggregationLimits(AggregationLimits{MaxBuckets: 100, MaxTotalBuckets: 150})
+	budget = search.NewBucketBudget()
+	if err := bucketUserIDs(budget, "first"); err != nil {
+		t.Fatalf("Expected first aggregation to fit, got %v", err)
+	}
+	if err := bucketUserIDs(budget, "second"); !errors.As(err, &tooMany) || !tooMany.Total {
+		t.Errorf("Expected per-request TooManyBucketsError, got %v", err)
+	}
+}
+
+func TestTermsAggregation(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	orders := []struct {
+		status string
+		region string
+	}{
+		{"shipped", "east"}, {"pending", "west"}, {"shipped", "west"},
+		{"cancelled", "east"}, {"shipped", "east"}, {"pending", "east"},
+	}
+	for i, order := range orders {
+		doc := document.NewDocument()
+		doc.AddField("order", fmt.Sprintf("order %d", i))
+		doc.AddField("status", order.status)
+		doc.AddField("region", order.region)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	aggregate := func(q query.Query, agg TermsAggregation) *TermsResult {
+		t.Helper()
+		results, err := search.SearchWithQuery(q)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		computed, err := search.NewAggregator().Aggregate(results, map[string]Aggregation{"agg": agg})
+		if err != nil {
+			t.Fatalf("Aggregate failed: %v", err)
+		}
+		return computed["agg"].(*TermsResult)
+	}
+
+	result := aggregate(query.NewMatchAllQuery(), TermsAggregation{Field: "status"})
+	want := []TermsBucket{{"shipped", 3}, {"pending", 2}, {"cancelled", 1}}
+	if !reflect.DeepEqual(result.Buckets, want) || result.SumOtherDocCount != 0 {
+		t.Errorf("Expected buckets %v, got %+v", want, result)
+	}
+
+	// Only matching documents are counted
+	result = aggregate(query.NewTermQuery("region", "east"), TermsAggregation{Field: "status"})
+	want = []TermsBucket{{"shipped", 2}, {"cancelled", 1}, {"pending", 1}}
+	if !reflect.DeepEqual(result.Buckets, want) {
+		t.Errorf("Expected buckets %v for east orders, got %v", want, result.Buckets)
+	}
+
+	// Size keeps the most frequent buckets and counts the rest
+	result = aggregate(query.NewMatchAllQuery(), TermsAggregation{Field: "status", Size: 1})
+	if !reflect.DeepEqual(result.Buckets, []TermsBucket{{"shipped", 3}}) || result.SumOtherDocCount != 3 {
+		t.Errorf("Expected one bucket with 3 other documents, got %+v", result)
+	}
+
+	// Bucket limits apply
+	search.SetAggregationLimits(AggregationLimits{MaxBuckets: 2})
+	results, err := search.SearchWithQuery(query.NewMatchAllQuery())
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	var tooMany *TooManyBucketsError
+	if _, err := search.NewAggregator().Aggregate(results, map[string]Aggregation{"agg": TermsAggregation{Field: "status"}}); !errors.As(err, &tooMany) {
+		t.Errorf("Expected TooManyBucketsError, got %v", err)
+	}
+}
+
+func TestMetricAggregations(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	people := []map[string]interface{}{
+		{"name": "alice", "age": 30.0},
+		{"name": "bob", "age": 25},
+		{"name": "carol"},                  // No age
+		{"name": "dave", "age": "unknown"}, // Not numeric
+		{"name": "erin", "age": 41.0},
+	}
+	for _, fields := range people {
+		doc := document.NewDocument()
+		for name, value := range fields {
+			doc.AddField(name, value)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	metric := func(q query.Query, agg MetricAggregation) *float64 {
+		t.Helper()
+		results, err := search.SearchWithQuery(q)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		computed, err := search.NewAggregator().Aggregate(results, map[string]Aggregation{"agg": agg})
+		if err != nil {
+			t.Fatalf("Aggregate failed: %v", err)
+		}
+		return computed["agg"].(*MetricResult).Value
+	}
+
+	tests := []struct {
+		metric string
+		want   float64
+	}{
+		{MetricAvg, 32},
+		{MetricSum, 96},
+		{MetricMin, 25},
+		{MetricMax, 41},
+	}
+	for _, tt := range tests {
+		value := metric(query.NewMatchAllQuery(), MetricAggregation{Metric: tt.metric, Field: "age"})
+		if value == nil || *value != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.metric, tt.want, value)
+		}
+	}
+
+	// Only documents without a numeric age match
+	noAges := query.NewTermsQuery("name", []string{"carol", "dave"})
+	if value := metric(noAges, MetricAggregation{Metric: MetricAvg, Field: "age"}); value != nil {
+		t.Errorf("Expected no average without values, got %v", *value)
+	}
+	if value := metric(noAges, MetricAggregation{Metric: MetricSum, Field: "age"}); value == nil || *value != 0 {
+		t.Errorf("Expected a sum of 0 without values, got %v", value)
+	}
+}
+
+func TestDateHistogramAggregation(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	timestamps := []interface{}{
+		time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC),
+		"2024-03-04T23:59:59Z",
+		// 01:15 on March 6th in UTC, still March 5th in New York
+		time.Date(2024, 3, 5, 20, 15, 0, 0, time.FixedZone("EST", -5*60*60)),
+		"2024-03-07T08:00:00+00:00",
+	}
+	for i, timestamp := range timestamps {
+		doc := document.NewDocument()
+		doc.AddField("event", fmt.Sprintf("event %d", i))
+		doc.AddField("timestamp", timestamp)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+
+	histogram := func(q query.Query, agg DateHistogramAggregation) []DateHistogramBucket {
+		t.Helper()
+		results, err := search.SearchWithQuery(q)
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		computed, err := search.NewAggregator().Aggregate(results, map[string]Aggregation{"agg": agg})
+		if err != nil {
+			t.Fatalf("Aggregate failed: %v", err)
+		}
+		return computed["agg"].(*DateHistogramResult).Buckets
+	}
+	day := func(d int) int64 {
+		return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC).UnixMilli()
+	}
+
+	// Three documents across two days
+	firstThree := query.NewTermsQuery("event", []string{"0", "1", "2"})
+	buckets := histogram(firstThree, DateHistogramAggregation{Field: "timestamp", CalendarInterval: IntervalDay})
+	want := []DateHistogramBucket{
+		{KeyAsString: "2024-03-04T00:00:00.000Z", Key: day(4), DocCount: 2},
+		{KeyAsString: "2024-03-05T00:00:00.000Z", Key: day(5), DocCount: 0},
+		{KeyAsString: "2024-03-06T00:00:00.000Z", Key: day(6), DocCount: 1},
+	}
+	if !reflect.DeepEqual(buckets, want) {
+		t.Errorf("Expected daily buckets %v, got %v", want, buckets)
+	}
+
+	// Weeks start on Monday; March 4th 2024 is a Monday
+	buckets = histogram(query.NewMatchAllQuery(), DateHistogramAggregation{Field: "timestamp", CalendarInterval: "1w"})
+	if len(buckets) != 1 || buckets[0].Key != day(4) || buckets[0].DocCount != 4 {
+		t.Errorf("Expected one weekly bucket of 4 documents, got %v", buckets)
+	}
+
+	// Fixed intervals are aligned to the epoch
+	buckets = histogram(firstThree, DateHistogramAggregation{Field: "timestamp", FixedInterval: 12 * time.Hour})
+	var keys []int64
+	for _, bucket := range buckets {
+		keys = append(keys, bucket.Key)
+	}
+	halfDay := int64(12 * time.Hour / time.Millisecond)
+	wantKeys := []int64{day(4), day(4) + halfDay, day(5), day(5) + halfDay, day(6)}
+	if !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("Expected 12h bucket keys %v, got %v", wantKeys, keys)
+	}
+
+	if _, err := ParseFixedInterval("1w"); err == nil {
+		t.Error("Expected an error for a fixed interval in weeks")
+	}
+	if interval, err := ParseFixedInterval("90m"); err != nil || interval != 90*time.Minute {
+		t.Errorf("ParseFixedInterval(90m) = %v, %v", interval, err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	doc := document.NewDocument()
+	doc.AddField("title", "The Quick brown fox")
+	doc.AddField("body", "A fox, the quickest of animals, outran the hounds. Quick thinking saved the fox again.")
+	docID, err := idx.AddDocument(doc)
+	if err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	store.docs[docID] = doc
+
+	tests := []struct {
+		name  string
+		query query.Query
+		opts  HighlightOptions
+		want  map[string][]string
+	}{
+		{
+			name:  "Match query wraps analyzed terms",
+			query: query.NewMatchQuery("title", "quick FOX"),
+			opts:  HighlightOptions{Fields: []string{"title"}},
+			want:  map[string][]string{"title": {"The <em>Quick</em> brown <em>fox</em>"}},
+		},
+		{
+			name:  "Custom tags",
+			query: query.NewMatchQuery("title", "brown"),
+			opts:  HighlightOptions{Fields: []string{"title"}, PreTag: "<b>", PostTag: "</b>"},
+			want:  map[string][]string{"title": {"The Quick <b>brown</b> fox"}},
+		},
+		{
+			name:  "Punctuation stays outside the tags",
+			query: query.NewMatchQuery("body", "fox"),
+			opts:  HighlightOptions{Fields: []string{"body"}},
+			want: map[string][]string{"body": {
+				"A <em>fox</em>, the quickest of animals, outran the hounds. Quick thinking saved the <em>fox</em> again.",
+			}},
+		},
+		{
+			name:  "Fragments",
+			query: query.NewMatchQuery("body", "fox"),
+			opts:  HighlightOptions{Fields: []string{"body"}, FragmentSize: 20},
+			want:  map[string][]string{"body": {"<em>fox</em>, the quickest of", "<em>fox</em> again."}},
+		},
+		{
+			name:  "Field patterns",
+			query: query.NewMatchQuery("_all", "quick"),
+			opts:  HighlightOptions{Fields: []string{"*"}, FragmentSize: 15},
+			want: map[string][]string{
+				"title": {"<em>Quick</em> brown fox"},
+				"body":  {"<em>Quick</em> thinking saved"},
+			},
+		},
+		{
+			name: "Must not terms are not highlighted",
+			query: func() query.Query {
+				q := query.NewBooleanQuery()
+				q.AddMust(query.NewMatchQuery("title", "quick"))
+				q.AddMustNot(query.NewMatchQuery("title", "fox"))
+				return q
+			}(),
+			opts: HighlightOptions{Fields: []string{"title"}},
+			want: map[string][]string{"title": {"The <em>Quick</em> brown fox"}},
+		},
+		{
+			name:  "No match",
+			query: query.NewMatchQuery("title", "hound"),
+			opts:  HighlightOptions{Fields: []string{"title"}},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := &Results{hits: []*Result{{ID: "0", Score: 1.0, Source: doc}}}
+			search.Highlight(results, tt.query, tt.opts)
+
+			response := FormatESResponse(results, 0, "test")
+			got := response.Hits.Hits[0].Highlight
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Expected highlight %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBM25LengthNormalization(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	// Both documents contain "fox" once; the long one is mostly other words
+	texts := []string{
+		"the fox",
+		"the fox ran through the field past the farm and into the forest beyond",
+		"nothing relevant here",
+	}
+	var ids []int
+	for _, text := range texts {
+		doc := document.NewDocument()
+		doc.AddField("body", text)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		ids = append(ids, docID)
+	}
+
+	if got := search.ScoringParams(); got != DefaultScoringParams {
+		t.Errorf("Expected default scoring params %+v, got %+v", DefaultScoringParams, got)
+	}
+
+	scores := func() map[int]float64 {
+		results, err := search.SearchWithQuery(query.NewMatchQuery("body", "fox"))
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		scores := make(map[int]float64)
+		for _, hit := range results.GetHits() {
+			scores[hit.DocID] = hit.Score
+		}
+		return scores
+	}
+
+	got := scores()
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 hits, got %d", len(got))
+	}
+	if got[ids[0]] <= got[ids[1]] {
+		t.Errorf("Expected the short document to outscore the long one, got %v and %v", got[ids[0]], got[ids[1]])
+	}
+
+	// The executor scores the same way
+	results, err := NewQueryExecutor(search).Execute(query.NewMatchQuery("body", "fox"))
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if hits := results.GetHits(); len(hits) != 2 || hits[0].DocID != ids[0] || hits[0].Score != got[ids[0]] {
+		t.Errorf("Expected the executor to rank document %d first with score %v", ids[0], got[ids[0]])
+	}
+
+	// Without length normalization the term frequencies tie
+	search.SetScoringParams(ScoringParams{K1: 1.2, B: 0})
+	got = scores()
+	if got[ids[0]] != got[ids[1]] {
+		t.Errorf("Expected equal scores with b=0, got %v and %v", got[ids[0]], got[ids[1]])
+	}
+}
+
+func TestFieldScopedTermQuery(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	add := func(title, body string) int {
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		doc.AddField("body", body)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		return docID
+	}
+	inTitle := add("Golang tips", "short notes")
+	add("Notes", "golang everywhere")
+	updatedID := add("Rust tips", "no match yet")
+
+	// An updated document is matched by its new title
+	updated := document.NewDocument()
+	updated.AddField("title", "More golang")
+	updated.AddField("body", "rewritten")
+	if err := idx.UpdateDocument(updatedID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	store.docs[updatedID] = updated
+
+	want := fmt.Sprint([]int{inTitle, updatedID})
+	for _, q := range []query.Query{query.NewTermQuery("title", "golang"), query.NewMatchQuery("title", "golang")} {
+		results, err := NewQueryExecutor(search).Execute(q)
+		if err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if got := fmt.Sprint(sortedDocIDs(results)); got != want {
+			t.Errorf("Expected executor %T on title to match %s, got %s", q, want, got)
+		}
+
+		results, err = search.SearchWithQuery(q)
+		if err != nil {
+			t.Fatalf("SearchWithQuery failed: %v", err)
+		}
+		if got := fmt.Sprint(sortedDocIDs(results)); got != want {
+			t.Errorf("Expected SearchWithQuery %T on title to match %s, got %s", q, want, got)
+		}
+	}
+}
+
+func TestArrayFieldQueries(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	add := func(tags []string, sizes []interface{}) int {
+		doc := document.NewDocument()
+		doc.AddField("tags", tags)
+		doc.AddField("sizes", sizes)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		return docID
+	}
+	goDoc := add([]string{"go", "search"}, []interface{}{1, 8})
+	rustDoc := add([]string{"rust", "systems programming"}, []interface{}{3})
+	updatedID := add([]string{"java"}, []interface{}{20})
+
+	// Updating an array field replaces every element's terms
+	updated := document.NewDocument()
+	updated.AddField("tags", []string{"kotlin", "go"})
+	updated.AddField("sizes", []interface{}{2.5, 30})
+	if err := idx.UpdateDocument(updatedID, updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	store.docs[updatedID] = updated
+
+	between := func(gte, lte float64) query.Query {
+		q := query.NewRangeQuery("sizes")
+		q.GreaterThanOrEqual(gte)
+		q.LessThanOrEqual(lte)
+		return q
+	}
+
+	tests := []struct {
+		name string
+		q    query.Query
+		want []int
+	}{
+		{"term on any element", query.NewTermQuery("tags", "go"), []int{goDoc, updatedID}},
+		{"term on a later element", query.NewTermQuery("tags", "programming"), []int{rustDoc}},
+		{"term on a replaced element", query.NewTermQuery("tags", "java"), []int{}},
+		{"phrase within an element", query.NewMatchPhraseQuery("tags", "systems programming"), []int{rustDoc}},
+		{"phrase across elements", query.NewMatchPhraseQuery("tags", "go search"), []int{}},
+		{"range on any element", between(5, 10), []int{goDoc}},
+		{"range on a decimal element", between(2, 3), []int{rustDoc, updatedID}},
+	}
+	for _, tt := range tests {
+		results, err := NewQueryExecutor(search).Execute(tt.q)
+		if err != nil {
+			t.Fatalf("%s: Execute failed: %v", tt.name, err)
+		}
+		if got, want := fmt.Sprint(sortedDocIDs(results)), fmt.Sprint(tt.want); got != want {
+			t.Errorf("%s: expected %s, got %s", tt.name, want, got)
+		}
+	}
+}
+
+func TestNestedObjectQueries(t *testing.T) {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	search := NewSearch(idx, store)
+
+	add := func(name string, age int, city string) int {
+		doc := document.NewDocument()
+		doc.AddField("title", "Notes by "+name)
+		doc.AddField("author", map[string]interface{}{
+			"name":    name,
+			"age":     age,
+			"address": map[string]interface{}{"city": city},
+		})
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+		return docID
+	}
+	jane := add("Jane", 30, "Paris")
+	john := add("John", 45, "Lyon")
+
+	older := query.NewRangeQuery("author.age")
+	older.GreaterThan(40.0)
+
+	tests := []struct {
+		name string
+		q    query.Query
+		want []int
+	}{
+		{"term on a nested field", query.NewTermQuery("author.name", "jane"), []int{jane}},
+		{"term on a two-level nested field", query.NewTermQuery("author.address.city", "lyon"), []int{john}},
+		{"term scoped to the nested field", query.NewTermQuery("author.name", "notes"), []int{}},
+		{"range on a nested field", older, []int{john}},
+		{"exists on a nested field", query.NewExistsQuery("author.address.city"), []int{jane, john}},
+	}
+	for _, tt := range tests {
+		results, err := NewQueryExecutor(search).Execute(tt.q)
+		if err != nil {
+			t.Fatalf("%s: Execute failed: %v", tt.name, err)
+		}
+		if got, want := fmt.Sprint(sortedDocIDs(results)), fmt.Sprint(tt.want); got != want {
+			t.Errorf("%s: expected %s, got %s", tt.name, want, got)
+		}
+	}
+}
+
+// sortedDocIDs returns the document IDs of the hits in ascending order
+func sortedDocIDs(results *Results) []int {
+	ids := make([]int, 0, results.Len())
+	for _, hit := range results.GetHits() {
+		ids = append(ids, hit.DocID)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// uncachedScore scores a document the way Search did before scorers, looking
+// up the index statistics and posting lists for every document
+func uncachedScore(s *Search, docID int, terms []string) float64 {
+	var score float64
+	N := float64(s.idx.GetDocumentCount())
+	docLen := float64(s.idx.DocumentLength(docID))
+	avgLen := s.idx.AverageDocumentLength()
+	for _, term := range terms {
+		postings := s.idx.GetPostings(term)
+		entry, exists := postings[docID]
+		if !exists {
+			continue
+		}
+		score += s.termScore(float64(entry.TermFreq), float64(len(postings)), N, docLen, avgLen)
+	}
+	return score
+}
+
+// newScoringIndex returns a search over numDocs documents drawing their
+// titles from a small vocabulary
+func newScoringIndex(tb testing.TB, numDocs int) *Search {
+	idx := index.NewIndex(analysis.NewStandardAnalyzer())
+	store := newMockStore()
+	words := []string{"quick", "brown", "fox", "lazy", "dog", "jumps", "over", "fence"}
+	for i := 0; i < numDocs; i++ {
+		title := ""
+		for j := 0; j <= i%5; j++ {
+			title += words[(i*7+j*3)%len(words)] + " "
+		}
+		doc := document.NewDocument()
+		doc.AddField("title", title)
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			tb.Fatalf("Failed to add document: %v", err)
+		}
+		store.docs[docID] = doc
+	}
+	return NewSearch(idx, store)
+}
+
+func TestScorerMatchesUncachedScores(t *testing.T) {
+	search := newScoringIndex(t, 200)
+	terms := []string{"quick", "fox", "fence", "missing"}
+
+	scorer := search.newScorer()
+	scored := 0
+	for docID := 0; docID < 200; docID++ {
+		got := scorer.score(docID, terms)
+		want := uncachedScore(search, docID, terms)
+		if got != want {
+			t.Fatalf("Expected document %d to score %f, got %f", docID, want, got)
+		}
+		if got > 0 {
+			scored++
+		}
+	}
+	if scored == 0 {
+		t.Fatal("Expected some documents to match the terms")
+	}
+}
+
+func BenchmarkScoring(b *testing.B) {
+	const numDocs = 10000
+	search := newScoringIndex(b, numDocs)
+	terms := []string{"quick", "brown", "fox"}
+
+	b.Run("Uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for docID := 0; docID < numDocs; docID++ {
+				uncachedScore(search, docID, terms)
+			}
+		}
+	})
+
+	b.Run("Scorer", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			scorer := search.newScorer()
+			for docID := 0; docID < numDocs; docID++ {
+				scorer.score(docID, terms)
+			}
+		}
+	})
+}
+
@@ -0,0 +1,46 @@
+package search
+
+import "container/heap"
+
+// scoredDoc is a matching document and its score, before the document is
+// loaded
+type scoredDoc struct {
+	docID int
+	score float64
+}
+
+// topDocs holds the size best scoring documents offered to it in a min-heap,
+// with the lowest held score on top
+type topDocs struct {
+	size int
+	docs []scoredDoc
+}
+
+// newTopDocs returns a collector holding the size best scoring documents
+func newTopDocs(size int) *topDocs {
+	return &topDocs{size: size, docs: make([]scoredDoc, 0, size)}
+}
+
+// offer holds a document while fewer than size are held, or in place of the
+// lowest scoring one when it scores higher
+func (t *topDocs) offer(docID int, score float64) {
+	if len(t.docs) < t.size {
+		heap.Push(t, scoredDoc{docID: docID, score: score})
+		return
+	}
+	if score > t.docs[0].score {
+		t.docs[0] = scoredDoc{docID: docID, score: score}
+		heap.Fix(t, 0)
+	}
+}
+
+// heap.Interface implementation
+func (t *topDocs) Len() int           { return len(t.docs) }
+func (t *topDocs) Less(i, j int) bool { return t.docs[i].score < t.docs[j].score }
+func (t *topDocs) Swap(i, j int)      { t.docs[i], t.docs[j] = t.docs[j], t.docs[i] }
+func (t *topDocs) Push(x interface{}) { t.docs = append(t.docs, x.(scoredDoc)) }
+func (t *topDocs) Pop() interface{} {
+	last := t.docs[len(t.docs)-1]
+	t.docs = t.docs[:len(t.docs)-1]
+	return last
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"my-indexer/document"
+	"my-indexer/index"
+)
+
+// exportedDocument is a document in a JSON export, with its ID and fields
+type exportedDocument struct {
+	ID     int                `json:"_id"`
+	Source *document.Document `json:"_source"`
+}
+
+// exportData is the JSON form of an exported index. Only the documents are
+// exported: the postings are rebuilt from them on import.
+type exportData struct {
+	Documents []exportedDocument `json:"documents"`
+}
+
+// ExportJSON writes the documents of an index to w as indented JSON, in
+// document ID order
+func ExportJSON(idx *index.Index, w io.Writer) error {
+	data := exportData{Documents: make([]exportedDocument, 0, idx.GetDocumentCount())}
+	idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		data.Documents = append(data.Documents, exportedDocument{ID: docID, Source: doc})
+		return true
+	})
+	sort.Slice(data.Documents, func(i, j int) bool {
+		return data.Documents[i].ID < data.Documents[j].ID
+	})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(data); err != nil {
+		return fmt.Errorf("failed to encode export: %w", err)
+	}
+	return nil
+}
+
+// ImportJSON reads documents written by ExportJSON from r and indexes them in
+// idx under their exported IDs, so their postings are regenerated
+func ImportJSON(r io.Reader, idx *index.Index) error {
+	var data exportData
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("failed to decode export: %w", err)
+	}
+
+	for _, exported := range data.Documents {
+		if exported.Source == nil {
+			return fmt.Errorf("document %d has no source", exported.ID)
+		}
+		if err := idx.CreateDocument(exported.ID, exported.Source); err != nil {
+			return fmt.Errorf("failed to import document %d: %w", exported.ID, err)
+		}
+	}
+	return nil
+}
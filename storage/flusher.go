@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"my-indexer/index"
+)
+
+// Flusher periodically persists an index to storage in the background, like
+// Elasticsearch's refresh interval. Saves are skipped while the index
+// generation is unchanged since the last save.
+type Flusher struct {
+	mu        sync.Mutex
+	idx       *index.Index
+	storage   *IndexStorage
+	interval  time.Duration
+	savedGen  uint64 // Index generation covered by the last save
+	saves     int    // Number of saves performed
+	lastErr   error  // Error of the most recent failed save
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+}
+
+// NewFlusher creates a flusher that saves idx to storage every interval. The
+// index is treated as persisted at its current generation, so only later
+// changes trigger a save.
+func NewFlusher(idx *index.Index, storage *IndexStorage, interval time.Duration) *Flusher {
+	return &Flusher{
+		idx:      idx,
+		storage:  storage,
+		interval: interval,
+		savedGen: idx.Generation(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins flushing in a background goroutine
+func (f *Flusher) Start() {
+	f.startOnce.Do(func() {
+		go f.run()
+	})
+}
+
+// run flushes on every tick until Stop is called
+func (f *Flusher) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			f.Flush()
+		case <-f.stop:
+			return
+		}
+	}
+}
+
+// Flush saves the index if it changed since the last save and reports
+// whether a save happened
+func (f *Flusher) Flush() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	gen := f.idx.Generation()
+	if gen == f.savedGen {
+		return false, nil
+	}
+
+	if err := f.storage.SaveIndex(f.idx); err != nil {
+		f.lastErr = err
+		return false, err
+	}
+
+	// Writes made during the save bump the generation past gen, so the next
+	// flush saves again
+	f.savedGen = gen
+	f.saves++
+	return true, nil
+}
+
+// Stop stops the background goroutine and performs a final flush so no
+// changes are lost on shutdown
+func (f *Flusher) Stop() error {
+	var err error
+	f.stopOnce.Do(func() {
+		close(f.stop)
+		f.startOnce.Do(func() { close(f.done) })
+		<-f.done
+		_, err = f.Flush()
+	})
+	return err
+}
+
+// Saves returns the number of saves performed
+func (f *Flusher) Saves() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.saves
+}
+
+// Err returns the error of the most recent failed save, if any
+func (f *Flusher) Err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastErr
+}
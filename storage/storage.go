@@ -1,29 +1,43 @@
 package storage
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/gob"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"my-indexer/document"
 	"my-indexer/index"
 )
 
+func init() {
+	// Array, object and date field values are held in interface values, which gob
+	// can only encode once their concrete types are registered
+	gob.Register([]interface{}{})
+	gob.Register(map[string]interface{}{})
+	gob.Register(time.Time{})
+}
+
 // IndexStorage handles persistence of the index
 type IndexStorage struct {
 	mu           sync.RWMutex
 	indexPath    string
 	documentsDir string
+	compress     bool // Whether files are written gzip compressed
 }
 
 // IndexData represents the serializable form of the index
 type IndexData struct {
-	Terms    map[string]*index.PostingList
-	DocCount int
-	NextID   int
+	Terms     map[string]*index.PostingList
+	DocCount  int
+	NextID    int
+	Documents map[int]DocumentData // Stored documents by ID; missing from older index files
 }
 
 // DocumentData represents the serializable form of a document
@@ -68,8 +82,15 @@ func validateIndexFilename(filename string) error {
 	return nil
 }
 
-// NewIndexStorage creates a new index storage
+// NewIndexStorage creates a new index storage that writes uncompressed files
 func NewIndexStorage(baseDir string, indexFilename string) (*IndexStorage, error) {
+	return NewIndexStorageWithOptions(baseDir, indexFilename, false)
+}
+
+// NewIndexStorageWithOptions creates a new index storage, gzip compressing the
+// index and document files it writes when compress is set. Files are loaded
+// whether or not they are compressed.
+func NewIndexStorageWithOptions(baseDir string, indexFilename string, compress bool) (*IndexStorage, error) {
 	// Create base directory if it doesn't exist
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create base directory: %w", err)
@@ -94,11 +115,60 @@ func NewIndexStorage(baseDir string, indexFilename string) (*IndexStorage, error
 	return &IndexStorage{
 		indexPath:    filepath.Join(baseDir, indexFilename),
 		documentsDir: documentsDir,
+		compress:     compress,
 	}, nil
 }
 
+// gzipMagic is the header every gzip stream starts with. A gob stream can't
+// start with it, so it tells compressed files apart from uncompressed ones.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// encode writes the gob encoding of data to w, gzip compressed if the storage
+// compresses its files
+func (s *IndexStorage) encode(w io.Writer, data interface{}) error {
+	if !s.compress {
+		return gob.NewEncoder(w).Encode(data)
+	}
+
+	zw := gzip.NewWriter(w)
+	if err := gob.NewEncoder(zw).Encode(data); err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// decode reads gob encoded data from r into data, decompressing it first if
+// it is gzip compressed
+func decode(r io.Reader, data interface{}) error {
+	br := bufio.NewReader(r)
+	if header, err := br.Peek(len(gzipMagic)); err == nil && string(header) == string(gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return gob.NewDecoder(zr).Decode(data)
+	}
+	return gob.NewDecoder(br).Decode(data)
+}
+
 // SaveIndex persists the index to disk
 func (s *IndexStorage) SaveIndex(idx *index.Index) error {
+	// Copy the index data before taking the storage lock. Index writes hold
+	// the index lock while saving documents here, so taking the locks in the
+	// other order would deadlock.
+	data := &IndexData{
+		Terms:     idx.GetTerms(),
+		DocCount:  idx.GetDocumentCount(),
+		NextID:    idx.GetNextDocID(),
+		Documents: make(map[int]DocumentData),
+	}
+	idx.ForEachDocument(func(docID int, doc *document.Document) bool {
+		data.Documents[docID] = DocumentData{Fields: doc.GetFields()}
+		return true
+	})
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -110,16 +180,8 @@ func (s *IndexStorage) SaveIndex(idx *index.Index) error {
 	}
 	defer file.Close()
 
-	// Prepare index data for serialization
-	data := &IndexData{
-		Terms:    idx.GetTerms(),
-		DocCount: idx.GetDocumentCount(),
-		NextID:   idx.GetNextDocID(),
-	}
-
 	// Serialize index data
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
+	if err := s.encode(file, data); err != nil {
 		os.Remove(tempPath)
 		return fmt.Errorf("failed to encode index: %w", err)
 	}
@@ -154,8 +216,7 @@ func (s *IndexStorage) LoadIndex() (*index.Index, error) {
 	defer file.Close()
 
 	var data IndexData
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
+	if err := decode(file, &data); err != nil {
 		return nil, fmt.Errorf("failed to decode index: %w", err)
 	}
 
@@ -165,6 +226,21 @@ func (s *IndexStorage) LoadIndex() (*index.Index, error) {
 		return nil, fmt.Errorf("failed to restore index: %w", err)
 	}
 
+	// Older index files carry no documents; keep their terms and counts as they are
+	if len(data.Documents) > 0 {
+		docs := make(map[int]*document.Document, len(data.Documents))
+		for docID, docData := range data.Documents {
+			doc, err := docData.toDocument()
+			if err != nil {
+				return nil, fmt.Errorf("failed to restore document %d: %w", docID, err)
+			}
+			docs[docID] = doc
+		}
+		if err := idx.RestoreDocuments(docs); err != nil {
+			return nil, fmt.Errorf("failed to restore documents: %w", err)
+		}
+	}
+
 	return idx, nil
 }
 
@@ -189,8 +265,7 @@ func (s *IndexStorage) SaveDocument(docID int, doc *document.Document) error {
 	}
 
 	// Serialize document data
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
+	if err := s.encode(file, data); err != nil {
 		os.Remove(tempPath)
 		return fmt.Errorf("failed to encode document: %w", err)
 	}
@@ -223,19 +298,21 @@ func (s *IndexStorage) LoadDocument(docID int) (*document.Document, error) {
 	defer file.Close()
 
 	var data DocumentData
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&data); err != nil {
+	if err := decode(file, &data); err != nil {
 		return nil, fmt.Errorf("failed to decode document: %w", err)
 	}
 
-	// Create new document and restore its fields
+	return data.toDocument()
+}
+
+// toDocument creates a document with the serialized fields
+func (d DocumentData) toDocument() (*document.Document, error) {
 	doc := document.NewDocument()
-	for name, field := range data.Fields {
+	for name, field := range d.Fields {
 		if err := doc.AddField(name, field.Value); err != nil {
 			return nil, fmt.Errorf("failed to restore document field: %w", err)
 		}
 	}
-
 	return doc, nil
 }
 
@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"my-indexer/document"
 	"my-indexer/index"
@@ -144,6 +148,366 @@ func TestIndexStorage(t *testing.T) {
 	}
 }
 
+func TestLoadIndexRestoresDocuments(t *testing.T) {
+	storage, err := NewIndexStorage(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	idx := index.NewIndex(nil)
+	titles := map[int]string{}
+	for _, title := range []string{"First document", "Second document"} {
+		doc := document.NewDocument()
+		if err := doc.AddField("title", title); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		author := map[string]interface{}{"name": "jane", "tags": []string{"go"}}
+		if err := doc.AddField("author", author); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		titles[docID] = title
+	}
+
+	if err := storage.SaveIndex(idx); err != nil {
+		t.Fatalf("Failed to save index: %v", err)
+	}
+	loadedIdx, err := storage.LoadIndex()
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+
+	for docID, title := range titles {
+		doc, err := loadedIdx.GetDocument(docID)
+		if err != nil {
+			t.Fatalf("Failed to get document %d from the loaded index: %v", docID, err)
+		}
+		field, err := doc.GetField("title")
+		if err != nil || field.Value != title {
+			t.Errorf("Expected document %d to have title %q, got %v", docID, title, field.Value)
+		}
+		if field, err := doc.GetField("author.name"); err != nil || field.Value != "jane" {
+			t.Errorf("Expected document %d to keep its nested author, got %v (%v)", docID, field.Value, err)
+		}
+	}
+
+	docs, err := loadedIdx.GetAllDocuments()
+	if err != nil || len(docs) != 2 {
+		t.Errorf("Expected 2 documents in the loaded index, got %d (%v)", len(docs), err)
+	}
+	if docIDs := loadedIdx.DocsWithField("title"); len(docIDs) != 2 {
+		t.Errorf("Expected 2 documents with a title in the loaded index, got %v", docIDs)
+	}
+	if _, ok := loadedIdx.GetDocumentVersion(0); !ok {
+		t.Error("Expected loaded documents to have a version")
+	}
+}
+
+func TestCompressedIndexStorage(t *testing.T) {
+	idx := index.NewIndex(nil)
+	for i := 0; i < 50; i++ {
+		doc := document.NewDocument()
+		if err := doc.AddField("title", "the quick brown fox jumps over the lazy dog"); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	sizes := map[bool]int64{}
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		storage, err := NewIndexStorageWithOptions(dir, "", compress)
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		if err := storage.SaveIndex(idx); err != nil {
+			t.Fatalf("Failed to save index: %v", err)
+		}
+		info, err := os.Stat(filepath.Join(dir, DefaultIndexFilename))
+		if err != nil {
+			t.Fatalf("Failed to stat index file: %v", err)
+		}
+		sizes[compress] = info.Size()
+
+		loadedIdx, err := storage.LoadIndex()
+		if err != nil {
+			t.Fatalf("Failed to load index (compress=%v): %v", compress, err)
+		}
+		if loadedIdx.GetDocumentCount() != 50 {
+			t.Errorf("Expected 50 documents in the loaded index, got %d", loadedIdx.GetDocumentCount())
+		}
+
+		doc, _ := idx.GetDocument(0)
+		if err := storage.SaveDocument(0, doc); err != nil {
+			t.Fatalf("Failed to save document: %v", err)
+		}
+		loadedDoc, err := storage.LoadDocument(0)
+		if err != nil {
+			t.Fatalf("Failed to load document (compress=%v): %v", compress, err)
+		}
+		if field, err := loadedDoc.GetField("title"); err != nil || field.Value != "the quick brown fox jumps over the lazy dog" {
+			t.Errorf("Expected the loaded document to keep its title, got %v (%v)", field, err)
+		}
+	}
+	if sizes[true] >= sizes[false] {
+		t.Errorf("Expected the compressed index file to be smaller, got %d bytes vs %d", sizes[true], sizes[false])
+	}
+
+	// Files written uncompressed still load with compression enabled
+	dir := t.TempDir()
+	plain, err := NewIndexStorage(dir, "")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if err := plain.SaveIndex(idx); err != nil {
+		t.Fatalf("Failed to save index: %v", err)
+	}
+	compressed, err := NewIndexStorageWithOptions(dir, "", true)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	if loadedIdx, err := compressed.LoadIndex(); err != nil || loadedIdx.GetDocumentCount() != 50 {
+		t.Errorf("Expected an uncompressed index file to load, got %v", err)
+	}
+}
+
+func TestExportImportJSON(t *testing.T) {
+	idx := index.NewIndex(nil)
+	for _, title := range []string{"The quick brown fox", "A lazy dog", "Quick thinking"} {
+		doc := document.NewDocument()
+		if err := doc.AddField("title", title); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		if err := doc.AddField("views", 7); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ExportJSON(idx, &buf); err != nil {
+		t.Fatalf("Failed to export index: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"title": "A lazy dog"`) {
+		t.Errorf("Expected the export to hold the document fields, got %s", buf.String())
+	}
+
+	imported := index.NewIndex(nil)
+	if err := ImportJSON(&buf, imported); err != nil {
+		t.Fatalf("Failed to import index: %v", err)
+	}
+	if imported.GetDocumentCount() != 3 {
+		t.Fatalf("Expected 3 imported documents, got %d", imported.GetDocumentCount())
+	}
+
+	for term := range idx.GetTerms() {
+		want := idx.GetPostings(term)
+		got := imported.GetPostings(term)
+		if len(got) != len(want) {
+			t.Errorf("Expected term %q to match %d documents after import, got %d", term, len(want), len(got))
+			continue
+		}
+		for docID := range want {
+			if _, ok := got[docID]; !ok {
+				t.Errorf("Expected term %q to match document %d after import", term, docID)
+			}
+		}
+	}
+	if docIDs := imported.DocsWithField("views"); len(docIDs) != 3 {
+		t.Errorf("Expected 3 imported documents with views, got %v", docIDs)
+	}
+
+	if err := ImportJSON(strings.NewReader("not json"), index.NewIndex(nil)); err == nil {
+		t.Error("Expected an error importing invalid JSON")
+	}
+}
+
+func TestIndexPersistence(t *testing.T) {
+	storage, err := NewIndexStorage(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	idx := index.NewIndex(nil)
+	idx.SetPersistence(storage)
+	var docIDs []int
+	for _, title := range []string{"Kept document", "Deleted document"} {
+		doc := document.NewDocument()
+		if err := doc.AddField("title", title); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		docID, err := idx.AddDocument(doc)
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+		docIDs = append(docIDs, docID)
+	}
+
+	// Adds and updates are saved
+	updated := document.NewDocument()
+	updated.AddField("title", "Updated document")
+	if err := idx.UpdateDocument(docIDs[0], updated); err != nil {
+		t.Fatalf("Failed to update document: %v", err)
+	}
+	doc, err := storage.LoadDocument(docIDs[0])
+	if err != nil {
+		t.Fatalf("Expected the updated document to be saved: %v", err)
+	}
+	if field, _ := doc.GetField("title"); field.Value != "Updated document" {
+		t.Errorf("Expected the saved document to be updated, got %v", field.Value)
+	}
+
+	// Deletes remove the saved document
+	if _, err := storage.LoadDocument(docIDs[1]); err != nil {
+		t.Fatalf("Expected the added document to be saved: %v", err)
+	}
+	if err := idx.DeleteDocument(docIDs[1]); err != nil {
+		t.Fatalf("Failed to delete document: %v", err)
+	}
+	if _, err := storage.LoadDocument(docIDs[1]); err == nil {
+		t.Error("Expected the deleted document to be removed from storage")
+	}
+
+	if err := storage.SaveIndex(idx); err != nil {
+		t.Fatalf("Failed to save index: %v", err)
+	}
+	loadedIdx, err := storage.LoadIndex()
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if _, err := loadedIdx.GetDocument(docIDs[1]); err == nil {
+		t.Error("Expected the deleted document to stay deleted after reloading")
+	}
+	if loadedIdx.GetDocumentCount() != 1 {
+		t.Errorf("Expected 1 document after reloading, got %d", loadedIdx.GetDocumentCount())
+	}
+}
+
+func TestIndexPersistenceConcurrentSave(t *testing.T) {
+	storage, err := NewIndexStorage(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	idx := index.NewIndex(nil)
+	idx.SetPersistence(storage)
+
+	// Writes save documents while holding the index lock, and saving the
+	// index reads it, so the two must not wait on each other's locks
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 50; i++ {
+			doc := document.NewDocument()
+			doc.AddField("title", fmt.Sprintf("document %d", i))
+			if _, err := idx.AddDocument(doc); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for i := 0; i < 20; i++ {
+		if err := storage.SaveIndex(idx); err != nil {
+			t.Fatalf("Failed to save index: %v", err)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Adding documents while saving the index deadlocked")
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	logDir := filepath.Join(dir, "txlog")
+	storage, err := NewIndexStorage(dir, "")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	idx := index.NewIndex(nil)
+	if err := idx.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to initialize transaction log: %v", err)
+	}
+	addDocument := func(title string) {
+		doc := document.NewDocument()
+		if err := doc.AddField("title", title); err != nil {
+			t.Fatalf("Failed to add field to document: %v", err)
+		}
+		if _, err := idx.AddDocument(doc); err != nil {
+			t.Fatalf("Failed to add document: %v", err)
+		}
+	}
+	logSize := func() int64 {
+		segments, err := filepath.Glob(filepath.Join(logDir, "transaction*.log"))
+		if err != nil || len(segments) == 0 {
+			t.Fatalf("Failed to list transaction log segments: %v", err)
+		}
+		var size int64
+		for _, segment := range segments {
+			info, err := os.Stat(segment)
+			if err != nil {
+				t.Fatalf("Failed to stat transaction log: %v", err)
+			}
+			size += info.Size()
+		}
+		return size
+	}
+
+	emptyLog := logSize()
+	addDocument("First document")
+	addDocument("Second document")
+	loggedSize := logSize()
+	if loggedSize <= emptyLog {
+		t.Fatal("Expected writes to be in the transaction log")
+	}
+
+	snapshotter := idx.EnableSnapshots(storage, 10*time.Millisecond)
+	deadline := time.Now().Add(2 * time.Second)
+	for snapshotter.Snapshots() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if snapshotter.Snapshots() == 0 {
+		t.Fatalf("Expected a snapshot to be taken (%v)", snapshotter.Err())
+	}
+	if _, err := os.Stat(filepath.Join(dir, DefaultIndexFilename)); err != nil {
+		t.Errorf("Expected a snapshot file: %v", err)
+	}
+	if size := logSize(); size >= loggedSize {
+		t.Errorf("Expected the transaction log to shrink after a snapshot, got %d bytes from %d", size, loggedSize)
+	}
+
+	// Writes after the last snapshot are recovered from the log
+	if err := snapshotter.Stop(); err != nil {
+		t.Fatalf("Failed to stop snapshots: %v", err)
+	}
+	addDocument("Third document")
+	idx.Close()
+
+	recovered, err := storage.LoadIndex()
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if err := recovered.InitTransactionLog(logDir); err != nil {
+		t.Fatalf("Failed to recover transaction log: %v", err)
+	}
+	defer recovered.Close()
+	if count := recovered.GetDocumentCount(); count != 3 {
+		t.Errorf("Expected 3 documents after recovery, got %d", count)
+	}
+}
+
 func TestIndexStorageCustomFilename(t *testing.T) {
 	// Create temporary directory for testing
 	tempDir, err := os.MkdirTemp("", "indexer-test-*")
@@ -250,3 +614,61 @@ func TestConcurrentAccess(t *testing.T) {
 		<-done
 	}
 }
+
+func TestFlusher(t *testing.T) {
+	storage, err := NewIndexStorage(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+
+	idx := index.NewIndex(nil)
+	flusher := NewFlusher(idx, storage, 10*time.Millisecond)
+	flusher.Start()
+
+	// Nothing changed yet, so ticks don't save
+	time.Sleep(50 * time.Millisecond)
+	if saves := flusher.Saves(); saves != 0 {
+		t.Fatalf("Expected no saves while idle, got %d", saves)
+	}
+
+	doc := document.NewDocument()
+	doc.AddField("title", "flushed")
+	if _, err := idx.AddDocument(doc); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for flusher.Saves() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if saves := flusher.Saves(); saves != 1 {
+		t.Fatalf("Expected 1 save after a write, got %d", saves)
+	}
+
+	// Idle ticks after the save are skipped
+	time.Sleep(50 * time.Millisecond)
+	if saves := flusher.Saves(); saves != 1 {
+		t.Errorf("Expected save to be skipped while idle, got %d saves", saves)
+	}
+
+	// Stop flushes pending changes
+	doc = document.NewDocument()
+	doc.AddField("title", "on shutdown")
+	if _, err := idx.AddDocument(doc); err != nil {
+		t.Fatalf("Failed to add document: %v", err)
+	}
+	if err := flusher.Stop(); err != nil {
+		t.Fatalf("Failed to stop flusher: %v", err)
+	}
+	if flusher.Saves() < 2 {
+		t.Errorf("Expected a save on shutdown, got %d saves", flusher.Saves())
+	}
+
+	loaded, err := storage.LoadIndex()
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if count := loaded.GetDocumentCount(); count != 2 {
+		t.Errorf("Expected 2 documents in the saved index, got %d", count)
+	}
+}
@@ -0,0 +1,204 @@
+package txlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// The log file starts with a header of the magic bytes and the format
+// version. Each entry follows as a record: the length of its payload and the
+// CRC32 of the payload, both big-endian uint32s, then the payload, the JSON
+// encoding of the entry.
+const (
+	logMagic         = "MIWL"
+	logVersion       = uint16(1)
+	headerSize       = len(logMagic) + 2
+	recordHeaderSize = 8
+	maxRecordSize    = 1 << 30 // Larger lengths can only come from corruption
+)
+
+// errTornRecord is returned by readRecord for a record that was cut short or
+// corrupted
+var errTornRecord = errors.New("torn log record")
+
+// writeHeader writes the format header of a new log
+func writeHeader(w io.Writer) error {
+	header := make([]byte, headerSize)
+	copy(header, logMagic)
+	binary.BigEndian.PutUint16(header[len(logMagic):], logVersion)
+	_, err := w.Write(header)
+	return err
+}
+
+// encodeRecord returns the record of an entry
+func encodeRecord(entry *LogEntry) ([]byte, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...), nil
+}
+
+// readRecord reads the next record, returning its entry and size. It returns
+// io.EOF at the clean end of the log and errTornRecord for a record that
+// can't be read whole or fails its checksum.
+func readRecord(r io.Reader) (*LogEntry, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, errTornRecord
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, 0, errTornRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, 0, errTornRecord
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errTornRecord
+	}
+
+	var entry LogEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return nil, 0, errTornRecord
+	}
+	return &entry, int64(recordHeaderSize) + int64(length), nil
+}
+
+// readRecords reads the records following the header. It also returns the
+// length of the log up to the end of the last valid record and whether a torn
+// record followed it.
+func readRecords(r io.Reader) ([]*LogEntry, int64, bool, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		// Only part of the header made it to disk
+		return nil, 0, true, nil
+	}
+	if version := binary.BigEndian.Uint16(header[len(logMagic):]); version != logVersion {
+		return nil, 0, false, fmt.Errorf("unsupported transaction log version %d", version)
+	}
+
+	var entries []*LogEntry
+	validSize := int64(headerSize)
+	for {
+		entry, size, err := readRecord(r)
+		if err == io.EOF {
+			return entries, validSize, false, nil
+		}
+		if err != nil {
+			return entries, validSize, true, nil
+		}
+		entries = append(entries, entry)
+		validSize += size
+	}
+}
+
+// readSegment reads the entries of a segment file. A torn tail is cut from
+// the file so later records aren't appended after it, and a segment in the
+// older JSON lines format is rewritten in the binary format. The result
+// reports whether the segment was torn.
+func readSegment(path string) ([]*LogEntry, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open log segment: %v", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(len(logMagic))
+	switch {
+	case len(magic) == 0:
+		// An empty file, such as one truncated outside the log
+		return nil, false, rewriteSegment(path, nil)
+	case string(magic) != logMagic:
+		entries := readLegacyEntries(reader)
+		return entries, false, rewriteSegment(path, entries)
+	}
+
+	entries, validSize, torn, err := readRecords(reader)
+	if err != nil {
+		return nil, false, err
+	}
+	if validSize < int64(headerSize) {
+		// Only part of the header made it to disk
+		return nil, true, rewriteSegment(path, nil)
+	}
+	if torn {
+		if err := os.Truncate(path, validSize); err != nil {
+			return nil, false, fmt.Errorf("failed to truncate torn log record: %v", err)
+		}
+	}
+	return entries, torn, nil
+}
+
+// rewriteSegment replaces a segment file with one holding entries in the
+// current format. The new file is written aside and renamed over the old one.
+func rewriteSegment(path string, entries []*LogEntry) error {
+	tempPath := path + ".tmp"
+	temp, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create log file: %v", err)
+	}
+
+	err = writeHeader(temp)
+	for _, entry := range entries {
+		if err != nil {
+			break
+		}
+		var record []byte
+		if record, err = encodeRecord(entry); err == nil {
+			_, err = temp.Write(record)
+		}
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rewrite log file: %v", err)
+	}
+	return nil
+}
+
+// readLegacyEntries reads a log in the older format of one JSON entry per
+// line, stopping at the first torn or corrupt line
+func readLegacyEntries(r *bufio.Reader) []*LogEntry {
+	var entries []*LogEntry
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			// Every entry was written as a whole line, so one without its
+			// newline was torn
+			var entry LogEntry
+			if json.Unmarshal(bytes.TrimSpace(line), &entry) != nil || !bytes.HasSuffix(line, []byte{'\n'}) {
+				return entries
+			}
+			entries = append(entries, &entry)
+		}
+		if err != nil {
+			return entries
+		}
+	}
+}
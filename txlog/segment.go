@@ -0,0 +1,201 @@
+package txlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// segmentPattern matches the file names of log segments after the first:
+// transaction.1.log, transaction.2.log and so on. The first segment is
+// transaction.log.
+var segmentPattern = regexp.MustCompile(`^transaction\.(\d+)\.log$`)
+
+// segment is one file of the log. The log is written to its last segment,
+// and rolls over to a new one once that reaches the maximum segment size.
+type segment struct {
+	number    int
+	path      string
+	scanned   bool  // Whether the transaction ID range is known
+	firstTxID int64 // Lowest transaction ID recorded in the segment, 0 if none
+	lastTxID  int64 // Highest transaction ID recorded in the segment, 0 if none
+}
+
+// segmentPath returns the path of a numbered segment
+func segmentPath(dir string, number int) string {
+	if number == 0 {
+		return filepath.Join(dir, "transaction.log")
+	}
+	return filepath.Join(dir, fmt.Sprintf("transaction.%d.log", number))
+}
+
+// listSegments returns the segments in dir in order
+func listSegments(dir string) ([]*segment, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log directory: %v", err)
+	}
+
+	var segments []*segment
+	for _, file := range files {
+		number := -1
+		if file.Name() == "transaction.log" {
+			number = 0
+		} else if m := segmentPattern.FindStringSubmatch(file.Name()); m != nil {
+			number, _ = strconv.Atoi(m[1])
+		}
+		if number >= 0 {
+			segments = append(segments, &segment{number: number, path: segmentPath(dir, number)})
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].number < segments[j].number })
+	return segments, nil
+}
+
+// track widens the segment's transaction ID range to include txID
+func (s *segment) track(txID int64) {
+	if s.firstTxID == 0 || txID < s.firstTxID {
+		s.firstTxID = txID
+	}
+	if txID > s.lastTxID {
+		s.lastTxID = txID
+	}
+}
+
+// activeSegment returns the segment being written to
+// Note: Caller must hold lock
+func (t *TransactionLog) activeSegment() *segment {
+	return t.segments[len(t.segments)-1]
+}
+
+// openActive opens the active segment for appending, writing the format
+// header if it is new
+// Note: Caller must hold write lock
+func (t *TransactionLog) openActive() error {
+	file, err := os.OpenFile(t.activeSegment().path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	size := info.Size()
+	if size == 0 {
+		if err := writeHeader(file); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to write log header: %v", err)
+		}
+		size = int64(headerSize)
+	}
+	t.file = file
+	t.dirty = size != info.Size() // The header of a new segment isn't synced yet
+	t.size = size
+	return nil
+}
+
+// writeEntry appends the record of an entry to the active segment in a single
+// write, rolling over to a new segment once the active one is full
+// Note: Caller must hold write lock
+func (t *TransactionLog) writeEntry(entry *LogEntry) error {
+	record, err := encodeRecord(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := t.file.Write(record); err != nil {
+		return err
+	}
+	t.size += int64(len(record))
+	t.dirty = true
+	t.activeSegment().track(entry.TransactionID)
+
+	if t.maxSegmentBytes > 0 && t.size >= t.maxSegmentBytes {
+		return t.rotate()
+	}
+	return nil
+}
+
+// rotate closes the active segment and starts a new one
+// Note: Caller must hold write lock
+func (t *TransactionLog) rotate() error {
+	if t.syncPolicy != SyncNever {
+		if err := t.syncIfDirty(); err != nil {
+			return err
+		}
+	}
+	if err := t.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log segment: %v", err)
+	}
+	number := t.activeSegment().number + 1
+	t.segments = append(t.segments, &segment{number: number, path: segmentPath(t.dir, number), scanned: true})
+	return t.openActive()
+}
+
+// Roll closes the active segment and starts a new one, so Compact can remove
+// it once a snapshot covers its transactions. An empty active segment is kept.
+func (t *TransactionLog) Roll() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.activeSegment().lastTxID == 0 {
+		return nil
+	}
+	return t.rotate()
+}
+
+// Segments returns the number of segment files in the log
+func (t *TransactionLog) Segments() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.segments)
+}
+
+// LastTransactionID returns the ID of the most recent transaction, 0 if
+// there was none. Once a snapshot covers it, it can be passed to Compact.
+func (t *TransactionLog) LastTransactionID() int64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nextTxID - 1
+}
+
+// Compact removes the segments whose transactions are all covered by a
+// snapshot: those before the active segment that only record transactions up
+// to snapshotTxID and hold none still waiting to be committed. Segments not
+// yet read by Recover are kept. It returns the number of segments removed.
+func (t *TransactionLog) Compact(snapshotTxID int64) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	kept := make([]*segment, 0, len(t.segments))
+	removed := 0
+	for i, seg := range t.segments {
+		if i == len(t.segments)-1 || !seg.scanned || seg.lastTxID > snapshotTxID || t.holdsUncommitted(seg) {
+			kept = append(kept, seg)
+			continue
+		}
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			t.segments = append(kept, t.segments[i:]...)
+			return removed, fmt.Errorf("failed to remove log segment: %v", err)
+		}
+		removed++
+	}
+	t.segments = kept
+	return removed, nil
+}
+
+// holdsUncommitted reports whether a segment may hold the operation of a
+// transaction that is not yet committed or rolled back
+// Note: Caller must hold lock
+func (t *TransactionLog) holdsUncommitted(seg *segment) bool {
+	for txID := range t.uncommitted {
+		if txID >= seg.firstTxID && txID <= seg.lastTxID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package txlog
+
+import (
+	"fmt"
+	"time"
+)
+
+// SyncPolicy selects when the transaction log fsyncs its file, trading write
+// throughput for durability across OS crashes
+type SyncPolicy struct {
+	each     bool
+	interval time.Duration
+}
+
+var (
+	// SyncEach fsyncs after every commit, so committed operations survive an
+	// OS crash
+	SyncEach = SyncPolicy{each: true}
+	// SyncNever leaves flushing to the OS
+	SyncNever = SyncPolicy{}
+)
+
+// SyncInterval fsyncs in the background every d when there were writes since
+// the last sync. Operations committed within the last interval can be lost
+// in an OS crash.
+func SyncInterval(d time.Duration) SyncPolicy {
+	return SyncPolicy{interval: d}
+}
+
+// NewTransactionLogWithSync creates a new transaction log kept in a single
+// segment that fsyncs according to policy
+func NewTransactionLogWithSync(logDir string, policy SyncPolicy) (*TransactionLog, error) {
+	txLog, err := NewTransactionLogWithOptions(logDir, 0)
+	if err != nil {
+		return nil, err
+	}
+	txLog.startSync(policy)
+	return txLog, nil
+}
+
+// startSync applies a sync policy, starting the background syncer of
+// SyncInterval
+func (t *TransactionLog) startSync(policy SyncPolicy) {
+	t.syncPolicy = policy
+	if policy.interval <= 0 {
+		return
+	}
+
+	t.stopSync = make(chan struct{})
+	t.syncDone = make(chan struct{})
+	go func() {
+		defer close(t.syncDone)
+
+		ticker := time.NewTicker(policy.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.mu.Lock()
+				t.syncIfDirty()
+				t.mu.Unlock()
+			case <-t.stopSync:
+				return
+			}
+		}
+	}()
+}
+
+// stopSyncer stops the background syncer of SyncInterval, if running
+func (t *TransactionLog) stopSyncer() {
+	if t.stopSync == nil {
+		return
+	}
+	close(t.stopSync)
+	<-t.syncDone
+	t.stopSync = nil
+}
+
+// syncIfDirty fsyncs the active segment if it was written since the last sync
+// Note: Caller must hold write lock
+func (t *TransactionLog) syncIfDirty() error {
+	if !t.dirty {
+		return nil
+	}
+	if err := t.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync log file: %v", err)
+	}
+	t.dirty = false
+	return nil
+}
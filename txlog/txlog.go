@@ -1,11 +1,8 @@
 package txlog
 
 import (
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
@@ -21,96 +18,127 @@ const (
 
 // LogEntry represents a single operation in the transaction log
 type LogEntry struct {
-	Operation   string              `json:"operation"`
-	Timestamp   time.Time           `json:"timestamp"`
-	DocumentID  int                 `json:"document_id"`
-	Document    *document.Document  `json:"document,omitempty"`
-	Committed   bool               `json:"committed"`
+	TransactionID int64              `json:"transaction_id"`
+	Operation     string             `json:"operation"`
+	Timestamp     time.Time          `json:"timestamp"`
+	DocumentID    int                `json:"document_id"`
+	Document      *document.Document `json:"document,omitempty"`
+	Committed     bool               `json:"committed"`
 }
 
 // TransactionLog manages write-ahead logging and recovery
 type TransactionLog struct {
-	mu           sync.RWMutex
-	file         *os.File
-	logPath      string
-	encoder      *json.Encoder
-	decoder      *json.Decoder
-	uncommitted  map[int]*LogEntry
+	mu              sync.RWMutex
+	dir             string
+	file            *os.File            // Active segment, the last one
+	size            int64               // Size of the active segment
+	segments        []*segment          // Segment files in order
+	maxSegmentBytes int64               // Size at which the log rolls over to a new segment; 0 means never
+	uncommitted     map[int64]*LogEntry // Uncommitted entries by transaction ID
+	nextTxID        int64               // Transaction ID assigned to the next operation
+	syncPolicy      SyncPolicy          // When the active segment is fsynced
+	dirty           bool                // Whether the active segment was written since the last sync
+	stopSync        chan struct{}       // Stops the background syncer of SyncInterval
+	syncDone        chan struct{}       // Closed when the background syncer has stopped
 }
 
-// NewTransactionLog creates a new transaction log
+// NewTransactionLog creates a new transaction log kept in a single segment
 func NewTransactionLog(logDir string) (*TransactionLog, error) {
+	return NewTransactionLogWithOptions(logDir, 0)
+}
+
+// NewTransactionLogWithOptions creates a new transaction log that rolls over
+// to a new segment file once the current one reaches maxSegmentBytes. A max
+// of 0 or less keeps the log in a single segment. Writes go to the last of
+// the segments already in logDir.
+func NewTransactionLogWithOptions(logDir string, maxSegmentBytes int64) (*TransactionLog, error) {
 	if err := os.MkdirAll(logDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create log directory: %v", err)
 	}
 
-	logPath := filepath.Join(logDir, "transaction.log")
-	file, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	segments, err := listSegments(logDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open log file: %v", err)
+		return nil, err
+	}
+	if len(segments) == 0 {
+		segments = []*segment{{number: 0, path: segmentPath(logDir, 0), scanned: true}}
 	}
 
 	txLog := &TransactionLog{
-		file:        file,
-		logPath:     logPath,
-		encoder:     json.NewEncoder(file),
-		decoder:     json.NewDecoder(file),
-		uncommitted: make(map[int]*LogEntry),
+		dir:             logDir,
+		segments:        segments,
+		maxSegmentBytes: maxSegmentBytes,
+		uncommitted:     make(map[int64]*LogEntry),
+		nextTxID:        1,
+	}
+	if err := txLog.openActive(); err != nil {
+		return nil, err
 	}
 
 	return txLog, nil
 }
 
-// LogOperation logs an operation to the transaction log
-func (t *TransactionLog) LogOperation(op string, docID int, doc *document.Document) error {
+// LogOperation logs an operation to the transaction log and returns the ID of
+// its transaction, which is passed to Commit or Rollback. Operations on the
+// same document get distinct transaction IDs.
+func (t *TransactionLog) LogOperation(op string, docID int, doc *document.Document) (int64, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	entry := &LogEntry{
-		Operation:  op,
-		Timestamp:  time.Now(),
-		DocumentID: docID,
-		Document:   doc,
-		Committed:  false,
+		TransactionID: t.nextTxID,
+		Operation:     op,
+		Timestamp:     time.Now(),
+		DocumentID:    docID,
+		Document:      doc,
+		Committed:     false,
 	}
 
-	if err := t.encoder.Encode(entry); err != nil {
-		return fmt.Errorf("failed to encode log entry: %v", err)
+	if err := t.writeEntry(entry); err != nil {
+		return 0, fmt.Errorf("failed to encode log entry: %v", err)
 	}
 
-	t.uncommitted[docID] = entry
-	return nil
+	t.uncommitted[entry.TransactionID] = entry
+	t.nextTxID++
+	return entry.TransactionID, nil
 }
 
-// Commit marks an operation as committed
-func (t *TransactionLog) Commit(docID int) error {
+// Commit marks the operation of a transaction as committed
+func (t *TransactionLog) Commit(txID int64) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	entry, exists := t.uncommitted[docID]
+	entry, exists := t.uncommitted[txID]
 	if !exists {
-		return fmt.Errorf("no uncommitted operation found for document ID %d", docID)
+		return fmt.Errorf("no uncommitted operation found for transaction ID %d", txID)
 	}
 
-	entry.Committed = true
-	if err := t.encoder.Encode(entry); err != nil {
+	// Encode a copy so entries already handed out aren't changed
+	committed := *entry
+	committed.Committed = true
+	if err := t.writeEntry(&committed); err != nil {
 		return fmt.Errorf("failed to encode commit entry: %v", err)
 	}
+	if t.syncPolicy.each {
+		if err := t.syncIfDirty(); err != nil {
+			return err
+		}
+	}
 
-	delete(t.uncommitted, docID)
+	delete(t.uncommitted, txID)
 	return nil
 }
 
-// Rollback removes an uncommitted operation
-func (t *TransactionLog) Rollback(docID int) error {
+// Rollback removes the uncommitted operation of a transaction
+func (t *TransactionLog) Rollback(txID int64) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if _, exists := t.uncommitted[docID]; !exists {
-		return fmt.Errorf("no uncommitted operation found for document ID %d", docID)
+	if _, exists := t.uncommitted[txID]; !exists {
+		return fmt.Errorf("no uncommitted operation found for transaction ID %d", txID)
 	}
 
-	delete(t.uncommitted, docID)
+	delete(t.uncommitted, txID)
 	return nil
 }
 
@@ -126,35 +154,64 @@ func (t *TransactionLog) GetUncommittedOperations() []*LogEntry {
 	return entries
 }
 
-// Recover processes the transaction log and returns operations that need to be replayed
+// Recover processes the transaction log and returns operations that need to
+// be replayed, reading the segments in order. A record that is cut short or
+// fails its checksum is treated as a torn write: it and everything after it,
+// including later segments, are dropped from the log, and the valid records
+// before it are returned. Segments in the older JSON lines format are read
+// and rewritten in the binary format.
 func (t *TransactionLog) Recover() ([]*LogEntry, error) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if _, err := t.file.Seek(0, 0); err != nil {
-		return nil, fmt.Errorf("failed to seek to start of log: %v", err)
-	}
-
 	var entries []*LogEntry
-	t.uncommitted = make(map[int]*LogEntry) // Reset uncommitted map
+	t.uncommitted = make(map[int64]*LogEntry) // Reset uncommitted map
+
+	if err := t.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close log file: %v", err)
+	}
+	for i, seg := range t.segments {
+		segEntries, torn, err := readSegment(seg.path)
+		if err != nil {
+			t.openActive()
+			return nil, err
+		}
 
-	for {
-		var entry LogEntry
-		if err := t.decoder.Decode(&entry); err != nil {
-			if err == io.EOF {
-				break
+		seg.scanned = true
+		seg.firstTxID, seg.lastTxID = 0, 0
+		for _, entry := range segEntries {
+			seg.track(entry.TransactionID)
+		}
+		entries = append(entries, segEntries...)
+
+		if torn {
+			// Later segments were written after the torn record
+			for _, later := range t.segments[i+1:] {
+				if err := os.Remove(later.path); err != nil && !os.IsNotExist(err) {
+					t.openActive()
+					return nil, fmt.Errorf("failed to remove log segment: %v", err)
+				}
 			}
-			return nil, fmt.Errorf("failed to decode log entry: %v", err)
+			t.segments = t.segments[:i+1]
+			break
 		}
+	}
+	if err := t.openActive(); err != nil {
+		return nil, err
+	}
 
-		entries = append(entries, &entry)
-		
+	for _, entry := range entries {
 		// Track uncommitted entries in memory
 		if !entry.Committed {
-			t.uncommitted[entry.DocumentID] = &entry
+			t.uncommitted[entry.TransactionID] = entry
 		} else {
 			// If we see a commit entry, remove the corresponding uncommitted entry
-			delete(t.uncommitted, entry.DocumentID)
+			delete(t.uncommitted, entry.TransactionID)
+		}
+
+		// Keep new transaction IDs clear of those already in the log
+		if entry.TransactionID >= t.nextTxID {
+			t.nextTxID = entry.TransactionID + 1
 		}
 	}
 
@@ -163,26 +220,39 @@ func (t *TransactionLog) Recover() ([]*LogEntry, error) {
 
 // Close closes the transaction log file
 func (t *TransactionLog) Close() error {
+	t.stopSyncer()
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	if t.syncPolicy != SyncNever {
+		if err := t.syncIfDirty(); err != nil {
+			return err
+		}
+	}
 	if err := t.file.Close(); err != nil {
 		return fmt.Errorf("failed to close log file: %v", err)
 	}
 	return nil
 }
 
-// Truncate removes all entries from the log file
+// Truncate removes all entries from the log, leaving a single empty segment
 func (t *TransactionLog) Truncate() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if err := t.file.Truncate(0); err != nil {
-		return fmt.Errorf("failed to truncate log file: %v", err)
+	if err := t.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %v", err)
+	}
+	for _, seg := range t.segments {
+		if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove log segment: %v", err)
+		}
 	}
-	if _, err := t.file.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to seek to start of log: %v", err)
+	t.segments = []*segment{{number: 0, path: segmentPath(t.dir, 0), scanned: true}}
+	if err := t.openActive(); err != nil {
+		return err
 	}
-	t.uncommitted = make(map[int]*LogEntry)
+	t.uncommitted = make(map[int64]*LogEntry)
 	return nil
 }
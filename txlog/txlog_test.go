@@ -2,7 +2,11 @@ package txlog
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"my-indexer/document"
 )
@@ -25,7 +29,7 @@ func TestTransactionLogging(t *testing.T) {
 	// Test logging an add operation
 	doc := document.NewDocument()
 	doc.AddField("title", "test document")
-	err = txLog.LogOperation(OpAdd, 1, doc)
+	txID, err := txLog.LogOperation(OpAdd, 1, doc)
 	if err != nil {
 		t.Errorf("Failed to log add operation: %v", err)
 	}
@@ -37,7 +41,7 @@ func TestTransactionLogging(t *testing.T) {
 	}
 
 	// Test commit
-	err = txLog.Commit(1)
+	err = txLog.Commit(txID)
 	if err != nil {
 		t.Errorf("Failed to commit operation: %v", err)
 	}
@@ -67,9 +71,9 @@ func TestRecovery(t *testing.T) {
 	doc2 := document.NewDocument()
 	doc2.AddField("title", "doc2")
 
-	txLog.LogOperation(OpAdd, 1, doc1)
+	txID, _ := txLog.LogOperation(OpAdd, 1, doc1)
 	txLog.LogOperation(OpAdd, 2, doc2)
-	txLog.Commit(1)
+	txLog.Commit(txID)
 
 	// Close the log
 	txLog.Close()
@@ -124,7 +128,7 @@ func TestRollback(t *testing.T) {
 	// Log an operation
 	doc := document.NewDocument()
 	doc.AddField("title", "test rollback")
-	err = txLog.LogOperation(OpAdd, 1, doc)
+	txID, err := txLog.LogOperation(OpAdd, 1, doc)
 	if err != nil {
 		t.Errorf("Failed to log operation: %v", err)
 	}
@@ -136,7 +140,7 @@ func TestRollback(t *testing.T) {
 	}
 
 	// Test rollback
-	err = txLog.Rollback(1)
+	err = txLog.Rollback(txID)
 	if err != nil {
 		t.Errorf("Failed to rollback operation: %v", err)
 	}
@@ -167,8 +171,8 @@ func TestCrashRecovery(t *testing.T) {
 	doc2 := document.NewDocument()
 	doc2.AddField("title", "doc2")
 
-	txLog.LogOperation(OpAdd, 1, doc1)
-	txLog.Commit(1)
+	txID, _ := txLog.LogOperation(OpAdd, 1, doc1)
+	txLog.Commit(txID)
 	txLog.LogOperation(OpAdd, 2, doc2)
 
 	// Simulate crash by not closing properly
@@ -209,3 +213,510 @@ func TestCrashRecovery(t *testing.T) {
 		t.Error("Failed to find uncommitted document 2")
 	}
 }
+
+func TestConcurrentTransactions(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	txLog, err := NewTransactionLog(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create transaction log: %v", err)
+	}
+
+	// Operations on the same document run concurrently, each in its own
+	// transaction
+	const workers = 10
+	var wg sync.WaitGroup
+	txIDs := make(chan int64, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			doc := document.NewDocument()
+			doc.AddField("n", i)
+			txID, err := txLog.LogOperation(OpUpdate, 1, doc)
+			if err != nil {
+				t.Errorf("Failed to log operation: %v", err)
+				return
+			}
+			txIDs <- txID
+		}(i)
+	}
+	wg.Wait()
+	close(txIDs)
+
+	seen := make(map[int64]bool)
+	for txID := range txIDs {
+		if seen[txID] {
+			t.Fatalf("Transaction ID %d was handed out twice", txID)
+		}
+		seen[txID] = true
+	}
+	if uncommitted := txLog.GetUncommittedOperations(); len(uncommitted) != workers {
+		t.Fatalf("Expected %d uncommitted operations on the same document, got %d", workers, len(uncommitted))
+	}
+
+	// Commit half of the transactions and roll back the rest
+	committed := 0
+	for txID := range seen {
+		if committed < workers/2 {
+			if err := txLog.Commit(txID); err != nil {
+				t.Errorf("Failed to commit transaction %d: %v", txID, err)
+			}
+			committed++
+		} else if err := txLog.Rollback(txID); err != nil {
+			t.Errorf("Failed to roll back transaction %d: %v", txID, err)
+		}
+	}
+	if uncommitted := txLog.GetUncommittedOperations(); len(uncommitted) != 0 {
+		t.Errorf("Expected no uncommitted operations, got %d", len(uncommitted))
+	}
+	for txID := range seen {
+		if err := txLog.Commit(txID); err == nil {
+			t.Errorf("Expected an error committing finished transaction %d", txID)
+		}
+	}
+	txLog.Close()
+
+	// Recovery sees each commit, and new transactions get fresh IDs
+	recoveredLog, err := NewTransactionLog(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create new transaction log: %v", err)
+	}
+	defer recoveredLog.Close()
+	entries, err := recoveredLog.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover log: %v", err)
+	}
+	commits := 0
+	for _, entry := range entries {
+		if entry.Committed {
+			commits++
+		}
+	}
+	if commits != workers/2 {
+		t.Errorf("Expected %d committed entries, got %d", workers/2, commits)
+	}
+	txID, err := recoveredLog.LogOperation(OpDelete, 1, nil)
+	if err != nil {
+		t.Fatalf("Failed to log operation: %v", err)
+	}
+	if seen[txID] {
+		t.Errorf("Expected a fresh transaction ID after recovery, got %d", txID)
+	}
+}
+
+func TestRecoverStopsAtCorruptEntry(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, logPath string)
+	}{
+		{"Garbage appended", func(t *testing.T, logPath string) {
+			file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatalf("Failed to open log file: %v", err)
+			}
+			defer file.Close()
+			if _, err := file.WriteString(`{"checksum":12,"transaction_id":4,"oper`); err != nil {
+				t.Fatalf("Failed to append garbage: %v", err)
+			}
+		}},
+		{"Entry altered", func(t *testing.T, logPath string) {
+			data, err := os.ReadFile(logPath)
+			if err != nil {
+				t.Fatalf("Failed to read log file: %v", err)
+			}
+			// Change the document ID of the last entry without breaking its JSON
+			i := strings.LastIndex(string(data), `"document_id":3`)
+			if i < 0 {
+				t.Fatal("Expected the log to hold document 3")
+			}
+			data[i+len(`"document_id":`)] = '9'
+			if err := os.WriteFile(logPath, data, 0644); err != nil {
+				t.Fatalf("Failed to write log file: %v", err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			txLog, err := NewTransactionLog(tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to create transaction log: %v", err)
+			}
+			for docID := 1; docID <= 3; docID++ {
+				doc := document.NewDocument()
+				doc.AddField("title", "valid entry")
+				txID, err := txLog.LogOperation(OpAdd, docID, doc)
+				if err != nil {
+					t.Fatalf("Failed to log operation: %v", err)
+				}
+				if err := txLog.Commit(txID); err != nil {
+					t.Fatalf("Failed to commit operation: %v", err)
+				}
+			}
+			txLog.Close()
+
+			tt.corrupt(t, filepath.Join(tmpDir, "transaction.log"))
+
+			recoveredLog, err := NewTransactionLog(tmpDir)
+			if err != nil {
+				t.Fatalf("Failed to create new transaction log: %v", err)
+			}
+			defer recoveredLog.Close()
+			entries, err := recoveredLog.Recover()
+			if err != nil {
+				t.Fatalf("Expected recovery to succeed up to the corruption, got %v", err)
+			}
+			if len(entries) < 5 {
+				t.Fatalf("Expected the valid entries before the corruption, got %d", len(entries))
+			}
+			for _, entry := range entries[:5] {
+				if entry.DocumentID < 1 || entry.DocumentID > 3 {
+					t.Errorf("Unexpected entry for document %d", entry.DocumentID)
+				}
+			}
+			for _, entry := range entries {
+				if entry.DocumentID == 9 {
+					t.Error("Expected the altered entry to be dropped")
+				}
+			}
+
+			// Entries logged after recovery follow the valid ones
+			txID, err := recoveredLog.LogOperation(OpDelete, 1, nil)
+			if err != nil {
+				t.Fatalf("Failed to log operation: %v", err)
+			}
+			if err := recoveredLog.Commit(txID); err != nil {
+				t.Fatalf("Failed to commit operation: %v", err)
+			}
+			after, err := recoveredLog.Recover()
+			if err != nil {
+				t.Fatalf("Failed to recover log: %v", err)
+			}
+			if len(after) != len(entries)+2 || after[len(after)-1].Operation != OpDelete {
+				t.Errorf("Expected the new entries after the %d valid ones, got %d entries", len(entries), len(after))
+			}
+		})
+	}
+}
+
+func TestRecoverTruncatedRecord(t *testing.T) {
+	// Write two committed operations, noting the log size before the last
+	// record
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "transaction.log")
+	txLog, err := NewTransactionLog(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create transaction log: %v", err)
+	}
+	doc := document.NewDocument()
+	doc.AddField("title", "doc1")
+	txID, _ := txLog.LogOperation(OpAdd, 1, doc)
+	txLog.Commit(txID)
+	txID, _ = txLog.LogOperation(OpAdd, 2, doc)
+	info, err := os.Stat(logPath)
+	if err != nil {
+		t.Fatalf("Failed to stat log file: %v", err)
+	}
+	lastStart := info.Size()
+	txLog.Commit(txID)
+	txLog.Close()
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.HasPrefix(string(data), logMagic) {
+		t.Fatalf("Expected the log to start with the format header")
+	}
+
+	cuts := map[string]int64{
+		"Inside record length":  lastStart + 2,
+		"After record header":   lastStart + recordHeaderSize,
+		"Inside record payload": int64(len(data)) - 1,
+	}
+	for name, cut := range cuts {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "transaction.log"), data[:cut], 0644); err != nil {
+				t.Fatalf("Failed to write log file: %v", err)
+			}
+
+			recoveredLog, err := NewTransactionLog(dir)
+			if err != nil {
+				t.Fatalf("Failed to open transaction log: %v", err)
+			}
+			defer recoveredLog.Close()
+			entries, err := recoveredLog.Recover()
+			if err != nil {
+				t.Fatalf("Expected recovery to tolerate a truncated record, got %v", err)
+			}
+			if len(entries) != 3 {
+				t.Fatalf("Expected the 3 records before the truncated one, got %d", len(entries))
+			}
+			if uncommitted := recoveredLog.GetUncommittedOperations(); len(uncommitted) != 1 || uncommitted[0].DocumentID != 2 {
+				t.Errorf("Expected the add of document 2 to be uncommitted, got %v", uncommitted)
+			}
+
+			// The torn record is dropped, so new records follow the valid ones
+			txID, err := recoveredLog.LogOperation(OpDelete, 1, nil)
+			if err != nil {
+				t.Fatalf("Failed to log operation: %v", err)
+			}
+			recoveredLog.Commit(txID)
+			after, err := recoveredLog.Recover()
+			if err != nil {
+				t.Fatalf("Failed to recover log: %v", err)
+			}
+			if len(after) != 5 {
+				t.Errorf("Expected 5 records after logging more, got %d", len(after))
+			}
+		})
+	}
+}
+
+func TestRecoverLegacyLog(t *testing.T) {
+	tmpDir := t.TempDir()
+	logPath := filepath.Join(tmpDir, "transaction.log")
+	legacy := `{"transaction_id":1,"operation":"add","timestamp":"2024-01-01T00:00:00Z","document_id":1,"document":{"title":"doc1"},"committed":false}
+{"transaction_id":1,"operation":"add","timestamp":"2024-01-01T00:00:00Z","document_id":1,"document":{"title":"doc1"},"committed":true}
+`
+	if err := os.WriteFile(logPath, []byte(legacy), 0644); err != nil {
+		t.Fatalf("Failed to write log file: %v", err)
+	}
+
+	txLog, err := NewTransactionLog(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to open transaction log: %v", err)
+	}
+	defer txLog.Close()
+	entries, err := txLog.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover legacy log: %v", err)
+	}
+	if len(entries) != 2 || !entries[1].Committed {
+		t.Fatalf("Expected the 2 legacy entries, got %d", len(entries))
+	}
+
+	// The log is rewritten in the binary format and stays usable
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	if !strings.HasPrefix(string(data), logMagic) {
+		t.Errorf("Expected the legacy log to be rewritten with the format header")
+	}
+	txID, err := txLog.LogOperation(OpDelete, 1, nil)
+	if err != nil {
+		t.Fatalf("Failed to log operation: %v", err)
+	}
+	if txID <= 1 {
+		t.Errorf("Expected a transaction ID after the legacy ones, got %d", txID)
+	}
+	if entries, err := txLog.Recover(); err != nil || len(entries) != 3 {
+		t.Errorf("Expected 3 entries after logging more, got %d (%v)", len(entries), err)
+	}
+}
+
+func TestSegmentRotation(t *testing.T) {
+	tmpDir := t.TempDir()
+	txLog, err := NewTransactionLogWithOptions(tmpDir, 1024)
+	if err != nil {
+		t.Fatalf("Failed to create transaction log: %v", err)
+	}
+
+	// Write past the segment size
+	logged := 0
+	for txLog.Segments() < 2 {
+		if logged > 100 {
+			t.Fatal("Expected the log to roll over to a second segment")
+		}
+		doc := document.NewDocument()
+		doc.AddField("title", "a document long enough to fill the segment quickly")
+		txID, err := txLog.LogOperation(OpAdd, logged, doc)
+		if err != nil {
+			t.Fatalf("Failed to log operation: %v", err)
+		}
+		if err := txLog.Commit(txID); err != nil {
+			t.Fatalf("Failed to commit operation: %v", err)
+		}
+		logged++
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "transaction.1.log")); err != nil {
+		t.Fatalf("Expected a second segment file: %v", err)
+	}
+
+	// One more operation lands in the second segment
+	doc := document.NewDocument()
+	doc.AddField("title", "second")
+	txID, _ := txLog.LogOperation(OpAdd, logged, doc)
+	txLog.Commit(txID)
+	logged++
+	txLog.Close()
+
+	// Recover reads both segments in order
+	recoveredLog, err := NewTransactionLogWithOptions(tmpDir, 1024)
+	if err != nil {
+		t.Fatalf("Failed to open transaction log: %v", err)
+	}
+	defer recoveredLog.Close()
+	entries, err := recoveredLog.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover log: %v", err)
+	}
+	if len(entries) != 2*logged {
+		t.Fatalf("Expected %d entries across the segments, got %d", 2*logged, len(entries))
+	}
+	for i, entry := range entries {
+		if entry.DocumentID != i/2 {
+			t.Fatalf("Expected entries in order, got document %d at %d", entry.DocumentID, i)
+		}
+	}
+
+	// Compaction drops the full segment once a snapshot covers it
+	removed, err := recoveredLog.Compact(recoveredLog.LastTransactionID())
+	if err != nil {
+		t.Fatalf("Failed to compact log: %v", err)
+	}
+	if removed != 1 || recoveredLog.Segments() != 1 {
+		t.Errorf("Expected the first segment to be removed, removed %d and kept %d", removed, recoveredLog.Segments())
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "transaction.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected the first segment file to be removed, got %v", err)
+	}
+	entries, err = recoveredLog.Recover()
+	if err != nil {
+		t.Fatalf("Failed to recover compacted log: %v", err)
+	}
+	if len(entries) != 2 || entries[0].DocumentID != logged-1 {
+		t.Errorf("Expected only the second segment's entries after compaction, got %d", len(entries))
+	}
+}
+
+func TestCompactKeepsUnsnapshottedSegments(t *testing.T) {
+	tmpDir := t.TempDir()
+	txLog, err := NewTransactionLogWithOptions(tmpDir, 256)
+	if err != nil {
+		t.Fatalf("Failed to create transaction log: %v", err)
+	}
+	defer txLog.Close()
+
+	// An uncommitted operation in the first segment
+	doc := document.NewDocument()
+	doc.AddField("title", "a document long enough to fill the segment quickly")
+	pending, _ := txLog.LogOperation(OpAdd, 1, doc)
+	for txLog.Segments() < 3 {
+		txID, err := txLog.LogOperation(OpAdd, 2, doc)
+		if err != nil {
+			t.Fatalf("Failed to log operation: %v", err)
+		}
+		txLog.Commit(txID)
+	}
+
+	firstSegment := filepath.Join(tmpDir, "transaction.log")
+	if removed, _ := txLog.Compact(txLog.LastTransactionID()); removed == 0 {
+		t.Error("Expected the segments without pending operations to be removed")
+	}
+	if _, err := os.Stat(firstSegment); err != nil {
+		t.Errorf("Expected the segment with a pending operation to be kept: %v", err)
+	}
+
+	txLog.Commit(pending)
+	if removed, _ := txLog.Compact(0); removed != 0 {
+		t.Errorf("Expected segments past the snapshot to be kept, removed %d", removed)
+	}
+	if _, err := txLog.Compact(txLog.LastTransactionID()); err != nil {
+		t.Fatalf("Failed to compact log: %v", err)
+	}
+	if _, err := os.Stat(firstSegment); !os.IsNotExist(err) {
+		t.Errorf("Expected the first segment to be removed once committed, got %v", err)
+	}
+	if segments := txLog.Segments(); segments != 1 {
+		t.Errorf("Expected only the active segment to be left, got %d", segments)
+	}
+}
+
+func TestSyncPolicies(t *testing.T) {
+	policies := map[string]SyncPolicy{
+		"Each":     SyncEach,
+		"Interval": SyncInterval(5 * time.Millisecond),
+		"Never":    SyncNever,
+	}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			txLog, err := NewTransactionLogWithSync(tmpDir, policy)
+			if err != nil {
+				t.Fatalf("Failed to create transaction log: %v", err)
+			}
+
+			doc := document.NewDocument()
+			doc.AddField("title", "durable document")
+			txID, err := txLog.LogOperation(OpAdd, 1, doc)
+			if err != nil {
+				t.Fatalf("Failed to log operation: %v", err)
+			}
+			if err := txLog.Commit(txID); err != nil {
+				t.Fatalf("Failed to commit operation: %v", err)
+			}
+			if policy == SyncEach {
+				txLog.mu.RLock()
+				dirty := txLog.dirty
+				txLog.mu.RUnlock()
+				if dirty {
+					t.Error("Expected SyncEach to sync the commit")
+				}
+			}
+			if err := txLog.Close(); err != nil {
+				t.Fatalf("Failed to close transaction log: %v", err)
+			}
+
+			reopened, err := NewTransactionLogWithSync(tmpDir, policy)
+			if err != nil {
+				t.Fatalf("Failed to reopen transaction log: %v", err)
+			}
+			defer reopened.Close()
+			entries, err := reopened.Recover()
+			if err != nil {
+				t.Fatalf("Failed to recover log: %v", err)
+			}
+			if len(entries) != 2 || !entries[1].Committed || entries[1].DocumentID != 1 {
+				t.Errorf("Expected the committed entry to survive reopening, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func BenchmarkCommit(b *testing.B) {
+	policies := []struct {
+		name   string
+		policy SyncPolicy
+	}{
+		{"SyncNever", SyncNever},
+		{"SyncInterval", SyncInterval(10 * time.Millisecond)},
+		{"SyncEach", SyncEach},
+	}
+	for _, p := range policies {
+		b.Run(p.name, func(b *testing.B) {
+			txLog, err := NewTransactionLogWithSync(b.TempDir(), p.policy)
+			if err != nil {
+				b.Fatalf("Failed to create transaction log: %v", err)
+			}
+			defer txLog.Close()
+
+			doc := document.NewDocument()
+			doc.AddField("title", "benchmark document")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				txID, err := txLog.LogOperation(OpAdd, i, doc)
+				if err != nil {
+					b.Fatalf("Failed to log operation: %v", err)
+				}
+				if err := txLog.Commit(txID); err != nil {
+					b.Fatalf("Failed to commit operation: %v", err)
+				}
+			}
+		})
+	}
+}